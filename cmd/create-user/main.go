@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"docufiller-update-server/internal/config"
+	"docufiller-update-server/internal/database"
+	"docufiller-update-server/internal/service"
+)
+
+// create-user 直接在数据库中创建管理后台用户，用于创建第一个 owner。
+// 之后可通过管理 API（/api/admin/users）管理其他用户。
+func main() {
+	configPath := flag.String("config", "config.yaml", "server config file")
+	username := flag.String("username", "", "username (required)")
+	role := flag.String("role", service.RoleOwner, "role: owner, maintainer or viewer")
+	programs := flag.String("programs", "", "comma-separated program IDs a maintainer may modify")
+	flag.Parse()
+
+	if *username == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load config
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Connect database
+	db, err := database.NewGORM(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to connect database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	req := service.CreateUserRequest{
+		Username: *username,
+		// 密码从环境变量读取，避免出现在命令行历史中；未设置时生成临时密码
+		Password: os.Getenv("ADMIN_PASSWORD"),
+		Role:     *role,
+	}
+	for _, p := range strings.Split(*programs, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			req.Programs = append(req.Programs, p)
+		}
+	}
+
+	user, temporary, err := service.NewUserService(db).CreateUser(req)
+	if err != nil {
+		log.Fatalf("Failed to create user: %v", err)
+	}
+
+	fmt.Printf("User: %s\n", user.Username)
+	fmt.Printf("Role: %s\n", user.Role)
+	if len(user.Programs) > 0 {
+		fmt.Printf("Programs: %s\n", strings.Join(user.Programs, ", "))
+	}
+	if temporary != "" {
+		fmt.Printf("Temporary Password: %s\n", temporary)
+		fmt.Println("The password is shown only once and must be changed after the first login.")
+	}
+}
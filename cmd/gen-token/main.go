@@ -1,17 +1,26 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	"docufiller-update-server/internal/config"
 	"docufiller-update-server/internal/database"
 	"docufiller-update-server/internal/service"
 )
 
+// gen-token 直接在数据库中创建管理 Token，用于引导第一个自动化调用方。
+// 之后可通过管理 API（/api/admin/tokens）创建和撤销其他管理 Token。
 func main() {
+	configPath := flag.String("config", "config.yaml", "server config file")
+	name := flag.String("name", "bootstrap", "token name, shown in the admin token list and audit logs")
+	expires := flag.Duration("expires", 0, "token lifetime, e.g. 720h (default: never expires)")
+	flag.Parse()
+
 	// Load config
-	cfg, err := config.Load("config.yaml")
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -21,16 +30,27 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect database: %v", err)
 	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
-	// Create token service
-	tokenSvc := service.NewTokenService(db)
+	var expiresAt *time.Time
+	if *expires > 0 {
+		t := time.Now().Add(*expires)
+		expiresAt = &t
+	}
 
 	// Generate admin token
-	token, tokenValue, err := tokenSvc.GenerateToken("", "admin", "system")
+	tokenSvc := service.NewTokenService(db)
+	token, tokenValue, err := tokenSvc.CreateAdminToken(*name, "gen-token", expiresAt)
 	if err != nil {
 		log.Fatalf("Failed to generate token: %v", err)
 	}
 
 	fmt.Printf("Admin Token: %s\n", tokenValue)
 	fmt.Printf("Token ID: %s\n", token.TokenID)
+	if expiresAt != nil {
+		fmt.Printf("Expires At: %s\n", expiresAt.Format(time.RFC3339))
+	}
+	fmt.Println("The token is shown only once. Use it as 'Authorization: Bearer <token>' on /api/admin.")
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"docufiller-update-server/internal/config"
+	"docufiller-update-server/internal/database"
+	"docufiller-update-server/internal/service"
+)
+
+// migrate-secrets 一次性迁移旧数据库：程序密钥用 masterKey 派生的 KEK 包装存储，
+// Token 只保留哈希。已迁移的记录保持不变，重复执行是安全的。
+func main() {
+	configPath := flag.String("config", "config.yaml", "server config file")
+	flag.Parse()
+
+	// Load config
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Crypto.MasterKey == "" {
+		log.Fatalf("crypto.masterKey is not set in %s", *configPath)
+	}
+
+	// Connect database
+	db, err := database.NewGORM(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to connect database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	result, err := service.MigrateSecrets(db, service.NewSecretBox(cfg.Crypto.MasterKey))
+	if err != nil {
+		log.Fatalf("Failed to migrate secrets: %v", err)
+	}
+
+	fmt.Printf("Encryption keys sealed: %d\n", result.KeysSealed)
+	fmt.Printf("Plaintext tokens cleared: %d\n", result.TokensCleared)
+	if result.TokensCleared > 0 {
+		fmt.Println("Existing tokens keep working, but their values can no longer be shown; regenerate a token to see it again.")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"docufiller-update-server/internal/config"
+	"docufiller-update-server/internal/database"
+	"docufiller-update-server/internal/service"
+)
+
+// rotate-master-key 用新的主密钥重新包装所有存储的程序密钥
+//
+// 旧主密钥取自配置文件，新主密钥通过 -new-master-key 或环境变量 NEW_MASTER_KEY 提供。
+// 成功后需将配置中的 crypto.masterKey 改为新主密钥再重启服务。
+func main() {
+	configPath := flag.String("config", "config.yaml", "server config file")
+	newMasterKey := flag.String("new-master-key", os.Getenv("NEW_MASTER_KEY"), "new master key (defaults to $NEW_MASTER_KEY)")
+	flag.Parse()
+
+	// Load config
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Crypto.MasterKey == "" {
+		log.Fatalf("crypto.masterKey is not set in %s", *configPath)
+	}
+	if *newMasterKey == "" {
+		log.Fatalf("New master key is required (-new-master-key or NEW_MASTER_KEY)")
+	}
+	if *newMasterKey == cfg.Crypto.MasterKey {
+		log.Fatalf("New master key must differ from the current one")
+	}
+
+	// Connect database
+	db, err := database.NewGORM(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Failed to connect database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	oldBox := service.NewSecretBox(cfg.Crypto.MasterKey)
+	newBox := service.NewSecretBox(*newMasterKey)
+	result, err := service.RewrapSecrets(db, oldBox, newBox)
+	if err != nil {
+		log.Fatalf("Failed to re-wrap secrets, nothing was changed: %v", err)
+	}
+
+	fmt.Printf("Encryption keys re-wrapped: %d\n", result.KeysRewrapped)
+	fmt.Printf("Plaintext encryption keys sealed: %d\n", result.KeysSealed)
+	fmt.Printf("Plaintext tokens cleared: %d\n", result.TokensCleared)
+	fmt.Printf("Pending token replacements re-sealed: %d\n", result.TokensRewrapped)
+	fmt.Printf("Admin TOTP secrets re-sealed: %d\n", result.TOTPRewrapped)
+	fmt.Printf("Now set crypto.masterKey in %s to the new master key and restart the server.\n", *configPath)
+}
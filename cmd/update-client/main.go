@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -15,6 +19,7 @@ var (
 	jsonOutput bool
 	daemonMode bool
 	daemonPort int
+	daemonSock string
 )
 
 var rootCmd = &cobra.Command{
@@ -37,6 +42,42 @@ var downloadCmd = &cobra.Command{
 	RunE:  runDownload,
 }
 
+var applyCmd = &cobra.Command{
+	Use:   "apply --version VERSION [--file PATH]",
+	Short: "Install an update",
+	Long: `Download (or take an already downloaded package), verify it and replace the
+install directory with it. The previous install is kept; if the configured
+health check fails the previous install is restored automatically.`,
+	RunE: runApply,
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore the previous install",
+	Long:  `Swap the install directory with the previous install kept by the last apply.`,
+	RunE:  runRollback,
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run as a long-running update agent",
+	Long: `Check for updates on the configured interval, download new versions in the
+background and install them according to agent.policy (auto, notify or manual).
+Mandatory updates are installed immediately. State is kept in agent.state_file
+across restarts. Supports systemd Type=notify services.`,
+	RunE: runAgent,
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon [--port PORT | --socket PATH]",
+	Short: "Run the local control API for the host application",
+	Long: `Serve a local HTTP API (127.0.0.1 or a Unix domain socket) through which the
+host application can check for updates, start, pause, resume and cancel a
+download, install the downloaded update and read the history. A new access
+token is written to daemon.token_file on every start.`,
+	RunE: runDaemon,
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "update-config.yaml", "config file")
 	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "output JSON format")
@@ -46,10 +87,19 @@ func init() {
 	downloadCmd.Flags().String("output", "", "output file path")
 	downloadCmd.Flags().String("version", "", "version to download")
 	downloadCmd.Flags().BoolVar(&daemonMode, "daemon", false, "enable daemon mode (HTTP server)")
-	downloadCmd.Flags().IntVar(&daemonPort, "port", 0, "HTTP server port (required with --daemon)")
+	downloadCmd.Flags().IntVar(&daemonPort, "port", 0, "HTTP server port (required with --daemon unless --socket is set)")
+	downloadCmd.Flags().StringVar(&daemonSock, "socket", "", "listen on this Unix domain socket instead of a TCP port (overrides daemon.socket)")
 	downloadCmd.MarkFlagRequired("version")
 
-	rootCmd.AddCommand(checkCmd, downloadCmd)
+	applyCmd.Flags().String("version", "", "version to install")
+	applyCmd.Flags().String("current-version", "", "currently installed version passed to install hooks (overrides config)")
+	applyCmd.Flags().String("file", "", "already downloaded package (verified against the server before installing)")
+	applyCmd.MarkFlagRequired("version")
+
+	daemonCmd.Flags().Int("port", 0, "HTTP server port (required unless --socket or daemon.socket is set)")
+	daemonCmd.Flags().String("socket", "", "listen on this Unix domain socket instead of a TCP port (overrides daemon.socket)")
+
+	rootCmd.AddCommand(checkCmd, downloadCmd, applyCmd, rollbackCmd, agentCmd, daemonCmd)
 }
 
 func main() {
@@ -89,9 +139,12 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	outputPath, _ := cmd.Flags().GetString("output")
 	daemon, _ := cmd.Flags().GetBool("daemon")
 	port, _ := cmd.Flags().GetInt("port")
+	if socket, _ := cmd.Flags().GetString("socket"); socket != "" {
+		cfg.Daemon.Socket = socket
+	}
 
 	// 验证 Daemon 模式参数
-	if daemon && port == 0 {
+	if daemon && port == 0 && cfg.Daemon.Socket == "" {
 		return fmt.Errorf("--port is required when using --daemon")
 	}
 
@@ -105,16 +158,131 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	return checker.DownloadWithOutput(version, outputPath)
 }
 
+func runApply(cmd *cobra.Command, args []string) error {
+	// Load config
+	cfg, err := client.LoadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	version, _ := cmd.Flags().GetString("version")
+	packagePath, _ := cmd.Flags().GetString("file")
+	if currentVersion, _ := cmd.Flags().GetString("current-version"); currentVersion != "" {
+		cfg.Program.CurrentVersion = currentVersion
+	}
+
+	checker := client.NewUpdateChecker(cfg, jsonOutput)
+	return checker.ApplyWithOutput(version, packagePath)
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	// Load config
+	cfg, err := client.LoadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	checker := client.NewUpdateChecker(cfg, jsonOutput)
+	return checker.RollbackWithOutput()
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	// Load config
+	cfg, err := client.LoadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// 日志同时写入 stderr（systemd journal）和 logging.file
+	var out io.Writer = os.Stderr
+	if cfg.Logging.File != "" {
+		f, err := os.OpenFile(cfg.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stderr, f)
+	}
+
+	agent, err := client.NewAgent(cfg, log.New(out, "", log.LstdFlags))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return agent.Run(ctx)
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	// Load config
+	cfg, err := client.LoadConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	port, _ := cmd.Flags().GetInt("port")
+	if socket, _ := cmd.Flags().GetString("socket"); socket != "" {
+		cfg.Daemon.Socket = socket
+	}
+	if port == 0 && cfg.Daemon.Socket == "" {
+		return fmt.Errorf("--port or --socket is required")
+	}
+
+	state := client.NewDaemonState(cfg.Program.CurrentVersion)
+	server, controller, err := startDaemonServer(cfg, state, port)
+	if err != nil {
+		return err
+	}
+
+	// 等待 /shutdown、父进程退出或终止信号
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-server.Done():
+	case <-ctx.Done():
+		server.Shutdown()
+	}
+	controller.Close()
+	return nil
+}
+
 func runDaemonDownload(cfg *client.Config, version, outputPath string, port int) error {
 	// 创建状态管理器
 	state := client.NewDaemonState(version)
 
-	// 创建并启动 Daemon 服务器
-	server := client.NewDaemonServer(port, state)
+	server, controller, err := startDaemonServer(cfg, state, port)
+	if err != nil {
+		return err
+	}
+
+	// 在后台下载，调用方可通过控制 API 暂停、恢复或取消
+	if _, err := controller.StartDownload(version, outputPath); err != nil {
+		server.Shutdown()
+		return err
+	}
+
+	// 等待 shutdown 信号
+	<-server.Done()
+	controller.Close()
+
+	return controller.LastError()
+}
+
+// startDaemonServer 创建控制器并在后台启动 Daemon 服务器
+func startDaemonServer(cfg *client.Config, state *client.DaemonState, port int) (*client.DaemonServer, *client.DaemonController, error) {
+	controller := client.NewDaemonController(cfg, state)
 
-	// 创建 checker 并设置 daemonState
-	checker := client.NewUpdateChecker(cfg, false)
-	checker.SetDaemonState(state)
+	// 创建 Daemon 服务器
+	server := client.NewDaemonServer(port, state)
+	if cfg.Daemon.Listen != "" {
+		server.SetListen(cfg.Daemon.Listen)
+	}
+	if cfg.Daemon.Socket != "" {
+		server.SetSocket(cfg.Daemon.Socket)
+	}
+	server.SetTokenFile(cfg.Daemon.TokenFile)
+	server.SetController(controller)
 
 	// 启动父进程监控
 	parentPID := client.GetParentPID()
@@ -130,17 +298,10 @@ func runDaemonDownload(cfg *client.Config, version, outputPath string, port int)
 	select {
 	case err := <-serverErr:
 		// 服务器启动失败
-		return fmt.Errorf("failed to start daemon server: %w", err)
+		return nil, nil, fmt.Errorf("failed to start daemon server: %w", err)
 	case <-time.After(5 * time.Second):
 		// 超时 - 假设服务器成功启动（Start() 是阻塞调用）
-		log.Printf("Daemon server started on port %d\n", port)
+		log.Printf("Daemon server started (token in %s)\n", cfg.Daemon.TokenFile)
 	}
-
-	// 执行下载
-	downloadErr := checker.DownloadWithOutput(version, outputPath)
-
-	// 等待 shutdown 信号
-	<-server.Done()
-
-	return downloadErr
+	return server, controller, nil
 }
@@ -8,13 +8,17 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 type UpdateAdmin struct {
-	serverURL string
-	token     string
-	client    *http.Client
+	serverURL      string
+	token          string
+	client         *http.Client
+	chunkThreshold int64 // 超过该大小的单文件上传改用分片上传
+	chunkSize      int64
+	signingKey     string // 发布签名私钥（base64），为空时不签名
 }
 
 func NewUpdateAdmin(serverURL, token string) *UpdateAdmin {
@@ -24,6 +28,8 @@ func NewUpdateAdmin(serverURL, token string) *UpdateAdmin {
 		client: &http.Client{
 			Timeout: 60 * time.Second,
 		},
+		chunkThreshold: defaultChunkThreshold,
+		chunkSize:      defaultChunkSize,
 	}
 }
 
@@ -35,12 +41,29 @@ type VersionInfo struct {
 	PublishDate time.Time `json:"publishDate"`
 }
 
-func (a *UpdateAdmin) UploadVersion(programID, channel, version, filePath, notes string, mandatory bool) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+// ReleaseOptions 版本的发布状态：Status 为空表示立即发布，draft 为草稿，
+// 指定 PublishAt（RFC 3339）时定时发布
+type ReleaseOptions struct {
+	Status    string `json:"status,omitempty"`
+	PublishAt string `json:"publishAt,omitempty"`
+
+	// 升级路径（仅上传时使用）：低于 MinUpgradeFrom 的客户端需先升级到中间版本，
+	// RequiredStep 表示更低版本的客户端必须先经过该版本
+	MinUpgradeFrom string `json:"-"`
+	RequiredStep   bool   `json:"-"`
+}
+
+// UploadVersion 上传版本；artifacts 为平台标识（os-arch[-variant]）到文件路径的映射，可与主文件同时提供
+//
+// 只有主文件且超过分片阈值时，自动改用可续传的分片上传。
+//
+// release 为空时立即发布，也可以是 draft，或配合 publishAt（RFC 3339）定时发布。
+func (a *UpdateAdmin) UploadVersion(programID, channel, version, filePath string, artifacts map[string]string, notes string, mandatory bool, release ReleaseOptions) error {
+	if filePath != "" && len(artifacts) == 0 {
+		if info, err := os.Stat(filePath); err == nil && info.Size() > a.chunkThreshold {
+			return a.UploadVersionChunked(programID, channel, version, filePath, notes, mandatory, release)
+		}
 	}
-	defer file.Close()
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -49,16 +72,44 @@ func (a *UpdateAdmin) UploadVersion(programID, channel, version, filePath, notes
 	writer.WriteField("version", version)
 	writer.WriteField("notes", notes)
 	writer.WriteField("mandatory", fmt.Sprintf("%v", mandatory))
+	writer.WriteField("status", release.Status)
+	writer.WriteField("publishAt", release.PublishAt)
+	writer.WriteField("minUpgradeFrom", release.MinUpgradeFrom)
+	writer.WriteField("requiredStep", fmt.Sprintf("%v", release.RequiredStep))
 
-	part, err := writer.CreateFormFile("file", file.Name())
-	if err != nil {
-		return fmt.Errorf("failed to create form file: %w", err)
+	if a.signingKey != "" {
+		mainPath := filePath
+		if mainPath == "" {
+			mainPath = mainArtifactPath(artifacts)
+		}
+		signature, err := a.signFile(programID, channel, version, "", mainPath, mandatory)
+		if err != nil {
+			return fmt.Errorf("failed to sign: %w", err)
+		}
+		writer.WriteField("signature", signature)
+		for platform, path := range artifacts {
+			key := normalizePlatform(platform)
+			signature, err := a.signFile(programID, channel, version, key, path, mandatory)
+			if err != nil {
+				return fmt.Errorf("failed to sign %s: %w", platform, err)
+			}
+			writer.WriteField("signature."+key, signature)
+		}
 	}
 
-	io.Copy(part, file)
+	if filePath != "" {
+		if err := addFormFile(writer, "file", filePath); err != nil {
+			return err
+		}
+	}
+	for platform, path := range artifacts {
+		if err := addFormFile(writer, "file."+platform, path); err != nil {
+			return err
+		}
+	}
 	writer.Close()
 
-	url := fmt.Sprintf("%s/api/version/%s/upload", a.serverURL, programID)
+	url := fmt.Sprintf("%s/api/programs/%s/versions", a.serverURL, programID)
 	req, err := http.NewRequest("POST", url, &body)
 	if err != nil {
 		return err
@@ -74,13 +125,34 @@ func (a *UpdateAdmin) UploadVersion(programID, channel, version, filePath, notes
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("upload failed with status %d", resp.StatusCode)
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, e.Error)
 	}
 
 	fmt.Printf("Version %s/%s/%s uploaded successfully\n", programID, channel, version)
 	return nil
 }
 
+// addFormFile 将本地文件写入 multipart 表单字段
+func addFormFile(writer *multipart.Writer, field, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	part, err := writer.CreateFormFile(field, filepath.Base(filePath))
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	_, err = io.Copy(part, file)
+	return err
+}
+
 func (a *UpdateAdmin) DeleteVersion(programID, channel, version string) error {
 	url := fmt.Sprintf("%s/api/version/%s/%s/%s", a.serverURL, programID, channel, version)
 	req, err := http.NewRequest("DELETE", url, nil)
@@ -127,3 +199,98 @@ func (a *UpdateAdmin) ListVersions(programID, channel string) ([]VersionInfo, er
 
 	return result.Versions, nil
 }
+
+// PublishVersion 发布草稿版本，或按 release.PublishAt 定时发布
+func (a *UpdateAdmin) PublishVersion(programID, channel, version string, release ReleaseOptions) error {
+	body, err := json.Marshal(release)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/publish?channel=%s", a.serverURL, programID, version, channel)
+	if err := a.post(url, body); err != nil {
+		return fmt.Errorf("publish failed: %w", err)
+	}
+
+	switch {
+	case release.PublishAt != "":
+		fmt.Printf("Version %s/%s/%s scheduled for %s\n", programID, channel, version, release.PublishAt)
+	case release.Status == "draft":
+		fmt.Printf("Version %s/%s/%s moved back to draft\n", programID, channel, version)
+	default:
+		fmt.Printf("Version %s/%s/%s published\n", programID, channel, version)
+	}
+	return nil
+}
+
+func (a *UpdateAdmin) YankVersion(programID, channel, version, reason string) error {
+	body, err := json.Marshal(map[string]string{"reason": reason})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/yank?channel=%s", a.serverURL, programID, version, channel)
+	if err := a.post(url, body); err != nil {
+		return fmt.Errorf("yank failed: %w", err)
+	}
+
+	fmt.Printf("Version %s/%s/%s yanked: %s\n", programID, channel, version, reason)
+	return nil
+}
+
+func (a *UpdateAdmin) UnyankVersion(programID, channel, version string) error {
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/unyank?channel=%s", a.serverURL, programID, version, channel)
+	if err := a.post(url, nil); err != nil {
+		return fmt.Errorf("unyank failed: %w", err)
+	}
+
+	fmt.Printf("Version %s/%s/%s unyanked\n", programID, channel, version)
+	return nil
+}
+
+func (a *UpdateAdmin) PromoteVersion(programID, version, fromChannel, toChannel string) error {
+	req := map[string]interface{}{"from": fromChannel, "to": toChannel}
+	if a.signingKey != "" {
+		// 签名覆盖通道，晋升时需为目标通道重新签名
+		signature, artifactSignatures, err := a.promoteSignatures(programID, version, fromChannel, toChannel)
+		if err != nil {
+			return fmt.Errorf("failed to sign: %w", err)
+		}
+		req["signature"] = signature
+		req["artifactSignatures"] = artifactSignatures
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/promote", a.serverURL, programID, version)
+	if err := a.post(url, body); err != nil {
+		return fmt.Errorf("promote failed: %w", err)
+	}
+
+	fmt.Printf("Version %s/%s promoted from %s to %s\n", programID, version, fromChannel, toChannel)
+	return nil
+}
+
+// post 发送带认证的 JSON POST 请求
+func (a *UpdateAdmin) post(url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 分片上传默认参数
+const (
+	defaultChunkThreshold = 64 << 20 // 超过该大小的文件自动使用分片上传
+	defaultChunkSize      = 8 << 20
+	chunkRetries          = 3
+)
+
+// uploadSession 服务端上传会话状态
+type uploadSession struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	TotalSize  int64  `json:"totalSize"`
+	ChunkSize  int64  `json:"chunkSize"`
+	ChunkCount int    `json:"chunkCount"`
+	Missing    []int  `json:"missing"`
+}
+
+// uploadState 本地记录的会话信息，中断后重新执行同一命令即可续传
+type uploadState struct {
+	SessionID string `json:"sessionId"`
+	ProgramID string `json:"programId"`
+	Channel   string `json:"channel"`
+	Version   string `json:"version"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// UploadVersionChunked 通过上传会话分片上传版本，支持断点续传
+func (a *UpdateAdmin) UploadVersionChunked(programID, channel, version, filePath, notes string, mandatory bool, release ReleaseOptions) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	fileHash, err := hashFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	signature, err := a.signFile(programID, channel, version, "", filePath, mandatory)
+	if err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+
+	statePath := filePath + ".upload"
+	session := a.resumeSession(statePath, programID, channel, version, info.Size(), fileHash)
+	if session == nil {
+		session, err = a.createSession(programID, channel, version, info.Size(), fileHash, notes, mandatory, signature, release)
+		if err != nil {
+			return err
+		}
+		state := uploadState{SessionID: session.ID, ProgramID: programID, Channel: channel, Version: version, Size: info.Size(), SHA256: fileHash}
+		if data, err := json.Marshal(state); err == nil {
+			_ = os.WriteFile(statePath, data, 0644)
+		}
+	} else {
+		fmt.Printf("Resuming upload session %s (%d of %d chunks missing)\n", session.ID, len(session.Missing), session.ChunkCount)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	for n, index := range session.Missing {
+		offset := int64(index) * session.ChunkSize
+		size := session.ChunkSize
+		if remaining := session.TotalSize - offset; remaining < size {
+			size = remaining
+		}
+		chunk := make([]byte, size)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return err
+		}
+
+		if err := a.putChunk(programID, session.ID, index, chunk); err != nil {
+			return fmt.Errorf("chunk %d failed (run the same command again to resume): %w", index, err)
+		}
+		fmt.Printf("\r  Uploaded chunk %d/%d", n+1, len(session.Missing))
+	}
+	fmt.Println()
+
+	url := fmt.Sprintf("%s/api/programs/%s/uploads/%s/complete", a.serverURL, programID, session.ID)
+	if err := a.post(url, nil); err != nil {
+		return fmt.Errorf("complete upload failed: %w", err)
+	}
+	os.Remove(statePath)
+
+	fmt.Printf("Version %s/%s/%s uploaded successfully\n", programID, channel, version)
+	return nil
+}
+
+// resumeSession 读取本地会话记录，会话仍有效且对应同一文件时返回服务端状态
+func (a *UpdateAdmin) resumeSession(statePath, programID, channel, version string, size int64, fileHash string) *uploadSession {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var state uploadState
+	if json.Unmarshal(data, &state) != nil ||
+		state.ProgramID != programID || state.Channel != channel || state.Version != version ||
+		state.Size != size || state.SHA256 != fileHash {
+		return nil
+	}
+
+	session, err := a.getSession(programID, state.SessionID)
+	if err != nil || session.Status != "active" {
+		return nil
+	}
+	return session
+}
+
+func (a *UpdateAdmin) createSession(programID, channel, version string, size int64, fileHash, notes string, mandatory bool, signature string, release ReleaseOptions) (*uploadSession, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"channel":   channel,
+		"version":   version,
+		"totalSize": size,
+		"chunkSize": a.chunkSize,
+		"sha256":    fileHash,
+		"notes":     notes,
+		"mandatory": mandatory,
+		"signature": signature,
+		"status":    release.Status,
+		"publishAt": release.PublishAt,
+
+		"minUpgradeFrom": release.MinUpgradeFrom,
+		"requiredStep":   release.RequiredStep,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/api/programs/%s/uploads", a.serverURL, programID)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	return a.doSession(req, http.StatusCreated)
+}
+
+func (a *UpdateAdmin) getSession(programID, sessionID string) (*uploadSession, error) {
+	url := fmt.Sprintf("%s/api/programs/%s/uploads/%s", a.serverURL, programID, sessionID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	return a.doSession(req, http.StatusOK)
+}
+
+func (a *UpdateAdmin) doSession(req *http.Request, wantStatus int) (*uploadSession, error) {
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, fmt.Errorf("upload session request failed with status %d: %s", resp.StatusCode, e.Error)
+	}
+
+	var session uploadSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// putChunk 上传单个分片，失败时重试
+func (a *UpdateAdmin) putChunk(programID, sessionID string, index int, chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+	url := fmt.Sprintf("%s/api/programs/%s/uploads/%s/chunks/%d", a.serverURL, programID, sessionID, index)
+
+	var lastErr error
+	for attempt := 0; attempt < chunkRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+
+		req, err := http.NewRequest("PUT", url, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Authorization", "Bearer "+a.token)
+		req.Header.Set("X-Chunk-SHA256", hex.EncodeToString(sum[:]))
+
+		resp, err := a.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		lastErr = fmt.Errorf("status %d", resp.StatusCode)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusUnprocessableEntity {
+			return lastErr // 请求本身有误，重试无意义
+		}
+	}
+	return lastErr
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -19,7 +20,7 @@ var rootCmd = &cobra.Command{
 }
 
 var uploadCmd = &cobra.Command{
-	Use:   "upload --channel <stable|beta> --version <version> --file <path>",
+	Use:   "upload --channel <stable|beta> --version <version> (--file <path> | --artifact <os-arch>=<path>...)",
 	Short: "Upload a new version",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -28,9 +29,37 @@ var uploadCmd = &cobra.Command{
 		filePath, _ := cmd.Flags().GetString("file")
 		notes, _ := cmd.Flags().GetString("notes")
 		mandatory, _ := cmd.Flags().GetBool("mandatory")
+		artifactFlags, _ := cmd.Flags().GetStringArray("artifact")
+		chunkThresholdMB, _ := cmd.Flags().GetInt64("chunk-threshold-mb")
+		chunkSizeMB, _ := cmd.Flags().GetInt64("chunk-size-mb")
+		draft, _ := cmd.Flags().GetBool("draft")
+		publishAt, _ := cmd.Flags().GetString("publish-at")
+		minUpgradeFrom, _ := cmd.Flags().GetString("min-upgrade-from")
+		requiredStep, _ := cmd.Flags().GetBool("required-step")
+
+		artifacts := make(map[string]string)
+		for _, a := range artifactFlags {
+			platform, path, ok := strings.Cut(a, "=")
+			if !ok || platform == "" || path == "" {
+				fmt.Fprintf(os.Stderr, "Error: invalid --artifact %q, expected os-arch[-variant]=path\n", a)
+				os.Exit(1)
+			}
+			artifacts[platform] = path
+		}
+		if filePath == "" && len(artifacts) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: --file or at least one --artifact is required")
+			os.Exit(1)
+		}
 
 		admin := NewUpdateAdmin(serverURL, token)
-		if err := admin.UploadVersion(programID, channel, version, filePath, notes, mandatory); err != nil {
+		admin.chunkThreshold = chunkThresholdMB << 20
+		admin.chunkSize = chunkSizeMB << 20
+		setSigningKey(cmd, admin)
+		release := ReleaseOptions{PublishAt: publishAt, MinUpgradeFrom: minUpgradeFrom, RequiredStep: requiredStep}
+		if draft {
+			release.Status = "draft"
+		}
+		if err := admin.UploadVersion(programID, channel, version, filePath, artifacts, notes, mandatory, release); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -53,6 +82,112 @@ var deleteCmd = &cobra.Command{
 	},
 }
 
+var publishCmd = &cobra.Command{
+	Use:   "publish --channel <stable|beta> --version <version> [--publish-at <time>]",
+	Short: "Publish a draft version now or at a scheduled time",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		channel, _ := cmd.Flags().GetString("channel")
+		version, _ := cmd.Flags().GetString("version")
+		publishAt, _ := cmd.Flags().GetString("publish-at")
+		draft, _ := cmd.Flags().GetBool("draft")
+
+		release := ReleaseOptions{PublishAt: publishAt}
+		if draft {
+			release.Status = "draft"
+		}
+		admin := NewUpdateAdmin(serverURL, token)
+		if err := admin.PublishVersion(programID, channel, version, release); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var yankCmd = &cobra.Command{
+	Use:   "yank --channel <stable|beta> --version <version> --reason <text>",
+	Short: "Withdraw a version without deleting it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		channel, _ := cmd.Flags().GetString("channel")
+		version, _ := cmd.Flags().GetString("version")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		admin := NewUpdateAdmin(serverURL, token)
+		if err := admin.YankVersion(programID, channel, version, reason); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var unyankCmd = &cobra.Command{
+	Use:   "unyank --channel <stable|beta> --version <version>",
+	Short: "Restore a withdrawn version",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		channel, _ := cmd.Flags().GetString("channel")
+		version, _ := cmd.Flags().GetString("version")
+
+		admin := NewUpdateAdmin(serverURL, token)
+		if err := admin.UnyankVersion(programID, channel, version); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote --version <version> --from <beta> --to <stable>",
+	Short: "Promote a version to another channel without re-uploading",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		version, _ := cmd.Flags().GetString("version")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+
+		admin := NewUpdateAdmin(serverURL, token)
+		setSigningKey(cmd, admin)
+		if err := admin.PromoteVersion(programID, version, from, to); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen --out <file>",
+	Short: "Generate an Ed25519 key pair for signing releases",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+
+		publicKey, err := generateSigningKey(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Private key written to %s (keep it offline, never upload it)\n", out)
+		fmt.Printf("Public key written to %s.pub\n\n", out)
+		fmt.Printf("Public key: %s\n", publicKey)
+		fmt.Println("Register it on the server with PUT /api/admin/programs/<id>/signing-key")
+	},
+}
+
+// setSigningKey 读取 --signing-key 指定的私钥
+func setSigningKey(cmd *cobra.Command, admin *UpdateAdmin) {
+	path, _ := cmd.Flags().GetString("signing-key")
+	if path == "" {
+		return
+	}
+	key, err := loadSigningKey(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	admin.signingKey = key
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list [--channel <stable|beta>]",
 	Short: "List versions",
@@ -77,26 +212,70 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:8080", "Server URL")
 	rootCmd.PersistentFlags().StringVar(&token, "token", "", "API token (required)")
 	rootCmd.PersistentFlags().StringVar(&programID, "program-id", "", "Program ID (required)")
-	rootCmd.MarkPersistentFlagRequired("token")
-	rootCmd.MarkPersistentFlagRequired("program-id")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// keygen 在本地离线执行，不需要连接服务端
+		if cmd == keygenCmd {
+			return nil
+		}
+		if token == "" || programID == "" {
+			return fmt.Errorf(`required flag(s) "program-id", "token" not set`)
+		}
+		return nil
+	}
 
 	uploadCmd.Flags().String("channel", "", "Channel (stable/beta)")
 	uploadCmd.Flags().String("version", "", "Version number")
 	uploadCmd.Flags().String("file", "", "File path")
 	uploadCmd.Flags().String("notes", "", "Release notes")
 	uploadCmd.Flags().Bool("mandatory", false, "Mandatory update")
+	uploadCmd.Flags().StringArray("artifact", nil, "Platform artifact as os-arch[-variant]=path (repeatable)")
+	uploadCmd.Flags().Int64("chunk-threshold-mb", defaultChunkThreshold>>20, "Use resumable chunked upload for files larger than this (MB)")
+	uploadCmd.Flags().Int64("chunk-size-mb", defaultChunkSize>>20, "Chunk size for chunked uploads (MB)")
+	uploadCmd.Flags().String("signing-key", "", "Private key file for signing the release manifest")
+	uploadCmd.Flags().Bool("draft", false, "Upload as a draft, visible only to upload and admin tokens")
+	uploadCmd.Flags().String("publish-at", "", "Publish automatically at this RFC 3339 time (e.g. 2025-01-31T09:00:00+08:00)")
+	uploadCmd.Flags().String("min-upgrade-from", "", "Oldest version that can upgrade directly to this one; older clients are routed through an intermediate version")
+	uploadCmd.Flags().Bool("required-step", false, "Mark this version as a required stepping stone that older clients must install first")
 	uploadCmd.MarkFlagRequired("channel")
 	uploadCmd.MarkFlagRequired("version")
-	uploadCmd.MarkFlagRequired("file")
 
 	deleteCmd.Flags().String("channel", "", "Channel (stable/beta)")
 	deleteCmd.Flags().String("version", "", "Version number")
 	deleteCmd.MarkFlagRequired("channel")
 	deleteCmd.MarkFlagRequired("version")
 
+	publishCmd.Flags().String("channel", "", "Channel (stable/beta)")
+	publishCmd.Flags().String("version", "", "Version number")
+	publishCmd.Flags().String("publish-at", "", "Schedule publication at this RFC 3339 time instead of publishing now")
+	publishCmd.Flags().Bool("draft", false, "Move the version back to draft")
+	publishCmd.MarkFlagRequired("channel")
+	publishCmd.MarkFlagRequired("version")
+
+	yankCmd.Flags().String("channel", "", "Channel (stable/beta)")
+	yankCmd.Flags().String("version", "", "Version number")
+	yankCmd.Flags().String("reason", "", "Reason shown to installed clients")
+	yankCmd.MarkFlagRequired("channel")
+	yankCmd.MarkFlagRequired("version")
+	yankCmd.MarkFlagRequired("reason")
+
+	unyankCmd.Flags().String("channel", "", "Channel (stable/beta)")
+	unyankCmd.Flags().String("version", "", "Version number")
+	unyankCmd.MarkFlagRequired("channel")
+	unyankCmd.MarkFlagRequired("version")
+
+	promoteCmd.Flags().String("version", "", "Version number")
+	promoteCmd.Flags().String("from", "", "Source channel (e.g. beta)")
+	promoteCmd.Flags().String("to", "", "Target channel (e.g. stable)")
+	promoteCmd.MarkFlagRequired("version")
+	promoteCmd.MarkFlagRequired("from")
+	promoteCmd.Flags().String("signing-key", "", "Private key file for signing the promoted release manifest")
+	promoteCmd.MarkFlagRequired("to")
+
+	keygenCmd.Flags().String("out", "signing.key", "Private key output file (public key is written to <out>.pub)")
+
 	listCmd.Flags().String("channel", "", "Channel filter (stable/beta)")
 
-	rootCmd.AddCommand(uploadCmd, deleteCmd, listCmd)
+	rootCmd.AddCommand(uploadCmd, deleteCmd, publishCmd, yankCmd, unyankCmd, promoteCmd, listCmd, keygenCmd)
 }
 
 func main() {
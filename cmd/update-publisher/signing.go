@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"docufiller-update-server/internal/signing"
+)
+
+// loadSigningKey 读取 keygen 生成的私钥文件
+func loadSigningKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signing key: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if _, err := signing.ParsePrivateKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// generateSigningKey 生成签名密钥对：私钥写入 path，公钥写入 path.pub 并返回
+func generateSigningKey(path string) (string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s already exists, refusing to overwrite", path)
+	}
+	publicKey, privateKey, err := signing.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(privateKey+"\n"), 0600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".pub", []byte(publicKey+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return publicKey, nil
+}
+
+// signFile 对本地文件的发布清单签名；未配置私钥时返回空签名
+func (a *UpdateAdmin) signFile(programID, channel, version, platform, filePath string, mandatory bool) (string, error) {
+	if a.signingKey == "" {
+		return "", nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	fileHash, err := hashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return signing.Sign(a.signingKey, signing.Manifest{
+		ProgramID: programID,
+		Version:   version,
+		Channel:   channel,
+		Platform:  platform,
+		FileHash:  fileHash,
+		FileSize:  info.Size(),
+		Mandatory: mandatory,
+	})
+}
+
+// mainArtifactPath 只上传平台制品时服务端以排序后的第一个制品作为主文件
+func mainArtifactPath(artifacts map[string]string) string {
+	keys := make([]string, 0, len(artifacts))
+	for key := range artifacts {
+		keys = append(keys, normalizePlatform(key))
+	}
+	sort.Strings(keys)
+	for key, path := range artifacts {
+		if normalizePlatform(key) == keys[0] {
+			return path
+		}
+	}
+	return ""
+}
+
+func normalizePlatform(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// versionDetail 服务端版本详情中签名所需的字段
+type versionDetail struct {
+	ProgramID string `json:"programId"`
+	Version   string `json:"version"`
+	FileHash  string `json:"fileHash"`
+	FileSize  int64  `json:"fileSize"`
+	Mandatory bool   `json:"mandatory"`
+	Artifacts []struct {
+		OS       string `json:"os"`
+		Arch     string `json:"arch"`
+		Variant  string `json:"variant"`
+		FileHash string `json:"fileHash"`
+		FileSize int64  `json:"fileSize"`
+	} `json:"artifacts"`
+}
+
+// promoteSignatures 按目标通道重新签名来源版本的主文件和各平台制品
+//
+// 签名基于服务端返回的哈希和大小，发布前应确认来源版本本身已通过签名校验。
+func (a *UpdateAdmin) promoteSignatures(programID, version, fromChannel, toChannel string) (string, map[string]string, error) {
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/%s", a.serverURL, programID, fromChannel, version)
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", nil, fmt.Errorf("failed to get source version: status %d", resp.StatusCode)
+	}
+
+	var detail versionDetail
+	if err := json.NewDecoder(resp.Body).Decode(&detail); err != nil {
+		return "", nil, err
+	}
+
+	m := signing.Manifest{
+		ProgramID: programID,
+		Version:   version,
+		Channel:   toChannel,
+		FileHash:  detail.FileHash,
+		FileSize:  detail.FileSize,
+		Mandatory: detail.Mandatory,
+	}
+	signature, err := signing.Sign(a.signingKey, m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	artifactSignatures := make(map[string]string, len(detail.Artifacts))
+	for _, art := range detail.Artifacts {
+		platform := art.OS + "-" + art.Arch
+		if art.Variant != "" {
+			platform += "-" + art.Variant
+		}
+		am := m
+		am.Platform = platform
+		am.FileHash = art.FileHash
+		am.FileSize = art.FileSize
+		if artifactSignatures[platform], err = signing.Sign(a.signingKey, am); err != nil {
+			return "", nil, err
+		}
+	}
+	return signature, artifactSignatures, nil
+}
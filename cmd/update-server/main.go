@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -49,6 +50,21 @@ func main() {
 	if err := database.AutoMigrate(db); err != nil {
 		logger.Fatalf("Failed to migrate database: %v", err)
 	}
+	if keys, tokens, err := service.CountPlaintextSecrets(db); err == nil && keys+tokens > 0 {
+		logger.Warnf("Database stores %d encryption keys and %d tokens in plaintext, run migrate-secrets to convert it", keys, tokens)
+	}
+
+	// 首次启动时将 config.yaml 中的管理员账号迁移到用户表
+	userSvc := service.NewUserService(db)
+	if created, err := userSvc.BootstrapFromConfig(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+		logger.Fatalf("Failed to migrate admin credentials: %v", err)
+	} else if created {
+		logger.Infof("Admin user %q migrated from config as owner, admin.password can now be removed from config.yaml", cfg.Admin.Username)
+	} else if count, err := userSvc.CountUsers(); err == nil && count == 0 {
+		logger.Warnf("No admin users exist, run create-user to create the first owner")
+	} else if cfg.Admin.Password != "" {
+		logger.Warnf("admin.password in config.yaml is no longer used for login, remove it")
+	}
 
 	// 初始化认证中间件
 	tokenSvc := service.NewTokenService(db)
@@ -56,6 +72,7 @@ func main() {
 
 	// 初始化加密服务
 	cryptoSvc := service.NewCryptoService(cfg.Crypto.MasterKey)
+	cryptoSvc.SetKeyStore(service.NewProgramService(db))
 	cryptoMiddleware := middleware.NewCryptoMiddleware(cryptoSvc)
 
 	// 设置 Gin
@@ -63,18 +80,25 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.Default()
+	// 客户端 IP 用于登录限流，只信任配置的反向代理传来的 X-Forwarded-For
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatalf("Invalid server.trustedProxies: %v", err)
+	}
 
 	// 添加 Session 中间件
+	// Cookie 中只保存服务端会话 Token，会话状态在 admin_sessions 表中
 	store := cookie.NewStore([]byte(cfg.Crypto.MasterKey))
-	store.Options(sessions.Options{
-		MaxAge:   3600 * 12, // 12 hours
+	sessionOptions := sessions.Options{
+		MaxAge:   int(service.SessionTTL.Seconds()),
 		Path:     "/",
-		Domain:   "",         // 留空表示当前域名
-		Secure:   false,      // HTTP 使用 false，HTTPS 使用 true
+		Domain:   "",    // 留空表示当前域名
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode, // 允许跨站点导航时发送 cookie
-	})
+	}
+	store.Options(sessionOptions)
 	r.Use(sessions.Sessions("admin-session", store))
+	// 通过 HTTPS 访问时自动设置 Secure
+	r.Use(handler.SecureSessionCookie(sessionOptions))
 
 	// 加载 HTML 模板 (使用嵌入的文件系统)
 	r.LoadHTMLFS(http.FS(web.Files), "*.html")
@@ -84,12 +108,20 @@ func main() {
 
 	// 初始化服务
 	storageService := service.NewStorageService(cfg.Storage.BasePath)
+	tokenSvc.SetSecretBox(cryptoSvc.SecretBox())
+	userSvc.SetSecretBox(cryptoSvc.SecretBox())
+	userSvc.SetTOTPRequired(cfg.Admin.RequireTOTP)
 	programService := service.NewProgramService(db)
+	programService.SetSecretBox(cryptoSvc.SecretBox())
 	versionService := service.NewVersionService(db, storageService)
+	versionService.SetSecretBox(cryptoSvc.SecretBox())
 	clientPackagerService := service.NewClientPackager(programService, cfg)
 
 	// 初始化 handlers
-	authHandler := handler.NewAuthHandler(cfg)
+	sessionSvc := service.NewSessionService(db)
+	loginThrottle := service.NewLoginThrottle(db)
+	authHandler := handler.NewAuthHandler(cfg, userSvc, sessionSvc, loginThrottle)
+	userHandler := handler.NewUserHandler(userSvc, sessionSvc)
 
 	adminHandler := handler.NewAdminHandler(
 		programService,
@@ -98,6 +130,54 @@ func main() {
 		clientPackagerService,
 	)
 
+	versionHandler := handler.NewVersionHandler(db, storageService)
+	versionHandler.SetMaxFileSize(cfg.Storage.MaxFileSize)
+	versionHandler.SetSecretBox(cryptoSvc.SecretBox())
+	uploadHandler := handler.NewUploadHandler(db, storageService, cfg.Storage.MaxFileSize)
+	uploadHandler.SetSecretBox(cryptoSvc.SecretBox())
+
+	// 定期清理过期的分片上传会话
+	go func() {
+		for range time.Tick(time.Hour) {
+			if n, err := uploadHandler.CleanupExpired(); err != nil {
+				logger.Warnf("Failed to clean up upload sessions: %v", err)
+			} else if n > 0 {
+				logger.Infof("Removed %d expired upload sessions", n)
+			}
+		}
+	}()
+
+	// 定期清理过期的登录会话和登录失败记录
+	go func() {
+		for range time.Tick(time.Hour) {
+			if n, err := sessionSvc.CleanupExpired(); err != nil {
+				logger.Warnf("Failed to clean up admin sessions: %v", err)
+			} else if n > 0 {
+				logger.Infof("Removed %d expired admin sessions", n)
+			}
+			if _, err := loginThrottle.Cleanup(); err != nil {
+				logger.Warnf("Failed to clean up login attempts: %v", err)
+			}
+		}
+	}()
+
+	// 定时发布：到期的 scheduled 版本转为 published
+	go func() {
+		publishDue := func() {
+			published, err := versionService.PublishDueVersions(time.Now())
+			if err != nil {
+				logger.Warnf("Failed to publish scheduled versions: %v", err)
+			}
+			for _, v := range published {
+				logger.Infof("Scheduled version published: %s/%s/%s (publishAt: %s)", v.ProgramID, v.Channel, v.Version, v.PublishAt.Format(time.RFC3339))
+			}
+		}
+		publishDue()
+		for range time.Tick(30 * time.Second) {
+			publishDue()
+		}
+	}()
+
 	// 根路径直接重定向到管理后台
 	r.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/admin")
@@ -111,10 +191,11 @@ func main() {
 		})
 	})
 	r.POST("/admin/login", authHandler.Login)
+	r.POST("/admin/login/totp", authHandler.LoginTOTP)
 
 	// Admin 页面路由 - 需要认证
 	adminGroup := r.Group("/admin")
-	adminGroup.Use(handler.AuthMiddleware())
+	adminGroup.Use(handler.AuthMiddleware(userSvc, sessionSvc))
 	{
 		adminGroup.GET("", func(c *gin.Context) {
 			c.HTML(http.StatusOK, "admin.html", gin.H{
@@ -125,32 +206,79 @@ func main() {
 		adminGroup.POST("/logout", authHandler.Logout)
 	}
 
-	// Admin API 路由 - 需要认证
+	// Admin API 路由 - 需要登录会话或管理 Token（Authorization: Bearer，见 gen-token）
+	// 会话用户按角色检查权限：读请求需要 read，写请求需要对路由中程序的 write，其余见 RequirePermission
+	requireOwner := handler.RequirePermission(service.PermOwner)
+	requireWrite := handler.RequirePermission(service.PermWrite)
 	adminAPI := r.Group("/api/admin")
-	adminAPI.Use(handler.AuthMiddleware())
+	adminAPI.Use(authMiddleware.RequireAdminOr(handler.AuthMiddleware(userSvc, sessionSvc)))
 	{
+		// 当前用户
+		adminAPI.GET("/me", userHandler.GetCurrentUser)
+		adminAPI.PUT("/me/password", userHandler.ChangePassword)
+		adminAPI.GET("/me/sessions", userHandler.ListMySessions)
+		adminAPI.DELETE("/me/sessions", userHandler.RevokeMyOtherSessions)
+		adminAPI.DELETE("/me/sessions/:sessionId", userHandler.RevokeMySession)
+		adminAPI.POST("/me/totp", userHandler.BeginTOTP)
+		adminAPI.POST("/me/totp/verify", userHandler.ConfirmTOTP)
+		adminAPI.POST("/me/totp/recovery-codes", userHandler.RegenerateRecoveryCodes)
+		adminAPI.DELETE("/me/totp", userHandler.DisableTOTP)
+
+		// 用户管理
+		adminAPI.GET("/users", requireOwner, userHandler.ListUsers)
+		adminAPI.POST("/users", requireOwner, userHandler.CreateUser)
+		adminAPI.PUT("/users/:username", requireOwner, userHandler.UpdateUser)
+		adminAPI.DELETE("/users/:username", requireOwner, userHandler.DeleteUser)
+		adminAPI.POST("/users/:username/reset-password", requireOwner, userHandler.ResetPassword)
+		adminAPI.DELETE("/users/:username/totp", requireOwner, userHandler.ResetTOTP)
+		adminAPI.GET("/users/:username/sessions", requireOwner, userHandler.ListUserSessions)
+		adminAPI.DELETE("/users/:username/sessions", requireOwner, userHandler.RevokeUserSessions)
+
 		// 统计信息
 		adminAPI.GET("/stats", adminHandler.GetStats)
 
 		// 程序管理
 		adminAPI.GET("/programs", adminHandler.ListPrograms)
-		adminAPI.POST("/programs", adminHandler.CreateProgram)
+		adminAPI.POST("/programs", requireOwner, adminHandler.CreateProgram)
 		adminAPI.GET("/programs/:programId", adminHandler.GetProgramDetail)
-		adminAPI.DELETE("/programs/:programId", adminHandler.DeleteProgram)
+		adminAPI.PUT("/programs/:programId", adminHandler.UpdateProgram)
+		adminAPI.DELETE("/programs/:programId", requireOwner, adminHandler.DeleteProgram)
 
 		// 版本管理
 		adminAPI.GET("/programs/:programId/versions", adminHandler.ListVersions)
-		adminAPI.DELETE("/programs/:programId/versions/:version", adminHandler.DeleteVersion)
+		adminAPI.PUT("/programs/:programId/versions/:version", adminHandler.UpdateVersion)
+		adminAPI.DELETE("/programs/:programId/versions/:version", versionHandler.DeleteVersion)
+		adminAPI.PUT("/programs/:programId/versions/:version/rollout", adminHandler.SetRollout)
+		adminAPI.POST("/programs/:programId/versions/:version/yank", versionHandler.YankVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/unyank", versionHandler.UnyankVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/publish", versionHandler.PublishVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/promote", versionHandler.PromoteVersion)
 
 		// 客户端包
-		adminAPI.GET("/programs/:programId/client/publish", adminHandler.DownloadPublishClient)
-		adminAPI.GET("/programs/:programId/client/update", adminHandler.DownloadUpdateClient)
+		adminAPI.GET("/programs/:programId/client/publish", requireWrite, adminHandler.DownloadPublishClient)
+		adminAPI.GET("/programs/:programId/client/update", requireWrite, adminHandler.DownloadUpdateClient)
 
 		// Token 管理
 		adminAPI.POST("/programs/:programId/tokens/regenerate", adminHandler.RegenerateToken)
+		adminAPI.GET("/programs/:programId/tokens", adminHandler.ListTokens)
+		adminAPI.POST("/programs/:programId/tokens", adminHandler.CreateToken)
+		adminAPI.GET("/programs/:programId/tokens/:tokenId", adminHandler.GetTokenDetail)
+		adminAPI.DELETE("/programs/:programId/tokens/:tokenId", adminHandler.RevokeToken)
+
+		// 管理 Token
+		adminAPI.GET("/tokens", requireOwner, adminHandler.ListAdminTokens)
+		adminAPI.POST("/tokens", requireOwner, adminHandler.CreateAdminToken)
+		adminAPI.DELETE("/tokens/:tokenId", requireOwner, adminHandler.RevokeAdminToken)
 
 		// 加密密钥管理
 		adminAPI.POST("/programs/:programId/encryption/regenerate", adminHandler.RegenerateEncryptionKey)
+		adminAPI.GET("/programs/:programId/encryption/keys", adminHandler.ListEncryptionKeys)
+		adminAPI.PUT("/programs/:programId/encryption", adminHandler.SetPackageEncryption)
+
+		// 发布签名公钥
+		adminAPI.PUT("/programs/:programId/signing-key", adminHandler.SetSigningKey)
+		adminAPI.GET("/programs/:programId/channels/:channel/policy", adminHandler.GetChannelPolicy)
+		adminAPI.PUT("/programs/:programId/channels/:channel/policy", adminHandler.SetChannelPolicy)
 	}
 
 	// 公开 API 路由
@@ -159,24 +287,36 @@ func main() {
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
-		public.GET("/programs/:programId/versions/latest", handler.NewVersionHandler(db).GetLatestVersion)
-		public.GET("/programs/:programId/versions", handler.NewVersionHandler(db).GetVersionList)
-		public.GET("/programs/:programId/versions/:channel/:version", handler.NewVersionHandler(db).GetVersionDetail)
+		public.GET("/programs/:programId/versions/latest", authMiddleware.OptionalAuth(), versionHandler.GetLatestVersion)
+		public.GET("/programs/:programId/versions", versionHandler.GetVersionList)
+		public.GET("/programs/:programId/versions/:channel/:version", authMiddleware.OptionalAuth(), versionHandler.GetVersionDetail)
 	}
 
 	// 认证路由 - 下载
 	download := r.Group("/api")
-	download.Use(authMiddleware.RequireDownload())
+	download.Use(authMiddleware.RequireDownloadOrUpload())
 	{
-		download.GET("/programs/:programId/download/:channel/:version", handler.NewVersionHandler(db).DownloadFile)
+		download.GET("/programs/:programId/download/:channel/:version", versionHandler.DownloadFile)
+		download.GET("/programs/:programId/patches/:channel/:version/:from", versionHandler.DownloadPatch)
 	}
 
 	// 认证路由 - 上传
 	upload := r.Group("/api")
 	upload.Use(authMiddleware.RequireUpload())
 	{
-		upload.POST("/programs/:programId/versions", handler.NewVersionHandler(db).UploadVersion)
-		upload.DELETE("/programs/:programId/versions/:version", handler.NewVersionHandler(db).DeleteVersion)
+		upload.POST("/programs/:programId/versions", versionHandler.UploadVersion)
+		upload.DELETE("/programs/:programId/versions/:version", versionHandler.DeleteVersion)
+		upload.POST("/programs/:programId/versions/:version/yank", versionHandler.YankVersion)
+		upload.POST("/programs/:programId/versions/:version/unyank", versionHandler.UnyankVersion)
+		upload.POST("/programs/:programId/versions/:version/promote", versionHandler.PromoteVersion)
+		upload.POST("/programs/:programId/versions/:version/publish", versionHandler.PublishVersion)
+
+		// 分片上传
+		upload.POST("/programs/:programId/uploads", uploadHandler.CreateSession)
+		upload.GET("/programs/:programId/uploads/:uploadId", uploadHandler.GetSession)
+		upload.PUT("/programs/:programId/uploads/:uploadId/chunks/:index", uploadHandler.UploadChunk)
+		upload.POST("/programs/:programId/uploads/:uploadId/complete", uploadHandler.CompleteSession)
+		upload.DELETE("/programs/:programId/uploads/:uploadId", uploadHandler.AbortSession)
 	}
 
 	// 向后兼容路由 - 映射到 docufiller
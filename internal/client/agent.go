@@ -0,0 +1,476 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// agent 的安装策略
+const (
+	AgentPolicyAuto   = "auto"   // 下载并在维护时间段内自动安装
+	AgentPolicyNotify = "notify" // 下载后执行 notify_command，由用户决定何时安装
+	AgentPolicyManual = "manual" // 只检查并记录可用版本
+)
+
+// minCheckInterval 检查间隔下限，避免配置错误时频繁请求服务端
+const minCheckInterval = time.Minute
+
+// AgentState agent 的持久化状态，重启后继续
+type AgentState struct {
+	InstalledVersion string           `json:"installedVersion,omitempty"` // agent 安装成功的版本，优先于 program.current_version
+	LastCheck        time.Time        `json:"lastCheck"`
+	NextCheck        time.Time        `json:"nextCheck"`
+	LastError        string           `json:"lastError,omitempty"`
+	Available        *AvailableUpdate `json:"available,omitempty"`       // 最近一次检查发现的新版本
+	Staged           *StagedUpdate    `json:"staged,omitempty"`          // 已下载并校验、等待安装的版本
+	NotifiedVersion  string           `json:"notifiedVersion,omitempty"` // 已执行过 notify_command 的版本
+	FailedVersion    string           `json:"failedVersion,omitempty"`   // 安装失败（已回滚）的版本，不再自动重试
+	LastApply        *ApplyRecord     `json:"lastApply,omitempty"`
+}
+
+// AvailableUpdate 可用的新版本
+type AvailableUpdate struct {
+	Version       string `json:"version"`
+	TargetVersion string `json:"targetVersion,omitempty"` // 升级路径的最终版本（Version 为下一跳时）
+	Mandatory     bool   `json:"mandatory"`
+	ReleaseNotes  string `json:"releaseNotes,omitempty"`
+}
+
+// StagedUpdate 已下载的更新包
+type StagedUpdate struct {
+	Version   string `json:"version"`
+	File      string `json:"file"`
+	Mandatory bool   `json:"mandatory"`
+}
+
+// ApplyRecord 最近一次安装结果
+type ApplyRecord struct {
+	Version    string    `json:"version"`
+	Time       time.Time `json:"time"`
+	Success    bool      `json:"success"`
+	RolledBack bool      `json:"rolledBack,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Agent 常驻更新代理：按间隔检查更新，后台下载，并按策略自动安装或等待用户安装
+//
+// 强制更新（mandatory）不受策略和维护时间段限制，下载后立即安装。
+type Agent struct {
+	config    *Config
+	checker   *UpdateChecker
+	installer *Installer
+	windows   []maintenanceWindow
+	logger    *log.Logger
+	state     AgentState
+	now       func() time.Time
+}
+
+// NewAgent 创建 agent 并加载上次保存的状态
+func NewAgent(config *Config, logger *log.Logger) (*Agent, error) {
+	switch config.Agent.Policy {
+	case AgentPolicyAuto, AgentPolicyNotify, AgentPolicyManual:
+	default:
+		return nil, fmt.Errorf("invalid agent.policy %q: must be auto, notify or manual", config.Agent.Policy)
+	}
+	if config.Agent.Policy == AgentPolicyAuto && config.Install.Dir == "" {
+		return nil, fmt.Errorf("agent.policy auto requires install.dir")
+	}
+	windows, err := parseMaintenanceWindows(config.Agent.MaintenanceWindows)
+	if err != nil {
+		return nil, err
+	}
+
+	// agent 不输出到终端，检查器使用 JSON 模式以关闭进度和提示输出
+	checker := NewUpdateChecker(config, true)
+	installer := NewInstaller(config.Install)
+	installer.SetLogger(logger)
+
+	a := &Agent{
+		config:    config,
+		checker:   checker,
+		installer: installer,
+		windows:   windows,
+		logger:    logger,
+		now:       time.Now,
+	}
+	if err := a.loadState(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// State 返回当前状态
+func (a *Agent) State() AgentState {
+	return a.state
+}
+
+// Run 持续运行直到 ctx 取消；由 systemd 启动（Type=notify）时发送 READY、STATUS 和 WATCHDOG 通知
+func (a *Agent) Run(ctx context.Context) error {
+	if a.state.NextCheck.IsZero() {
+		// 首次运行也随机推迟，避免大量客户端同时启动时集中请求
+		a.state.NextCheck = a.now().Add(a.jitter())
+	}
+	a.logger.Printf("Update agent started (policy %s, current version %s, next check %s)",
+		a.config.Agent.Policy, a.currentVersion(), a.state.NextCheck.Format(time.RFC3339))
+	sdNotify("READY=1")
+	defer sdNotify("STOPPING=1")
+
+	// 下载、安装钩子和健康检查可能持续较长时间，watchdog 在单独的 goroutine 中发送，不受 tick 阻塞
+	if interval := sdWatchdogInterval(); interval > 0 {
+		watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+		defer stopWatchdog()
+		go runWatchdog(watchdogCtx, interval)
+	}
+
+	for {
+		a.tick(ctx)
+
+		timer := time.NewTimer(a.untilNextWake())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.logger.Printf("Update agent stopped")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// runWatchdog 每隔 interval 发送 WATCHDOG=1，直到 ctx 取消
+func runWatchdog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// tick 到达检查时间时检查更新，有待安装且满足条件的版本时安装
+//
+// ctx 取消时中断进行中的检查和下载；已开始的安装会完成（或回滚），避免留下不完整的安装目录。
+func (a *Agent) tick(ctx context.Context) {
+	if !a.now().Before(a.state.NextCheck) {
+		a.check(ctx)
+	}
+	if ctx.Err() == nil && a.applyDue() {
+		a.applyStaged()
+	}
+	if err := a.saveState(); err != nil {
+		a.logger.Printf("Failed to save agent state: %v", err)
+	}
+	sdNotify("STATUS=" + a.statusLine())
+}
+
+// check 检查更新，按策略下载新版本
+func (a *Agent) check(ctx context.Context) {
+	now := a.now()
+	current := a.currentVersion()
+	checker := a.checker.withContext(ctx)
+
+	info, err := checker.CheckUpdate(current)
+	if err == nil && info != nil {
+		err = checker.VerifyUpdateInfo(info)
+	}
+	if ctx.Err() != nil {
+		// 停止时中断的检查不计入状态，下次启动重新检查
+		return
+	}
+	a.state.LastCheck = now
+	a.state.NextCheck = now.Add(a.interval() + a.jitter())
+	if err != nil {
+		a.state.LastError = err.Error()
+		a.logger.Printf("Update check failed: %v", err)
+		return
+	}
+	a.state.LastError = ""
+	if info == nil || (current != "" && CompareVersions(info.Version, current) <= 0) {
+		a.state.Available = nil
+		a.logger.Printf("Up to date (%s)", current)
+		return
+	}
+
+	a.state.Available = &AvailableUpdate{
+		Version:       info.Version,
+		TargetVersion: info.LatestVersion,
+		Mandatory:     info.Mandatory,
+		ReleaseNotes:  info.ReleaseNotes,
+	}
+	if info.Version == a.state.FailedVersion {
+		a.logger.Printf("Version %s is available but failed to install before, waiting for a newer version", info.Version)
+		return
+	}
+	if a.config.Agent.Policy == AgentPolicyManual && !info.Mandatory {
+		a.logger.Printf("Version %s is available (policy manual, not downloading)", info.Version)
+		return
+	}
+
+	if !a.isStaged(info.Version) {
+		path := a.checker.generateOutputPath(info.Version)
+		a.logger.Printf("Downloading %s", info.Version)
+		if _, _, err := checker.downloadVerified(info.Version, path); err != nil {
+			if ctx.Err() != nil {
+				// 已下载部分保留，下次检查时续传
+				a.logger.Printf("Download of %s interrupted", info.Version)
+				return
+			}
+			a.state.LastError = err.Error()
+			a.logger.Printf("Download of %s failed: %v", info.Version, err)
+			return
+		}
+		a.state.Staged = &StagedUpdate{Version: info.Version, File: path}
+		a.logger.Printf("Downloaded %s to %s", info.Version, path)
+	}
+	a.state.Staged.Mandatory = info.Mandatory
+
+	if a.config.Agent.Policy == AgentPolicyNotify && !info.Mandatory && a.state.NotifiedVersion != info.Version {
+		a.notify(ctx, info)
+	}
+}
+
+// isStaged 版本是否已下载且文件仍存在
+func (a *Agent) isStaged(version string) bool {
+	if a.state.Staged == nil || a.state.Staged.Version != version {
+		return false
+	}
+	_, err := os.Stat(a.state.Staged.File)
+	return err == nil
+}
+
+// notify 执行 notify_command 通知用户有新版本可安装
+func (a *Agent) notify(ctx context.Context, info *UpdateInfo) {
+	a.state.NotifiedVersion = info.Version
+	a.logger.Printf("Version %s is ready to install", info.Version)
+	if len(a.config.Agent.NotifyCommand) == 0 {
+		return
+	}
+	env := []string{
+		"UPDATE_OLD_VERSION=" + a.currentVersion(),
+		"UPDATE_NEW_VERSION=" + info.Version,
+		"UPDATE_PACKAGE_FILE=" + a.state.Staged.File,
+		"UPDATE_RELEASE_NOTES=" + info.ReleaseNotes,
+	}
+	output, err := runCommandContext(ctx, a.config.Agent.NotifyCommand, "", "", env, defaultHookTimeout)
+	if err != nil {
+		a.logger.Printf("Notify command failed: %v", commandError(err, output))
+	}
+}
+
+// applyDue 已下载的版本是否应当现在安装：强制更新立即安装，auto 策略在维护时间段内安装
+func (a *Agent) applyDue() bool {
+	staged := a.state.Staged
+	if staged == nil || staged.Version == a.state.FailedVersion {
+		return false
+	}
+	if staged.Mandatory {
+		return true
+	}
+	return a.config.Agent.Policy == AgentPolicyAuto && a.inMaintenanceWindow(a.now())
+}
+
+// applyStaged 安装已下载的版本；失败（已回滚）的版本记录下来，不再自动重试
+func (a *Agent) applyStaged() {
+	staged := a.state.Staged
+	if a.config.Install.Dir == "" {
+		a.logger.Printf("Mandatory update %s is downloaded but install.dir is not configured", staged.Version)
+		return
+	}
+
+	a.installer.SetCurrentVersion(a.currentVersion())
+	result, err := a.installer.Apply(staged.File, staged.Version)
+	record := &ApplyRecord{Version: staged.Version, Time: a.now(), Success: err == nil}
+	a.state.LastApply = record
+	if err != nil {
+		record.Error = err.Error()
+		record.RolledBack = result != nil && result.RolledBack
+		a.state.FailedVersion = staged.Version
+		a.logger.Printf("Installing %s failed: %v", staged.Version, err)
+		return
+	}
+
+	a.logger.Printf("Installed %s", staged.Version)
+	a.state.InstalledVersion = staged.Version
+	a.state.Staged = nil
+	// 升级路径上还有后续版本时立即继续检查
+	if available := a.state.Available; available != nil && available.TargetVersion != "" && available.TargetVersion != staged.Version {
+		a.state.NextCheck = a.now()
+	}
+	a.state.Available = nil
+}
+
+// untilNextWake 距下次检查或维护时间段开始（有待自动安装的版本时）的时长
+func (a *Agent) untilNextWake() time.Duration {
+	now := a.now()
+	next := a.state.NextCheck
+	if a.state.Staged != nil && a.state.Staged.Version != a.state.FailedVersion && a.config.Agent.Policy == AgentPolicyAuto {
+		if start, ok := nextWindowStart(a.windows, now); ok && start.Before(next) {
+			next = start
+		}
+	}
+	if d := next.Sub(now); d > time.Second {
+		return d
+	}
+	return time.Second
+}
+
+// currentVersion 当前安装的版本：agent 安装过的版本优先，否则取配置
+func (a *Agent) currentVersion() string {
+	if a.state.InstalledVersion != "" {
+		return a.state.InstalledVersion
+	}
+	return a.config.Program.CurrentVersion
+}
+
+func (a *Agent) interval() time.Duration {
+	interval := time.Duration(a.config.Agent.CheckInterval) * time.Second
+	if interval < minCheckInterval {
+		return minCheckInterval
+	}
+	return interval
+}
+
+func (a *Agent) jitter() time.Duration {
+	if a.config.Agent.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(a.config.Agent.Jitter) * int64(time.Second)))
+}
+
+// inMaintenanceWindow 未配置维护时间段时任何时间都可以安装
+func (a *Agent) inMaintenanceWindow(t time.Time) bool {
+	if len(a.windows) == 0 {
+		return true
+	}
+	for _, w := range a.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusLine systemd STATUS 显示的一行状态
+func (a *Agent) statusLine() string {
+	switch {
+	case a.state.Staged != nil && a.state.Staged.Version != a.state.FailedVersion:
+		return fmt.Sprintf("Version %s downloaded, waiting to install", a.state.Staged.Version)
+	case a.state.Available != nil:
+		return fmt.Sprintf("Version %s available", a.state.Available.Version)
+	case a.state.LastError != "":
+		return "Last check failed: " + a.state.LastError
+	default:
+		return fmt.Sprintf("Up to date (%s), next check %s", a.currentVersion(), a.state.NextCheck.Format("2006-01-02 15:04"))
+	}
+}
+
+func (a *Agent) loadState() error {
+	if a.config.Agent.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(a.config.Agent.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read agent state: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.state); err != nil {
+		// 状态文件损坏时重新开始，不阻止 agent 启动
+		a.logger.Printf("Ignoring corrupt agent state %s: %v", a.config.Agent.StateFile, err)
+		a.state = AgentState{}
+	}
+	return nil
+}
+
+// saveState 先写临时文件再重命名，避免中断时留下不完整的状态文件
+func (a *Agent) saveState() error {
+	path := a.config.Agent.StateFile
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// maintenanceWindow 每天的本地时间段（分钟），end 小于 start 表示跨午夜
+type maintenanceWindow struct {
+	start, end int
+}
+
+// parseMaintenanceWindows 解析 "HH:MM-HH:MM" 格式的时间段
+func parseMaintenanceWindows(specs []string) ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid maintenance window %q: expected HH:MM-HH:MM", spec)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid maintenance window %q: start equals end", spec)
+		}
+		windows = append(windows, maintenanceWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w maintenanceWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// nextWindowStart 严格晚于 now 的最近一个时间段开始时间
+func nextWindowStart(windows []maintenanceWindow, now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, w := range windows {
+		start := time.Date(now.Year(), now.Month(), now.Day(), w.start/60, w.start%60, 0, 0, now.Location())
+		if !start.After(now) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next, !next.IsZero()
+}
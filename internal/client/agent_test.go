@@ -0,0 +1,293 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// agentTestServer 模拟服务端：latest 返回 version，下载返回 pkg
+func agentTestServer(t *testing.T, version string, pkg []byte, mandatory *bool) *httptest.Server {
+	t.Helper()
+	sum := sha256.Sum256(pkg)
+	hash := hex.EncodeToString(sum[:])
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/versions/latest"):
+			json.NewEncoder(w).Encode(UpdateInfo{
+				ProgramID: "testapp",
+				Version:   version,
+				Channel:   "stable",
+				FileSize:  int64(len(pkg)),
+				FileHash:  hash,
+				Mandatory: *mandatory,
+			})
+		case strings.HasPrefix(r.URL.Path, "/api/download/"):
+			w.Header().Set("ETag", `"`+hash+`"`)
+			http.ServeContent(w, r, "pkg.zip", time.Time{}, bytes.NewReader(pkg))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func newTestAgent(t *testing.T, root, serverURL, policy string) *Agent {
+	t.Helper()
+	config := DefaultConfig()
+	config.ServerURL = serverURL
+	config.ProgramID = "testapp"
+	config.Program.CurrentVersion = "1.0.0"
+	config.Program.InstallIDFile = filepath.Join(root, "install.id")
+	config.Auth.KeyringFile = ""
+	config.Auth.TokenFile = ""
+	config.Download.SavePath = filepath.Join(root, "updates")
+	config.Install.Dir = filepath.Join(root, "app")
+	config.Agent.Policy = policy
+	config.Agent.Jitter = 0
+	config.Agent.StateFile = filepath.Join(root, "agent.state")
+
+	agent, err := NewAgent(config, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewAgent failed: %v", err)
+	}
+	return agent
+}
+
+func agentPackage(t *testing.T, root, version string) []byte {
+	t.Helper()
+	path := filepath.Join(root, "src-"+version+".zip")
+	writeZip(t, path, map[string]string{"version.txt": version})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAgentAutoPolicy(t *testing.T) {
+	root := t.TempDir()
+	mandatory := false
+	srv := agentTestServer(t, "2.0.0", agentPackage(t, root, "2.0.0"), &mandatory)
+	defer srv.Close()
+
+	agent := newTestAgent(t, root, srv.URL, AgentPolicyAuto)
+	agent.tick(context.Background())
+
+	state := agent.State()
+	if state.InstalledVersion != "2.0.0" || state.LastApply == nil || !state.LastApply.Success {
+		t.Fatalf("state = %+v, want 2.0.0 installed", state)
+	}
+	if got := readInstalled(t, filepath.Join(root, "app"), "version.txt"); got != "2.0.0" {
+		t.Errorf("installed version = %q", got)
+	}
+	if !state.NextCheck.After(state.LastCheck) {
+		t.Errorf("next check %v should be after last check %v", state.NextCheck, state.LastCheck)
+	}
+
+	// 重启后沿用已安装版本和下次检查时间
+	restarted := newTestAgent(t, root, srv.URL, AgentPolicyAuto)
+	if restarted.currentVersion() != "2.0.0" {
+		t.Errorf("current version after restart = %s, want 2.0.0", restarted.currentVersion())
+	}
+	if !restarted.State().NextCheck.Equal(state.NextCheck) {
+		t.Errorf("next check after restart = %v, want %v", restarted.State().NextCheck, state.NextCheck)
+	}
+}
+
+func TestAgentMaintenanceWindow(t *testing.T) {
+	root := t.TempDir()
+	mandatory := false
+	srv := agentTestServer(t, "2.0.0", agentPackage(t, root, "2.0.0"), &mandatory)
+	defer srv.Close()
+
+	agent := newTestAgent(t, root, srv.URL, AgentPolicyAuto)
+	agent.windows, _ = parseMaintenanceWindows([]string{"02:00-04:00"})
+	agent.config.Agent.CheckInterval = 24 * 3600
+	noon := time.Date(2026, 1, 10, 12, 0, 0, 0, time.Local)
+	agent.now = func() time.Time { return noon }
+
+	// 维护时间段外只下载，并在时间段开始时醒来
+	agent.tick(context.Background())
+	if agent.State().Staged == nil || agent.State().InstalledVersion != "" {
+		t.Fatalf("state = %+v, want staged but not installed", agent.State())
+	}
+	if wake := agent.untilNextWake(); wake != 14*time.Hour {
+		t.Errorf("next wake in %v, want 14h", wake)
+	}
+
+	agent.now = func() time.Time { return noon.Add(14 * time.Hour) }
+	agent.tick(context.Background())
+	if agent.State().InstalledVersion != "2.0.0" {
+		t.Errorf("state = %+v, want installed inside the window", agent.State())
+	}
+}
+
+func TestAgentNotifyAndManualPolicy(t *testing.T) {
+	root := t.TempDir()
+	mandatory := false
+	srv := agentTestServer(t, "2.0.0", agentPackage(t, root, "2.0.0"), &mandatory)
+	defer srv.Close()
+
+	// manual：只记录可用版本
+	manual := newTestAgent(t, root, srv.URL, AgentPolicyManual)
+	manual.config.Agent.StateFile = ""
+	manual.tick(context.Background())
+	if manual.State().Available == nil || manual.State().Staged != nil {
+		t.Errorf("manual state = %+v, want available but not staged", manual.State())
+	}
+
+	// notify：下载并执行 notify_command，不安装
+	agent := newTestAgent(t, root, srv.URL, AgentPolicyNotify)
+	marker := filepath.Join(root, "notified")
+	if runtime.GOOS != "windows" {
+		agent.config.Agent.NotifyCommand = []string{"sh", "-c", `echo "$UPDATE_NEW_VERSION" > "` + marker + `"`}
+	}
+	agent.tick(context.Background())
+	state := agent.State()
+	if state.Staged == nil || state.InstalledVersion != "" || state.NotifiedVersion != "2.0.0" {
+		t.Fatalf("notify state = %+v, want staged and notified", state)
+	}
+	if runtime.GOOS != "windows" {
+		if data, _ := os.ReadFile(marker); strings.TrimSpace(string(data)) != "2.0.0" {
+			t.Errorf("notify command output = %q", data)
+		}
+	}
+
+	// 强制更新不受策略限制，立即安装
+	mandatory = true
+	agent.state.NextCheck = time.Time{}
+	agent.tick(context.Background())
+	if agent.State().InstalledVersion != "2.0.0" {
+		t.Errorf("state = %+v, want mandatory update installed", agent.State())
+	}
+}
+
+func TestAgentRunWatchdogAndStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a Unix datagram socket")
+	}
+	root := t.TempDir()
+
+	// 模拟 systemd 的通知套接字
+	socket := filepath.Join(root, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+	notifications := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			notifications <- string(buf[:n])
+		}
+	}()
+
+	// 下载一直挂起，直到请求被取消
+	downloading := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/versions/latest"):
+			json.NewEncoder(w).Encode(UpdateInfo{ProgramID: "testapp", Version: "2.0.0", FileSize: 1024, FileHash: strings.Repeat("0", 64)})
+		case strings.HasPrefix(r.URL.Path, "/api/download/"):
+			downloading <- struct{}{}
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, root, srv.URL, AgentPolicyAuto)
+	agent.state.NextCheck = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- agent.Run(ctx) }()
+
+	select {
+	case <-downloading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("download did not start")
+	}
+
+	// 下载阻塞期间仍持续发送 WATCHDOG=1
+	for len(notifications) > 0 {
+		<-notifications
+	}
+	deadline := time.After(2 * time.Second)
+	for pings := 0; pings < 2; {
+		select {
+		case n := <-notifications:
+			if n == "WATCHDOG=1" {
+				pings++
+			}
+		case <-deadline:
+			t.Fatal("no watchdog notifications while the download is blocked")
+		}
+	}
+
+	// 取消 ctx 中断进行中的下载
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after ctx was cancelled during a download")
+	}
+	if agent.state.LastError != "" || agent.state.Staged != nil {
+		t.Errorf("interrupted download should not be recorded, state = %+v", agent.state)
+	}
+}
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	windows, err := parseMaintenanceWindows([]string{"22:30-02:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(h, m int) time.Time { return time.Date(2026, 1, 10, h, m, 0, 0, time.Local) }
+	for _, tc := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{day(22, 30), true},
+		{day(23, 59), true},
+		{day(1, 59), true},
+		{day(2, 0), false},
+		{day(12, 0), false},
+	} {
+		if got := windows[0].contains(tc.t); got != tc.want {
+			t.Errorf("contains(%s) = %v, want %v", tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+	if next, _ := nextWindowStart(windows, day(23, 0)); !next.Equal(day(22, 30).AddDate(0, 0, 1)) {
+		t.Errorf("next window start = %v", next)
+	}
+
+	for _, bad := range []string{"02:00", "25:00-03:00", "03:00-03:00"} {
+		if _, err := parseMaintenanceWindows([]string{bad}); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
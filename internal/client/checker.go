@@ -1,11 +1,17 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"runtime"
 	"time"
+
+	"docufiller-update-server/internal/pkgcrypt"
 )
 
 // UpdateChecker 更新检查器
@@ -15,6 +21,15 @@ type UpdateChecker struct {
 	jsonOutput  bool
 	maxRetries  int
 	daemonState *DaemonState // Daemon 状态管理器
+	installID   string       // 安装 ID（延迟加载）
+
+	keyring      pkgcrypt.Keyring // 包加密密钥（延迟加载，见 LoadKeyring）
+	currentKeyID string
+	token        string // 认证 Token（延迟加载，见 LoadToken）
+
+	installLog *log.Logger // 安装钩子输出写入的日志（logging.file，延迟打开）
+
+	ctx context.Context // 请求所用的 context，取消时中断进行中的请求（见 withContext）
 }
 
 // NewUpdateChecker 创建更新检查器
@@ -38,6 +53,14 @@ type CheckResult struct {
 	ReleaseNotes   string `json:"releaseNotes,omitempty"`
 	PublishDate    string `json:"publishDate,omitempty"`
 	Mandatory      bool   `json:"mandatory"`
+
+	CurrentYanked     bool   `json:"currentYanked,omitempty"`
+	CurrentYankReason string `json:"currentYankReason,omitempty"`
+
+	// LatestVersion 为升级路径上的下一跳时，TargetVersion 为最终目标版本
+	TargetVersion       string `json:"targetVersion,omitempty"`
+	MinSupportedVersion string `json:"minSupportedVersion,omitempty"`
+	BelowMinSupported   bool   `json:"belowMinSupported,omitempty"`
 }
 
 // Check 检查更新并输出结果
@@ -50,11 +73,7 @@ func (c *UpdateChecker) Check(currentVersion string) error {
 	if info == nil {
 		// No update available
 		if c.jsonOutput {
-			result := &CheckResult{
-				HasUpdate:     false,
-				LatestVersion: currentVersion,
-			}
-			return json.NewEncoder(os.Stdout).Encode(result)
+			return json.NewEncoder(os.Stdout).Encode(newCheckResult(nil, currentVersion))
 		}
 		fmt.Printf("✓ Already up to date (version %s)\n", currentVersion)
 		return nil
@@ -66,9 +85,27 @@ func (c *UpdateChecker) Check(currentVersion string) error {
 // CheckUpdate 检查是否有新版本（internal method）
 func (c *UpdateChecker) CheckUpdate(currentVersion string) (*UpdateInfo, error) {
 	url := fmt.Sprintf("%s/api/programs/%s/versions/latest?channel=%s",
-		c.config.ServerURL, c.config.GetProgramID(), "stable") // TODO: support channel
+		c.config.ServerURL, c.config.GetProgramID(), c.channel())
+	if currentVersion != "" {
+		url += "&current=" + neturl.QueryEscape(currentVersion)
+	}
 
-	resp, err := c.httpClient.Get(url)
+	req, err := c.newRequest(http.MethodGet, url)
+	if err != nil {
+		return nil, &UpdateError{
+			Code:    "REQUEST_ERROR",
+			Message: "Failed to build request",
+			Err:     err,
+		}
+	}
+	if installID := c.getInstallID(); installID != "" {
+		req.Header.Set("X-Install-ID", installID)
+	}
+	if _, keyID := c.getKeyring(); keyID != "" {
+		req.Header.Set("X-Encryption-Key-ID", keyID)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, &UpdateError{
 			Code:    "NETWORK_ERROR",
@@ -101,27 +138,111 @@ func (c *UpdateChecker) CheckUpdate(currentVersion string) (*UpdateInfo, error)
 		}
 	}
 
-	// Check if version is newer
-	if currentVersion != "" && CompareVersions(info.Version, currentVersion) <= 0 {
+	// 密钥轮换：保存新密钥，失败不影响本次检查（宽限期内旧密钥仍可用）
+	if info.KeyUpdate != nil {
+		if err := c.applyKeyUpdate(info.KeyUpdate); err != nil && !c.jsonOutput {
+			fmt.Printf("  ⚠ Failed to apply encryption key update: %v\n", err)
+		}
+	}
+
+	// Token 轮换：保存新 Token，失败不影响本次检查（宽限期内旧 Token 仍可用）
+	if info.TokenUpdate != nil {
+		if err := c.applyTokenUpdate(info.TokenUpdate); err != nil && !c.jsonOutput {
+			fmt.Printf("  ⚠ Failed to apply token update: %v\n", err)
+		}
+	}
+
+	// Check if version is newer（当前版本已被撤回时仍返回，以便提示用户）
+	if currentVersion != "" && CompareVersions(info.Version, currentVersion) <= 0 && !info.CurrentYanked {
 		return nil, nil
 	}
 
 	return &info, nil
 }
 
+// channel 请求更新的通道，未配置时为 stable
+func (c *UpdateChecker) channel() string {
+	if c.config.Channel != "" {
+		return c.config.Channel
+	}
+	return "stable"
+}
+
+// newRequest 创建携带认证令牌和客户端平台信息的请求
+func (c *UpdateChecker) newRequest(method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.context(), method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.getToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("X-Client-OS", runtime.GOOS)
+	req.Header.Set("X-Client-Arch", runtime.GOARCH)
+	if c.config.Program.Variant != "" {
+		req.Header.Set("X-Client-Variant", c.config.Program.Variant)
+	}
+	return req, nil
+}
+
+// withContext 返回使用 ctx 发送请求的检查器副本，用于可暂停、取消的下载
+func (c *UpdateChecker) withContext(ctx context.Context) *UpdateChecker {
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+func (c *UpdateChecker) context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// getInstallID 获取持久化的安装 ID，失败时返回空（仅能获取全量发布的版本）
+func (c *UpdateChecker) getInstallID() string {
+	if c.installID != "" {
+		return c.installID
+	}
+	if c.config.Program.InstallIDFile == "" {
+		return ""
+	}
+	id, err := LoadOrCreateInstallID(c.config.Program.InstallIDFile)
+	if err != nil {
+		return ""
+	}
+	c.installID = id
+	return id
+}
+
+// newCheckResult 把检查结果转换为 JSON 输出格式，info 为 nil 表示已是最新版本
+func newCheckResult(info *UpdateInfo, currentVersion string) *CheckResult {
+	if info == nil {
+		return &CheckResult{
+			HasUpdate:     false,
+			LatestVersion: currentVersion,
+		}
+	}
+	return &CheckResult{
+		HasUpdate:           currentVersion == "" || CompareVersions(info.Version, currentVersion) > 0,
+		CurrentVersion:      currentVersion,
+		LatestVersion:       info.Version,
+		FileSize:            info.FileSize,
+		ReleaseNotes:        info.ReleaseNotes,
+		PublishDate:         info.PublishDate.Format(time.RFC3339),
+		Mandatory:           info.Mandatory,
+		CurrentYanked:       info.CurrentYanked,
+		CurrentYankReason:   info.CurrentYankReason,
+		TargetVersion:       info.LatestVersion,
+		MinSupportedVersion: info.MinSupportedVersion,
+		BelowMinSupported:   info.BelowMinSupported,
+	}
+}
+
 // outputResult 输出检查结果
 func (c *UpdateChecker) outputResult(info *UpdateInfo, currentVersion string) error {
 	if c.jsonOutput {
-		result := &CheckResult{
-			HasUpdate:     true,
-			CurrentVersion: currentVersion,
-			LatestVersion:  info.Version,
-			FileSize:      info.FileSize,
-			ReleaseNotes:  info.ReleaseNotes,
-			PublishDate:   info.PublishDate.Format(time.RFC3339),
-			Mandatory:     info.Mandatory,
-		}
-		return json.NewEncoder(os.Stdout).Encode(result)
+		return json.NewEncoder(os.Stdout).Encode(newCheckResult(info, currentVersion))
 	}
 
 	// Human-readable output
@@ -129,7 +250,17 @@ func (c *UpdateChecker) outputResult(info *UpdateInfo, currentVersion string) er
 	if currentVersion != "" {
 		fmt.Printf("  Current version: %s\n", currentVersion)
 	}
-	fmt.Printf("  Latest version: %s\n", info.Version)
+	if info.CurrentYanked {
+		fmt.Printf("  ⚠ Current version has been withdrawn: %s\n", info.CurrentYankReason)
+	}
+	if info.BelowMinSupported {
+		fmt.Printf("  ⚠ Current version is below the minimum supported version %s, update is required\n", info.MinSupportedVersion)
+	}
+	if info.LatestVersion != "" {
+		fmt.Printf("  Next version: %s (upgrade path to %s)\n", info.Version, info.LatestVersion)
+	} else {
+		fmt.Printf("  Latest version: %s\n", info.Version)
+	}
 	if currentVersion != "" && CompareVersions(info.Version, currentVersion) > 0 {
 		fmt.Printf("\n  New version available!\n")
 	}
@@ -14,6 +14,9 @@ type Config struct {
 	Program  ProgramConfig  `yaml:"program"`
 	Auth     AuthConfig     `yaml:"auth"`
 	Download DownloadConfig `yaml:"download"`
+	Install  InstallConfig  `yaml:"install"`
+	Agent    AgentConfig    `yaml:"agent"`
+	Daemon   DaemonConfig   `yaml:"daemon"`
 	Logging  LoggingConfig  `yaml:"logging"`
 
 	// Deprecated fields for backward compatibility
@@ -33,11 +36,17 @@ type ServerConfig struct {
 type ProgramConfig struct {
 	ID             string `yaml:"id"`
 	CurrentVersion string `yaml:"current_version"`
+	InstallIDFile  string `yaml:"install_id_file"` // 安装 ID 持久化文件，用于灰度发布分组
+	Variant        string `yaml:"variant"`         // 平台制品变体（可选），os/arch 取自运行时
 }
 
 type AuthConfig struct {
-	Token         string `yaml:"token"`
-	EncryptionKey string `yaml:"encryption_key"`
+	Token            string   `yaml:"token"`
+	TokenFile        string   `yaml:"token_file"` // 保存 Token 轮换时收到的新 Token，优先于 token
+	EncryptionKey    string   `yaml:"encryption_key"`
+	EncryptionKeys   []string `yaml:"encryption_keys"`    // 轮换宽限期内仍需使用的其他密钥（base64）
+	KeyringFile      string   `yaml:"keyring_file"`       // 保存更新检查时收到的新密钥
+	SigningPublicKey string   `yaml:"signing_public_key"` // 发布签名公钥（base64），配置后拒绝未签名或签名无效的版本
 }
 
 type DownloadConfig struct {
@@ -47,6 +56,32 @@ type DownloadConfig struct {
 	AutoVerify bool   `yaml:"auto_verify"`
 }
 
+// InstallConfig apply/rollback 命令的安装配置
+type InstallConfig struct {
+	Dir                string   `yaml:"dir"`                  // 程序安装目录，apply 用新版本整体替换
+	PreviousDir        string   `yaml:"previous_dir"`         // 保留的上一版本目录（默认 <dir>.previous），rollback 从此恢复
+	HealthCheck        []string `yaml:"health_check"`         // 替换后执行的健康检查命令（如 ["app.exe", "--self-test"]），退出码非 0 时自动回滚
+	HealthCheckTimeout int      `yaml:"health_check_timeout"` // 健康检查超时（秒）
+}
+
+// AgentConfig agent 命令（常驻更新代理）的配置
+type AgentConfig struct {
+	CheckInterval      int      `yaml:"check_interval"`      // 检查间隔（秒）
+	Jitter             int      `yaml:"jitter"`              // 每次检查随机推迟 0~jitter 秒，避免所有客户端同时请求
+	Policy             string   `yaml:"policy"`              // auto | notify | manual
+	MaintenanceWindows []string `yaml:"maintenance_windows"` // 允许自动安装的本地时间段（如 "02:00-05:00"），为空表示任何时间
+	NotifyCommand      []string `yaml:"notify_command"`      // notify 策略下新版本下载完成后执行的命令
+	StateFile          string   `yaml:"state_file"`          // 持久化状态文件，重启后继续
+}
+
+// DaemonConfig daemon 命令和 download --daemon 的本地控制 API 配置
+type DaemonConfig struct {
+	Listen      string `yaml:"listen"`       // TCP 监听地址（默认 127.0.0.1，仅本机可访问）
+	Socket      string `yaml:"socket"`       // Unix 域套接字路径，配置后代替 TCP 端口
+	TokenFile   string `yaml:"token_file"`   // 每次启动生成的访问令牌写入此文件（权限 0600）
+	HistoryFile string `yaml:"history_file"` // 检查、下载、安装记录
+}
+
 type LoggingConfig struct {
 	Level string `yaml:"level"`
 	File  string `yaml:"file"`
@@ -60,7 +95,12 @@ func DefaultConfig() *Config {
 			Timeout: 30,
 		},
 		Program: ProgramConfig{
-			ID: "",
+			ID:            "",
+			InstallIDFile: "update-client.id",
+		},
+		Auth: AuthConfig{
+			KeyringFile: "update-client.keys",
+			TokenFile:   "update-client.token",
 		},
 		Download: DownloadConfig{
 			SavePath:   "./updates",
@@ -68,6 +108,20 @@ func DefaultConfig() *Config {
 			Keep:       3,
 			AutoVerify: true,
 		},
+		Install: InstallConfig{
+			HealthCheckTimeout: 60,
+		},
+		Agent: AgentConfig{
+			CheckInterval: 6 * 3600,
+			Jitter:        600,
+			Policy:        AgentPolicyNotify,
+			StateFile:     "update-agent.state",
+		},
+		Daemon: DaemonConfig{
+			Listen:      "127.0.0.1",
+			TokenFile:   "update-client.daemon-token",
+			HistoryFile: "update-client.history",
+		},
 		Logging: LoggingConfig{
 			Level: "info",
 			File:  "update-client.log",
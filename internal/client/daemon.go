@@ -1,31 +1,44 @@
 package client
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 // DaemonState 下载状态
 type DaemonState struct {
-	State    string         `json:"state"`     // idle | downloading | completed | error
-	Version  string         `json:"version"`
-	File     string         `json:"file"`
-	Progress *ProgressInfo  `json:"progress,omitempty"`
-	Error    string         `json:"error,omitempty"`
-	mu       sync.RWMutex
+	State             string        `json:"state"` // idle | downloading | paused | cancelled | completed | applying | installed | error
+	Version           string        `json:"version"`
+	File              string        `json:"file"`
+	Progress          *ProgressInfo `json:"progress,omitempty"`
+	Error             string        `json:"error,omitempty"`
+	resumedFrom       int64
+	events            *eventLog // GET /events 推送的事件
+	lastProgressEvent time.Time
+	mu                sync.RWMutex
 }
 
 // ProgressInfo 进度信息（用于 JSON 输出）
 type ProgressInfo struct {
-	Downloaded int64   `json:"downloaded"`
-	Total      int64   `json:"total"`
-	Percentage float64 `json:"percentage"`
-	Speed      int64   `json:"speed"`
+	Downloaded  int64   `json:"downloaded"`
+	Total       int64   `json:"total"`
+	Percentage  float64 `json:"percentage"`
+	Speed       int64   `json:"speed"`
+	Resumed     bool    `json:"resumed,omitempty"`     // 是否为断点续传
+	ResumedFrom int64   `json:"resumedFrom,omitempty"` // 续传起始字节
 }
 
 // NewDaemonState 创建新的状态管理器
@@ -33,6 +46,7 @@ func NewDaemonState(version string) *DaemonState {
 	return &DaemonState{
 		State:   "idle",
 		Version: version,
+		events:  newEventLog(),
 	}
 }
 
@@ -40,7 +54,10 @@ func NewDaemonState(version string) *DaemonState {
 func (d *DaemonState) SetState(state string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.State = state
+	if d.State != state {
+		d.State = state
+		d.events.publish(EventState, d.toJSON())
+	}
 }
 
 // GetState 获取状态（线程安全）
@@ -50,6 +67,25 @@ func (d *DaemonState) GetState() string {
 	return d.State
 }
 
+// SetVersion 设置当前处理的版本（控制 API 下载其他版本时）
+func (d *DaemonState) SetVersion(version string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Version = version
+}
+
+// Reset 切换到新状态并清除文件、进度和错误（如取消下载后）
+func (d *DaemonState) Reset(state string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.State = state
+	d.File = ""
+	d.Progress = nil
+	d.Error = ""
+	d.resumedFrom = 0
+	d.events.publish(EventState, d.toJSON())
+}
+
 // SetProgress 设置进度
 func (d *DaemonState) SetProgress(downloaded, total int64, speed float64) {
 	d.mu.Lock()
@@ -61,10 +97,28 @@ func (d *DaemonState) SetProgress(downloaded, total int64, speed float64) {
 	}
 
 	d.Progress = &ProgressInfo{
-		Downloaded: downloaded,
-		Total:      total,
-		Percentage: percentage,
-		Speed:      int64(speed),
+		Downloaded:  downloaded,
+		Total:       total,
+		Percentage:  percentage,
+		Speed:       int64(speed),
+		Resumed:     d.resumedFrom > 0,
+		ResumedFrom: d.resumedFrom,
+	}
+
+	// 每个数据块都会更新进度，事件按间隔限频，下载完成时总是发送
+	if now := time.Now(); now.Sub(d.lastProgressEvent) >= progressEventInterval || downloaded == total {
+		d.lastProgressEvent = now
+		d.events.publish(EventProgress, d.Progress)
+	}
+}
+
+// SetResumedFrom 记录本次下载的续传起始位置（0 表示从头下载）
+func (d *DaemonState) SetResumedFrom(offset int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resumedFrom = offset
+	if offset > 0 && d.Progress == nil {
+		d.Progress = &ProgressInfo{Downloaded: offset, Resumed: true, ResumedFrom: offset}
 	}
 }
 
@@ -72,9 +126,34 @@ func (d *DaemonState) SetProgress(downloaded, total int64, speed float64) {
 func (d *DaemonState) SetCompleted(filePath string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	changed := d.State != "completed" || d.File != filePath
 	d.State = "completed"
 	d.File = filePath
 	d.Error = "" // Clear any previous error
+	if changed {
+		d.events.publish(EventState, d.toJSON())
+	}
+}
+
+// SetVerified 发送下载包哈希校验结果事件
+func (d *DaemonState) SetVerified(filePath string, verified bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events.publish(EventVerified, map[string]interface{}{
+		"version":  d.Version,
+		"file":     filePath,
+		"verified": verified,
+	})
+}
+
+// SetDecrypted 发送加密包已解密事件
+func (d *DaemonState) SetDecrypted(filePath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events.publish(EventDecrypted, map[string]interface{}{
+		"version": d.Version,
+		"file":    filePath,
+	})
 }
 
 // SetError 设置错误状态
@@ -87,13 +166,32 @@ func (d *DaemonState) SetError(err error) {
 	defer d.mu.Unlock()
 	d.State = "error"
 	d.Error = err.Error()
+	d.events.publish(EventError, map[string]interface{}{
+		"state":   d.State,
+		"version": d.Version,
+		"error":   d.Error,
+	})
+}
+
+// Subscribe 订阅状态事件，返回需先发送的事件、后续事件通道和取消订阅函数
+//
+// lastEventID 为客户端收到的最后一个事件 ID（Last-Event-ID），仍在缓冲区内时补发之后的事件，
+// 否则先发送一个当前状态快照。通道被关闭表示订阅者跟不上事件，应重新连接。
+func (d *DaemonState) Subscribe(lastEventID int64) ([]DaemonEvent, <-chan DaemonEvent, func()) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.events.subscribe(lastEventID, func() interface{} { return d.toJSON() })
 }
 
 // ToJSON 转换为 JSON（用于 HTTP 响应）
 func (d *DaemonState) ToJSON() map[string]interface{} {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
+	return d.toJSON()
+}
 
+// toJSON 调用方持有 d.mu
+func (d *DaemonState) toJSON() map[string]interface{} {
 	result := map[string]interface{}{
 		"state":   d.State,
 		"version": d.Version,
@@ -113,50 +211,151 @@ func (d *DaemonState) ToJSON() map[string]interface{} {
 }
 
 // DaemonServer Daemon HTTP 服务器
+//
+// 默认只监听 127.0.0.1（或 Unix 域套接字）。每次启动生成访问令牌并写入令牌文件，
+// 除 GET /status 外的请求须携带 Authorization: Bearer <token>。
 type DaemonServer struct {
-	port        int
-	server      *http.Server
-	state       *DaemonState
-	done        chan struct{}
-	shutdownReq bool
+	port         int
+	listen       string // TCP 监听地址，默认 127.0.0.1
+	socket       string // 非空时监听 Unix 域套接字
+	tokenFile    string
+	token        string
+	controller   *DaemonController
+	server       *http.Server
+	state        *DaemonState
+	done         chan struct{}
+	shutdownReq  bool
 	shutdownOnce sync.Once
-	mu          sync.RWMutex
+	mu           sync.RWMutex
 }
 
 // NewDaemonServer 创建 Daemon 服务器
 func NewDaemonServer(port int, state *DaemonState) *DaemonServer {
 	return &DaemonServer{
-		port:  port,
-		state: state,
-		done:  make(chan struct{}),
+		port:   port,
+		listen: "127.0.0.1",
+		token:  newDaemonToken(),
+		state:  state,
+		done:   make(chan struct{}),
+	}
+}
+
+// newDaemonToken 生成本次运行的访问令牌
+func newDaemonToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate daemon token: %v", err))
 	}
+	return hex.EncodeToString(b)
+}
+
+// SetListen 设置 TCP 监听地址（如 0.0.0.0 允许其他主机访问）
+func (d *DaemonServer) SetListen(host string) {
+	d.listen = host
+}
+
+// SetSocket 改为监听 Unix 域套接字
+func (d *DaemonServer) SetSocket(path string) {
+	d.socket = path
+}
+
+// SetTokenFile 设置访问令牌文件，启动时写入，关闭时删除
+func (d *DaemonServer) SetTokenFile(path string) {
+	d.tokenFile = path
+}
+
+// SetController 启用检查、下载控制、安装和历史记录接口
+func (d *DaemonServer) SetController(controller *DaemonController) {
+	d.controller = controller
+}
+
+// Token 返回本次运行的访问令牌
+func (d *DaemonServer) Token() string {
+	return d.token
 }
 
 // Start 启动 HTTP 服务器
 func (d *DaemonServer) Start() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/status", d.handleStatus)
-	mux.HandleFunc("/shutdown", d.handleShutdown)
+	mux.HandleFunc("/shutdown", d.authorized(d.handleShutdown))
+	mux.HandleFunc("/events", d.authorized(d.handleEvents))
+	if d.controller != nil {
+		mux.HandleFunc("/check", d.authorized(d.handleCheck))
+		mux.HandleFunc("/download", d.authorized(d.handleDownload))
+		mux.HandleFunc("/download/pause", d.authorized(d.handlePause))
+		mux.HandleFunc("/download/resume", d.authorized(d.handleResume))
+		mux.HandleFunc("/download/cancel", d.authorized(d.handleCancel))
+		mux.HandleFunc("/apply", d.authorized(d.handleApply))
+		mux.HandleFunc("/history", d.authorized(d.handleHistory))
+	}
 
 	d.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", d.port),
 		Handler:      mux,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: 2 * time.Minute, // /check 需等待服务端响应
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Printf("✓ Daemon mode started on port %d\n", d.port)
-
-	// 检查端口是否可用
-	listener, err := net.Listen("tcp", d.server.Addr)
+	listener, err := d.newListener()
 	if err != nil {
-		return fmt.Errorf("port %d is already in use. Try a different port", d.port)
+		return err
+	}
+	if d.tokenFile != "" {
+		if err := os.WriteFile(d.tokenFile, []byte(d.token), 0600); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to write daemon token file: %w", err)
+		}
+	}
+
+	if d.socket != "" {
+		log.Printf("✓ Daemon mode started on %s\n", d.socket)
+	} else {
+		log.Printf("✓ Daemon mode started on port %d\n", d.port)
 	}
 
 	return d.server.Serve(listener)
 }
 
+// newListener 监听 Unix 域套接字（仅当前用户可访问）或 TCP 端口
+func (d *DaemonServer) newListener() (net.Listener, error) {
+	if d.socket != "" {
+		// 删除上次异常退出残留的套接字文件
+		os.Remove(d.socket)
+		listener, err := net.Listen("unix", d.socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", d.socket, err)
+		}
+		if err := os.Chmod(d.socket, 0600); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to restrict access to %s: %w", d.socket, err)
+		}
+		return listener, nil
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(d.listen, strconv.Itoa(d.port)))
+	if err != nil {
+		return nil, fmt.Errorf("port %d is already in use. Try a different port", d.port)
+	}
+	return listener, nil
+}
+
+// authorized 校验访问令牌
+func (d *DaemonServer) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) != 1 {
+			writeDaemonJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"success": false,
+				"error":   "unauthorized",
+				"message": "Missing or invalid daemon token",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
 // handleStatus 处理 /status 请求
 func (d *DaemonServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -167,6 +366,72 @@ func (d *DaemonServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(d.state.ToJSON())
 }
 
+// handleEvents 处理 GET /events：以 Server-Sent Events 推送状态变化、进度、校验结果和错误
+//
+// 重连时浏览器或客户端携带 Last-Event-ID，从缓冲区补发错过的事件。
+func (d *DaemonServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	// 事件流长时间保持连接，不受服务器 WriteTimeout 限制
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	lastEventID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	initial, events, unsubscribe := d.state.Subscribe(lastEventID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range initial {
+		if writeSSE(w, event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-d.done:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return // 订阅者跟不上，断开后由客户端重连补发
+			}
+			if writeSSE(w, event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// 注释行，保持连接并及时发现已断开的客户端
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeSSE 按 text/event-stream 格式写入一个事件
+func writeSSE(w io.Writer, event DaemonEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
 // handleShutdown 处理 /shutdown 请求
 func (d *DaemonServer) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -197,12 +462,163 @@ func (d *DaemonServer) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	go d.Shutdown()
 }
 
+// handleCheck 处理 POST /check：检查更新，可在请求体中指定 currentVersion
+func (d *DaemonServer) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		CurrentVersion string `json:"currentVersion"`
+	}
+	if !decodeDaemonRequest(w, r, &req) {
+		return
+	}
+	result, err := d.controller.Check(req.CurrentVersion)
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusOK, result)
+}
+
+// handleDownload 处理 POST /download：后台下载指定版本，未指定时下载最近一次检查发现的版本
+func (d *DaemonServer) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Version string `json:"version"`
+		Output  string `json:"output"`
+	}
+	if !decodeDaemonRequest(w, r, &req) {
+		return
+	}
+	version, err := d.controller.StartDownload(req.Version, req.Output)
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"version": version,
+		"message": "Download started",
+	})
+}
+
+// handlePause 处理 POST /download/pause：中断下载，已下载部分保留
+func (d *DaemonServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	d.handleDownloadControl(w, r, d.controller.Pause, "Download paused")
+}
+
+// handleResume 处理 POST /download/resume：从暂停处续传
+func (d *DaemonServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	d.handleDownloadControl(w, r, d.controller.Resume, "Download resumed")
+}
+
+// handleCancel 处理 POST /download/cancel：中断下载并删除已下载部分
+func (d *DaemonServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	d.handleDownloadControl(w, r, d.controller.Cancel, "Download cancelled")
+}
+
+func (d *DaemonServer) handleDownloadControl(w http.ResponseWriter, r *http.Request, action func() error, message string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := action(); err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": message,
+	})
+}
+
+// handleApply 处理 POST /apply：后台安装已下载的版本，结果见 /status 和 /history
+func (d *DaemonServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := d.controller.Apply()
+	if err != nil {
+		writeDaemonError(w, err)
+		return
+	}
+	writeDaemonJSON(w, http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"version": version,
+		"message": "Install started",
+	})
+}
+
+// handleHistory 处理 GET /history：最近的检查、下载和安装记录（新的在前）
+func (d *DaemonServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeDaemonJSON(w, http.StatusOK, d.controller.History())
+}
+
+// decodeDaemonRequest 解析可选的 JSON 请求体，失败时返回 400
+func decodeDaemonRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil || r.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		writeDaemonJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"error":   "invalid_request",
+			"message": "Invalid JSON body",
+		})
+		return false
+	}
+	return true
+}
+
+// writeDaemonError 按错误码返回 409（当前状态不允许该操作）或 502（服务端、下载等错误）
+func writeDaemonError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	code := "error"
+	var ue *UpdateError
+	if errors.As(err, &ue) {
+		code = strings.ToLower(ue.Code)
+		switch ue.Code {
+		case "BUSY", "NOT_DOWNLOADING", "NOT_PAUSED", "NOTHING_TO_APPLY", "NO_UPDATE":
+			status = http.StatusConflict
+		case "CONFIG_ERROR":
+			status = http.StatusBadRequest
+		}
+	}
+	writeDaemonJSON(w, status, map[string]interface{}{
+		"success": false,
+		"error":   code,
+		"message": err.Error(),
+	})
+}
+
+func writeDaemonJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
 // Shutdown 关闭服务器
 func (d *DaemonServer) Shutdown() {
 	d.shutdownOnce.Do(func() {
 		if d.server != nil {
 			d.server.Close()
 		}
+		if d.tokenFile != "" {
+			os.Remove(d.tokenFile)
+		}
+		if d.socket != "" {
+			os.Remove(d.socket)
+		}
 		if d.done != nil {
 			close(d.done)
 		}
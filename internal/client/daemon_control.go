@@ -0,0 +1,374 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxHistoryEntries 历史记录文件保留的条数
+const maxHistoryEntries = 100
+
+// 历史记录的操作类型
+const (
+	HistoryCheck    = "check"
+	HistoryDownload = "download"
+	HistoryApply    = "apply"
+)
+
+// HistoryEntry 一条检查、下载或安装记录
+//
+// 检查只记录发现新版本或失败的结果，避免频繁检查挤掉下载和安装记录。
+type HistoryEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"` // check | download | apply
+	Version    string    `json:"version,omitempty"`
+	Success    bool      `json:"success"`
+	Cancelled  bool      `json:"cancelled,omitempty"`
+	RolledBack bool      `json:"rolledBack,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DaemonController 本地控制 API 背后的操作：检查、可暂停的下载、安装和历史记录
+//
+// 同一时间只允许一个下载或安装；状态变化通过 DaemonState 反映到 /status。
+type DaemonController struct {
+	config  *Config
+	checker *UpdateChecker
+	state   *DaemonState
+
+	mu        sync.Mutex
+	current   string          // 当前安装的版本，安装成功后更新
+	available *UpdateInfo     // 最近一次检查发现的新版本
+	download  *daemonDownload // 进行中或已暂停的下载
+	staged    *StagedUpdate   // 已下载并校验、等待安装的版本
+	applying  bool
+	lastErr   error
+	history   []HistoryEntry
+}
+
+// daemonDownload 一次下载任务；暂停后保留，恢复时用同一路径续传
+type daemonDownload struct {
+	version string
+	path    string
+	cancel  context.CancelFunc
+	stop    string        // pause | cancel，下载中断后据此设置状态
+	done    chan struct{} // 下载 goroutine 结束时关闭
+}
+
+// NewDaemonController 创建控制器并加载历史记录
+func NewDaemonController(config *Config, state *DaemonState) *DaemonController {
+	// 控制 API 不输出到终端，检查器使用 JSON 模式以关闭进度和提示输出
+	checker := NewUpdateChecker(config, true)
+	checker.SetDaemonState(state)
+
+	c := &DaemonController{
+		config:  config,
+		checker: checker,
+		state:   state,
+		current: config.Program.CurrentVersion,
+	}
+	c.loadHistory()
+	return c
+}
+
+// Check 检查更新；currentVersion 为空时使用配置或上次安装的版本
+func (c *DaemonController) Check(currentVersion string) (*CheckResult, error) {
+	c.mu.Lock()
+	if currentVersion == "" {
+		currentVersion = c.current
+	}
+	c.mu.Unlock()
+
+	info, err := c.checker.CheckUpdate(currentVersion)
+	if err == nil && info != nil {
+		err = c.checker.VerifyUpdateInfo(info)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.record(HistoryEntry{Action: HistoryCheck, Error: err.Error()})
+		return nil, err
+	}
+	c.available = info
+	if info != nil {
+		c.record(HistoryEntry{Action: HistoryCheck, Version: info.Version, Success: true})
+	}
+	return newCheckResult(info, currentVersion), nil
+}
+
+// StartDownload 在后台下载 version（为空时取最近一次检查发现的版本），返回下载的版本
+func (c *DaemonController) StartDownload(version, outputPath string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.applying || c.download != nil && !c.download.stopped() {
+		return "", &UpdateError{Code: "BUSY", Message: "Another download or install is in progress"}
+	}
+	if version == "" {
+		if c.available == nil {
+			return "", &UpdateError{Code: "NO_UPDATE", Message: "No version specified and no update found by the last check"}
+		}
+		version = c.available.Version
+	}
+	if outputPath == "" {
+		outputPath = c.checker.generateOutputPath(version)
+	}
+	// 放弃暂停中的其他版本，保留其已下载部分供以后续传
+	c.startDownload(&daemonDownload{version: version, path: outputPath})
+	return version, nil
+}
+
+// Pause 中断进行中的下载，保留已下载部分
+func (c *DaemonController) Pause() error {
+	c.mu.Lock()
+	dl := c.download
+	if dl == nil || dl.stopped() {
+		c.mu.Unlock()
+		return &UpdateError{Code: "NOT_DOWNLOADING", Message: "No download in progress"}
+	}
+	dl.stop = "pause"
+	dl.cancel()
+	c.mu.Unlock()
+
+	<-dl.done
+	return nil
+}
+
+// Resume 从暂停处继续下载
+func (c *DaemonController) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dl := c.download
+	if dl == nil || !dl.stopped() || dl.stop != "pause" {
+		return &UpdateError{Code: "NOT_PAUSED", Message: "No paused download"}
+	}
+	c.startDownload(&daemonDownload{version: dl.version, path: dl.path})
+	return nil
+}
+
+// Cancel 中断进行中或暂停的下载并删除已下载部分
+func (c *DaemonController) Cancel() error {
+	c.mu.Lock()
+	dl := c.download
+	if dl == nil {
+		c.mu.Unlock()
+		return &UpdateError{Code: "NOT_DOWNLOADING", Message: "No download in progress"}
+	}
+	if !dl.stopped() {
+		dl.stop = "cancel"
+		dl.cancel()
+		c.mu.Unlock()
+		<-dl.done
+		return nil
+	}
+
+	// 已暂停：直接清理
+	c.finishCancel(dl)
+	c.mu.Unlock()
+	return nil
+}
+
+// Apply 在后台安装已下载的版本，返回安装的版本
+func (c *DaemonController) Apply() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.applying || c.download != nil && !c.download.stopped() {
+		return "", &UpdateError{Code: "BUSY", Message: "Another download or install is in progress"}
+	}
+	if c.staged == nil {
+		return "", &UpdateError{Code: "NOTHING_TO_APPLY", Message: "No downloaded update to install"}
+	}
+	installer := c.checker.newInstaller()
+	if _, _, err := installer.paths(); err != nil {
+		return "", err
+	}
+	installer.SetCurrentVersion(c.current)
+
+	staged := *c.staged
+	c.applying = true
+	c.state.SetState("applying")
+	go c.runApply(installer, staged)
+	return staged.Version, nil
+}
+
+// History 返回历史记录，新的在前
+func (c *DaemonController) History() []HistoryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]HistoryEntry, len(c.history))
+	for i, entry := range c.history {
+		entries[len(c.history)-1-i] = entry
+	}
+	return entries
+}
+
+// LastError 最近一次下载或安装的错误
+func (c *DaemonController) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// Close 暂停进行中的下载（保留已下载部分）并等待安装结束
+func (c *DaemonController) Close() {
+	c.Pause()
+	for {
+		c.mu.Lock()
+		applying := c.applying
+		c.mu.Unlock()
+		if !applying {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// startDownload 启动下载 goroutine，调用方持有 c.mu
+func (c *DaemonController) startDownload(dl *daemonDownload) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dl.cancel = cancel
+	dl.done = make(chan struct{})
+	c.download = dl
+	c.state.SetVersion(dl.version)
+	log.Printf("Downloading %s to %s", dl.version, dl.path)
+	go c.runDownload(ctx, dl)
+}
+
+func (c *DaemonController) runDownload(ctx context.Context, dl *daemonDownload) {
+	defer close(dl.done)
+	_, _, err := c.checker.withContext(ctx).downloadVerified(dl.version, dl.path)
+	dl.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stop := dl.stop
+	if err == nil {
+		stop = "" // 暂停或取消前已下载完成
+	}
+	switch stop {
+	case "pause":
+		c.state.SetState("paused")
+		log.Printf("Download of %s paused", dl.version)
+	case "cancel":
+		c.finishCancel(dl)
+	default:
+		if c.download == dl {
+			c.download = nil
+		}
+		c.lastErr = err
+		if err != nil {
+			c.state.SetError(err)
+			c.record(HistoryEntry{Action: HistoryDownload, Version: dl.version, Error: err.Error()})
+			log.Printf("Download of %s failed: %v", dl.version, err)
+			return
+		}
+		mandatory := c.available != nil && c.available.Version == dl.version && c.available.Mandatory
+		c.staged = &StagedUpdate{Version: dl.version, File: dl.path, Mandatory: mandatory}
+		c.state.SetCompleted(dl.path)
+		c.record(HistoryEntry{Action: HistoryDownload, Version: dl.version, Success: true})
+		log.Printf("Downloaded %s to %s", dl.version, dl.path)
+	}
+}
+
+// finishCancel 删除已下载部分并清除下载状态，调用方持有 c.mu
+func (c *DaemonController) finishCancel(dl *daemonDownload) {
+	os.Remove(dl.path + partSuffix)
+	os.Remove(dl.path + partSuffix + ".etag")
+	if c.download == dl {
+		c.download = nil
+	}
+	c.state.Reset("cancelled")
+	c.record(HistoryEntry{Action: HistoryDownload, Version: dl.version, Cancelled: true})
+	log.Printf("Download of %s cancelled", dl.version)
+}
+
+func (c *DaemonController) runApply(installer *Installer, staged StagedUpdate) {
+	result, err := installer.Apply(staged.File, staged.Version)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applying = false
+	c.lastErr = err
+	entry := HistoryEntry{Action: HistoryApply, Version: staged.Version, Success: err == nil}
+	if err != nil {
+		entry.Error = err.Error()
+		entry.RolledBack = result != nil && result.RolledBack
+		c.state.SetError(err)
+		c.record(entry)
+		log.Printf("Installing %s failed: %v", staged.Version, err)
+		return
+	}
+	c.current = staged.Version
+	c.staged = nil
+	c.available = nil
+	c.state.SetState("installed")
+	c.record(entry)
+	log.Printf("Installed %s", staged.Version)
+}
+
+// stopped 下载 goroutine 是否已结束
+func (dl *daemonDownload) stopped() bool {
+	select {
+	case <-dl.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// record 追加历史记录并写入文件，调用方持有 c.mu
+func (c *DaemonController) record(entry HistoryEntry) {
+	entry.Time = time.Now()
+	c.history = append(c.history, entry)
+	if len(c.history) > maxHistoryEntries {
+		c.history = c.history[len(c.history)-maxHistoryEntries:]
+	}
+	if err := c.saveHistory(); err != nil {
+		log.Printf("Failed to save history: %v", err)
+	}
+}
+
+func (c *DaemonController) loadHistory() {
+	if c.config.Daemon.HistoryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(c.config.Daemon.HistoryFile)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &c.history); err != nil {
+		log.Printf("Ignoring corrupt history file %s: %v", c.config.Daemon.HistoryFile, err)
+		c.history = nil
+	}
+}
+
+// saveHistory 先写临时文件再重命名，避免中断时留下不完整的文件
+func (c *DaemonController) saveHistory() error {
+	path := c.config.Daemon.HistoryFile
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
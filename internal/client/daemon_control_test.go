@@ -0,0 +1,233 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowWriter 每次写入后停顿，让下载持续足够长的时间以便暂停
+type slowWriter struct {
+	http.ResponseWriter
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	return w.ResponseWriter.Write(p)
+}
+
+// daemonClient 通过 Unix 域套接字访问控制 API
+type daemonClient struct {
+	t     *testing.T
+	http  *http.Client
+	token string
+}
+
+func (c *daemonClient) do(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	c.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, "http://daemon"+path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+// waitFor 轮询 /status 直到 state 为 want
+func (c *daemonClient) waitFor(want string, withProgress bool) map[string]interface{} {
+	c.t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		_, status := c.do(http.MethodGet, "/status", "", nil)
+		if status["state"] == want && (!withProgress || status["progress"] != nil) {
+			return status
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_, status := c.do(http.MethodGet, "/status", "", nil)
+	c.t.Fatalf("timed out waiting for state %s, status = %v", want, status)
+	return nil
+}
+
+func TestDaemonControlAPI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses a Unix domain socket")
+	}
+	root := t.TempDir()
+
+	// 不可压缩的内容，使更新包足够大
+	payload := make([]byte, 512*1024)
+	rand.Read(payload)
+	pkgPath := filepath.Join(root, "src.zip")
+	writeZip(t, pkgPath, map[string]string{"version.txt": "2.0.0", "data.bin": string(payload)})
+	pkg, _ := os.ReadFile(pkgPath)
+	sum := sha256.Sum256(pkg)
+	hash := hex.EncodeToString(sum[:])
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/versions/latest"):
+			json.NewEncoder(w).Encode(UpdateInfo{ProgramID: "testapp", Version: "2.0.0", FileSize: int64(len(pkg)), FileHash: hash})
+		case strings.HasPrefix(r.URL.Path, "/api/download/"):
+			w.Header().Set("ETag", `"`+hash+`"`)
+			http.ServeContent(slowWriter{w}, r, "pkg.zip", time.Time{}, bytes.NewReader(pkg))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.ServerURL = srv.URL
+	config.ProgramID = "testapp"
+	config.Program.CurrentVersion = "1.0.0"
+	config.Program.InstallIDFile = filepath.Join(root, "install.id")
+	config.Auth.KeyringFile = ""
+	config.Auth.TokenFile = ""
+	config.Download.SavePath = filepath.Join(root, "updates")
+	config.Install.Dir = filepath.Join(root, "app")
+	config.Logging.File = ""
+	config.Daemon.HistoryFile = filepath.Join(root, "history.json")
+	tokenFile := filepath.Join(root, "daemon.token")
+	socket := filepath.Join(root, "d.sock")
+
+	state := NewDaemonState("1.0.0")
+	server := NewDaemonServer(0, state)
+	server.SetSocket(socket)
+	server.SetTokenFile(tokenFile)
+	server.SetController(NewDaemonController(config, state))
+	go server.Start()
+	defer server.Shutdown()
+
+	var token []byte
+	for i := 0; i < 100 && len(token) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		token, _ = os.ReadFile(tokenFile)
+	}
+	if string(token) != server.Token() {
+		t.Fatalf("token file = %q, want the server token", token)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("socket should be private, got %v, %v", info, err)
+	}
+
+	c := &daemonClient{t: t, token: server.Token(), http: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}}
+
+	// /status 保持原有格式，不需要令牌；其他接口需要
+	if code, status := c.do(http.MethodGet, "/status", "", nil); code != http.StatusOK || status["state"] != "idle" || status["version"] != "1.0.0" {
+		t.Fatalf("status = %d %v", code, status)
+	}
+	if code, _ := c.do(http.MethodPost, "/check", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("check with a wrong token = %d, want 401", code)
+	}
+
+	code, result := c.do(http.MethodPost, "/check", c.token, nil)
+	if code != http.StatusOK || result["hasUpdate"] != true || result["latestVersion"] != "2.0.0" {
+		t.Fatalf("check = %d %v", code, result)
+	}
+
+	// 下载 → 暂停 → 取消：已下载部分被删除
+	if code, result := c.do(http.MethodPost, "/download", c.token, nil); code != http.StatusAccepted || result["version"] != "2.0.0" {
+		t.Fatalf("download = %d %v", code, result)
+	}
+	c.waitFor("downloading", true)
+	if code, _ := c.do(http.MethodPost, "/download", c.token, nil); code != http.StatusConflict {
+		t.Errorf("second download = %d, want 409", code)
+	}
+	if code, result := c.do(http.MethodPost, "/download/pause", c.token, nil); code != http.StatusOK {
+		t.Fatalf("pause = %d %v", code, result)
+	}
+	c.waitFor("paused", false)
+	partPath := filepath.Join(root, "updates", "app-v2.0.0.zip") + partSuffix
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("partial download should be kept while paused: %v", err)
+	}
+	if code, _ := c.do(http.MethodPost, "/download/cancel", c.token, nil); code != http.StatusOK {
+		t.Fatalf("cancel = %d", code)
+	}
+	c.waitFor("cancelled", false)
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Error("partial download should be removed after cancel")
+	}
+
+	// 下载 → 暂停 → 恢复（续传）→ 安装
+	c.do(http.MethodPost, "/download", c.token, map[string]string{"version": "2.0.0"})
+	c.waitFor("downloading", true)
+	c.do(http.MethodPost, "/download/pause", c.token, nil)
+	c.waitFor("paused", false)
+	if code, _ := c.do(http.MethodPost, "/download/resume", c.token, nil); code != http.StatusOK {
+		t.Fatalf("resume = %d", code)
+	}
+	status := c.waitFor("completed", false)
+	if progress, _ := status["progress"].(map[string]interface{}); progress == nil || progress["resumed"] != true {
+		t.Errorf("resumed download should report resume, status = %v", status)
+	}
+
+	if code, result := c.do(http.MethodPost, "/apply", c.token, nil); code != http.StatusAccepted {
+		t.Fatalf("apply = %d %v", code, result)
+	}
+	c.waitFor("installed", false)
+	if got := readInstalled(t, config.Install.Dir, "version.txt"); got != "2.0.0" {
+		t.Errorf("installed version = %q", got)
+	}
+	if code, _ := c.do(http.MethodPost, "/apply", c.token, nil); code != http.StatusConflict {
+		t.Errorf("apply without a staged update = %d, want 409", code)
+	}
+
+	// 历史记录新的在前，并写入文件
+	req, _ := http.NewRequest(http.MethodGet, "http://daemon/history", nil)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []HistoryEntry
+	json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	var actions []string
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	want := "apply,download,download,check"
+	if strings.Join(actions, ",") != want || !history[0].Success || !history[2].Cancelled {
+		t.Errorf("history = %+v, want %s", history, want)
+	}
+	if data, err := os.ReadFile(config.Daemon.HistoryFile); err != nil || !strings.Contains(string(data), `"apply"`) {
+		t.Errorf("history file = %s, %v", data, err)
+	}
+
+	// 关闭后删除令牌文件和套接字
+	server.Shutdown()
+	if _, err := os.Stat(tokenFile); !os.IsNotExist(err) {
+		t.Error("token file should be removed on shutdown")
+	}
+}
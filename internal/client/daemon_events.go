@@ -0,0 +1,99 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Daemon 事件类型（GET /events 的 event 字段）
+const (
+	EventState     = "state"     // 状态变化，数据与 /status 相同
+	EventProgress  = "progress"  // 下载进度（限频）
+	EventVerified  = "verified"  // 下载包哈希校验结果
+	EventDecrypted = "decrypted" // 加密包已解密
+	EventError     = "error"     // 下载或安装失败
+)
+
+const (
+	// eventBufferSize 保留用于 Last-Event-ID 补发的最近事件数
+	eventBufferSize = 64
+	// subscriberBufferSize 每个订阅者未读事件的上限，超过时断开该订阅者，由其携带 Last-Event-ID 重连补发
+	subscriberBufferSize = 64
+	// progressEventInterval 进度事件的最小间隔，下载完成时不受限制
+	progressEventInterval = 250 * time.Millisecond
+	// eventHeartbeatInterval 心跳注释的发送间隔
+	eventHeartbeatInterval = 15 * time.Second
+)
+
+// DaemonEvent 推送给 /events 订阅者的事件
+type DaemonEvent struct {
+	ID   int64
+	Type string
+	Data interface{}
+}
+
+// eventLog 事件环形缓冲区和订阅者
+type eventLog struct {
+	mu          sync.Mutex
+	lastID      int64
+	buffer      []DaemonEvent // 按 ID 递增，最多 eventBufferSize 条
+	subscribers map[chan DaemonEvent]struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{subscribers: make(map[chan DaemonEvent]struct{})}
+}
+
+// publish 记录事件并发送给所有订阅者；订阅者跟不上时断开，不阻塞下载
+func (l *eventLog) publish(eventType string, data interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	event := DaemonEvent{ID: l.lastID, Type: eventType, Data: data}
+	l.buffer = append(l.buffer, event)
+	if len(l.buffer) > eventBufferSize {
+		l.buffer = l.buffer[len(l.buffer)-eventBufferSize:]
+	}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe 注册订阅者
+//
+// lastEventID 之后的事件都还在缓冲区内时补发这些事件；否则（新连接或错过的事件已被覆盖）
+// 返回 snapshot 生成的当前状态事件，其 ID 为最新事件的 ID。
+func (l *eventLog) subscribe(lastEventID int64, snapshot func() interface{}) ([]DaemonEvent, <-chan DaemonEvent, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var initial []DaemonEvent
+	if lastEventID > 0 && lastEventID <= l.lastID && (len(l.buffer) == 0 || lastEventID >= l.buffer[0].ID-1) {
+		for _, event := range l.buffer {
+			if event.ID > lastEventID {
+				initial = append(initial, event)
+			}
+		}
+	} else {
+		initial = []DaemonEvent{{ID: l.lastID, Type: EventState, Data: snapshot()}}
+	}
+
+	ch := make(chan DaemonEvent, subscriberBufferSize)
+	l.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.subscribers[ch]; ok {
+			delete(l.subscribers, ch)
+			close(ch)
+		}
+	}
+	return initial, ch, unsubscribe
+}
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"bufio"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -82,8 +85,20 @@ func TestDaemonServer(t *testing.T) {
 		t.Errorf("Expected version '1.0.0', got '%v'", result["version"])
 	}
 
+	// /shutdown 需要访问令牌
+	resp1, err := http.Post("http://localhost:19876/shutdown", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to call /shutdown: %v", err)
+	}
+	resp1.Body.Close()
+	if resp1.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without token, got %d", resp1.StatusCode)
+	}
+
 	// 测试 /shutdown 端点
-	resp2, err := http.Post("http://localhost:19876/shutdown", "application/json", nil)
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:19876/shutdown", nil)
+	req.Header.Set("Authorization", "Bearer "+server.Token())
+	resp2, err := http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatalf("Failed to call /shutdown: %v", err)
 	}
@@ -102,3 +117,143 @@ func TestDaemonServer(t *testing.T) {
 	<-server.Done()
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestDaemonStateEvents(t *testing.T) {
+	state := NewDaemonState("1.0.0")
+
+	// 新订阅者先收到当前状态快照
+	initial, events, unsubscribe := state.Subscribe(0)
+	defer unsubscribe()
+	if len(initial) != 1 || initial[0].Type != EventState {
+		t.Fatalf("initial = %+v, want a state snapshot", initial)
+	}
+
+	state.SetState("downloading")
+	state.SetState("downloading") // 状态未变化不发送
+	for i := int64(1); i <= 100; i++ {
+		state.SetProgress(i*1024, 100*1024, 1024)
+	}
+	state.SetVerified("/tmp/app.zip", true)
+	state.SetCompleted("/tmp/app.zip")
+	state.SetError(os.ErrNotExist)
+
+	var types []string
+	for len(events) > 0 {
+		types = append(types, (<-events).Type)
+	}
+	// 进度限频：第一块和最后一块各一次
+	want := "state,progress,progress,verified,state,error"
+	if got := strings.Join(types, ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+
+	// Last-Event-ID 仍在缓冲区内时补发之后的事件
+	replay, _, unsubscribe2 := state.Subscribe(4)
+	defer unsubscribe2()
+	if len(replay) != 2 || replay[0].ID != 5 || replay[1].Type != EventError {
+		t.Errorf("replay = %+v, want events 5 and 6", replay)
+	}
+
+	// 错过的事件已被覆盖时只发送快照；未读事件超过上限的订阅者被断开
+	for i := 0; i <= subscriberBufferSize; i++ {
+		state.SetVerified("/tmp/app.zip", true)
+	}
+	replay, _, unsubscribe3 := state.Subscribe(2)
+	defer unsubscribe3()
+	if len(replay) != 1 || replay[0].Type != EventState {
+		t.Errorf("replay after overflow = %+v, want a snapshot", replay)
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if received != subscriberBufferSize {
+		t.Errorf("slow subscriber received %d events before being disconnected, want %d", received, subscriberBufferSize)
+	}
+}
+
+func TestDaemonServerEvents(t *testing.T) {
+	state := NewDaemonState("1.0.0")
+	server := NewDaemonServer(0, state)
+	srv := httptest.NewServer(server.authorized(server.handleEvents))
+	defer srv.Close()
+
+	subscribe := func(lastEventID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Authorization", "Bearer "+server.Token())
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %s", ct)
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	// readEvent 读取一个事件，返回 id、类型和数据
+	readEvent := func(r *bufio.Reader) (string, string, map[string]interface{}) {
+		t.Helper()
+		var id, eventType string
+		var data map[string]interface{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read event: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				return id, eventType, data
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+			}
+		}
+	}
+
+	// 未携带令牌被拒绝
+	if resp, err := http.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("events without token = %d, want 401", resp.StatusCode)
+		}
+	}
+
+	// 多个订阅者都收到同样的事件
+	first, close1 := subscribe("")
+	defer close1()
+	second, close2 := subscribe("")
+	defer close2()
+	for _, r := range []*bufio.Reader{first, second} {
+		if _, eventType, data := readEvent(r); eventType != EventState || data["state"] != "idle" {
+			t.Fatalf("first event = %s %v, want idle snapshot", eventType, data)
+		}
+	}
+
+	state.SetState("downloading")
+	state.SetProgress(512, 1024, 100)
+	for _, r := range []*bufio.Reader{first, second} {
+		if id, eventType, data := readEvent(r); id != "1" || eventType != EventState || data["state"] != "downloading" {
+			t.Errorf("event = %s %s %v, want state downloading", id, eventType, data)
+		}
+		if _, eventType, data := readEvent(r); eventType != EventProgress || data["percentage"] != 50.0 {
+			t.Errorf("event = %s %v, want progress 50%%", eventType, data)
+		}
+	}
+
+	// 断线重连携带 Last-Event-ID 补发错过的事件
+	close1()
+	state.SetError(os.ErrNotExist)
+	replay, close3 := subscribe("2")
+	defer close3()
+	if id, eventType, data := readEvent(replay); id != "3" || eventType != EventError || data["error"] == nil {
+		t.Errorf("replayed event = %s %s %v, want error event 3", id, eventType, data)
+	}
+}
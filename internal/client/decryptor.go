@@ -1,17 +1,17 @@
 package client
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
+
+	"docufiller-update-server/internal/pkgcrypt"
 )
 
-// Decryptor 文件解密器
+// Decryptor 文件解密器，按文件头中的密钥 ID 从密钥环选择密钥
 type Decryptor struct {
-	key []byte
+	keys pkgcrypt.Keyring
 }
 
 // NewDecryptor 创建解密器
@@ -25,50 +25,46 @@ func NewDecryptor(base64Key string) (*Decryptor, error) {
 		return nil, fmt.Errorf("invalid key length: expected 16, 24, or 32 bytes, got %d", len(key))
 	}
 
-	return &Decryptor{key: key}, nil
+	return &Decryptor{keys: pkgcrypt.NewKeyring(key)}, nil
+}
+
+// NewKeyringDecryptor 以密钥环创建解密器，密钥轮换期间可解密新旧密钥加密的包
+func NewKeyringDecryptor(keys pkgcrypt.Keyring) *Decryptor {
+	return &Decryptor{keys: keys}
 }
 
-// DecryptFile 解密文件（CTR 模式）
+// DecryptFile 解密文件（分块 AES-GCM，见 pkgcrypt）
+//
+// 解密结果先写入临时文件，全部分块认证通过后才替换 dstPath，
+// 密文被篡改或密钥错误时不会留下部分明文。srcPath 与 dstPath 可以相同。
 func (d *Decryptor) DecryptFile(srcPath, dstPath string) error {
-	// 读取加密文件
 	srcFile, err := os.Open(srcPath)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
 	}
 	defer srcFile.Close()
 
-	// 读取全部内容到临时文件
-	tempPath := dstPath + ".tmp"
-	dstFile, err := os.Create(tempPath)
+	reader, err := pkgcrypt.NewReader(srcFile, d.keys)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+		return fmt.Errorf("failed to read encrypted package: %w", err)
 	}
-	defer dstFile.Close()
 
-	// 创建 AES 解密器
-	block, err := aes.NewCipher(d.key)
+	tempPath := dstPath + ".tmp"
+	dstFile, err := os.Create(tempPath)
 	if err != nil {
-		return fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	// 读取 IV（前 16 字节）
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(srcFile, iv); err != nil {
-		return fmt.Errorf("failed to read IV: %w", err)
+		return fmt.Errorf("failed to create destination file: %w", err)
 	}
 
-	// 创建流解密器
-	stream := cipher.NewCTR(block, iv)
-	reader := &cipher.StreamReader{S: stream, R: srcFile}
-
-	// 解密并写入临时文件
 	if _, err := io.Copy(dstFile, reader); err != nil {
+		dstFile.Close()
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to decrypt: %w", err)
 	}
-
-	// 关闭文件
-	dstFile.Close()
+	if err := dstFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write decrypted file: %w", err)
+	}
+	srcFile.Close()
 
 	// 替换原文件
 	if err := os.Rename(tempPath, dstPath); err != nil {
@@ -1,8 +1,13 @@
 package client
 
 import (
+	"bytes"
 	"encoding/base64"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"docufiller-update-server/internal/pkgcrypt"
 )
 
 func TestNewDecryptor_ValidKey(t *testing.T) {
@@ -47,3 +52,45 @@ func TestNewDecryptor_Valid24ByteKey(t *testing.T) {
 		t.Fatalf("Expected no error for 24-byte key, got %v", err)
 	}
 }
+
+func TestDecryptFile_RoundTripAndTamper(t *testing.T) {
+	rawKey := []byte("12345678901234567890123456789012")
+	plain := bytes.Repeat([]byte("package content "), 10000)
+
+	var sealed bytes.Buffer
+	w, err := pkgcrypt.NewWriter(&sealed, rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	w.Close()
+
+	d, err := NewDecryptor(base64.StdEncoding.EncodeToString(rawKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pkg.zip")
+	if err := os.WriteFile(path, sealed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DecryptFile(path, path); err != nil {
+		t.Fatalf("DecryptFile failed: %v", err)
+	}
+	got, _ := os.ReadFile(path)
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted content mismatch")
+	}
+
+	tampered := append([]byte(nil), sealed.Bytes()...)
+	tampered[len(tampered)/2] ^= 1
+	if err := os.WriteFile(path, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DecryptFile(path, path); err == nil {
+		t.Fatal("expected error for tampered package")
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, tampered) {
+		t.Error("tampered package should be left untouched")
+	}
+}
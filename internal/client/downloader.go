@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,62 +25,113 @@ type DownloadResult struct {
 
 // DownloadWithOutput 下载更新并输出结果
 func (c *UpdateChecker) DownloadWithOutput(version string, outputPath string) error {
+	if outputPath == "" {
+		outputPath = c.generateOutputPath(version)
+	}
+
+	decrypted, verified, err := c.downloadVerified(version, outputPath)
+	if err != nil {
+		if c.daemonState != nil {
+			c.daemonState.SetError(err)
+		}
+		return c.outputError(err)
+	}
+	return c.outputDownloadResult(outputPath, decrypted, verified)
+}
+
+// downloadVerified 下载指定版本到 outputPath，完成签名、哈希校验和解密
+func (c *UpdateChecker) downloadVerified(version string, outputPath string) (decrypted, verified bool, err error) {
 	// 设置初始状态
 	if c.daemonState != nil {
 		c.daemonState.SetState("idle")
 	}
 
-	if outputPath == "" {
-		outputPath = c.generateOutputPath(version)
-	}
-
 	// Download
 	if !c.jsonOutput {
 		fmt.Printf("✓ Starting download: %s\n", filepath.Base(outputPath))
 	}
 	info, err := c.CheckUpdate("")
+	if err == nil && info != nil && info.Version != version {
+		// 请求的不是最新版本，按该版本的详情校验
+		info, err = c.GetVersionInfo(version)
+	}
+	if err == nil && info != nil {
+		err = c.VerifyUpdateInfo(info)
+	}
 	if err != nil {
-		if c.daemonState != nil {
-			c.daemonState.SetError(err)
-		}
-		return c.outputError(err)
+		return false, false, err
 	}
 	if info != nil && !c.jsonOutput {
 		fmt.Printf("  Size: %.1f MB\n", float64(info.FileSize)/1024/1024)
 	}
 
-	if err := c.DownloadUpdate(version, outputPath, c.progressCallback); err != nil {
+	// 优先使用增量补丁，失败时回退到完整下载
+	patched := false
+	if info != nil && info.Version == version {
+		if patch, basePath := c.selectPatch(info); patch != nil {
+			if err := c.downloadWithPatch(info, patch, basePath, outputPath); err != nil {
+				if !c.jsonOutput {
+					fmt.Printf("  Patch from %s failed (%v), downloading full package\n", patch.From, err)
+				}
+			} else {
+				patched = true
+				if !c.jsonOutput {
+					fmt.Printf("  Rebuilt from %s using a %.1f MB patch\n", patch.From, float64(patch.Size)/1024/1024)
+				}
+			}
+		}
+	}
+
+	if !patched {
+		if err := c.DownloadUpdate(version, outputPath, c.progressCallback); err != nil {
+			return false, false, err
+		}
+	}
+
+	// 加密包先校验密文，再解密并校验明文
+	if info != nil && info.Encrypted && !patched {
+		if err := c.decryptDownload(info, outputPath); err != nil {
+			return false, false, err
+		}
+		decrypted = true
 		if c.daemonState != nil {
-			c.daemonState.SetError(err)
+			c.daemonState.SetDecrypted(outputPath)
 		}
-		return c.outputError(err)
 	}
 
 	// Verify
-	verified := true
+	verified = true
 	if info != nil && info.FileHash != "" {
 		verified, _ = c.VerifyFile(outputPath, info.FileHash)
+		if c.daemonState != nil {
+			c.daemonState.SetVerified(outputPath, verified)
+		}
 		if !verified {
-			err := fmt.Errorf("verification failed")
-			if c.daemonState != nil {
-				c.daemonState.SetError(err)
-			}
-			return c.outputError(err)
+			return decrypted, false, fmt.Errorf("verification failed")
 		}
 	}
 
-	// Decrypt if key is available
-	decrypted := false
-	if c.config.Auth.EncryptionKey != "" {
-		decryptor, err := NewDecryptor(c.config.Auth.EncryptionKey)
-		if err == nil {
-			if err := decryptor.DecryptFile(outputPath, outputPath); err == nil {
-				decrypted = true
-			}
+	return decrypted, verified, nil
+}
+
+// decryptDownload 校验下载的密文哈希并用程序密钥原地解密
+func (c *UpdateChecker) decryptDownload(info *UpdateInfo, path string) error {
+	keys, _ := c.getKeyring()
+	if len(keys) == 0 {
+		return &UpdateError{Code: "DECRYPT_ERROR", Message: "Package is encrypted but no encryption key is configured"}
+	}
+	if _, ok := keys[info.EncryptionKeyID]; info.EncryptionKeyID != "" && !ok {
+		return &UpdateError{Code: "DECRYPT_ERROR", Message: fmt.Sprintf("Package is encrypted with key %s which this client does not have", info.EncryptionKeyID)}
+	}
+	if info.EncryptedHash != "" {
+		if ok, err := c.VerifyFile(path, info.EncryptedHash); err != nil || !ok {
+			return &UpdateError{Code: "VERIFY_ERROR", Message: "Encrypted package hash does not match", Err: err}
 		}
 	}
-
-	return c.outputDownloadResult(outputPath, decrypted, verified)
+	if err := NewKeyringDecryptor(keys).DecryptFile(path, path); err != nil {
+		return &UpdateError{Code: "DECRYPT_ERROR", Message: "Failed to decrypt package", Err: err}
+	}
+	return nil
 }
 
 func (c *UpdateChecker) generateOutputPath(version string) string {
@@ -150,6 +202,9 @@ func (c *UpdateChecker) DownloadUpdate(version string, destPath string, callback
 
 	// 重试机制
 	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 && c.context().Err() != nil {
+			break // 下载被暂停或取消，不再重试
+		}
 		if attempt > 0 {
 			time.Sleep(time.Duration(attempt) * 2 * time.Second) // 指数退避
 		}
@@ -165,6 +220,11 @@ func (c *UpdateChecker) DownloadUpdate(version string, destPath string, callback
 	return lastErr
 }
 
+// downloadOnce 下载一次更新包
+//
+// 数据先写入 destPath.part，中断后（包括进程重启）用 Range 从已有长度续传，
+// 并以 If-Range 携带上次响应的 ETag；服务端文件已变化时返回完整内容，重新开始。
+// 服务端 ETag 为文件 SHA256，下载完成后先校验再重命名为 destPath。
 func (c *UpdateChecker) downloadOnce(version string, destPath string, callback ProgressCallback) error {
 	// 设置状态为 downloading
 	if c.daemonState != nil {
@@ -174,7 +234,37 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 	url := fmt.Sprintf("%s/api/download/%s/%s/%s",
 		c.config.ServerURL, c.config.GetProgramID(), c.config.Channel, version)
 
-	resp, err := c.httpClient.Get(url)
+	req, err := c.newRequest(http.MethodGet, url)
+	if err != nil {
+		return &UpdateError{
+			Code:    "REQUEST_ERROR",
+			Message: "Failed to build request",
+			Err:     err,
+		}
+	}
+
+	// 确保目录存在
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return &UpdateError{
+			Code:    "FILE_ERROR",
+			Message: "Failed to create directory",
+			Err:     err,
+		}
+	}
+
+	partPath := destPath + partSuffix
+	etagPath := partPath + ".etag"
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag, err := os.ReadFile(etagPath); err == nil && len(etag) > 0 {
+			req.Header.Set("If-Range", string(etag))
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return &UpdateError{
 			Code:    "NETWORK_ERROR",
@@ -184,24 +274,44 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partPath)
+			return &UpdateError{
+				Code:    "DOWNLOAD_ERROR",
+				Message: "Unexpected Content-Range in resumed download",
+			}
+		}
+	case http.StatusOK:
+		offset = 0 // 服务端不支持续传或文件已变化
+	case http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(partPath)
+		os.Remove(etagPath)
+		return &UpdateError{
+			Code:    "DOWNLOAD_ERROR",
+			Message: "Partial download is no longer valid",
+		}
+	default:
 		return &UpdateError{
 			Code:    "DOWNLOAD_ERROR",
 			Message: fmt.Sprintf("Server returned status %d", resp.StatusCode),
 		}
 	}
 
-	// 确保目录存在
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return &UpdateError{
-			Code:    "FILE_ERROR",
-			Message: "Failed to create directory",
-			Err:     err,
-		}
+	etag := resp.Header.Get("ETag")
+	if etag != "" {
+		_ = os.WriteFile(etagPath, []byte(etag), 0644)
+	} else {
+		os.Remove(etagPath)
 	}
 
-	// 创建文件
-	file, err := os.Create(destPath)
+	// 打开分片文件（续传时追加）
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return &UpdateError{
 			Code:    "FILE_ERROR",
@@ -212,9 +322,15 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 	defer file.Close()
 
 	// 获取文件大小
-	total := resp.ContentLength
-	downloaded := int64(0)
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	downloaded := offset
 	startTime := time.Now()
+	if c.daemonState != nil {
+		c.daemonState.SetResumedFrom(offset)
+	}
 
 	// 使用 buffer 复制
 	buffer := make([]byte, 32*1024) // 32KB chunks
@@ -230,17 +346,18 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 			// 调用进度回调
 			if callback != nil && total > 0 {
 				elapsed := time.Since(startTime).Seconds()
-				speed := float64(downloaded) / elapsed
+				speed := float64(downloaded-offset) / elapsed
 				if elapsed == 0 {
 					speed = 0
 				}
 
 				progress := DownloadProgress{
-					Version:    version,
-					Downloaded: downloaded,
-					Total:      total,
-					Percentage: float64(downloaded) / float64(total) * 100,
-					Speed:      speed,
+					Version:     version,
+					Downloaded:  downloaded,
+					Total:       total,
+					Percentage:  float64(downloaded) / float64(total) * 100,
+					Speed:       speed,
+					ResumedFrom: offset,
 				}
 
 				callback(progress)
@@ -264,6 +381,37 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 		}
 	}
 
+	if err := file.Close(); err != nil {
+		return &UpdateError{
+			Code:    "FILE_ERROR",
+			Message: "Failed to write file",
+			Err:     err,
+		}
+	}
+
+	// 强 ETag 即文件哈希，续传拼接后的文件必须与之一致
+	if hash := etagHash(etag); hash != "" {
+		ok, err := c.VerifyFile(partPath, hash)
+		if err != nil || !ok {
+			os.Remove(partPath)
+			os.Remove(etagPath)
+			return &UpdateError{
+				Code:    "VERIFY_ERROR",
+				Message: "Downloaded file hash does not match",
+				Err:     err,
+			}
+		}
+	}
+
+	if err := os.Rename(partPath, destPath); err != nil {
+		return &UpdateError{
+			Code:    "FILE_ERROR",
+			Message: "Failed to move downloaded file",
+			Err:     err,
+		}
+	}
+	os.Remove(etagPath)
+
 	// 下载成功
 	if c.daemonState != nil {
 		c.daemonState.SetCompleted(destPath)
@@ -271,6 +419,41 @@ func (c *UpdateChecker) downloadOnce(version string, destPath string, callback P
 	return nil
 }
 
+// partSuffix 未完成下载的文件后缀
+const partSuffix = ".part"
+
+// contentRangeStart 解析 Content-Range 头（bytes start-end/total）的起始位置
+func contentRangeStart(header string) (int64, bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, false
+	}
+	rangePart := strings.TrimPrefix(header, "bytes ")
+	dash := strings.IndexByte(rangePart, '-')
+	if dash <= 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(rangePart[:dash], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// etagHash 从强 ETag 中取出 SHA256 哈希，弱 ETag 或其他格式返回空
+func etagHash(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+	hash := strings.Trim(etag, `"`)
+	if len(hash) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return ""
+	}
+	return hash
+}
+
 // VerifyFile 验证文件 SHA256 哈希
 func (c *UpdateChecker) VerifyFile(filePath string, expectedHash string) (bool, error) {
 	file, err := os.Open(filePath)
@@ -1,9 +1,15 @@
 package client
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDownloadUpdate(t *testing.T) {
@@ -38,3 +44,84 @@ func TestDownloadUpdate(t *testing.T) {
 		t.Error("Progress callback was not called")
 	}
 }
+
+func TestDownloadOnce_Resume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	var gotRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "pkg.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.ServerURL = srv.URL
+	config.ProgramID = "testapp"
+	state := NewDaemonState("1.0.0")
+	checker := NewUpdateChecker(config, true)
+	checker.SetDaemonState(state)
+
+	// 模拟上次中断留下的分片
+	destPath := filepath.Join(t.TempDir(), "pkg.zip")
+	os.WriteFile(destPath+partSuffix, content[:40000], 0644)
+	os.WriteFile(destPath+partSuffix+".etag", []byte(etag), 0644)
+
+	var first DownloadProgress
+	err := checker.downloadOnce("1.0.0", destPath, func(p DownloadProgress) {
+		if first.Downloaded == 0 {
+			first = p
+		}
+	})
+	if err != nil {
+		t.Fatalf("downloadOnce failed: %v", err)
+	}
+
+	if gotRange != "bytes=40000-" {
+		t.Errorf("Range = %q, want bytes=40000-", gotRange)
+	}
+	if first.ResumedFrom != 40000 || first.Total != int64(len(content)) {
+		t.Errorf("progress = %+v, want resumed from 40000 of %d", first, len(content))
+	}
+	data, _ := os.ReadFile(destPath)
+	if !bytes.Equal(data, content) {
+		t.Error("resumed file differs from server content")
+	}
+	if _, err := os.Stat(destPath + partSuffix); !os.IsNotExist(err) {
+		t.Error(".part file should be removed after completion")
+	}
+	if progress, ok := state.ToJSON()["progress"].(*ProgressInfo); !ok || !progress.Resumed {
+		t.Errorf("daemon progress should report resume, got %+v", state.ToJSON()["progress"])
+	}
+}
+
+func TestDownloadOnce_ChangedFileRestarts(t *testing.T) {
+	content := []byte("new package content")
+	sum := sha256.Sum256(content)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		http.ServeContent(w, r, "pkg.zip", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.ServerURL = srv.URL
+	checker := NewUpdateChecker(config, true)
+
+	// 分片来自旧文件，If-Range 不匹配时服务端返回完整内容
+	destPath := filepath.Join(t.TempDir(), "pkg.zip")
+	os.WriteFile(destPath+partSuffix, []byte("old package"), 0644)
+	os.WriteFile(destPath+partSuffix+".etag", []byte(`"stale"`), 0644)
+
+	if err := checker.downloadOnce("1.0.0", destPath, nil); err != nil {
+		t.Fatalf("downloadOnce failed: %v", err)
+	}
+	data, _ := os.ReadFile(destPath)
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %q, want %q", data, content)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFileName 更新包根目录中声明安装钩子的清单文件
+const ManifestFileName = "update-manifest.yaml"
+
+// 钩子阶段
+const (
+	HookPreApply  = "pre_apply"  // 替换安装目录之前（如停止服务），失败时不替换
+	HookPostApply = "post_apply" // 替换之后、健康检查之前（如迁移配置、注册计划任务）
+	HookRollback  = "rollback"   // 更新失败或手动回滚时，撤销前两个阶段的操作
+)
+
+// defaultHookTimeout 未声明 timeout 的钩子的超时时间
+const defaultHookTimeout = 60 * time.Second
+
+// commandWaitDelay 命令退出或被结束后等待其输出管道关闭的最长时间
+const commandWaitDelay = 2 * time.Second
+
+// PackageManifest 更新包清单（update-manifest.yaml）
+type PackageManifest struct {
+	Hooks HookSet `yaml:"hooks"`
+}
+
+// HookSet 各阶段的钩子，按声明顺序执行，任一钩子失败即停止
+type HookSet struct {
+	PreApply  []Hook `yaml:"pre_apply"`
+	PostApply []Hook `yaml:"post_apply"`
+	Rollback  []Hook `yaml:"rollback"`
+}
+
+// Hook 安装钩子命令
+type Hook struct {
+	Name    string            `yaml:"name"`
+	Command []string          `yaml:"command"`  // 如 ["cmd", "/c", "scripts/stop.bat"]；相对路径优先在包目录中查找
+	WorkDir string            `yaml:"work_dir"` // 工作目录，相对于包目录（默认包目录）
+	Timeout int               `yaml:"timeout"`  // 超时（秒，默认 60）
+	Env     map[string]string `yaml:"env"`      // 额外的环境变量
+}
+
+// LoadPackageManifest 读取包目录中的清单，包中没有清单时返回空清单
+func LoadPackageManifest(packageDir string) (*PackageManifest, error) {
+	manifest := &PackageManifest{}
+	data, err := os.ReadFile(filepath.Join(packageDir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ManifestFileName, err)
+	}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ManifestFileName, err)
+	}
+	for phase, hooks := range map[string][]Hook{
+		HookPreApply:  manifest.Hooks.PreApply,
+		HookPostApply: manifest.Hooks.PostApply,
+		HookRollback:  manifest.Hooks.Rollback,
+	} {
+		for i, h := range hooks {
+			if len(h.Command) == 0 {
+				return nil, fmt.Errorf("%s: %s hook %d has no command", ManifestFileName, phase, i+1)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// hookName 日志和错误信息中的钩子名称
+func (h Hook) hookName(phase string, index int) string {
+	if h.Name != "" {
+		return fmt.Sprintf("%s/%s", phase, h.Name)
+	}
+	return fmt.Sprintf("%s/%d", phase, index+1)
+}
+
+// runHooks 在包目录中依次执行一个阶段的钩子，输出写入日志；退出码非 0 或超时视为失败
+//
+// env 为 UPDATE_* 环境变量，钩子声明的 env 可覆盖。
+func runHooks(logger *log.Logger, phase string, hooks []Hook, packageDir string, env map[string]string) error {
+	for i, h := range hooks {
+		name := h.hookName(phase, i)
+		workDir := packageDir
+		if h.WorkDir != "" {
+			workDir = h.WorkDir
+			if !filepath.IsAbs(workDir) {
+				workDir = filepath.Join(packageDir, workDir)
+			}
+		}
+		timeout := time.Duration(h.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultHookTimeout
+		}
+
+		vars := []string{"UPDATE_PHASE=" + phase}
+		for k, v := range env {
+			vars = append(vars, k+"="+v)
+		}
+		for k, v := range h.Env {
+			vars = append(vars, k+"="+v)
+		}
+
+		logger.Printf("[hook %s] running %s", name, strings.Join(h.Command, " "))
+		output, err := runCommand(h.Command, packageDir, workDir, vars, timeout)
+		for _, line := range strings.Split(strings.TrimRight(output, "\r\n"), "\n") {
+			if line != "" {
+				logger.Printf("[hook %s] %s", name, strings.TrimRight(line, "\r"))
+			}
+		}
+		if err != nil {
+			logger.Printf("[hook %s] failed: %v", name, err)
+			return fmt.Errorf("hook %s failed: %w", name, err)
+		}
+		logger.Printf("[hook %s] completed", name)
+	}
+	return nil
+}
+
+// runCommand 执行命令并返回合并的 stdout/stderr
+//
+// 相对路径的可执行文件优先在 baseDir 中查找（包中自带的脚本或程序），env 追加到当前进程的环境变量之后。
+func runCommand(argv []string, baseDir, workDir string, env []string, timeout time.Duration) (string, error) {
+	return runCommandContext(context.Background(), argv, baseDir, workDir, env, timeout)
+}
+
+// runCommandContext 同 runCommand，parent 取消时结束命令
+func runCommandContext(parent context.Context, argv []string, baseDir, workDir string, env []string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	name := argv[0]
+	if !filepath.IsAbs(name) {
+		if _, err := os.Stat(filepath.Join(baseDir, name)); err == nil {
+			name = filepath.Join(baseDir, name)
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, argv[1:]...)
+	killProcessTree(cmd)
+	// 子进程退出或超时后，最多再等待 commandWaitDelay 让仍持有输出管道的后台进程释放
+	cmd.WaitDelay = commandWaitDelay
+	cmd.Dir = workDir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	if parent.Err() != nil {
+		return output.String(), parent.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return output.String(), fmt.Errorf("timed out after %s", timeout)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// 命令本身已成功退出，只是启动的后台进程仍持有输出管道
+		err = nil
+	}
+	return output.String(), err
+}
+
+// commandError 附上命令输出的末尾，便于在错误信息中定位原因
+func commandError(err error, output string) error {
+	out := strings.TrimSpace(output)
+	if out == "" {
+		return err
+	}
+	if len(out) > 500 {
+		out = out[len(out)-500:]
+	}
+	return fmt.Errorf("%w: %s", err, out)
+}
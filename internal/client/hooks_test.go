@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hookPackage 生成带 update-manifest.yaml 的更新包，钩子脚本把阶段和环境变量追加到 journal
+func hookPackage(t *testing.T, path, version, journal string, failPhase string) {
+	t.Helper()
+	script := `echo "$UPDATE_PHASE $UPDATE_OLD_VERSION->$UPDATE_NEW_VERSION $GREETING" >> "` + journal + `"
+echo "output from $UPDATE_PHASE"
+if [ "$UPDATE_PHASE" = "` + failPhase + `" ]; then exit 4; fi
+`
+	manifest := `hooks:
+  pre_apply:
+    - name: stop-service
+      command: ["sh", "hooks/record.sh"]
+      env:
+        GREETING: hello
+  post_apply:
+    - name: migrate-config
+      command: ["sh", "hooks/record.sh"]
+      timeout: 10
+  rollback:
+    - command: ["sh", "hooks/record.sh"]
+`
+	writeZip(t, path, map[string]string{
+		"version.txt":          version,
+		"hooks/record.sh":      script,
+		"update-manifest.yaml": manifest,
+	})
+}
+
+func readJournal(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return lines
+}
+
+func TestInstallerHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts use sh")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	journal := filepath.Join(root, "journal.txt")
+	var logBuf bytes.Buffer
+
+	installer := NewInstaller(InstallConfig{Dir: dir})
+	installer.SetLogger(log.New(&logBuf, "", 0))
+	installer.SetCurrentVersion("1.0.0")
+
+	v2 := filepath.Join(root, "v2.zip")
+	hookPackage(t, v2, "2.0.0", journal, "")
+	if _, err := installer.Apply(v2, "2.0.0"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	want := []string{"pre_apply 1.0.0->2.0.0 hello", "post_apply 1.0.0->2.0.0"}
+	if got := readJournal(t, journal); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("journal = %q, want %q", got, want)
+	}
+	if !strings.Contains(logBuf.String(), "[hook post_apply/migrate-config] output from post_apply") {
+		t.Errorf("hook output was not logged:\n%s", logBuf.String())
+	}
+
+	// post_apply 失败：恢复 2.0.0 并执行失败版本的 rollback 钩子
+	os.Remove(journal)
+	installer.SetCurrentVersion("2.0.0")
+	v3 := filepath.Join(root, "v3.zip")
+	hookPackage(t, v3, "3.0.0", journal, HookPostApply)
+	result, err := installer.Apply(v3, "3.0.0")
+	var ue *UpdateError
+	if !errors.As(err, &ue) || ue.Code != "HOOK_FAILED" {
+		t.Fatalf("expected HOOK_FAILED, got %v", err)
+	}
+	if !result.RolledBack {
+		t.Error("expected the update to be rolled back")
+	}
+	if got := readInstalled(t, dir, "version.txt"); got != "2.0.0" {
+		t.Errorf("installed version = %q, want 2.0.0", got)
+	}
+	want = []string{"pre_apply 2.0.0->3.0.0 hello", "post_apply 2.0.0->3.0.0", "rollback 2.0.0->3.0.0"}
+	if got := readJournal(t, journal); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("journal = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(dir + ".rollback"); !os.IsNotExist(err) {
+		t.Error("the failed version should be removed after its rollback hooks ran")
+	}
+
+	// pre_apply 失败：安装目录不变
+	os.Remove(journal)
+	hookPackage(t, v3, "3.0.0", journal, HookPreApply)
+	result, err = installer.Apply(v3, "3.0.0")
+	if !errors.As(err, &ue) || ue.Code != "HOOK_FAILED" {
+		t.Fatalf("expected HOOK_FAILED, got %v", err)
+	}
+	if result.RolledBack || result.Success {
+		t.Errorf("result = %+v, nothing was replaced so nothing should be rolled back", result)
+	}
+	if got := readInstalled(t, dir, "version.txt"); got != "2.0.0" {
+		t.Errorf("installed version = %q, want 2.0.0", got)
+	}
+	if got := readJournal(t, journal); len(got) != 2 || !strings.HasPrefix(got[1], "rollback") {
+		t.Errorf("journal = %q, want pre_apply then rollback", got)
+	}
+}
+
+func TestInstallerRollbackHookEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts use sh")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	journal := filepath.Join(root, "journal.txt")
+
+	// 手动部署的 1.0.0，没有安装器的版本记录
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "version.txt"), []byte("1.0.0"), 0644)
+
+	installer := NewInstaller(InstallConfig{Dir: dir})
+	installer.SetCurrentVersion("1.0.0")
+	v2 := filepath.Join(root, "v2.zip")
+	hookPackage(t, v2, "2.0.0", journal, "")
+	if _, err := installer.Apply(v2, "2.0.0"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	// 手动回滚：与安装失败时相同，OLD 为恢复的版本，NEW 为被替换的版本
+	os.Remove(journal)
+	result, err := installer.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if result.Version != "1.0.0" {
+		t.Errorf("restored version = %q, want 1.0.0", result.Version)
+	}
+	want := []string{"rollback 1.0.0->2.0.0"}
+	if got := readJournal(t, journal); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("journal = %q, want %q", got, want)
+	}
+
+	// 再次回滚恢复 2.0.0；被替换的 1.0.0 没有钩子
+	os.Remove(journal)
+	if result, err := installer.Rollback(); err != nil || result.Version != "2.0.0" {
+		t.Fatalf("second Rollback = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Error("the replaced 1.0.0 install has no rollback hooks to run")
+	}
+}
+
+func TestLoadPackageManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest, err := LoadPackageManifest(dir)
+	if err != nil || len(manifest.Hooks.PreApply) != 0 {
+		t.Fatalf("missing manifest: %+v, %v", manifest, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, ManifestFileName), []byte("hooks:\n  post_apply:\n    - name: empty\n"), 0644)
+	if _, err := LoadPackageManifest(dir); err == nil {
+		t.Error("expected an error for a hook without a command")
+	}
+}
+
+func TestRunCommandTimeoutKillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	dir := t.TempDir()
+
+	// sh 启动的子进程持有输出管道，超时后须连同子进程一起结束
+	start := time.Now()
+	_, err := runCommand([]string{"sh", "-c", "sleep 5; echo done"}, dir, dir, nil, 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runCommand returned after %s, the timeout did not stop the child process", elapsed)
+	}
+
+	// 命令成功退出但留下仍持有输出管道的后台进程：不阻塞到后台进程结束
+	start = time.Now()
+	output, err := runCommand([]string{"sh", "-c", "sleep 30 & echo started"}, dir, dir, nil, 10*time.Second)
+	if err != nil || strings.TrimSpace(output) != "started" {
+		t.Errorf("runCommand = %q, %v", output, err)
+	}
+	if elapsed := time.Since(start); elapsed > commandWaitDelay+2*time.Second {
+		t.Errorf("runCommand waited %s for a background process", elapsed)
+	}
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadOrCreateInstallID 读取持久化的安装 ID，不存在时生成并写入文件
+//
+// 安装 ID 用于服务端灰度发布分组，必须在多次检查之间保持不变。
+func LoadOrCreateInstallID(path string) (string, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read install id: %w", err)
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate install id: %w", err)
+	}
+	id := hex.EncodeToString(buf)
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create install id directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to write install id: %w", err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadOrCreateInstallID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "update-client.id")
+
+	first, err := LoadOrCreateInstallID(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateInstallID failed: %v", err)
+	}
+	if len(first) != 32 {
+		t.Errorf("Expected 32-char install id, got %q", first)
+	}
+
+	second, err := LoadOrCreateInstallID(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateInstallID failed: %v", err)
+	}
+	if first != second {
+		t.Errorf("Install id should be stable across calls: %s != %s", first, second)
+	}
+}
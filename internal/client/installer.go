@@ -0,0 +1,473 @@
+package client
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installedVersionFile 安装目录中记录所装版本的文件，手动回滚时据此确定恢复的版本
+const installedVersionFile = ".update-version"
+
+// ApplyResult 安装结果
+type ApplyResult struct {
+	Success     bool   `json:"success"`
+	Version     string `json:"version,omitempty"`
+	InstallDir  string `json:"installDir"`
+	PreviousDir string `json:"previousDir,omitempty"` // 保留的上一版本，首次安装时为空
+	RolledBack  bool   `json:"rolledBack,omitempty"`  // 已恢复上一版本（rollback 命令或健康检查失败）
+	Error       string `json:"error,omitempty"`
+}
+
+// Installer 把更新包安装到程序目录
+//
+// 新版本先解压到安装目录旁的 staging 目录，再通过两次目录重命名与安装目录交换，
+// 原安装目录保留为 PreviousDir。staging 与安装目录位于同一父目录，重命名在同一文件系统内完成，
+// 任一时刻安装目录要么是完整的旧版本，要么是完整的新版本。
+// 交换前须停止正在运行的程序（Windows 上无法重命名被占用的目录），可由包中的 pre_apply 钩子完成。
+type Installer struct {
+	config         InstallConfig
+	currentVersion string      // 当前安装的版本，作为 UPDATE_OLD_VERSION 传给钩子
+	logger         *log.Logger // 钩子输出写入的日志
+}
+
+// NewInstaller 创建安装器
+func NewInstaller(config InstallConfig) *Installer {
+	return &Installer{config: config, logger: log.New(io.Discard, "", 0)}
+}
+
+// SetCurrentVersion 设置当前安装的版本
+func (i *Installer) SetCurrentVersion(version string) {
+	i.currentVersion = version
+}
+
+// SetLogger 设置钩子输出写入的日志
+func (i *Installer) SetLogger(logger *log.Logger) {
+	i.logger = logger
+}
+
+// paths 返回安装目录、上一版本目录的绝对路径
+func (i *Installer) paths() (dir, previous string, err error) {
+	if i.config.Dir == "" {
+		return "", "", &UpdateError{Code: "CONFIG_ERROR", Message: "install.dir is not configured"}
+	}
+	dir, err = filepath.Abs(i.config.Dir)
+	if err != nil {
+		return "", "", err
+	}
+	previous = i.config.PreviousDir
+	if previous == "" {
+		previous = dir + ".previous"
+	}
+	previous, err = filepath.Abs(previous)
+	return dir, previous, err
+}
+
+// hookEnv 传给钩子的 UPDATE_* 环境变量
+func (i *Installer) hookEnv(oldVersion, newVersion, dir, previous, packageDir string) map[string]string {
+	return map[string]string{
+		"UPDATE_OLD_VERSION":  oldVersion,
+		"UPDATE_NEW_VERSION":  newVersion,
+		"UPDATE_INSTALL_DIR":  dir,
+		"UPDATE_PREVIOUS_DIR": previous,
+		"UPDATE_PACKAGE_DIR":  packageDir,
+	}
+}
+
+// Apply 解压已校验的更新包并替换安装目录
+//
+// 依次执行包中的 pre_apply 钩子、替换安装目录、post_apply 钩子和健康检查。
+// 任一步失败都视为更新失败：恢复上一版本并执行包中的 rollback 钩子。
+func (i *Installer) Apply(packagePath, version string) (*ApplyResult, error) {
+	dir, previous, err := i.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	staging := dir + ".staging"
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, &UpdateError{Code: "INSTALL_ERROR", Message: "Failed to clean up staging directory", Err: err}
+	}
+	if err := extractPackage(packagePath, staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, &UpdateError{Code: "INSTALL_ERROR", Message: fmt.Sprintf("Failed to extract package: %v", err), Err: err}
+	}
+	manifest, err := LoadPackageManifest(staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, &UpdateError{Code: "INSTALL_ERROR", Message: err.Error(), Err: err}
+	}
+	if err := writeInstalledVersion(staging, version); err != nil {
+		os.RemoveAll(staging)
+		return nil, &UpdateError{Code: "INSTALL_ERROR", Message: fmt.Sprintf("Failed to record installed version: %v", err), Err: err}
+	}
+
+	result := &ApplyResult{Success: true, Version: version, InstallDir: dir}
+	i.logger.Printf("Applying %s to %s", version, dir)
+
+	// pre_apply 失败时安装目录未改动，只需执行 rollback 钩子撤销已执行的操作
+	if err := runHooks(i.logger, HookPreApply, manifest.Hooks.PreApply, staging, i.hookEnv(i.currentVersion, version, dir, previous, staging)); err != nil {
+		hookErr := runHooks(i.logger, HookRollback, manifest.Hooks.Rollback, staging, i.hookEnv(i.currentVersion, version, dir, previous, staging))
+		os.RemoveAll(staging)
+		result.Success = false
+		return result, failureError("HOOK_FAILED", err, "install directory left unchanged", hookErr)
+	}
+
+	// 不是由安装器安装的原目录（如首次手动部署）补记当前版本，之后手动回滚到它时可以确定版本
+	if readInstalledVersion(dir) == "" {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			if err := writeInstalledVersion(dir, i.currentVersion); err != nil {
+				i.logger.Printf("Failed to record the version of %s: %v", dir, err)
+			}
+		}
+	}
+	hasPrevious, err := swapInstall(staging, dir, previous)
+	if err != nil {
+		hookErr := runHooks(i.logger, HookRollback, manifest.Hooks.Rollback, staging, i.hookEnv(i.currentVersion, version, dir, previous, staging))
+		os.RemoveAll(staging)
+		result.Success = false
+		return result, failureError("INSTALL_ERROR", fmt.Errorf("failed to replace install directory: %w", err), "", hookErr)
+	}
+	if hasPrevious {
+		result.PreviousDir = previous
+	}
+
+	code := "HOOK_FAILED"
+	err = runHooks(i.logger, HookPostApply, manifest.Hooks.PostApply, dir, i.hookEnv(i.currentVersion, version, dir, previous, dir))
+	if err == nil {
+		code = "HEALTH_CHECK_FAILED"
+		err = i.runHealthCheck(dir)
+	}
+	if err == nil {
+		i.logger.Printf("Applied %s to %s", version, dir)
+		return result, nil
+	}
+
+	result.Success = false
+	if !hasPrevious {
+		hookErr := runHooks(i.logger, HookRollback, manifest.Hooks.Rollback, dir, i.hookEnv(i.currentVersion, version, dir, previous, dir))
+		return result, failureError(code, err, "no previous install to restore", hookErr)
+	}
+	// 失败的新版本在 rollback 钩子执行完后删除，不作为可恢复的上一版本保留
+	failed, rbErr := restorePrevious(dir, previous)
+	if rbErr != nil {
+		return result, &UpdateError{Code: "ROLLBACK_ERROR", Message: fmt.Sprintf("%v; rollback also failed: %v", err, rbErr), Err: rbErr}
+	}
+	hookErr := runHooks(i.logger, HookRollback, manifest.Hooks.Rollback, failed, i.hookEnv(i.currentVersion, version, dir, "", failed))
+	os.RemoveAll(failed)
+	result.RolledBack = true
+	result.PreviousDir = ""
+	return result, failureError(code, err, "previous install restored", hookErr)
+}
+
+// Rollback 恢复保留的上一版本，当前版本转为上一版本（再次执行可撤销回滚）
+//
+// 恢复后执行被替换版本的 rollback 钩子，与安装失败时相同：UPDATE_OLD_VERSION 为恢复的版本，
+// UPDATE_NEW_VERSION 为被替换的版本。
+func (i *Installer) Rollback() (*ApplyResult, error) {
+	dir, previous, err := i.paths()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(previous); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &UpdateError{Code: "NO_PREVIOUS_INSTALL", Message: fmt.Sprintf("No previous install found at %s", previous)}
+		}
+		return nil, err
+	}
+	displaced, err := restorePrevious(dir, previous)
+	if err == nil && displaced != "" {
+		err = os.Rename(displaced, previous)
+	}
+	if err != nil {
+		return nil, &UpdateError{Code: "ROLLBACK_ERROR", Message: fmt.Sprintf("Failed to restore previous install: %v", err), Err: err}
+	}
+
+	restored := readInstalledVersion(dir)
+	result := &ApplyResult{Success: true, Version: restored, InstallDir: dir, RolledBack: true}
+	if displaced == "" {
+		return result, nil
+	}
+	result.PreviousDir = previous
+	i.logger.Printf("Rolled back %s, replaced install kept at %s", dir, previous)
+
+	replaced := readInstalledVersion(previous)
+	if replaced == "" {
+		replaced = i.currentVersion
+	}
+	manifest, err := LoadPackageManifest(previous)
+	if err == nil {
+		err = runHooks(i.logger, HookRollback, manifest.Hooks.Rollback, previous, i.hookEnv(restored, replaced, dir, previous, previous))
+	}
+	if err != nil {
+		result.Success = false
+		return result, &UpdateError{Code: "HOOK_FAILED", Message: fmt.Sprintf("Previous install restored, but %v", err), Err: err}
+	}
+	return result, nil
+}
+
+// runHealthCheck 在安装目录中执行健康检查命令，未配置时直接通过
+func (i *Installer) runHealthCheck(dir string) error {
+	if len(i.config.HealthCheck) == 0 {
+		return nil
+	}
+	timeout := time.Duration(i.config.HealthCheckTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	output, err := runCommand(i.config.HealthCheck, dir, dir, nil, timeout)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", commandError(err, output))
+	}
+	return nil
+}
+
+// failureError 更新失败的错误，附上回滚结果和 rollback 钩子的错误
+func failureError(code string, cause error, outcome string, hookErr error) error {
+	msg := cause.Error()
+	if outcome != "" {
+		msg += "; " + outcome
+	}
+	if hookErr != nil {
+		msg += fmt.Sprintf("; %v", hookErr)
+	}
+	return &UpdateError{Code: code, Message: msg, Err: cause}
+}
+
+// swapInstall 用 staging 目录替换安装目录，原安装目录移到 previous
+//
+// 第二次重命名失败时把原安装目录移回。返回原安装目录是否存在（首次安装为 false）。
+func swapInstall(staging, dir, previous string) (bool, error) {
+	if _, err := os.Stat(dir); err != nil {
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return false, err
+		}
+		return false, os.Rename(staging, dir)
+	}
+
+	if err := os.RemoveAll(previous); err != nil {
+		return false, err
+	}
+	if err := os.Rename(dir, previous); err != nil {
+		return false, err
+	}
+	if err := os.Rename(staging, dir); err != nil {
+		if restoreErr := os.Rename(previous, dir); restoreErr != nil {
+			return true, fmt.Errorf("%w (restoring the original install also failed: %v)", err, restoreErr)
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// writeInstalledVersion 在安装目录中记录版本，version 为空时不记录
+func writeInstalledVersion(dir, version string) error {
+	if version == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(dir, installedVersionFile), []byte(version+"\n"), 0644)
+}
+
+// readInstalledVersion 读取安装目录中记录的版本，没有记录时返回空
+func readInstalledVersion(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, installedVersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// restorePrevious 把 previous 移回安装目录，返回被替换下来的安装目录（<dir>.rollback，安装目录不存在时为空）
+func restorePrevious(dir, previous string) (string, error) {
+	displaced := dir + ".rollback"
+	if err := os.RemoveAll(displaced); err != nil {
+		return "", err
+	}
+	if err := os.Rename(dir, displaced); err != nil {
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		displaced = ""
+	}
+	if err := os.Rename(previous, dir); err != nil {
+		if displaced != "" {
+			os.Rename(displaced, dir)
+		}
+		return "", err
+	}
+	return displaced, nil
+}
+
+// extractPackage 把 ZIP 包解压到 dest，拒绝指向 dest 之外的路径和符号链接
+func extractPackage(packagePath, dest string) error {
+	reader, err := zip.OpenReader(packagePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	root := filepath.Clean(dest) + string(os.PathSeparator)
+	for _, f := range reader.File {
+		target := filepath.Join(dest, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(target+string(os.PathSeparator), root) {
+			return fmt.Errorf("invalid path in package: %s", f.Name)
+		}
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("symbolic links are not supported: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		perm := mode.Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		if err := extractFile(f, target, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string, perm os.FileMode) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// ApplyWithOutput 安装指定版本并输出结果
+//
+// packagePath 为空时先下载并校验该版本；否则按服务端的版本信息校验本地包的哈希后再安装。
+func (c *UpdateChecker) ApplyWithOutput(version, packagePath string) error {
+	installer := c.newInstaller()
+	if _, _, err := installer.paths(); err != nil {
+		return c.outputError(err)
+	}
+
+	if packagePath == "" {
+		packagePath = c.generateOutputPath(version)
+		if _, _, err := c.downloadVerified(version, packagePath); err != nil {
+			return c.outputError(err)
+		}
+	} else if err := c.verifyLocalPackage(version, packagePath); err != nil {
+		return c.outputError(err)
+	}
+
+	result, err := installer.Apply(packagePath, version)
+	if err != nil {
+		if c.jsonOutput && result != nil {
+			return c.outputApplyResult(result, err)
+		}
+		return c.outputError(err)
+	}
+	return c.outputApplyResult(result, nil)
+}
+
+// RollbackWithOutput 恢复保留的上一版本并输出结果
+func (c *UpdateChecker) RollbackWithOutput() error {
+	result, err := c.newInstaller().Rollback()
+	if err != nil {
+		if c.jsonOutput && result != nil {
+			return c.outputApplyResult(result, err)
+		}
+		return c.outputError(err)
+	}
+	return c.outputApplyResult(result, nil)
+}
+
+// newInstaller 按客户端配置创建安装器，钩子输出追加到 logging.file
+func (c *UpdateChecker) newInstaller() *Installer {
+	installer := NewInstaller(c.config.Install)
+	installer.SetCurrentVersion(c.config.Program.CurrentVersion)
+	if c.installLog == nil && c.config.Logging.File != "" {
+		if f, err := os.OpenFile(c.config.Logging.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			c.installLog = log.New(f, "", log.LstdFlags)
+		}
+	}
+	if c.installLog != nil {
+		installer.SetLogger(c.installLog)
+	}
+	return installer
+}
+
+// verifyLocalPackage 按服务端版本信息（含发布签名）校验已下载的完整包
+func (c *UpdateChecker) verifyLocalPackage(version, packagePath string) error {
+	info, err := c.GetVersionInfo(version)
+	if err == nil {
+		err = c.VerifyUpdateInfo(info)
+	}
+	if err != nil {
+		return err
+	}
+	if info.FileHash == "" {
+		return nil
+	}
+	ok, err := c.VerifyFile(packagePath, info.FileHash)
+	if err != nil || !ok {
+		return &UpdateError{Code: "VERIFY_ERROR", Message: fmt.Sprintf("Package %s does not match version %s", packagePath, version), Err: err}
+	}
+	return nil
+}
+
+// outputApplyResult 输出安装或回滚结果；JSON 模式下失败时输出结果后仍返回 applyErr，使命令以非零状态退出
+func (c *UpdateChecker) outputApplyResult(result *ApplyResult, applyErr error) error {
+	if c.jsonOutput {
+		if applyErr != nil {
+			result.Success = false
+			result.Error = applyErr.Error()
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			return err
+		}
+		return applyErr
+	}
+
+	fmt.Println()
+	switch {
+	case result.RolledBack && result.Version != "":
+		fmt.Printf("✓ Rolled back %s to %s\n", result.InstallDir, result.Version)
+	case result.RolledBack:
+		fmt.Printf("✓ Restored previous install to %s\n", result.InstallDir)
+	default:
+		fmt.Printf("✓ Installed %s to %s\n", result.Version, result.InstallDir)
+	}
+	if result.PreviousDir != "" {
+		if result.RolledBack {
+			fmt.Printf("  Replaced install kept at %s (run \"rollback\" again to undo)\n", result.PreviousDir)
+		} else {
+			fmt.Printf("  Previous install kept at %s (use \"rollback\" to restore it)\n", result.PreviousDir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readInstalled(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestInstallerApplyAndRollback(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	installer := NewInstaller(InstallConfig{Dir: dir})
+
+	v1 := filepath.Join(root, "v1.zip")
+	writeZip(t, v1, map[string]string{"version.txt": "1.0.0", "lib/a.dll": "a1"})
+	v2 := filepath.Join(root, "v2.zip")
+	writeZip(t, v2, map[string]string{"version.txt": "2.0.0"})
+
+	// 首次安装没有上一版本
+	result, err := installer.Apply(v1, "1.0.0")
+	if err != nil {
+		t.Fatalf("Apply v1 failed: %v", err)
+	}
+	if result.PreviousDir != "" {
+		t.Errorf("first install should have no previous dir, got %s", result.PreviousDir)
+	}
+	if got := readInstalled(t, dir, "lib/a.dll"); got != "a1" {
+		t.Errorf("lib/a.dll = %q", got)
+	}
+
+	// 替换后旧版本保留在 <dir>.previous，不残留旧文件
+	result, err = installer.Apply(v2, "2.0.0")
+	if err != nil {
+		t.Fatalf("Apply v2 failed: %v", err)
+	}
+	if result.PreviousDir != dir+".previous" {
+		t.Errorf("PreviousDir = %s", result.PreviousDir)
+	}
+	if got := readInstalled(t, dir, "version.txt"); got != "2.0.0" {
+		t.Errorf("installed version = %q, want 2.0.0", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lib/a.dll")); !os.IsNotExist(err) {
+		t.Error("files from the old version should not remain")
+	}
+	if _, err := os.Stat(dir + ".staging"); !os.IsNotExist(err) {
+		t.Error("staging directory should be gone")
+	}
+
+	// rollback 交换两个版本，再次执行可撤销
+	if _, err := installer.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := readInstalled(t, dir, "version.txt"); got != "1.0.0" {
+		t.Errorf("after rollback version = %q, want 1.0.0", got)
+	}
+	if got := readInstalled(t, dir+".previous", "version.txt"); got != "2.0.0" {
+		t.Errorf("after rollback previous = %q, want 2.0.0", got)
+	}
+	installer.Rollback()
+	if got := readInstalled(t, dir, "version.txt"); got != "2.0.0" {
+		t.Errorf("after second rollback version = %q, want 2.0.0", got)
+	}
+
+	// 没有上一版本时 rollback 报错
+	os.RemoveAll(dir + ".previous")
+	_, err = installer.Rollback()
+	var ue *UpdateError
+	if !errors.As(err, &ue) || ue.Code != "NO_PREVIOUS_INSTALL" {
+		t.Errorf("expected NO_PREVIOUS_INSTALL, got %v", err)
+	}
+}
+
+func TestInstallerHealthCheckRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("health check script uses sh")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+
+	good := filepath.Join(root, "good.zip")
+	writeZip(t, good, map[string]string{"version.txt": "1.0.0", "check.sh": "exit 0"})
+	bad := filepath.Join(root, "bad.zip")
+	writeZip(t, bad, map[string]string{"version.txt": "2.0.0", "check.sh": "echo broken; exit 3"})
+
+	installer := NewInstaller(InstallConfig{Dir: dir, HealthCheck: []string{"sh", "check.sh"}, HealthCheckTimeout: 10})
+	if _, err := installer.Apply(good, "1.0.0"); err != nil {
+		t.Fatalf("Apply good failed: %v", err)
+	}
+
+	// 健康检查失败：恢复上一版本，失败的版本不保留
+	result, err := installer.Apply(bad, "2.0.0")
+	var ue *UpdateError
+	if !errors.As(err, &ue) || ue.Code != "HEALTH_CHECK_FAILED" {
+		t.Fatalf("expected HEALTH_CHECK_FAILED, got %v", err)
+	}
+	if !result.RolledBack || result.Success {
+		t.Errorf("result = %+v, want rolled back", result)
+	}
+	if got := readInstalled(t, dir, "version.txt"); got != "1.0.0" {
+		t.Errorf("after failed health check version = %q, want 1.0.0", got)
+	}
+	if _, err := os.Stat(dir + ".previous"); !os.IsNotExist(err) {
+		t.Error("the failed version should not be kept as previous install")
+	}
+}
+
+func TestExtractPackageRejectsEscapingPaths(t *testing.T) {
+	root := t.TempDir()
+	pkg := filepath.Join(root, "evil.zip")
+	writeZip(t, pkg, map[string]string{"../outside.txt": "x"})
+
+	if err := extractPackage(pkg, filepath.Join(root, "dest")); err == nil {
+		t.Fatal("expected an error for a path outside the destination")
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside.txt")); !os.IsNotExist(err) {
+		t.Error("file was written outside the destination")
+	}
+}
+
+// captureStdout 返回 fn 执行期间写入 os.Stdout 的内容
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestApplyWithOutputJSONFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("health check script uses sh")
+	}
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	good := filepath.Join(root, "good.zip")
+	writeZip(t, good, map[string]string{"version.txt": "1.0.0", "check.sh": "exit 0"})
+	bad := filepath.Join(root, "bad.zip")
+	writeZip(t, bad, map[string]string{"version.txt": "2.0.0", "check.sh": "exit 3"})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(UpdateInfo{ProgramID: "testapp", Version: "2.0.0", Channel: "stable"})
+	}))
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.ServerURL = srv.URL
+	config.ProgramID = "testapp"
+	config.Auth.KeyringFile = ""
+	config.Auth.TokenFile = ""
+	config.Logging.File = ""
+	config.Install = InstallConfig{Dir: dir, HealthCheck: []string{"sh", "check.sh"}, HealthCheckTimeout: 10}
+	checker := NewUpdateChecker(config, true)
+	if _, err := checker.newInstaller().Apply(good, "1.0.0"); err != nil {
+		t.Fatalf("Apply good failed: %v", err)
+	}
+
+	// 健康检查失败并回滚：输出 success=false、rolledBack=true，且返回错误使命令以非零状态退出
+	var err error
+	output := captureStdout(t, func() { err = checker.ApplyWithOutput("2.0.0", bad) })
+	var ue *UpdateError
+	if !errors.As(err, &ue) || ue.Code != "HEALTH_CHECK_FAILED" {
+		t.Errorf("ApplyWithOutput error = %v, want HEALTH_CHECK_FAILED", err)
+	}
+	var result map[string]interface{}
+	if jsonErr := json.Unmarshal([]byte(output), &result); jsonErr != nil {
+		t.Fatalf("invalid JSON output %q: %v", output, jsonErr)
+	}
+	if result["success"] != false || result["rolledBack"] != true || result["error"] == nil {
+		t.Errorf("output = %v, want success=false, rolledBack=true and an error", result)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"docufiller-update-server/internal/pkgcrypt"
+)
+
+// LoadKeyring 汇总客户端持有的包加密密钥，返回密钥环和当前（最新）密钥 ID
+//
+// 密钥来自配置的 encryption_key、encryption_keys，以及 keyring_file 中保存的、
+// 更新检查时收到的新密钥。keyring_file 中最后一个密钥最新，否则 encryption_key 为当前密钥。
+func LoadKeyring(auth AuthConfig) (pkgcrypt.Keyring, string, error) {
+	keys := pkgcrypt.Keyring{}
+	current := ""
+
+	if auth.EncryptionKey != "" {
+		key, err := pkgcrypt.ParseKey(auth.EncryptionKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid encryption_key: %w", err)
+		}
+		current = keys.Add(key)
+	}
+	for i, k := range auth.EncryptionKeys {
+		key, err := pkgcrypt.ParseKey(k)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid encryption_keys[%d]: %w", i, err)
+		}
+		keys.Add(key)
+	}
+
+	if auth.KeyringFile == "" {
+		return keys, current, nil
+	}
+	f, err := os.Open(auth.KeyringFile)
+	if os.IsNotExist(err) {
+		return keys, current, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read keyring: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, err := pkgcrypt.ParseKey(line)
+		if err != nil {
+			continue // 忽略损坏的行，其余密钥仍可用
+		}
+		current = keys.Add(key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to read keyring: %w", err)
+	}
+	return keys, current, nil
+}
+
+// appendKeyring 将新密钥追加到 keyring_file，成为当前密钥
+func appendKeyring(path string, key []byte) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create keyring directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open keyring: %w", err)
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return f.Close()
+}
+
+// getKeyring 获取客户端密钥环（延迟加载），配置无效时返回空密钥环
+func (c *UpdateChecker) getKeyring() (pkgcrypt.Keyring, string) {
+	if c.keyring == nil {
+		keys, current, err := LoadKeyring(c.config.Auth)
+		if err != nil {
+			keys, current = pkgcrypt.Keyring{}, ""
+		}
+		c.keyring, c.currentKeyID = keys, current
+	}
+	return c.keyring, c.currentKeyID
+}
+
+// applyKeyUpdate 用已有的旧密钥解开服务端下发的新密钥，保存到 keyring_file
+func (c *UpdateChecker) applyKeyUpdate(update *KeyUpdate) error {
+	keys, _ := c.getKeyring()
+	if _, ok := keys[update.KeyID]; ok {
+		return nil
+	}
+	kek, ok := keys[update.WrappedWith]
+	if !ok {
+		return fmt.Errorf("key update wrapped with unknown key %s", update.WrappedWith)
+	}
+	key, err := pkgcrypt.UnwrapKey(kek, update.KeyID, update.WrappedKey)
+	if err != nil {
+		return err
+	}
+	if c.config.Auth.KeyringFile != "" {
+		if err := appendKeyring(c.config.Auth.KeyringFile, key); err != nil {
+			return err
+		}
+	}
+	c.currentKeyID = keys.Add(key)
+	return nil
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+
+	"docufiller-update-server/internal/pkgcrypt"
+)
+
+func TestApplyKeyUpdate(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+
+	cfg := DefaultConfig()
+	cfg.Auth.EncryptionKey = base64.StdEncoding.EncodeToString(oldKey)
+	cfg.Auth.KeyringFile = filepath.Join(t.TempDir(), "client.keys")
+	checker := NewUpdateChecker(cfg, true)
+
+	if _, current := checker.getKeyring(); current != pkgcrypt.KeyID(oldKey) {
+		t.Fatalf("current key = %s, want the configured key", current)
+	}
+
+	wrapped, err := pkgcrypt.WrapKey(oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := &KeyUpdate{KeyID: pkgcrypt.KeyID(newKey), WrappedKey: wrapped, WrappedWith: pkgcrypt.KeyID(oldKey)}
+	if err := checker.applyKeyUpdate(update); err != nil {
+		t.Fatalf("applyKeyUpdate failed: %v", err)
+	}
+
+	// The received key survives a restart and becomes current; the configured key stays usable
+	keys, current, err := LoadKeyring(cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != pkgcrypt.KeyID(newKey) {
+		t.Errorf("current key after reload = %s, want %s", current, pkgcrypt.KeyID(newKey))
+	}
+	if _, ok := keys[pkgcrypt.KeyID(oldKey)]; !ok {
+		t.Error("configured key missing from keyring")
+	}
+
+	// An update wrapped with a key the client never had is rejected
+	other := make([]byte, 32)
+	rand.Read(other)
+	wrapped, _ = pkgcrypt.WrapKey(other, other)
+	fresh := NewUpdateChecker(cfg, true)
+	if err := fresh.applyKeyUpdate(&KeyUpdate{KeyID: pkgcrypt.KeyID(other), WrappedKey: wrapped, WrappedWith: pkgcrypt.KeyID(other)}); err == nil {
+		t.Error("expected error for key wrapped with an unknown key")
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"docufiller-update-server/internal/delta"
+)
+
+// selectPatch 选择本地存在基础包的最小补丁
+func (c *UpdateChecker) selectPatch(info *UpdateInfo) (*PatchInfo, string) {
+	var best *PatchInfo
+	var bestBase string
+	for i := range info.Patches {
+		p := &info.Patches[i]
+		base := c.localPackagePath(p.From)
+		if base == "" {
+			continue
+		}
+		if _, err := os.Stat(base); err != nil {
+			continue
+		}
+		if best == nil || p.Size < best.Size {
+			best, bestBase = p, base
+		}
+	}
+	return best, bestBase
+}
+
+// localPackagePath 本地保存的指定版本完整包路径（仅按版本号命名时可定位）
+func (c *UpdateChecker) localPackagePath(version string) string {
+	if c.config.Download.Naming != "version" {
+		return ""
+	}
+	return c.generateOutputPath(version)
+}
+
+// downloadWithPatch 下载补丁并以本地基础包重建完整包
+//
+// 补丁和重建结果分别按补丁哈希与完整包 FileHash 校验，任何一步失败都不会留下目标文件。
+func (c *UpdateChecker) downloadWithPatch(info *UpdateInfo, patch *PatchInfo, basePath, destPath string) error {
+	if c.daemonState != nil {
+		c.daemonState.SetState("downloading")
+	}
+
+	url := patch.URL
+	if strings.HasPrefix(url, "/") {
+		url = c.config.ServerURL + url
+	}
+	req, err := c.newRequest(http.MethodGet, url)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d for patch", resp.StatusCode)
+	}
+
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := destPath + ".patching"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	patchHash := sha256.New()
+	fileHash := sha256.New()
+	err = delta.Apply(base, io.TeeReader(resp.Body, patchHash), io.MultiWriter(out, fileHash))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if patch.Hash != "" && hex.EncodeToString(patchHash.Sum(nil)) != patch.Hash {
+		return fmt.Errorf("patch hash mismatch")
+	}
+	if info.FileHash != "" && hex.EncodeToString(fileHash.Sum(nil)) != info.FileHash {
+		return fmt.Errorf("rebuilt package hash mismatch")
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return err
+	}
+
+	if c.daemonState != nil {
+		c.daemonState.SetCompleted(destPath)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docufiller-update-server/internal/delta"
+)
+
+func writePackage(t *testing.T, path, appContent string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"lib/shared.dll": strings.Repeat("shared ", 4096),
+		"app.exe":        appContent,
+	} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+}
+
+func fileSHA256(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadWithPatch(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.ProgramID = "testapp"
+	config.Download.SavePath = dir
+	checker := NewUpdateChecker(config, true)
+
+	basePath := checker.generateOutputPath("1.0.0")
+	writePackage(t, basePath, "app v1")
+	newPath := filepath.Join(dir, "source-1.1.0.zip")
+	writePackage(t, newPath, "app v2")
+
+	var patch bytes.Buffer
+	if err := delta.Diff(basePath, newPath, &patch); err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	patchSum := sha256.Sum256(patch.Bytes())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(patch.Bytes())
+	}))
+	defer srv.Close()
+	config.ServerURL = srv.URL
+
+	info := &UpdateInfo{
+		Version:  "1.1.0",
+		FileHash: fileSHA256(t, newPath),
+		Patches: []PatchInfo{
+			{From: "0.9.0", Size: 1, URL: "/missing-base"},
+			{From: "1.0.0", Size: int64(patch.Len()), Hash: hex.EncodeToString(patchSum[:]), URL: "/patch"},
+		},
+	}
+
+	p, base := checker.selectPatch(info)
+	if p == nil || p.From != "1.0.0" || base != basePath {
+		t.Fatalf("selectPatch = %+v, %q; want patch from 1.0.0", p, base)
+	}
+
+	destPath := checker.generateOutputPath("1.1.0")
+	if err := checker.downloadWithPatch(info, p, base, destPath); err != nil {
+		t.Fatalf("downloadWithPatch failed: %v", err)
+	}
+	if got := fileSHA256(t, destPath); got != info.FileHash {
+		t.Errorf("rebuilt hash = %s, want %s", got, info.FileHash)
+	}
+
+	// 哈希不匹配时不留下目标文件
+	os.Remove(destPath)
+	info.FileHash = strings.Repeat("0", 64)
+	if err := checker.downloadWithPatch(info, p, base, destPath); err == nil {
+		t.Error("expected hash mismatch error")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Error("destination should not exist after a failed patch")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package client
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessTree 让命令在独立的进程组中运行，超时时结束整个进程组（包括脚本启动的子进程）
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package client
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// killProcessTree 超时时用 taskkill /T 结束命令及其启动的所有子进程
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
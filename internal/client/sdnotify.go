@@ -0,0 +1,42 @@
+package client
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify 向 systemd 发送服务状态通知（Type=notify），未由 systemd 启动时不做任何操作
+//
+// state 如 "READY=1"、"STATUS=..."、"STOPPING=1"、"WATCHDOG=1"。
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// 以 @ 开头的是 Linux 抽象命名空间套接字
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval 启用了 systemd watchdog（WatchdogSec）时返回发送 WATCHDOG=1 的间隔，否则为 0
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	// 按 systemd 建议，以超时时间的一半发送
+	return time.Duration(usec) * time.Microsecond / 2
+}
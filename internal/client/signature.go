@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"docufiller-update-server/internal/signing"
+)
+
+// VerifyUpdateInfo 使用配置中固定的公钥校验发布清单签名
+//
+// 未配置公钥时不校验；配置后缺少签名或签名无效都会拒绝，
+// 签名覆盖文件哈希，下载完成后的哈希校验因此可信。
+// 清单中的程序和通道取客户端自己的配置和请求的通道，其他程序或通道的有效签名同样被拒绝。
+func (c *UpdateChecker) VerifyUpdateInfo(info *UpdateInfo) error {
+	publicKey := c.config.Auth.SigningPublicKey
+	if publicKey == "" {
+		return nil
+	}
+	if info.Signature == "" {
+		return &UpdateError{
+			Code:    "SIGNATURE_ERROR",
+			Message: fmt.Sprintf("Version %s is not signed", info.Version),
+		}
+	}
+	programID, channel := c.config.GetProgramID(), c.channel()
+	if info.ProgramID != programID || info.Channel != channel {
+		return &UpdateError{
+			Code: "SIGNATURE_ERROR",
+			Message: fmt.Sprintf("Version %s is for %s/%s, expected %s/%s",
+				info.Version, info.ProgramID, info.Channel, programID, channel),
+		}
+	}
+	manifest := info.Manifest()
+	manifest.ProgramID, manifest.Channel = programID, channel
+	if err := signing.Verify(publicKey, manifest, info.Signature); err != nil {
+		return &UpdateError{
+			Code:    "SIGNATURE_ERROR",
+			Message: fmt.Sprintf("Signature verification failed for version %s", info.Version),
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// Manifest 返回更新信息对应的发布清单
+//
+// 低于最低支持版本时服务端会覆盖 mandatory，签名按版本自身的标记校验。
+func (info *UpdateInfo) Manifest() signing.Manifest {
+	mandatory := info.Mandatory
+	if info.ReleaseMandatory != nil {
+		mandatory = *info.ReleaseMandatory
+	}
+	return signing.Manifest{
+		ProgramID: info.ProgramID,
+		Version:   info.Version,
+		Channel:   info.Channel,
+		Platform:  info.Platform,
+		FileHash:  info.FileHash,
+		FileSize:  info.FileSize,
+		Mandatory: mandatory,
+	}
+}
+
+// GetVersionInfo 获取指定版本的详情（按客户端平台选择制品）
+func (c *UpdateChecker) GetVersionInfo(version string) (*UpdateInfo, error) {
+	url := fmt.Sprintf("%s/api/programs/%s/versions/%s/%s",
+		c.config.ServerURL, c.config.GetProgramID(), c.channel(), version)
+
+	req, err := c.newRequest(http.MethodGet, url)
+	if err != nil {
+		return nil, &UpdateError{Code: "REQUEST_ERROR", Message: "Failed to build request", Err: err}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &UpdateError{
+			Code:    "NETWORK_ERROR",
+			Message: fmt.Sprintf("Failed to connect to server: %v", err),
+			Err:     err,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &UpdateError{Code: "NO_VERSION", Message: fmt.Sprintf("Version %s not found", version)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &UpdateError{Code: "SERVER_ERROR", Message: fmt.Sprintf("Server returned status %d", resp.StatusCode)}
+	}
+
+	var info UpdateInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, &UpdateError{Code: "PARSE_ERROR", Message: "Failed to parse response", Err: err}
+	}
+	return &info, nil
+}
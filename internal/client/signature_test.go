@@ -0,0 +1,110 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"docufiller-update-server/internal/signing"
+)
+
+func TestVerifyUpdateInfo(t *testing.T) {
+	publicKey, privateKey, err := signing.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &UpdateInfo{
+		ProgramID: "testapp",
+		Version:   "1.1.0",
+		Channel:   "stable",
+		FileHash:  "ab12",
+		FileSize:  100,
+	}
+	info.Signature, _ = signing.Sign(privateKey, info.Manifest())
+
+	config := DefaultConfig()
+	config.ProgramID = "testapp"
+	checker := NewUpdateChecker(config, true)
+
+	// 未固定公钥时不校验
+	if err := checker.VerifyUpdateInfo(&UpdateInfo{Version: "1.1.0"}); err != nil {
+		t.Errorf("expected no verification without a pinned key, got %v", err)
+	}
+
+	config.Auth.SigningPublicKey = publicKey
+	if err := checker.VerifyUpdateInfo(info); err != nil {
+		t.Errorf("VerifyUpdateInfo failed: %v", err)
+	}
+
+	tampered := *info
+	tampered.FileHash = "cd34"
+	if ue, ok := checker.VerifyUpdateInfo(&tampered).(*UpdateError); !ok || ue.Code != "SIGNATURE_ERROR" {
+		t.Errorf("expected SIGNATURE_ERROR for tampered info, got %v", ue)
+	}
+
+	unsigned := *info
+	unsigned.Signature = ""
+	if ue, ok := checker.VerifyUpdateInfo(&unsigned).(*UpdateError); !ok || ue.Code != "SIGNATURE_ERROR" {
+		t.Errorf("expected SIGNATURE_ERROR for unsigned info, got %v", ue)
+	}
+
+	// 同一密钥为其他通道或程序签名的有效清单不能发给本客户端
+	beta := *info
+	beta.Channel = "beta"
+	beta.Signature, _ = signing.Sign(privateKey, beta.Manifest())
+	if ue, ok := checker.VerifyUpdateInfo(&beta).(*UpdateError); !ok || ue.Code != "SIGNATURE_ERROR" {
+		t.Errorf("expected SIGNATURE_ERROR for a manifest signed for the beta channel, got %v", ue)
+	}
+	otherProgram := *info
+	otherProgram.ProgramID = "otherapp"
+	otherProgram.Signature, _ = signing.Sign(privateKey, otherProgram.Manifest())
+	if ue, ok := checker.VerifyUpdateInfo(&otherProgram).(*UpdateError); !ok || ue.Code != "SIGNATURE_ERROR" {
+		t.Errorf("expected SIGNATURE_ERROR for a manifest signed for another program, got %v", ue)
+	}
+
+	// 低于最低支持版本时服务端覆盖 mandatory，签名按版本自身的标记校验
+	forced := *info
+	releaseMandatory := false
+	forced.Mandatory = true
+	forced.ReleaseMandatory = &releaseMandatory
+	if err := checker.VerifyUpdateInfo(&forced); err != nil {
+		t.Errorf("expected forced mandatory to keep the signature valid, got %v", err)
+	}
+}
+
+func TestDownloadRefusesBadSignature(t *testing.T) {
+	publicKey, _, _ := signing.GenerateKey()
+	_, otherKey, _ := signing.GenerateKey()
+
+	info := UpdateInfo{ProgramID: "testapp", Version: "1.1.0", Channel: "stable", FileHash: "ab12", FileSize: 4}
+	info.Signature, _ = signing.Sign(otherKey, info.Manifest())
+
+	downloaded := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/programs/testapp/versions/latest" {
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+		downloaded = true
+		w.Write([]byte("data"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.ServerURL = srv.URL
+	config.ProgramID = "testapp"
+	config.Download.SavePath = dir
+	config.Auth.SigningPublicKey = publicKey
+	checker := NewUpdateChecker(config, false)
+
+	err := checker.DownloadWithOutput("1.1.0", "")
+	if ue, ok := err.(*UpdateError); !ok || ue.Code != "SIGNATURE_ERROR" {
+		t.Errorf("expected SIGNATURE_ERROR, got %v", err)
+	}
+	if downloaded {
+		t.Error("package must not be downloaded when the signature is invalid")
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadToken 返回客户端使用的认证 Token
+//
+// token_file 中保存的是 Token 轮换时收到的新 Token，存在时优先于配置中的 token。
+func LoadToken(auth AuthConfig) string {
+	if auth.TokenFile != "" {
+		if data, err := os.ReadFile(auth.TokenFile); err == nil {
+			if token := strings.TrimSpace(string(data)); token != "" {
+				return token
+			}
+		}
+	}
+	return auth.Token
+}
+
+// saveToken 写入 token_file（先写临时文件再重命名，避免中断时留下不完整的 Token）
+func saveToken(path, token string) error {
+	dir := filepath.Dir(path)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create token directory: %w", err)
+		}
+	}
+	tmp, err := os.CreateTemp(dir, ".token-*")
+	if err != nil {
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	if _, err := tmp.WriteString(token + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	return nil
+}
+
+// getToken 获取认证 Token（延迟加载）
+func (c *UpdateChecker) getToken() string {
+	if c.token == "" {
+		c.token = LoadToken(c.config.Auth)
+	}
+	return c.token
+}
+
+// applyTokenUpdate 保存服务端下发的替换 Token，之后的请求改用新 Token
+//
+// 未配置 token_file 时只在本次运行中生效，需手动更新配置中的 token。
+func (c *UpdateChecker) applyTokenUpdate(update *TokenUpdate) error {
+	if update.Token == "" || update.Token == c.getToken() {
+		return nil
+	}
+	if c.config.Auth.TokenFile != "" {
+		if err := saveToken(c.config.Auth.TokenFile, update.Token); err != nil {
+			return err
+		}
+	}
+	c.token = update.Token
+	return nil
+}
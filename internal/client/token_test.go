@@ -0,0 +1,48 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenUpdateOnCheck(t *testing.T) {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		info := UpdateInfo{Version: "1.0.0"}
+		if r.Header.Get("Authorization") == "Bearer old-token" {
+			info.TokenUpdate = &TokenUpdate{Token: "new-token"}
+		}
+		json.NewEncoder(w).Encode(info)
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = server.URL
+	cfg.Program.ID = "testapp"
+	cfg.Auth.Token = "old-token"
+	cfg.Auth.TokenFile = filepath.Join(t.TempDir(), "client.token")
+	checker := NewUpdateChecker(cfg, true)
+
+	for i := 0; i < 2; i++ {
+		if _, err := checker.CheckUpdate("1.0.0"); err != nil {
+			t.Fatalf("CheckUpdate failed: %v", err)
+		}
+	}
+	if len(seen) != 2 || seen[0] != "Bearer old-token" || seen[1] != "Bearer new-token" {
+		t.Errorf("Authorization headers = %v, want the replacement token after the first check", seen)
+	}
+
+	// The replacement survives a restart and takes precedence over the configured token
+	if token := LoadToken(cfg.Auth); token != "new-token" {
+		t.Errorf("LoadToken = %q, want new-token", token)
+	}
+
+	cfg.Auth.TokenFile = filepath.Join(t.TempDir(), "missing.token")
+	if token := LoadToken(cfg.Auth); token != "old-token" {
+		t.Errorf("LoadToken without token file = %q, want the configured token", token)
+	}
+}
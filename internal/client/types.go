@@ -14,6 +14,57 @@ type UpdateInfo struct {
 	PublishDate  time.Time `json:"publishDate"`
 	Mandatory    bool      `json:"mandatory"`
 	DownloadCount int      `json:"downloadCount"`
+	Yanked       bool      `json:"yanked"`
+	YankReason   string    `json:"yankReason,omitempty"`
+	Platform     string    `json:"platform,omitempty"` // 服务端选中的平台制品
+	Patches      []PatchInfo `json:"patches,omitempty"`  // 可用的增量补丁
+	Signature    string    `json:"signature,omitempty"` // 发布清单的 Ed25519 签名
+
+	// 加密存储的包：下载内容为密文，FileSize/FileHash 对应解密后的明文
+	Encrypted       bool   `json:"encrypted,omitempty"`
+	EncryptedSize   int64  `json:"encryptedSize,omitempty"`
+	EncryptedHash   string `json:"encryptedHash,omitempty"`
+	EncryptionKeyID string `json:"encryptionKeyId,omitempty"` // 加密所用密钥，客户端须持有
+
+	// 客户端密钥不是当前密钥时，服务端下发用旧密钥包装的新密钥
+	KeyUpdate *KeyUpdate `json:"keyUpdate,omitempty"`
+	// 请求所用 Token 正在轮换时，服务端下发替换 Token
+	TokenUpdate *TokenUpdate `json:"tokenUpdate,omitempty"`
+
+	// 调用方当前版本的撤回状态（请求时携带 current 参数）
+	CurrentYanked     bool   `json:"currentYanked,omitempty"`
+	CurrentYankReason string `json:"currentYankReason,omitempty"`
+
+	// 升级路径：Version 为下一跳时 LatestVersion 为最终目标版本
+	LatestVersion  string `json:"latestVersion,omitempty"`
+	MinUpgradeFrom string `json:"minUpgradeFrom,omitempty"`
+	RequiredStep   bool   `json:"requiredStep,omitempty"`
+
+	// 当前版本低于通道最低支持版本时 Mandatory 被服务端置为 true，ReleaseMandatory 为版本自身的标记
+	ReleaseMandatory    *bool  `json:"releaseMandatory,omitempty"`
+	MinSupportedVersion string `json:"minSupportedVersion,omitempty"`
+	BelowMinSupported   bool   `json:"belowMinSupported,omitempty"`
+}
+
+// KeyUpdate 用客户端已有密钥（WrappedWith）包装的新密钥
+type KeyUpdate struct {
+	KeyID       string `json:"keyId"`
+	WrappedKey  string `json:"wrappedKey"`
+	WrappedWith string `json:"wrappedWith"`
+}
+
+// TokenUpdate 替换 Token，旧 Token 在 ExpiresAt 后失效
+type TokenUpdate struct {
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// PatchInfo 增量补丁信息
+type PatchInfo struct {
+	From string `json:"from"` // 基础版本
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+	URL  string `json:"url"`
 }
 
 // DownloadProgress 下载进度
@@ -23,6 +74,7 @@ type DownloadProgress struct {
 	Total      int64
 	Percentage float64
 	Speed      float64 // bytes/second
+	ResumedFrom int64  // 续传起始位置，0 表示从头下载
 }
 
 // ProgressCallback 进度回调函数
@@ -1,56 +1,11 @@
 package client
 
 import (
-	"strconv"
-	"strings"
+	"docufiller-update-server/internal/semver"
 )
 
-// CompareVersions 比较两个版本号
+// CompareVersions 比较两个版本号（SemVer 2.0 规则，与服务端一致）
 // 返回: -1 (v1 < v2), 0 (v1 == v2), 1 (v1 > v2)
 func CompareVersions(v1, v2 string) int {
-	// 移除 v 前缀
-	v1 = strings.TrimPrefix(v1, "v")
-	v2 = strings.TrimPrefix(v2, "v")
-
-	parts1 := parseVersion(v1)
-	parts2 := parseVersion(v2)
-
-	maxLen := len(parts1)
-	if len(parts2) > maxLen {
-		maxLen = len(parts2)
-	}
-
-	for i := 0; i < maxLen; i++ {
-		p1 := 0
-		p2 := 0
-
-		if i < len(parts1) {
-			p1 = parts1[i]
-		}
-		if i < len(parts2) {
-			p2 = parts2[i]
-		}
-
-		if p1 > p2 {
-			return 1
-		}
-		if p1 < p2 {
-			return -1
-		}
-	}
-
-	return 0
-}
-
-// parseVersion 解析版本号字符串为整数数组
-func parseVersion(version string) []int {
-	parts := strings.Split(version, ".")
-	result := make([]int, len(parts))
-
-	for i, part := range parts {
-		val, _ := strconv.Atoi(part)
-		result[i] = val
-	}
-
-	return result
+	return semver.Compare(v1, v2)
 }
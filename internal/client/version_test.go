@@ -17,6 +17,9 @@ func TestCompareVersions(t *testing.T) {
 		{"with v prefix", "v1.0.0", "1.0.0", 0},
 		{"three parts", "1.2.3", "1.2.2", 1},
 		{"four parts", "1.2.3.4", "1.2.3.3", 1},
+		{"pre-release lower than release", "1.0.0-beta.1", "1.0.0", -1},
+		{"pre-release numeric identifiers", "2.0.0-rc.10", "2.0.0-rc.9", 1},
+		{"build metadata ignored", "1.0.0+20260101", "1.0.0", 0},
 	}
 
 	for _, tt := range tests {
@@ -21,8 +21,9 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Host string `yaml:"host"`
+	Port           int      `yaml:"port"`
+	Host           string   `yaml:"host"`
+	TrustedProxies []string `yaml:"trustedProxies"` // 反向代理地址（IP 或 CIDR），只信任来自这些地址的 X-Forwarded-For
 }
 
 type DatabaseConfig struct {
@@ -55,8 +56,9 @@ type CryptoConfig struct {
 
 // AdminConfig 管理员配置
 type AdminConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	RequireTOTP bool   `yaml:"requireTotp"` // owner 和 maintainer（可发布、轮换密钥）必须启用两步验证
 }
 
 // Load 从 YAML 文件加载配置
@@ -2,12 +2,14 @@ package database
 
 import (
 	"context"
+	"encoding/base64"
 	"os"
 	"path/filepath"
 	"time"
 
 	"docufiller-update-server/internal/logger"
 	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
@@ -79,10 +81,51 @@ func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (stri
 
 // AutoMigrate 自动迁移数据库模型
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
 		&models.Program{},
 		&models.Version{},
+		&models.VersionArtifact{},
+		&models.VersionPatch{},
+		&models.UploadSession{},
+		&models.UploadChunk{},
+		&models.ChannelPolicy{},
 		&models.Token{},
 		&models.EncryptionKey{},
-	)
+		&models.AdminUser{},
+		&models.AdminSession{},
+		&models.LoginAttempt{},
+	); err != nil {
+		return err
+	}
+	return migrateEncryptionKeys(db)
+}
+
+// migrateEncryptionKeys 将每个程序一条的旧密钥记录迁移为密钥历史
+//
+// 旧表在 program_id 上有唯一索引，会阻止轮换时保存新密钥；旧记录补上派生的密钥 ID 并标记为 active。
+func migrateEncryptionKeys(db *gorm.DB) error {
+	const legacyIndex = "idx_encryption_keys_program_id"
+	if db.Migrator().HasIndex(&models.EncryptionKey{}, legacyIndex) {
+		if err := db.Migrator().DropIndex(&models.EncryptionKey{}, legacyIndex); err != nil {
+			return err
+		}
+	}
+
+	var legacy []models.EncryptionKey
+	if err := db.Unscoped().Where("key_id IS NULL OR key_id = ''").Find(&legacy).Error; err != nil {
+		return err
+	}
+	for _, k := range legacy {
+		raw, err := base64.StdEncoding.DecodeString(k.KeyData)
+		if err != nil {
+			logger.Warnf("Skipping unreadable encryption key for %s: %v", k.ProgramID, err)
+			continue
+		}
+		err = db.Unscoped().Model(&models.EncryptionKey{}).Where("id = ?", k.ID).
+			Updates(map[string]interface{}{"key_id": pkgcrypt.KeyID(raw), "status": "active"}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
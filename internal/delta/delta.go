@@ -0,0 +1,303 @@
+// Package delta 生成和应用 zip 包之间的增量补丁
+//
+// 补丁按 zip 条目对齐：新包中与旧包原始压缩数据完全相同的条目记录为对旧包的区间复制，
+// 其余字节（文件头、中央目录、变化的条目）原样写入补丁。应用补丁得到的文件与新包逐字节一致，
+// 因此可以直接用新包的 SHA256 校验重建结果。
+package delta
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// magic 补丁文件头
+var magic = []byte("UPDELTA1")
+
+// 补丁操作码
+const (
+	opCopy   byte = 'C' // 从旧包复制区间: offset(uint64) length(uint64)
+	opInsert byte = 'I' // 写入补丁中的数据: length(uint64) data
+	opEnd    byte = 'E'
+)
+
+var (
+	// ErrNotZip 新旧文件不是 zip 包，无法生成条目级补丁
+	ErrNotZip = errors.New("delta: not a zip archive")
+	// ErrInvalidPatch 补丁格式错误或与基础文件不匹配
+	ErrInvalidPatch = errors.New("delta: invalid patch")
+)
+
+// entryKey 用于在旧包中查找原始数据可能相同的条目
+type entryKey struct {
+	name             string
+	method           uint16
+	crc32            uint32
+	compressedSize   uint64
+	uncompressedSize uint64
+}
+
+// segment 新包中可从旧包复制的区间
+type segment struct {
+	newOffset int64
+	oldOffset int64
+	length    int64
+}
+
+// Diff 生成从 oldPath 到 newPath 的补丁并写入 w
+func Diff(oldPath, newPath string, w io.Writer) error {
+	oldFile, oldZip, err := openZip(oldPath)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+
+	newFile, newZip, err := openZip(newPath)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+
+	newInfo, err := newFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	oldEntries := make(map[entryKey]*zip.File, len(oldZip.File))
+	for _, f := range oldZip.File {
+		oldEntries[keyOf(f)] = f
+	}
+
+	var segments []segment
+	for _, f := range newZip.File {
+		if f.CompressedSize64 == 0 {
+			continue
+		}
+		old, ok := oldEntries[keyOf(f)]
+		if !ok {
+			continue
+		}
+		newOffset, err := f.DataOffset()
+		if err != nil {
+			return err
+		}
+		oldOffset, err := old.DataOffset()
+		if err != nil {
+			return err
+		}
+		length := int64(f.CompressedSize64)
+		same, err := sameBytes(io.NewSectionReader(oldFile, oldOffset, length), io.NewSectionReader(newFile, newOffset, length))
+		if err != nil {
+			return err
+		}
+		if same {
+			segments = append(segments, segment{newOffset: newOffset, oldOffset: oldOffset, length: length})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].newOffset < segments[j].newOffset })
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(magic); err != nil {
+		return err
+	}
+	if err := writeUint64(bw, uint64(newInfo.Size())); err != nil {
+		return err
+	}
+
+	var pos int64
+	for _, seg := range segments {
+		if seg.newOffset < pos {
+			continue
+		}
+		if seg.newOffset > pos {
+			if err := writeInsert(bw, newFile, pos, seg.newOffset-pos); err != nil {
+				return err
+			}
+		}
+		if err := bw.WriteByte(opCopy); err != nil {
+			return err
+		}
+		if err := writeUint64(bw, uint64(seg.oldOffset)); err != nil {
+			return err
+		}
+		if err := writeUint64(bw, uint64(seg.length)); err != nil {
+			return err
+		}
+		pos = seg.newOffset + seg.length
+	}
+	if pos < newInfo.Size() {
+		if err := writeInsert(bw, newFile, pos, newInfo.Size()-pos); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte(opEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Apply 以 old 为基础应用补丁，将重建的文件写入 w
+func Apply(old io.ReaderAt, patch io.Reader, w io.Writer) error {
+	br := bufio.NewReader(patch)
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil || !bytes.Equal(header, magic) {
+		return ErrInvalidPatch
+	}
+	size, err := readUint64(br)
+	if err != nil {
+		return ErrInvalidPatch
+	}
+
+	var written uint64
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return ErrInvalidPatch
+		}
+
+		switch op {
+		case opCopy:
+			offset, err1 := readUint64(br)
+			length, err2 := readUint64(br)
+			if err1 != nil || err2 != nil {
+				return ErrInvalidPatch
+			}
+			n, err := io.Copy(w, io.NewSectionReader(old, int64(offset), int64(length)))
+			if err != nil {
+				return err
+			}
+			if uint64(n) != length {
+				return fmt.Errorf("%w: base file too short", ErrInvalidPatch)
+			}
+			written += length
+		case opInsert:
+			length, err := readUint64(br)
+			if err != nil {
+				return ErrInvalidPatch
+			}
+			n, err := io.CopyN(w, br, int64(length))
+			if err != nil {
+				if err == io.EOF {
+					return ErrInvalidPatch
+				}
+				return err
+			}
+			written += uint64(n)
+		case opEnd:
+			if written != size {
+				return fmt.Errorf("%w: size mismatch", ErrInvalidPatch)
+			}
+			return nil
+		default:
+			return ErrInvalidPatch
+		}
+	}
+}
+
+// ApplyFile 以 oldPath 为基础应用补丁文件，输出到 outPath
+func ApplyFile(oldPath, patchPath, outPath string) error {
+	old, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if err := Apply(old, patch, out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func openZip(path string) (*os.File, *zip.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotZip, path)
+	}
+	return f, zr, nil
+}
+
+func keyOf(f *zip.File) entryKey {
+	return entryKey{
+		name:             f.Name,
+		method:           f.Method,
+		crc32:            f.CRC32,
+		compressedSize:   f.CompressedSize64,
+		uncompressedSize: f.UncompressedSize64,
+	}
+}
+
+// sameBytes 逐块比较两个区间的内容
+func sameBytes(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+func writeInsert(w *bufio.Writer, src io.ReaderAt, offset, length int64) error {
+	if err := w.WriteByte(opInsert); err != nil {
+		return err
+	}
+	if err := writeUint64(w, uint64(length)); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, io.NewSectionReader(src, offset, length))
+	return err
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
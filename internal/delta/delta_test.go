@@ -0,0 +1,105 @@
+package delta
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, entries map[string]string, order []string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, name := range order {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entries[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffApply(t *testing.T) {
+	dir := t.TempDir()
+	large := strings.Repeat("unchanged library content ", 4096)
+
+	oldPath := filepath.Join(dir, "old.zip")
+	newPath := filepath.Join(dir, "new.zip")
+	writeZip(t, oldPath, map[string]string{
+		"lib/core.dll": large,
+		"app.exe":      "version 1",
+		"removed.txt":  "gone in v2",
+	}, []string{"lib/core.dll", "app.exe", "removed.txt"})
+	writeZip(t, newPath, map[string]string{
+		"app.exe":      "version 2",
+		"lib/core.dll": large,
+		"added.txt":    "new in v2",
+	}, []string{"app.exe", "lib/core.dll", "added.txt"})
+
+	var patch bytes.Buffer
+	if err := Diff(oldPath, newPath, &patch); err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	newData, _ := os.ReadFile(newPath)
+	if patch.Len() >= len(newData) {
+		t.Errorf("patch (%d bytes) should be smaller than the new package (%d bytes)", patch.Len(), len(newData))
+	}
+
+	old, _ := os.Open(oldPath)
+	defer old.Close()
+	var rebuilt bytes.Buffer
+	if err := Apply(old, bytes.NewReader(patch.Bytes()), &rebuilt); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !bytes.Equal(rebuilt.Bytes(), newData) {
+		t.Error("rebuilt package differs from the new package")
+	}
+}
+
+func TestApply_WrongBase(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.zip")
+	newPath := filepath.Join(dir, "new.zip")
+	writeZip(t, oldPath, map[string]string{"a.txt": strings.Repeat("a", 1000)}, []string{"a.txt"})
+	writeZip(t, newPath, map[string]string{"a.txt": strings.Repeat("a", 1000), "b.txt": "b"}, []string{"a.txt", "b.txt"})
+
+	var patch bytes.Buffer
+	if err := Diff(oldPath, newPath, &patch); err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Apply(bytes.NewReader([]byte("short")), bytes.NewReader(patch.Bytes()), &out); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("expected ErrInvalidPatch, got %v", err)
+	}
+	if err := Apply(bytes.NewReader(nil), strings.NewReader("garbage"), &out); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("expected ErrInvalidPatch for bad header, got %v", err)
+	}
+}
+
+func TestDiff_NotZip(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.bin")
+	newPath := filepath.Join(dir, "new.bin")
+	os.WriteFile(oldPath, []byte("plain old"), 0644)
+	os.WriteFile(newPath, []byte("plain new"), 0644)
+
+	var patch bytes.Buffer
+	if err := Diff(oldPath, newPath, &patch); !errors.Is(err, ErrNotZip) {
+		t.Errorf("expected ErrNotZip, got %v", err)
+	}
+}
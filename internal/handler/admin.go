@@ -1,10 +1,17 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
+
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/semver"
 	"docufiller-update-server/internal/service"
+	"docufiller-update-server/internal/signing"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type AdminHandler struct {
@@ -75,27 +82,47 @@ func (h *AdminHandler) GetProgramDetail(c *gin.Context) {
 		return
 	}
 
-	encryptionKey, _ := h.programService.GetProgramEncryptionKey(programID)
-	uploadTokenObj, _ := h.tokenService.GetToken(programID, "upload", "admin")
-	downloadTokenObj, _ := h.tokenService.GetToken(programID, "download", "admin")
-
-	uploadToken := ""
-	downloadToken := ""
-	if uploadTokenObj != nil {
-		uploadToken = uploadTokenObj.TokenValue
-	}
-	if downloadTokenObj != nil {
-		downloadToken = downloadTokenObj.TokenValue
+	// 只读用户不返回加密密钥
+	encryptionKey := ""
+	if user := currentUser(c); user == nil || service.UserCan(user, service.PermWrite, programID) {
+		encryptionKey, _ = h.programService.GetProgramEncryptionKey(programID)
 	}
 
+	// Token 只存储哈希，明文仅在创建或重新生成时返回一次
 	c.JSON(http.StatusOK, gin.H{
 		"program":       program,
 		"encryptionKey": encryptionKey,
-		"uploadToken":   uploadToken,
-		"downloadToken": downloadToken,
 	})
 }
 
+// UpdateProgram 修改程序信息
+func (h *AdminHandler) UpdateProgram(c *gin.Context) {
+	programID := c.Param("programId")
+
+	var req service.UpdateProgramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.programService.GetByProgramID(programID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "程序不存在"})
+		return
+	}
+
+	program, err := h.programService.UpdateProgramInfo(programID, req)
+	if err != nil {
+		if errors.Is(err, service.ErrProgramNameRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, program)
+}
+
 // DeleteProgram 删除程序
 func (h *AdminHandler) DeleteProgram(c *gin.Context) {
 	programID := c.Param("programId")
@@ -121,17 +148,112 @@ func (h *AdminHandler) ListVersions(c *gin.Context) {
 	c.JSON(http.StatusOK, versions)
 }
 
-// DeleteVersion 删除版本
-func (h *AdminHandler) DeleteVersion(c *gin.Context) {
+// UpdateVersion 修改版本的发布说明和升级路径设置（?channel=，默认 stable）
+func (h *AdminHandler) UpdateVersion(c *gin.Context) {
 	programID := c.Param("programId")
 	version := c.Param("version")
+	channel := c.DefaultQuery("channel", "stable")
 
-	if err := h.versionService.DeleteVersion(programID, "", version); err != nil {
+	var req service.UpdateVersionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MinUpgradeFrom != nil && *req.MinUpgradeFrom != "" && !semver.Valid(*req.MinUpgradeFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minUpgradeFrom must be a valid semantic version"})
+		return
+	}
+
+	v, err := h.versionService.UpdateVersion(programID, channel, version, req)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	logger.Infof("Version updated: %s/%s/%s by %s", programID, channel, version, currentActor(c))
+	c.JSON(http.StatusOK, v)
+}
+
+// SetRollout 修改版本灰度比例
+func (h *AdminHandler) SetRollout(c *gin.Context) {
+	programID := c.Param("programId")
+	version := c.Param("version")
+
+	var req struct {
+		Channel    string `json:"channel"`
+		Percentage *int   `json:"percentage" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Channel == "" {
+		req.Channel = "stable"
+	}
+	if *req.Percentage < 0 || *req.Percentage > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "percentage must be between 0 and 100"})
+		return
+	}
+
+	if err := h.versionService.SetRolloutPercentage(programID, req.Channel, version, *req.Percentage); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "版本不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Infof("Rollout updated: %s/%s/%s -> %d%%", programID, req.Channel, version, *req.Percentage)
+	c.JSON(http.StatusOK, gin.H{
+		"success":           true,
+		"rolloutPercentage": *req.Percentage,
+	})
+}
+
+// GetChannelPolicy 获取通道升级策略
+func (h *AdminHandler) GetChannelPolicy(c *gin.Context) {
+	policy, err := h.versionService.GetChannelPolicy(c.Param("programId"), c.Param("channel"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// SetChannelPolicy 设置通道最低支持版本，低于该版本的客户端会被要求强制更新
+func (h *AdminHandler) SetChannelPolicy(c *gin.Context) {
+	programID := c.Param("programId")
+	channel := c.Param("channel")
+
+	var req struct {
+		MinSupportedVersion string `json:"minSupportedVersion"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.programService.GetProgramByID(programID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	policy, err := h.versionService.SetMinSupportedVersion(programID, channel, req.MinSupportedVersion)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidVersion) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "minSupportedVersion must be a valid semantic version"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.Infof("Channel policy updated: %s/%s min supported version %q", programID, channel, req.MinSupportedVersion)
+	c.JSON(http.StatusOK, policy)
 }
 
 // DownloadPublishClient 下载发布客户端包
@@ -170,7 +292,10 @@ func (h *AdminHandler) DownloadUpdateClient(c *gin.Context) {
 	c.File(result.PackagePath)
 }
 
-// RegenerateToken 重新生成指定类型的 Token
+// RegenerateToken 轮换指定类型的默认 Token
+//
+// 旧 Token 在宽限期内（可选 gracePeriodHours，默认 7 天；0 表示立即撤销）仍然有效，
+// 期间用旧 Token 做更新检查的客户端会收到新 Token。
 func (h *AdminHandler) RegenerateToken(c *gin.Context) {
 	programID := c.Param("programId")
 	tokenType := c.Query("type")
@@ -180,30 +305,273 @@ func (h *AdminHandler) RegenerateToken(c *gin.Context) {
 		return
 	}
 
-	token, tokenValue, err := h.tokenService.RegenerateToken(programID, tokenType, "admin")
+	var req struct {
+		GracePeriodHours *int `json:"gracePeriodHours"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil || (req.GracePeriodHours != nil && *req.GracePeriodHours < 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "gracePeriodHours must be a non-negative integer"})
+			return
+		}
+	}
+	grace := service.DefaultTokenGracePeriod
+	if req.GracePeriodHours != nil {
+		grace = time.Duration(*req.GracePeriodHours) * time.Hour
+	}
+
+	token, tokenValue, err := h.tokenService.RotateToken(programID, tokenType, currentActor(c), grace)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"tokenID": token.TokenID,
 		"token":   tokenValue,
 		"type":    tokenType,
+	}
+	if grace > 0 {
+		resp["previousExpiresAt"] = time.Now().Add(grace)
+	}
+	logger.Infof("%s token for %s rotated to %s (grace period %s)", tokenType, programID, token.TokenID[:12], grace)
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListTokens 列出程序的 Token（不含 Token 值）
+func (h *AdminHandler) ListTokens(c *gin.Context) {
+	tokens, err := h.tokenService.ListTokens(c.Param("programId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateToken 创建带名称、权限范围、通道限制和过期时间的 Token，Token 值只在此返回一次
+func (h *AdminHandler) CreateToken(c *gin.Context) {
+	programID := c.Param("programId")
+
+	var req service.CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.programService.GetByProgramID(programID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	token, tokenValue, err := h.tokenService.CreateToken(programID, currentActor(c), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidScope),
+			errors.Is(err, service.ErrInvalidExpiry),
+			errors.Is(err, service.ErrTokenNameRequired):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Infof("Token %q created for %s by %s, scopes: %v", token.Name, programID, currentActor(c), token.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"tokenValue": tokenValue,
+	})
+}
+
+// GetTokenDetail 获取 Token 详情（不含 Token 值）
+func (h *AdminHandler) GetTokenDetail(c *gin.Context) {
+	token, err := h.tokenService.GetTokenByID(c.Param("programId"), c.Param("tokenId"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":  token,
+		"scopes": service.TokenScopes(token),
 	})
 }
 
-// RegenerateEncryptionKey 重新生成加密密钥
+// RevokeToken 撤销程序的指定 Token
+func (h *AdminHandler) RevokeToken(c *gin.Context) {
+	programID := c.Param("programId")
+	tokenID := c.Param("tokenId")
+
+	if err := h.tokenService.RevokeProgramToken(programID, tokenID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Infof("Token %s of %s revoked by %s", tokenID, programID, currentActor(c))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListAdminTokens 列出管理 Token（不含 Token 值）
+func (h *AdminHandler) ListAdminTokens(c *gin.Context) {
+	tokens, err := h.tokenService.ListAdminTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateAdminToken 创建管理 Token，Token 值只在此返回一次
+func (h *AdminHandler) CreateAdminToken(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, tokenValue, err := h.tokenService.CreateAdminToken(req.Name, currentActor(c), req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidExpiry) || errors.Is(err, service.ErrTokenNameRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Infof("Admin token %q created by %s", token.Name, currentActor(c))
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"tokenValue": tokenValue,
+	})
+}
+
+// RevokeAdminToken 撤销管理 Token
+func (h *AdminHandler) RevokeAdminToken(c *gin.Context) {
+	tokenID := c.Param("tokenId")
+
+	if err := h.tokenService.RevokeAdminToken(tokenID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Infof("Admin token %s revoked by %s", tokenID, currentActor(c))
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RegenerateEncryptionKey 轮换加密密钥
+//
+// 原密钥进入宽限期（可选 gracePeriodHours，默认 30 天），期间仍可解密，
+// 持有原密钥的客户端在更新检查时获得新密钥。
 func (h *AdminHandler) RegenerateEncryptionKey(c *gin.Context) {
 	programID := c.Param("programId")
 
-	newKey, err := h.programService.RegenerateEncryptionKey(programID)
+	var req struct {
+		GracePeriodHours int `json:"gracePeriodHours"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil || req.GracePeriodHours < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "gracePeriodHours must be a non-negative integer"})
+			return
+		}
+	}
+
+	if _, err := h.programService.GetByProgramID(programID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		return
+	}
+
+	record, encryptionKey, err := h.programService.RotateEncryptionKey(programID, time.Duration(req.GracePeriodHours)*time.Hour)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	logger.Infof("Encryption key for %s rotated to %s", programID, record.KeyID)
+	c.JSON(http.StatusOK, gin.H{
+		"keyId":         record.KeyID,
+		"encryptionKey": encryptionKey,
+	})
+}
+
+// ListEncryptionKeys 列出程序的密钥历史（不含密钥内容）
+func (h *AdminHandler) ListEncryptionKeys(c *gin.Context) {
+	keys, err := h.programService.ListEncryptionKeys(c.Param("programId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// SetPackageEncryption 开启或关闭程序的包加密存储
+func (h *AdminHandler) SetPackageEncryption(c *gin.Context) {
+	programID := c.Param("programId")
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.programService.SetPackageEncryption(programID, *req.Enabled); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		case errors.Is(err, service.ErrEncryptionKeyMissing):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	logger.Infof("Package encryption for %s set to %v", programID, *req.Enabled)
 	c.JSON(http.StatusOK, gin.H{
-		"encryptionKey": newKey,
+		"encryptPackages": *req.Enabled,
 	})
-}
\ No newline at end of file
+}
+
+// SetSigningKey 设置程序的签名公钥
+func (h *AdminHandler) SetSigningKey(c *gin.Context) {
+	programID := c.Param("programId")
+
+	var req struct {
+		PublicKey string `json:"publicKey"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.programService.SetSigningPublicKey(programID, req.PublicKey); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Program not found"})
+		case errors.Is(err, signing.ErrInvalidKey):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"signingPublicKey": req.PublicKey,
+	})
+}
@@ -1,19 +1,31 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"docufiller-update-server/internal/config"
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/service"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
+// sessionTokenKey Cookie 会话中保存服务端会话 Token 的键，会话状态保存在 admin_sessions 表
+const sessionTokenKey = "sid"
+
 type AuthHandler struct {
-	cfg *config.Config
+	cfg      *config.Config
+	users    *service.UserService
+	sessions *service.SessionService
+	throttle *service.LoginThrottle
 }
 
-func NewAuthHandler(cfg *config.Config) *AuthHandler {
-	return &AuthHandler{cfg: cfg}
+func NewAuthHandler(cfg *config.Config, users *service.UserService, sessions *service.SessionService, throttle *service.LoginThrottle) *AuthHandler {
+	return &AuthHandler{cfg: cfg, users: users, sessions: sessions, throttle: throttle}
 }
 
 // Login 处理登录请求
@@ -28,40 +40,190 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// 从配置验证凭据
-	if req.Username != h.cfg.Admin.Username || req.Password != h.cfg.Admin.Password {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+	// 连续失败过多的 IP 或用户名在锁定期内直接拒绝，不校验密码
+	ip := c.ClientIP()
+	if !h.checkThrottle(c, ip, req.Username) {
 		return
 	}
 
-	// 设置 Session
-	session := sessions.Default(c)
-	session.Set("authenticated", true)
-	session.Set("username", req.Username)
-	if err := session.Save(); err != nil {
+	// 从用户表验证凭据（config.yaml 中的账号在首次启动时已迁移）
+	user, err := h.users.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			h.recordFailure(ip, req.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	// 启用了两步验证：只创建预认证会话，校验验证码（LoginTOTP）后才建立登录会话
+	if user.TOTPEnabled {
+		if !h.saveSession(c, user.Username, true) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": false, "totpRequired": true})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// LoginTOTP 两步登录的第二步：校验 TOTP 验证码或恢复码
+func (h *AuthHandler) LoginTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+
+	token := sessionToken(c)
+	preAuth, err := h.sessions.Get(token)
+	if err != nil || !preAuth.PreAuth {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期，请重新输入用户名和密码"})
+		return
+	}
+
+	ip := c.ClientIP()
+	if !h.checkThrottle(c, ip, preAuth.Username) {
+		return
+	}
+
+	user, err := h.users.VerifySecondFactor(preAuth.Username, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidTOTPCode) || errors.Is(err, service.ErrInvalidCredentials) ||
+			errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrTOTPNotEnabled) {
+			h.recordFailure(ip, preAuth.Username)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+		return
+	}
+
+	// 预认证会话作废，换发新的会话 Token
+	h.sessions.Revoke(token)
+	h.completeLogin(c, user)
+}
+
+// checkThrottle 锁定期内返回 429 和 Retry-After
+func (h *AuthHandler) checkThrottle(c *gin.Context, ip, username string) bool {
+	retryAfter, err := h.throttle.Check(ip, username)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, service.ErrLoginLocked) {
+		seconds := int(retryAfter.Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":      "登录失败次数过多，请稍后再试",
+			"retryAfter": seconds,
+		})
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+	return false
+}
+
+func (h *AuthHandler) recordFailure(ip, username string) {
+	if err := h.throttle.RecordFailure(ip, username); err != nil {
+		logger.Warnf("Failed to record login failure: %v", err)
+	}
+	logger.WithFields(map[string]interface{}{
+		"ip":       ip,
+		"username": username,
+	}).Warn("Admin login failed")
+}
+
+// completeLogin 清除失败计数并建立登录会话
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.AdminUser) {
+	if err := h.throttle.RecordSuccess(user.Username); err != nil {
+		logger.Warnf("Failed to reset login failures: %v", err)
+	}
+	if !h.saveSession(c, user.Username, false) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":                true,
+		"role":                   user.Role,
+		"mustChangePassword":     user.MustChangePassword,
+		"totpEnrollmentRequired": h.users.NeedsTOTPEnrollment(user),
+	})
 }
 
-// Logout 处理登出请求
+// saveSession 创建服务端会话，Cookie 中只保存会话 Token；失败时返回 500
+func (h *AuthHandler) saveSession(c *gin.Context, username string, preAuth bool) bool {
+	token, _, err := h.sessions.Create(username, c.ClientIP(), c.Request.UserAgent(), preAuth)
+	if err == nil {
+		session := sessions.Default(c)
+		session.Clear()
+		session.Set(sessionTokenKey, token)
+		err = session.Save()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+		return false
+	}
+	return true
+}
+
+// Logout 处理登出请求，同时删除服务端会话
 func (h *AuthHandler) Logout(c *gin.Context) {
+	if token := sessionToken(c); token != "" {
+		h.sessions.Revoke(token)
+	}
 	session := sessions.Default(c)
 	session.Clear()
 	session.Save()
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// AuthMiddleware 管理后台认证中间件
-func AuthMiddleware() gin.HandlerFunc {
+// sessionToken 返回 Cookie 中的服务端会话 Token
+func sessionToken(c *gin.Context) string {
+	token, _ := sessions.Default(c).Get(sessionTokenKey).(string)
+	return token
+}
+
+// SecureSessionCookie 通过 TLS（或 X-Forwarded-Proto 为 https 的反向代理）访问时为会话 Cookie 设置 Secure
+func SecureSessionCookie(options sessions.Options) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		session := sessions.Default(c)
+		opts := options
+		opts.Secure = options.Secure || c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+		sessions.Default(c).Options(opts)
+		c.Next()
+	}
+}
 
-		authenticated := session.Get("authenticated")
-		if authenticated != true {
-			// 未登录，返回 401 或重定向到登录页
+// selfServiceRoutes 只涉及当前用户自己的路由：不按角色检查，须先修改密码或启用两步验证的用户也可访问
+var selfServiceRoutes = map[string]bool{
+	"/admin":                            true,
+	"/admin/logout":                     true,
+	"/api/admin/me":                     true,
+	"/api/admin/me/password":            true,
+	"/api/admin/me/totp":                true,
+	"/api/admin/me/totp/verify":         true,
+	"/api/admin/me/totp/recovery-codes": true,
+	"/api/admin/me/sessions":            true,
+	"/api/admin/me/sessions/:sessionId": true,
+}
+
+// AuthMiddleware 管理后台认证中间件
+//
+// 校验服务端会话并加载当前用户，按角色检查对路由中程序的权限：
+// GET/HEAD 请求需要读权限，其他请求需要写权限。需要更高权限的路由另加 RequirePermission。
+func AuthMiddleware(users *service.UserService, sessionSvc *service.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user *models.AdminUser
+		session, err := sessionSvc.Get(sessionToken(c))
+		if err == nil && !session.PreAuth {
+			user, _ = users.GetUser(session.Username)
+		}
+		if user == nil || !user.IsActive {
+			// 未登录、会话已撤销或过期、用户已删除或停用，返回 401 或重定向到登录页
 			if c.Request.Header.Get("Content-Type") == "application/json" {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
 			} else {
@@ -70,7 +232,104 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		c.Set("adminUser", user)
+		c.Set("adminSession", session)
+
+		if selfServiceRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if user.MustChangePassword {
+			c.JSON(http.StatusForbidden, gin.H{"error": "请先修改密码", "mustChangePassword": true})
+			c.Abort()
+			return
+		}
+		if users.NeedsTOTPEnrollment(user) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "请先启用两步验证", "totpEnrollmentRequired": true})
+			c.Abort()
+			return
+		}
+
+		perm := service.PermWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			perm = service.PermRead
+		}
+		if !service.UserCan(user, perm, c.Param("programId")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
 
+// RequirePermission 要求当前用户对路由中的程序具有 perm 权限
+//
+// 使用管理 Token 的请求没有关联用户，不受角色限制。
+func RequirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user := currentUser(c); user != nil && !service.UserCan(user, perm, c.Param("programId")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
+
+// currentUser 返回当前登录的管理后台用户，使用 Token 认证的请求返回 nil
+func currentUser(c *gin.Context) *models.AdminUser {
+	if v, ok := c.Get("adminUser"); ok {
+		if user, ok := v.(*models.AdminUser); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentSession 返回当前请求的服务端会话，使用 Token 认证的请求返回 nil
+func currentSession(c *gin.Context) *models.AdminSession {
+	if v, ok := c.Get("adminSession"); ok {
+		if session, ok := v.(*models.AdminSession); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// currentActor 返回当前请求的操作者标识（管理员用户名或 Token 摘要），用于审计记录
+func currentActor(c *gin.Context) string {
+	if v, ok := c.Get("token"); ok {
+		if token, ok := v.(*models.Token); ok {
+			id := token.TokenID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			if token.Name != "" {
+				return fmt.Sprintf("%s-token:%s(%s)", token.TokenType, id, token.Name)
+			}
+			return fmt.Sprintf("%s-token:%s", token.TokenType, id)
+		}
+	}
+	if user := currentUser(c); user != nil {
+		return user.Username
+	}
+	return "unknown"
+}
+
+// requireScope 检查请求 Token 能否以 scope 权限操作程序的 channel 通道，不允许时返回 403
+//
+// 中间件只能检查路由中的通道，请求体或上传会话中的通道由 handler 调用此函数检查。
+// 没有 Token 的请求（管理后台会话）不受限制。
+func requireScope(c *gin.Context, tokenSvc *service.TokenService, scope, programID, channel string) bool {
+	v, ok := c.Get("token")
+	if !ok {
+		return true
+	}
+	if token, ok := v.(*models.Token); ok && tokenSvc.HasPermission(token, scope, programID, channel) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token is not allowed to %s on channel %s", scope, channel)})
+	return false
+}
@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/semver"
+	"docufiller-update-server/internal/service"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UploadHandler 分片上传会话
+//
+// 流程：POST 创建会话 -> PUT 逐个上传分片（X-Chunk-SHA256 头携带分片校验和）
+// -> GET 查询已接收的分片 -> POST complete 合并文件并创建版本。
+type UploadHandler struct {
+	uploadSvc  *service.UploadService
+	versionSvc *service.VersionService
+	tokenSvc   *service.TokenService
+}
+
+func NewUploadHandler(db *gorm.DB, storageSvc *service.StorageService, maxFileSize int64) *UploadHandler {
+	return &UploadHandler{
+		uploadSvc:  service.NewUploadService(db, storageSvc, maxFileSize),
+		versionSvc: service.NewVersionService(db, storageSvc),
+		tokenSvc:   service.NewTokenService(db),
+	}
+}
+
+// SetSecretBox 设置解开存储密钥所用的 SecretBox（见 CryptoService.SecretBox）
+func (h *UploadHandler) SetSecretBox(box *service.SecretBox) {
+	h.versionSvc.SetSecretBox(box)
+}
+
+// uploadSessionResponse 上传会话状态
+type uploadSessionResponse struct {
+	*models.UploadSession
+	ChunkCount    int                  `json:"chunkCount"`
+	ReceivedBytes int64                `json:"receivedBytes"`
+	Received      []models.UploadChunk `json:"received"`
+	Missing       []int                `json:"missing"`
+}
+
+// CreateSession 创建上传会话
+func (h *UploadHandler) CreateSession(c *gin.Context) {
+	programID := c.Param("programId")
+
+	var req struct {
+		Channel   string `json:"channel" binding:"required"`
+		Version   string `json:"version" binding:"required"`
+		TotalSize int64  `json:"totalSize" binding:"required"`
+		ChunkSize int64  `json:"chunkSize"`
+		SHA256    string `json:"sha256"`
+		Notes     string `json:"notes"`
+		Mandatory bool   `json:"mandatory"`
+		Rollout   *int   `json:"rollout"` // 未提供时为 100
+		Signature string `json:"signature"`
+		Status    string `json:"status"`
+		PublishAt string `json:"publishAt"`
+
+		MinUpgradeFrom string `json:"minUpgradeFrom"`
+		RequiredStep   bool   `json:"requiredStep"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "channel, version and totalSize are required"})
+		return
+	}
+	if !requireScope(c, h.tokenSvc, service.ScopeUpload, programID, req.Channel) {
+		return
+	}
+	if !semver.Valid(req.Version) {
+		c.JSON(400, gin.H{"error": "version must be a valid semantic version"})
+		return
+	}
+	rollout := 100
+	if req.Rollout != nil {
+		if *req.Rollout < 1 || *req.Rollout > 100 {
+			c.JSON(400, gin.H{"error": "rollout must be between 1 and 100"})
+			return
+		}
+		rollout = *req.Rollout
+	}
+	if req.MinUpgradeFrom != "" && !semver.Valid(req.MinUpgradeFrom) {
+		c.JSON(400, gin.H{"error": "minUpgradeFrom must be a valid semantic version"})
+		return
+	}
+	publishAt, err := service.ParsePublishAt(req.PublishAt)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	status, publishAt, err := service.ResolveReleaseStatus(req.Status, publishAt, time.Now())
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkUploadTarget(c, h.versionSvc, programID, req.Channel, req.Version) {
+		return
+	}
+	if req.Signature == "" {
+		if publicKey, err := h.versionSvc.SigningPublicKey(programID); err == nil && publicKey != "" {
+			c.JSON(400, gin.H{"error": service.ErrSignatureRequired.Error()})
+			return
+		}
+	}
+
+	session := &models.UploadSession{
+		ProgramID:     programID,
+		Channel:       req.Channel,
+		Version:       req.Version,
+		TotalSize:     req.TotalSize,
+		ChunkSize:     req.ChunkSize,
+		FileHash:      req.SHA256,
+		ReleaseNotes:  req.Notes,
+		Mandatory:     req.Mandatory,
+		Rollout:       rollout,
+		Signature:     req.Signature,
+		ReleaseStatus: status,
+		PublishAt:     publishAt,
+		CreatedBy:     currentActor(c),
+
+		MinUpgradeFrom: req.MinUpgradeFrom,
+		RequiredStep:   req.RequiredStep,
+	}
+	if err := h.uploadSvc.CreateSession(session); err != nil {
+		switch {
+		case errors.Is(err, service.ErrFileTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file exceeds maximum size of %d bytes", h.uploadSvc.MaxFileSize())})
+		case errors.Is(err, service.ErrChunkInvalid):
+			c.JSON(400, gin.H{"error": err.Error()})
+		default:
+			logger.Errorf("Failed to create upload session: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to create upload session"})
+		}
+		return
+	}
+
+	logger.Infof("Upload session created: %s for %s/%s/%s, size: %d", session.ID, programID, req.Channel, req.Version, req.TotalSize)
+	h.respondSession(c, http.StatusCreated, session)
+}
+
+// GetSession 查询上传会话及已接收的分片
+func (h *UploadHandler) GetSession(c *gin.Context) {
+	session, ok := h.loadSession(c)
+	if !ok {
+		return
+	}
+	h.respondSession(c, http.StatusOK, session)
+}
+
+// UploadChunk 上传一个分片
+func (h *UploadHandler) UploadChunk(c *gin.Context) {
+	session, ok := h.loadSession(c)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid chunk index"})
+		return
+	}
+	checksum := c.GetHeader("X-Chunk-SHA256")
+	if checksum == "" {
+		c.JSON(400, gin.H{"error": "X-Chunk-SHA256 header is required"})
+		return
+	}
+
+	chunk, err := h.uploadSvc.SaveChunk(session, index, c.Request.Body, checksum)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadExpired):
+			c.JSON(410, gin.H{"error": "Upload session is no longer active"})
+		case errors.Is(err, service.ErrChunkChecksum):
+			c.JSON(422, gin.H{"error": "Chunk checksum mismatch"})
+		case errors.Is(err, service.ErrChunkInvalid):
+			c.JSON(400, gin.H{"error": err.Error()})
+		default:
+			logger.Errorf("Failed to save chunk %d of %s: %v", index, session.ID, err)
+			c.JSON(500, gin.H{"error": "Failed to save chunk"})
+		}
+		return
+	}
+
+	c.JSON(200, chunk)
+}
+
+// CompleteSession 合并分片并创建版本
+func (h *UploadHandler) CompleteSession(c *gin.Context) {
+	session, ok := h.loadSession(c)
+	if !ok {
+		return
+	}
+
+	if !checkUploadTarget(c, h.versionSvc, session.ProgramID, session.Channel, session.Version) {
+		return
+	}
+
+	encryptionKey, err := h.versionSvc.PackageEncryptionKey(session.ProgramID)
+	if err != nil {
+		logger.Errorf("Failed to load encryption key for %s: %v", session.ProgramID, err)
+		c.JSON(500, gin.H{"error": "Failed to load encryption key"})
+		return
+	}
+	stored, err := h.uploadSvc.Assemble(session, service.PackageFileName(session.ProgramID, session.Version), encryptionKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadExpired):
+			c.JSON(410, gin.H{"error": "Upload session is no longer active"})
+		case errors.Is(err, service.ErrUploadIncomplete):
+			chunks, _ := h.uploadSvc.ListChunks(session.ID)
+			c.JSON(409, gin.H{"error": "Upload is incomplete", "missing": h.uploadSvc.MissingChunks(session, chunks)})
+		case errors.Is(err, service.ErrFileHashMismatch):
+			c.JSON(422, gin.H{"error": "File hash mismatch"})
+		default:
+			logger.Errorf("Failed to assemble upload %s: %v", session.ID, err)
+			c.JSON(500, gin.H{"error": "Failed to assemble file"})
+		}
+		return
+	}
+
+	// 合并后任何失败都删除已移入版本目录的文件
+	published := false
+	defer func() {
+		if !published {
+			discardUploadFiles(h.versionSvc, session.ProgramID, session.Channel, session.Version)
+		}
+	}()
+
+	// 定时发布时间可能在上传期间已经过去
+	status, publishAt, err := service.ResolveReleaseStatus(session.ReleaseStatus, session.PublishAt, time.Now())
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	v := &models.Version{
+		ProgramID:         session.ProgramID,
+		Version:           session.Version,
+		Channel:           session.Channel,
+		FileName:          stored.FileName,
+		FilePath:          h.versionSvc.GetStorageService().VersionDir(session.ProgramID, session.Channel, session.Version),
+		FileSize:          stored.Size,
+		FileHash:          stored.Hash,
+		ReleaseNotes:      session.ReleaseNotes,
+		PublishDate:       time.Now(),
+		Mandatory:         session.Mandatory,
+		RolloutPercentage: session.Rollout,
+		Signature:         session.Signature,
+		Status:            status,
+		PublishAt:         publishAt,
+		MinUpgradeFrom:    session.MinUpgradeFrom,
+		RequiredStep:      session.RequiredStep,
+	}
+	stored.Apply(v)
+	if err := h.versionSvc.PublishVersion(v); err != nil {
+		if isSignatureError(err) {
+			// 签名在创建会话时已确定，无法修正，放弃整个会话
+			logger.Warnf("Rejected upload session %s: %v", session.ID, err)
+			if err := h.uploadSvc.Abort(session); err != nil {
+				logger.Warnf("Failed to abort upload session %s: %v", session.ID, err)
+			}
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		logger.Errorf("Failed to create version record: %v", err)
+		c.JSON(500, gin.H{"error": "Failed to create version"})
+		return
+	}
+	published = true
+
+	if err := h.uploadSvc.Complete(session); err != nil {
+		logger.Warnf("Failed to clean up upload session %s: %v", session.ID, err)
+	}
+
+	logger.Infof("Version uploaded successfully via session %s: %s/%s/%s", session.ID, session.ProgramID, session.Channel, session.Version)
+	c.JSON(http.StatusOK, gin.H{"message": "Version uploaded successfully", "version": v})
+}
+
+// AbortSession 取消上传会话
+func (h *UploadHandler) AbortSession(c *gin.Context) {
+	session, ok := h.loadSession(c)
+	if !ok {
+		return
+	}
+	if err := h.uploadSvc.Abort(session); err != nil {
+		logger.Errorf("Failed to abort upload session %s: %v", session.ID, err)
+		c.JSON(500, gin.H{"error": "Failed to abort upload session"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Upload session aborted"})
+}
+
+// CleanupExpired 删除过期未完成的上传会话（供定时任务调用）
+func (h *UploadHandler) CleanupExpired() (int, error) {
+	return h.uploadSvc.CleanupExpired()
+}
+
+func (h *UploadHandler) loadSession(c *gin.Context) (*models.UploadSession, bool) {
+	session, err := h.uploadSvc.GetSession(c.Param("programId"), c.Param("uploadId"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Upload session not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+		}
+		return nil, false
+	}
+	if !requireScope(c, h.tokenSvc, service.ScopeUpload, session.ProgramID, session.Channel) {
+		return nil, false
+	}
+	return session, true
+}
+
+func (h *UploadHandler) respondSession(c *gin.Context, status int, session *models.UploadSession) {
+	chunks, err := h.uploadSvc.ListChunks(session.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	var received int64
+	for _, chunk := range chunks {
+		received += chunk.Size
+	}
+	c.JSON(status, uploadSessionResponse{
+		UploadSession: session,
+		ChunkCount:    service.ChunkCount(session),
+		ReceivedBytes: received,
+		Received:      chunks,
+		Missing:       h.uploadSvc.MissingChunks(session, chunks),
+	})
+}
@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	users    *service.UserService
+	sessions *service.SessionService
+}
+
+func NewUserHandler(users *service.UserService, sessions *service.SessionService) *UserHandler {
+	return &UserHandler{users: users, sessions: sessions}
+}
+
+// revokeSessions 撤销用户的登录会话（exceptSessionID 非空时保留该会话）
+func (h *UserHandler) revokeSessions(username, exceptSessionID string) {
+	if n, err := h.sessions.RevokeAll(username, exceptSessionID); err != nil {
+		logger.Warnf("Failed to revoke sessions of %s: %v", username, err)
+	} else if n > 0 {
+		logger.Infof("Revoked %d sessions of %s", n, username)
+	}
+}
+
+// userErrorStatus 将用户服务错误映射为 HTTP 状态码
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrUsernameRequired),
+		errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrWeakPassword):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrInvalidTOTPCode),
+		errors.Is(err, service.ErrTOTPRequired):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrTOTPNotEnabled),
+		errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotPending):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// ListUsers 列出管理后台用户
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	users, err := h.users.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser 创建用户；未提供密码时返回临时密码（仅显示一次）
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req service.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, temporary, err := h.users.CreateUser(req)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+		"actor":    currentActor(c),
+	}).Info("Admin user created")
+
+	resp := gin.H{"user": user}
+	if temporary != "" {
+		resp["temporaryPassword"] = temporary
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// UpdateUser 修改用户角色、可修改的程序和启用状态
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	var req service.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.UpdateUser(c.Param("username"), req)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+		"active":   user.IsActive,
+		"actor":    currentActor(c),
+	}).Info("Admin user updated")
+
+	if !user.IsActive {
+		h.revokeSessions(user.Username, "")
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser 删除用户
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+	if err := h.users.DeleteUser(username); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.revokeSessions(username, "")
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"actor":    currentActor(c),
+	}).Info("Admin user deleted")
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// ResetPassword 强制重置用户密码，返回临时密码，用户登录后须先修改
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	username := c.Param("username")
+	password, err := h.users.ResetPassword(username)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.revokeSessions(username, "")
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"actor":    currentActor(c),
+	}).Info("Admin user password reset")
+
+	c.JSON(http.StatusOK, gin.H{"temporaryPassword": password})
+}
+
+// GetCurrentUser 返回当前登录的用户，totpRequired 表示角色要求启用两步验证
+func (h *UserHandler) GetCurrentUser(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		*models.AdminUser
+		TOTPRequired bool `json:"totpRequired"`
+	}{user, h.users.TOTPRequired(user)})
+}
+
+// ChangePassword 当前用户修改自己的密码
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.users.ChangePassword(user.Username, req.CurrentPassword, req.NewPassword); err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "当前密码错误"})
+			return
+		}
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// 密码修改后其他设备上的会话失效
+	if session := currentSession(c); session != nil {
+		h.revokeSessions(user.Username, session.SessionID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ListMySessions 列出当前用户的登录会话
+func (h *UserHandler) ListMySessions(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+	h.listSessions(c, user.Username)
+}
+
+// RevokeMySession 撤销当前用户的指定会话（如在丢失的设备上登录的会话）
+func (h *UserHandler) RevokeMySession(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+	if err := h.sessions.RevokeSession(user.Username, c.Param("sessionId")); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// RevokeMyOtherSessions 退出当前用户在其他设备上的所有会话
+func (h *UserHandler) RevokeMyOtherSessions(c *gin.Context) {
+	user, session := currentUser(c), currentSession(c)
+	if user == nil || session == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+	n, err := h.sessions.RevokeAll(user.Username, session.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": n})
+}
+
+// ListUserSessions 列出指定用户的登录会话
+func (h *UserHandler) ListUserSessions(c *gin.Context) {
+	if _, err := h.users.GetUser(c.Param("username")); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	h.listSessions(c, c.Param("username"))
+}
+
+// RevokeUserSessions 撤销指定用户的所有会话（如会话被盗用）
+func (h *UserHandler) RevokeUserSessions(c *gin.Context) {
+	username := c.Param("username")
+	if _, err := h.users.GetUser(username); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	n, err := h.sessions.RevokeAll(username, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"revoked":  n,
+		"actor":    currentActor(c),
+	}).Info("Admin user sessions revoked")
+
+	c.JSON(http.StatusOK, gin.H{"revoked": n})
+}
+
+func (h *UserHandler) listSessions(c *gin.Context, username string) {
+	list, err := h.sessions.List(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if current := currentSession(c); current != nil {
+		for i := range list {
+			list[i].Current = list[i].SessionID == current.SessionID
+		}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// totpCodeRequest 需要校验验证码的两步验证操作
+type totpCodeRequest struct {
+	Code string `json:"code" binding:"required"` // TOTP 验证码或恢复码
+}
+
+// BeginTOTP 开始绑定两步验证，返回密钥和 otpauth:// URI
+func (h *UserHandler) BeginTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+
+	enrollment, err := h.users.BeginTOTPEnrollment(user.Username)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP 校验验证码后启用两步验证，返回恢复码（只显示一次）
+func (h *UserHandler) ConfirmTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.users.ConfirmTOTPEnrollment(user.Username, req.Code)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": user.Username,
+	}).Info("Two-factor authentication enabled")
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes 生成新的恢复码，旧恢复码失效
+func (h *UserHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.users.RegenerateRecoveryCodes(user.Username, req.Code)
+	if err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableTOTP 校验验证码后关闭自己的两步验证
+func (h *UserHandler) DisableTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not logged in as a user"})
+		return
+	}
+
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.users.DisableTOTP(user.Username, req.Code); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": user.Username,
+	}).Info("Two-factor authentication disabled")
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// ResetTOTP 清除用户的两步验证（用户丢失验证器时由 owner 操作）
+func (h *UserHandler) ResetTOTP(c *gin.Context) {
+	username := c.Param("username")
+	if err := h.users.ResetTOTP(username); err != nil {
+		c.JSON(userErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"username": username,
+		"actor":    currentActor(c),
+	}).Info("Two-factor authentication reset")
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -1,40 +1,117 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
-	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"docufiller-update-server/internal/logger"
 	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/semver"
 	"docufiller-update-server/internal/service"
+	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// 平台制品上传字段前缀，如 file.linux-arm64 与对应签名 signature.linux-arm64
+const (
+	artifactFieldPrefix  = "file."
+	signatureFieldPrefix = "signature."
+)
+
+const (
+	// maxUploadFiles 一次上传的文件数上限（主文件与各平台制品）
+	maxUploadFiles = 16
+	// uploadFormOverhead 表单字段、签名和 multipart 分隔符占用的余量
+	uploadFormOverhead = 1 << 20
+)
+
 type VersionHandler struct {
-	versionSvc *service.VersionService
+	versionSvc  *service.VersionService
+	tokenSvc    *service.TokenService
+	maxFileSize int64 // 单个上传文件的大小上限，0 表示不限制
+}
+
+// latestVersionResponse 最新版本响应，附带调用方当前版本的撤回状态
+type latestVersionResponse struct {
+	*models.Version
+	CurrentYanked     bool        `json:"currentYanked,omitempty"`
+	CurrentYankReason string      `json:"currentYankReason,omitempty"`
+	Platform          string      `json:"platform,omitempty"` // 所选平台制品，为空表示主文件
+	Patches           []patchInfo `json:"patches,omitempty"`
+
+	// 升级路径：返回的版本是下一跳时，LatestVersion 为最终目标版本
+	LatestVersion string `json:"latestVersion,omitempty"`
+	// 低于通道最低支持版本时 mandatory 被强制置为 true，ReleaseMandatory 保留版本自身（已签名）的标记
+	ReleaseMandatory    *bool  `json:"releaseMandatory,omitempty"`
+	MinSupportedVersion string `json:"minSupportedVersion,omitempty"`
+	BelowMinSupported   bool   `json:"belowMinSupported,omitempty"`
+
+	// 客户端上报的密钥（X-Encryption-Key-ID）不是当前密钥时，下发用它包装的当前密钥
+	KeyUpdate *service.KeyUpdate `json:"keyUpdate,omitempty"`
+	// 请求 Token 正在轮换时，下发替换 Token
+	TokenUpdate *service.TokenUpdate `json:"tokenUpdate,omitempty"`
 }
 
-func NewVersionHandler(db *gorm.DB) *VersionHandler {
-	storageSvc := service.NewStorageService("./data/packages")
+// patchInfo 可用的增量补丁，客户端用本地已有的 from 版本包重建完整包
+type patchInfo struct {
+	From string `json:"from"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+	URL  string `json:"url"`
+}
+
+func NewVersionHandler(db *gorm.DB, storageSvc *service.StorageService) *VersionHandler {
 	return &VersionHandler{
 		versionSvc: service.NewVersionService(db, storageSvc),
+		tokenSvc:   service.NewTokenService(db),
 	}
 }
 
+// SetSecretBox 设置解开存储密钥所用的 SecretBox（见 CryptoService.SecretBox）
+func (h *VersionHandler) SetSecretBox(box *service.SecretBox) {
+	h.versionSvc.SetSecretBox(box)
+	h.tokenSvc.SetSecretBox(box)
+}
+
+// SetMaxFileSize 设置单个上传文件的大小上限（StorageConfig.MaxFileSize）
+func (h *VersionHandler) SetMaxFileSize(n int64) {
+	h.maxFileSize = n
+}
+
 // GetLatestVersion 获取最新版本
 func (h *VersionHandler) GetLatestVersion(c *gin.Context) {
 	programID := c.Param("programId")
 	channel := c.DefaultQuery("channel", "stable")
+	strategy := c.Query("strategy")
+	current := c.Query("current")
+	platform := requestPlatform(c)
+	installID := c.GetHeader("X-Install-ID")
+	if installID == "" {
+		installID = c.Query("installId")
+	}
 
-	logger.Debugf("Get latest version request, program: %s, channel: %s", programID, channel)
+	logger.Debugf("Get latest version request, program: %s, channel: %s, strategy: %s", programID, channel, strategy)
 
-	version, err := h.versionSvc.GetLatestVersion(programID, channel)
+	version, latest, err := h.versionSvc.FindUpgradePath(service.LatestVersionQuery{
+		ProgramID: programID,
+		Channel:   channel,
+		Strategy:  strategy,
+		InstallID: installID,
+		Platform:  platform,
+		Current:   current,
+	})
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(404, gin.H{"error": "No version found"})
+		} else if errors.Is(err, service.ErrInvalidStrategy) {
+			c.JSON(400, gin.H{"error": "strategy must be 'semver' or 'publish_date'"})
 		} else {
 			logger.Errorf("Failed to get latest version: %v", err)
 			c.JSON(500, gin.H{"error": "Internal server error"})
@@ -42,7 +119,59 @@ func (h *VersionHandler) GetLatestVersion(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, version)
+	resp := latestVersionResponse{}
+	resp.Version, resp.Platform = selectPlatformFile(version, platform)
+	if patches, err := h.versionSvc.ListPatches(version.ID, resp.Platform); err != nil {
+		logger.Warnf("Failed to list patches: %v", err)
+	} else {
+		for _, p := range patches {
+			url := fmt.Sprintf("/api/programs/%s/patches/%s/%s/%s", programID, channel, version.Version, p.FromVersion)
+			if p.Platform != "" {
+				url += "?platform=" + p.Platform
+			}
+			resp.Patches = append(resp.Patches, patchInfo{From: p.FromVersion, Size: p.FileSize, Hash: p.FileHash, URL: url})
+		}
+	}
+	if current != "" {
+		if cv, err := h.versionSvc.GetVersion(programID, channel, current); err == nil && cv.Yanked {
+			resp.CurrentYanked = true
+			resp.CurrentYankReason = cv.YankReason
+		}
+	}
+	if latest != nil && latest.ID != version.ID {
+		resp.LatestVersion = latest.Version
+	}
+	if policy, err := h.versionSvc.GetChannelPolicy(programID, channel); err != nil {
+		logger.Warnf("Failed to get channel policy: %v", err)
+	} else {
+		resp.MinSupportedVersion = policy.MinSupportedVersion
+		if service.BelowMinSupported(policy, current) {
+			releaseMandatory := resp.Version.Mandatory
+			forced := *resp.Version
+			forced.Mandatory = true
+			resp.Version = &forced
+			resp.ReleaseMandatory = &releaseMandatory
+			resp.BelowMinSupported = true
+		}
+	}
+	if keyID := c.GetHeader("X-Encryption-Key-ID"); keyID != "" {
+		if update, err := h.versionSvc.EncryptionKeyUpdate(programID, keyID); err != nil {
+			logger.Warnf("Failed to prepare key update: %v", err)
+		} else {
+			resp.KeyUpdate = update
+		}
+	}
+	if value, ok := c.Get("token"); ok {
+		if token, ok := value.(*models.Token); ok && token.ProgramID == programID {
+			if update, err := h.tokenSvc.TokenReplacement(token); err != nil {
+				logger.Warnf("Failed to prepare token update: %v", err)
+			} else {
+				resp.TokenUpdate = update
+			}
+		}
+	}
+
+	c.JSON(200, resp)
 }
 
 // GetVersionList 获取版本列表
@@ -52,7 +181,7 @@ func (h *VersionHandler) GetVersionList(c *gin.Context) {
 
 	logger.Debugf("Get version list request, program: %s, channel: %s", programID, channel)
 
-	versions, err := h.versionSvc.GetVersionList(programID, channel)
+	versions, err := h.versionSvc.ListPublished(programID, channel)
 	if err != nil {
 		logger.Errorf("Failed to get version list: %v", err)
 		c.JSON(500, gin.H{"error": "Internal server error"})
@@ -71,6 +200,9 @@ func (h *VersionHandler) GetVersionDetail(c *gin.Context) {
 	logger.Debugf("Get version detail: %s/%s/%s", programID, channel, version)
 
 	v, err := h.versionSvc.GetVersion(programID, channel, version)
+	if err == nil && !h.canAccess(c, v, "") {
+		err = gorm.ErrRecordNotFound
+	}
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(404, gin.H{"error": "Version not found"})
@@ -81,71 +213,286 @@ func (h *VersionHandler) GetVersionDetail(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, v)
+	selected, selectedPlatform := selectPlatformFile(v, requestPlatform(c))
+	c.JSON(200, latestVersionResponse{Version: selected, Platform: selectedPlatform})
+}
+
+// selectPlatformFile 返回客户端平台对应制品的文件信息和签名，客户端按此校验
+//
+// 没有匹配的制品时返回主文件，平台标识为空。
+func selectPlatformFile(v *models.Version, platform service.Platform) (*models.Version, string) {
+	if platform.IsZero() {
+		return v, ""
+	}
+	a, ok := service.SelectArtifact(v, platform)
+	if !ok {
+		return v, ""
+	}
+	selected := *v
+	selected.FileName = a.FileName
+	selected.FileSize = a.FileSize
+	selected.FileHash = a.FileHash
+	selected.Signature = a.Signature
+	selected.Encrypted = a.Encrypted
+	selected.EncryptedSize = a.EncryptedSize
+	selected.EncryptedHash = a.EncryptedHash
+	selected.EncryptionKeyID = a.EncryptionKeyID
+	return &selected, service.Platform{OS: a.OS, Arch: a.Arch, Variant: a.Variant}.String()
 }
 
 // UploadVersion 上传新版本
 func (h *VersionHandler) UploadVersion(c *gin.Context) {
+	// 解析表单前限制请求体大小，超出时不再把剩余内容写入磁盘；单个文件的大小在解析后检查
+	if h.maxFileSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxFileSize*maxUploadFiles+uploadFormOverhead)
+		if _, err := c.MultipartForm(); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request exceeds maximum size of %d bytes", tooLarge.Limit)})
+				return
+			}
+		}
+	}
+
 	programID := c.Param("programId")
 	channel := c.PostForm("channel")
 	version := c.PostForm("version")
 	notes := c.PostForm("notes")
 	mandatory, _ := strconv.ParseBool(c.PostForm("mandatory"))
+	rollout := 100
+	if v := c.PostForm("rollout"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(400, gin.H{"error": "rollout must be between 1 and 100"})
+			return
+		}
+		rollout = n
+	}
 
 	if programID == "" || channel == "" || version == "" {
 		c.JSON(400, gin.H{"error": "programId, channel and version are required"})
 		return
 	}
+	if !requireScope(c, h.tokenSvc, service.ScopeUpload, programID, channel) {
+		return
+	}
 
-	fileHeader, err := c.FormFile("file")
+	if !semver.Valid(version) {
+		c.JSON(400, gin.H{"error": "version must be a valid semantic version"})
+		return
+	}
+
+	minUpgradeFrom := c.PostForm("minUpgradeFrom")
+	if minUpgradeFrom != "" && !semver.Valid(minUpgradeFrom) {
+		c.JSON(400, gin.H{"error": "minUpgradeFrom must be a valid semantic version"})
+		return
+	}
+	requiredStep, _ := strconv.ParseBool(c.PostForm("requiredStep"))
+
+	publishAt, err := service.ParsePublishAt(c.PostForm("publishAt"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	status, publishAt, err := service.ResolveReleaseStatus(c.PostForm("status"), publishAt, time.Now())
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	form, err := c.MultipartForm()
 	if err != nil {
 		c.JSON(400, gin.H{"error": "file is required"})
 		return
 	}
 
-	logger.Infof("Upload request: %s/%s/%s, file: %s", programID, channel, version, fileHeader.Filename)
+	// 主文件（单文件上传）与平台制品（字段名 file.<os>-<arch>[-<variant>]）
+	var mainHeader *multipart.FileHeader
+	if files := form.File["file"]; len(files) > 0 {
+		mainHeader = files[0]
+	}
+	artifactHeaders := make(map[string]*multipart.FileHeader)
+	platforms := make(map[string]service.Platform)
+	for field, files := range form.File {
+		if !strings.HasPrefix(field, artifactFieldPrefix) || len(files) == 0 {
+			continue
+		}
+		p, err := service.ParsePlatform(strings.TrimPrefix(field, artifactFieldPrefix))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if _, exists := platforms[p.String()]; exists {
+			c.JSON(400, gin.H{"error": "duplicate platform: " + p.String()})
+			return
+		}
+		platforms[p.String()] = p
+		artifactHeaders[p.String()] = files[0]
+	}
+	if mainHeader == nil && len(artifactHeaders) == 0 {
+		c.JSON(400, gin.H{"error": "file is required"})
+		return
+	}
+	if h.maxFileSize > 0 {
+		files := mapValues(artifactHeaders)
+		if mainHeader != nil {
+			files = append(files, mainHeader)
+		}
+		if len(files) > maxUploadFiles {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("too many files: at most %d per upload", maxUploadFiles)})
+			return
+		}
+		for _, fh := range files {
+			if fh.Size > h.maxFileSize {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file %s exceeds maximum size of %d bytes", fh.Filename, h.maxFileSize)})
+				return
+			}
+		}
+	}
+
+	platformKeys := make([]string, 0, len(platforms))
+	for key := range platforms {
+		platformKeys = append(platformKeys, key)
+	}
+	sort.Strings(platformKeys)
 
-	// 打开文件
-	file, err := fileHeader.Open()
-	if err != nil {
-		logger.Errorf("Failed to open uploaded file: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to process file"})
+	logger.Infof("Upload request: %s/%s/%s, main file: %v, platforms: %v", programID, channel, version, mainHeader != nil, platformKeys)
+
+	// 已存在的版本直接拒绝，避免重新上传覆盖正在下发的文件
+	if !checkUploadTarget(c, h.versionSvc, programID, channel, version) {
 		return
 	}
-	defer file.Close()
 
-	// 保存文件
-	fileName, fileSize, fileHash, err := h.versionSvc.GetStorageService().SaveFile(programID, channel, version, file)
+	storageSvc := h.versionSvc.GetStorageService()
+	fileDir := storageSvc.VersionDir(programID, channel, version)
+	encryptionKey, err := h.versionSvc.PackageEncryptionKey(programID)
 	if err != nil {
-		logger.Errorf("Failed to save file: %v", err)
-		c.JSON(500, gin.H{"error": "Failed to save file"})
+		logger.Errorf("Failed to load encryption key for %s: %v", programID, err)
+		c.JSON(500, gin.H{"error": "Failed to load encryption key"})
 		return
 	}
 
+	// 写入文件后任何失败都删除已写入的文件
+	published := false
+	defer func() {
+		if !published {
+			discardUploadFiles(h.versionSvc, programID, channel, version)
+		}
+	}()
+
+	// 保存平台制品
+	artifacts := make([]models.VersionArtifact, 0, len(platformKeys))
+	for _, key := range platformKeys {
+		p := platforms[key]
+		stored, err := saveUploadedFile(artifactHeaders[key], func(r io.Reader) (*service.StoredFile, error) {
+			return storageSvc.Store(programID, channel, version, service.ArtifactFileName(programID, version, key), r, encryptionKey)
+		})
+		if err != nil {
+			logger.Errorf("Failed to save artifact %s: %v", key, err)
+			c.JSON(500, gin.H{"error": "Failed to save file"})
+			return
+		}
+		artifact := models.VersionArtifact{
+			OS:        p.OS,
+			Arch:      p.Arch,
+			Variant:   p.Variant,
+			FileName:  stored.FileName,
+			FilePath:  fileDir,
+			FileSize:  stored.Size,
+			FileHash:  stored.Hash,
+			Signature: c.PostForm(signatureFieldPrefix + key),
+		}
+		stored.ApplyArtifact(&artifact)
+		artifacts = append(artifacts, artifact)
+	}
+
+	// 保存主文件；仅上传平台制品时以第一个制品作为主文件，供未上报平台的客户端使用
+	var mainFile *service.StoredFile
+	signature := c.PostForm("signature")
+	if mainHeader != nil {
+		mainFile, err = saveUploadedFile(mainHeader, func(r io.Reader) (*service.StoredFile, error) {
+			return storageSvc.Store(programID, channel, version, service.PackageFileName(programID, version), r, encryptionKey)
+		})
+		if err != nil {
+			logger.Errorf("Failed to save file: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to save file"})
+			return
+		}
+	} else {
+		a := artifacts[0]
+		mainFile = &service.StoredFile{
+			FileName:      a.FileName,
+			Size:          a.FileSize,
+			Hash:          a.FileHash,
+			Encrypted:     a.Encrypted,
+			EncryptedSize: a.EncryptedSize,
+			EncryptedHash: a.EncryptedHash,
+			KeyID:         a.EncryptionKeyID,
+		}
+	}
+
 	// 创建版本记录
 	v := &models.Version{
-		ProgramID:    programID,
-		Version:      version,
-		Channel:      channel,
-		FileName:     fileName,
-		FilePath:     filepath.Join("./data/packages", programID, channel, version),
-		FileSize:     fileSize,
-		FileHash:     fileHash,
-		ReleaseNotes: notes,
-		PublishDate:  time.Now(),
-		Mandatory:    mandatory,
-	}
-
-	if err := h.versionSvc.CreateVersion(v); err != nil {
+		ProgramID:         programID,
+		Version:           version,
+		Channel:           channel,
+		FileName:          mainFile.FileName,
+		FilePath:          fileDir,
+		FileSize:          mainFile.Size,
+		FileHash:          mainFile.Hash,
+		ReleaseNotes:      notes,
+		PublishDate:       time.Now(),
+		Mandatory:         mandatory,
+		RolloutPercentage: rollout,
+		Artifacts:         artifacts,
+		Signature:         signature,
+		Status:            status,
+		PublishAt:         publishAt,
+		MinUpgradeFrom:    minUpgradeFrom,
+		RequiredStep:      requiredStep,
+	}
+	mainFile.Apply(v)
+
+	if err := h.versionSvc.PublishVersion(v); err != nil {
+		if isSignatureError(err) {
+			logger.Warnf("Rejected upload %s/%s/%s: %v", programID, channel, version, err)
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		logger.Errorf("Failed to create version record: %v", err)
 		c.JSON(500, gin.H{"error": "Failed to create version"})
 		return
 	}
+	published = true
 
-	logger.Infof("Version uploaded successfully: %s/%s/%s", programID, channel, version)
+	logger.Infof("Version uploaded successfully: %s/%s/%s, status: %s", programID, channel, version, status)
 	c.JSON(http.StatusOK, gin.H{"message": "Version uploaded successfully", "version": v})
 }
 
+// checkUploadTarget 检查版本是否可以写入存储目录（见 VersionService.CheckUploadTarget），不可以时写入错误响应
+func checkUploadTarget(c *gin.Context, versionSvc *service.VersionService, programID, channel, version string) bool {
+	err := versionSvc.CheckUploadTarget(programID, channel, version)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrVersionExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Version already exists"})
+	case errors.Is(err, service.ErrVersionFilesInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logger.Errorf("Failed to check upload target %s/%s/%s: %v", programID, channel, version, err)
+		c.JSON(500, gin.H{"error": "Failed to check version"})
+	}
+	return false
+}
+
+// discardUploadFiles 删除上传失败的版本已写入的文件（见 VersionService.DiscardUploadFiles）
+func discardUploadFiles(versionSvc *service.VersionService, programID, channel, version string) {
+	if err := versionSvc.DiscardUploadFiles(programID, channel, version); err != nil {
+		logger.Warnf("Failed to delete files of failed upload %s/%s/%s: %v", programID, channel, version, err)
+	}
+}
+
 // DeleteVersion 删除版本
 func (h *VersionHandler) DeleteVersion(c *gin.Context) {
 	programID := c.Param("programId")
@@ -155,12 +502,22 @@ func (h *VersionHandler) DeleteVersion(c *gin.Context) {
 	if channel == "" {
 		channel = "stable" // 默认通道
 	}
+	if !requireScope(c, h.tokenSvc, service.ScopeDelete, programID, channel) {
+		return
+	}
 
 	logger.Infof("Delete request: %s/%s/%s", programID, channel, version)
 
-	// 删除文件
-	if err := h.versionSvc.GetStorageService().DeleteFile(programID, channel, version); err != nil {
-		logger.Warnf("Failed to delete file: %v", err)
+	// 删除文件（仍被其他通道的晋升版本引用时保留）
+	if v, err := h.versionSvc.GetVersion(programID, channel, version); err == nil {
+		storageSvc := h.versionSvc.GetStorageService()
+		if shared, err := h.versionSvc.IsArtifactShared(v); err != nil {
+			logger.Warnf("Failed to check artifact references: %v", err)
+		} else if shared {
+			logger.Infof("Artifact still referenced by another channel, keeping file: %s", storageSvc.GetVersionFilePath(v))
+		} else if err := storageSvc.DeleteVersionFiles(v); err != nil {
+			logger.Warnf("Failed to delete file: %v", err)
+		}
 	}
 
 	// 删除记录
@@ -173,6 +530,160 @@ func (h *VersionHandler) DeleteVersion(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "Version deleted successfully"})
 }
 
+// PromoteVersion 将版本晋升到其他通道（复用已存储的文件）
+func (h *VersionHandler) PromoteVersion(c *gin.Context) {
+	programID := c.Param("programId")
+	version := c.Param("version")
+
+	var req struct {
+		From               string            `json:"from" binding:"required"`
+		To                 string            `json:"to" binding:"required"`
+		Signature          string            `json:"signature"`          // 目标通道主文件清单的签名
+		ArtifactSignatures map[string]string `json:"artifactSignatures"` // 平台标识 -> 签名
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "from and to channels are required"})
+		return
+	}
+	if req.From == req.To {
+		c.JSON(400, gin.H{"error": "source and target channels must differ"})
+		return
+	}
+	if !requireScope(c, h.tokenSvc, service.ScopePublish, programID, req.To) {
+		return
+	}
+
+	actor := currentActor(c)
+	logger.Infof("Promote request: %s/%s %s -> %s by %s", programID, version, req.From, req.To, actor)
+
+	signatures := map[string]string{"": req.Signature}
+	for platform, sig := range req.ArtifactSignatures {
+		signatures[platform] = sig
+	}
+
+	v, err := h.versionSvc.PromoteVersion(programID, version, req.From, req.To, actor, signatures)
+	if err != nil {
+		switch {
+		case isSignatureError(err):
+			c.JSON(400, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(404, gin.H{"error": "Source version not found"})
+		case errors.Is(err, service.ErrVersionExists):
+			c.JSON(409, gin.H{"error": "Version already exists in target channel"})
+		case errors.Is(err, service.ErrVersionYanked):
+			c.JSON(400, gin.H{"error": "Cannot promote a yanked version"})
+		case errors.Is(err, service.ErrVersionNotPublished):
+			c.JSON(400, gin.H{"error": "Cannot promote an unpublished version"})
+		default:
+			logger.Errorf("Failed to promote version: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to promote version"})
+		}
+		return
+	}
+
+	logger.Infof("Version promoted: %s/%s %s -> %s", programID, version, req.From, req.To)
+	c.JSON(http.StatusOK, gin.H{"message": "Version promoted successfully", "version": v})
+}
+
+// PublishVersion 修改版本的发布状态：立即发布、定时发布或转回草稿
+func (h *VersionHandler) PublishVersion(c *gin.Context) {
+	programID := c.Param("programId")
+	version := c.Param("version")
+	channel := c.DefaultQuery("channel", "stable")
+	if !requireScope(c, h.tokenSvc, service.ScopePublish, programID, channel) {
+		return
+	}
+
+	var req struct {
+		Status    string `json:"status"`
+		PublishAt string `json:"publishAt"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	publishAt, err := service.ParsePublishAt(req.PublishAt)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	v, err := h.versionSvc.SetReleaseStatus(programID, channel, version, req.Status, publishAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(404, gin.H{"error": "Version not found"})
+		case errors.Is(err, service.ErrInvalidStatus):
+			c.JSON(400, gin.H{"error": err.Error()})
+		default:
+			logger.Errorf("Failed to update release status: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to update release status"})
+		}
+		return
+	}
+
+	logger.Infof("Release status changed: %s/%s/%s -> %s by %s", programID, channel, version, v.Status, currentActor(c))
+	c.JSON(200, gin.H{"message": "Release status updated", "version": v})
+}
+
+// YankVersion 撤回版本
+func (h *VersionHandler) YankVersion(c *gin.Context) {
+	programID := c.Param("programId")
+	version := c.Param("version")
+	channel := c.DefaultQuery("channel", "stable")
+	if !requireScope(c, h.tokenSvc, service.ScopeYank, programID, channel) {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "reason is required"})
+		return
+	}
+
+	v, err := h.versionSvc.YankVersion(programID, channel, version, req.Reason)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Version not found"})
+		} else {
+			logger.Errorf("Failed to yank version: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to yank version"})
+		}
+		return
+	}
+
+	logger.Infof("Version yanked: %s/%s/%s, reason: %s", programID, channel, version, req.Reason)
+	c.JSON(200, gin.H{"message": "Version yanked successfully", "version": v})
+}
+
+// UnyankVersion 取消撤回版本
+func (h *VersionHandler) UnyankVersion(c *gin.Context) {
+	programID := c.Param("programId")
+	version := c.Param("version")
+	channel := c.DefaultQuery("channel", "stable")
+	if !requireScope(c, h.tokenSvc, service.ScopeYank, programID, channel) {
+		return
+	}
+
+	v, err := h.versionSvc.UnyankVersion(programID, channel, version)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Version not found"})
+		} else {
+			logger.Errorf("Failed to unyank version: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to unyank version"})
+		}
+		return
+	}
+
+	logger.Infof("Version unyanked: %s/%s/%s", programID, channel, version)
+	c.JSON(200, gin.H{"message": "Version unyanked successfully", "version": v})
+}
+
 // DownloadFile 下载文件
 func (h *VersionHandler) DownloadFile(c *gin.Context) {
 	programID := c.Param("programId")
@@ -182,6 +693,9 @@ func (h *VersionHandler) DownloadFile(c *gin.Context) {
 	logger.Debugf("Download request: %s/%s/%s", programID, channel, version)
 
 	v, err := h.versionSvc.GetVersion(programID, channel, version)
+	if err == nil && !h.canAccess(c, v, service.ScopeDownload) {
+		err = gorm.ErrRecordNotFound
+	}
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(404, gin.H{"error": "Version not found"})
@@ -191,9 +705,159 @@ func (h *VersionHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	filePath := h.versionSvc.GetStorageService().GetFilePath(programID, channel, version)
-	c.File(filePath)
+	if v.Yanked {
+		logger.Warnf("Download of yanked version: %s/%s/%s", programID, channel, version)
+		c.Header("X-Version-Yanked", "true")
+	}
+
+	filePath := h.versionSvc.GetStorageService().GetVersionFilePath(v)
+	fileHash, encrypted := v.FileHash, v.Encrypted
+	if v.Encrypted {
+		fileHash = v.EncryptedHash
+	}
+	if platform := requestPlatform(c); !platform.IsZero() && len(v.Artifacts) > 0 {
+		a, ok := service.SelectArtifact(v, platform)
+		if !ok {
+			c.JSON(404, gin.H{"error": "No artifact for platform " + platform.String()})
+			return
+		}
+		filePath = h.versionSvc.GetStorageService().GetArtifactFilePath(a)
+		fileHash, encrypted = a.FileHash, a.Encrypted
+		if a.Encrypted {
+			fileHash = a.EncryptedHash
+		}
+	}
+	// 加密存储的包按原样下发，ETag 为密文哈希，客户端用程序密钥解密
+	if encrypted {
+		c.Header("X-Package-Encrypted", "aes-gcm-chunked")
+	}
+	serveFile(c, filePath, fileHash)
+
+	// 续传请求不重复计数
+	if c.GetHeader("Range") != "" && c.Writer.Status() == http.StatusPartialContent {
+		return
+	}
 
 	// 增加下载计数
 	go h.versionSvc.IncrementDownloadCount(v.ID)
 }
+
+// DownloadPatch 下载增量补丁
+func (h *VersionHandler) DownloadPatch(c *gin.Context) {
+	programID := c.Param("programId")
+	channel := c.Param("channel")
+	version := c.Param("version")
+	from := c.Param("from")
+	platform := c.Query("platform")
+
+	logger.Debugf("Patch download request: %s/%s/%s from %s, platform: %s", programID, channel, version, from, platform)
+
+	v, err := h.versionSvc.GetVersion(programID, channel, version)
+	if err == nil && !h.canAccess(c, v, service.ScopeDownload) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Version not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	p, err := h.versionSvc.GetPatch(v.ID, from, platform)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(404, gin.H{"error": "Patch not found"})
+		} else {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	serveFile(c, h.versionSvc.GetStorageService().GetPatchFilePath(p), p.FileHash)
+}
+
+// canAccess 检查请求能否访问版本
+//
+// 已发布版本需要 scope 权限（为空表示公开）；草稿和定时版本只对可向该通道
+// 上传或发布的 Token 与管理员 Token 可见，其他请求按不存在处理。
+func (h *VersionHandler) canAccess(c *gin.Context, v *models.Version, scope string) bool {
+	scopes := []string{scope}
+	if !service.IsPublished(v) {
+		scopes = []string{service.ScopeUpload, service.ScopePublish}
+	} else if scope == "" {
+		return true
+	}
+	value, ok := c.Get("token")
+	if !ok {
+		return false
+	}
+	token, ok := value.(*models.Token)
+	if !ok {
+		return false
+	}
+	for _, sc := range scopes {
+		if h.tokenSvc.HasPermission(token, sc, v.ProgramID, v.Channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile 以文件哈希作为强 ETag 发送文件
+//
+// http.ServeContent 负责处理 Range、If-Range 和 If-None-Match，
+// 客户端可以用 ETag 校验断点续传的分片属于同一个文件。
+func serveFile(c *gin.Context, filePath, fileHash string) {
+	if fileHash != "" {
+		c.Header("ETag", `"`+fileHash+`"`)
+	}
+	c.File(filePath)
+}
+
+// isSignatureError 判断是否为发布签名缺失或无效
+func isSignatureError(err error) bool {
+	return errors.Is(err, service.ErrSignatureRequired) || errors.Is(err, service.ErrSignatureInvalid)
+}
+
+// saveUploadedFile 打开上传的文件并交给存储函数保存
+func saveUploadedFile(fh *multipart.FileHeader, save func(io.Reader) (*service.StoredFile, error)) (*service.StoredFile, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return save(f)
+}
+
+func mapValues(m map[string]*multipart.FileHeader) []*multipart.FileHeader {
+	values := make([]*multipart.FileHeader, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// requestPlatform 从查询参数或客户端请求头读取平台信息（os 与 arch 需同时提供）
+func requestPlatform(c *gin.Context) service.Platform {
+	p := service.Platform{
+		OS:      c.Query("os"),
+		Arch:    c.Query("arch"),
+		Variant: c.Query("variant"),
+	}
+	if p.OS == "" && p.Arch == "" {
+		p = service.Platform{
+			OS:      c.GetHeader("X-Client-OS"),
+			Arch:    c.GetHeader("X-Client-Arch"),
+			Variant: c.GetHeader("X-Client-Variant"),
+		}
+	}
+	if p.OS == "" || p.Arch == "" {
+		return service.Platform{}
+	}
+	p.OS = strings.ToLower(p.OS)
+	p.Arch = strings.ToLower(p.Arch)
+	p.Variant = strings.ToLower(p.Variant)
+	return p
+}
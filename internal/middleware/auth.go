@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"docufiller-update-server/internal/models"
 	"docufiller-update-server/internal/service"
 	"strings"
 
@@ -25,14 +26,37 @@ func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
 	return m.requireAuth("admin")
 }
 
-// RequireUpload 需要上传权限（Admin 或 Upload Token）
+// RequireAdminOr 携带 Authorization 头的请求按 RequireAdmin 认证，否则交给 fallback
+//
+// 用于管理 API：浏览器使用登录会话（fallback），自动化脚本使用管理 Token。
+func (m *AuthMiddleware) RequireAdminOr(fallback gin.HandlerFunc) gin.HandlerFunc {
+	requireAdmin := m.RequireAdmin()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			requireAdmin(c)
+			return
+		}
+		fallback(c)
+	}
+}
+
+// RequireUpload 需要任一写权限（upload/publish/yank/delete）
+//
+// 用于发布端路由：具体操作所需的权限范围和通道限制由 handler 检查。
 func (m *AuthMiddleware) RequireUpload() gin.HandlerFunc {
-	return m.requireAuthWithProgram("upload")
+	return m.requireAuthWithProgram(service.ScopeUpload, service.ScopePublish, service.ScopeYank, service.ScopeDelete)
 }
 
 // RequireDownload 需要下载权限
 func (m *AuthMiddleware) RequireDownload() gin.HandlerFunc {
-	return m.requireAuthWithProgram("download")
+	return m.requireAuthWithProgram(service.ScopeDownload)
+}
+
+// RequireDownloadOrUpload 需要下载或上传权限
+//
+// 用于下载路由：草稿版本允许上传 Token 下载以便发布前验证，具体版本的权限由 handler 检查。
+func (m *AuthMiddleware) RequireDownloadOrUpload() gin.HandlerFunc {
+	return m.requireAuthWithProgram(service.ScopeDownload, service.ScopeUpload)
 }
 
 func (m *AuthMiddleware) requireAuth(requiredType string) gin.HandlerFunc {
@@ -60,12 +84,14 @@ func (m *AuthMiddleware) requireAuth(requiredType string) gin.HandlerFunc {
 			return
 		}
 
+		m.noteRotatedUse(c, tokenRecord)
 		c.Set("token", tokenRecord)
 		c.Next()
 	}
 }
 
-func (m *AuthMiddleware) requireAuthWithProgram(requiredType string) gin.HandlerFunc {
+// requireAuthWithProgram 需要对路由中的程序（及 :channel 通道，如有）拥有任一 scopes 权限
+func (m *AuthMiddleware) requireAuthWithProgram(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := m.extractToken(c)
 		if token == "" {
@@ -82,17 +108,31 @@ func (m *AuthMiddleware) requireAuthWithProgram(requiredType string) gin.Handler
 		}
 
 		programID := c.Param("programId")
-		if !m.tokenSvc.HasPermission(tokenRecord, requiredType, programID) {
+		channel := c.Param("channel")
+		allowed := false
+		for _, scope := range scopes {
+			if m.tokenSvc.HasPermission(tokenRecord, scope, programID, channel) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
 			c.JSON(403, gin.H{"error": "program access denied"})
 			c.Abort()
 			return
 		}
 
+		m.noteRotatedUse(c, tokenRecord)
 		c.Set("token", tokenRecord)
 		c.Next()
 	}
 }
 
+// noteRotatedUse 记录轮换宽限期内仍在使用旧 Token 的主机
+func (m *AuthMiddleware) noteRotatedUse(c *gin.Context, token *models.Token) {
+	m.tokenSvc.NoteRotatedUse(token, c.ClientIP(), c.GetHeader("X-Install-ID"))
+}
+
 func (m *AuthMiddleware) extractToken(c *gin.Context) string {
 	auth := c.GetHeader("Authorization")
 	if auth == "" {
@@ -113,6 +153,7 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		token := m.extractToken(c)
 		if token != "" {
 			if tokenRecord, err := m.tokenSvc.ValidateToken(token); err == nil {
+				m.noteRotatedUse(c, tokenRecord)
 				c.Set("token", tokenRecord)
 			}
 		}
@@ -70,6 +70,7 @@ func (m *CryptoMiddleware) Process() gin.HandlerFunc {
 			ResponseWriter: c.Writer,
 			cryptoSvc:      m.cryptoSvc,
 			programID:      programID,
+			keyID:          encryptedData.KeyID,
 			shouldEncrypt:  isEncrypted,
 		}
 		c.Writer = writer
@@ -82,6 +83,7 @@ type cryptoResponseWriter struct {
 	gin.ResponseWriter
 	cryptoSvc     *service.CryptoService
 	programID     string
+	keyID         string // 以请求所用的密钥加密响应，轮换期间客户端未必已有新密钥
 	shouldEncrypt bool
 	written       bool
 }
@@ -101,7 +103,7 @@ func (w *cryptoResponseWriter) Write(data []byte) (int, error) {
 	logger.Debugf("Encrypting response for program: %s", w.programID)
 
 	// Encrypt response
-	encrypted, err := w.cryptoSvc.Encrypt(data, w.programID)
+	encrypted, err := w.cryptoSvc.EncryptWithKey(data, w.programID, w.keyID)
 	if err != nil {
 		logger.Errorf("Encryption failed for program %s: %v", w.programID, err)
 		// Return original data if encryption fails
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// AdminSession 管理后台登录会话，Cookie 中只保存会话 Token，状态保存在服务端以便列出和撤销
+type AdminSession struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	SessionID  string    `gorm:"uniqueIndex;size:64;not null" json:"sessionId"` // 会话 Token 的 SHA256，不存储原始 Token
+	Username   string    `gorm:"index;size:50;not null" json:"username"`
+	PreAuth    bool      `gorm:"default:false" json:"-"` // 密码已通过、等待两步验证码，不能访问管理接口
+	IP         string    `gorm:"size:64" json:"ip"`
+	UserAgent  string    `gorm:"size:255" json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `gorm:"index" json:"expiresAt"`
+	Current    bool      `gorm:"-" json:"current"` // 是否为发起请求的会话，仅用于列表
+}
+
+// TableName 指定表名
+func (AdminSession) TableName() string {
+	return "admin_sessions"
+}
+
+// LoginAttempt 登录失败计数，按 IP 或用户名分别统计，用于限流和锁定
+type LoginAttempt struct {
+	ID            uint   `gorm:"primaryKey"`
+	Kind          string `gorm:"uniqueIndex:idx_login_attempt_key;size:10;not null"`  // ip, user
+	Subject       string `gorm:"uniqueIndex:idx_login_attempt_key;size:100;not null"` // IP 地址或用户名
+	Failures      int    `gorm:"default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// TableName 指定表名
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
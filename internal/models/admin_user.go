@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// AdminUser 管理后台用户
+type AdminUser struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	Username           string     `gorm:"uniqueIndex;size:50;not null" json:"username"`
+	PasswordHash       string     `gorm:"size:100;not null" json:"-"`                // bcrypt 哈希
+	Role               string     `gorm:"size:20;not null" json:"role"`              // owner, maintainer, viewer
+	Programs           []string   `gorm:"serializer:json;size:1000" json:"programs"` // maintainer 可修改的程序
+	MustChangePassword bool       `gorm:"default:false" json:"mustChangePassword"`   // 重置密码后须先修改密码
+	IsActive           bool       `gorm:"default:true" json:"isActive"`
+	TOTPSecret         string     `gorm:"column:totp_secret;size:255" json:"-"` // 用 masterKey 派生的 KEK 包装（service.SecretBox）；未启用时为待确认的绑定
+	TOTPEnabled        bool       `gorm:"column:totp_enabled;default:false" json:"totpEnabled"`
+	TOTPLastStep       int64      `gorm:"column:totp_last_step;default:0" json:"-"` // 最近一次通过的时间步，防止验证码重放
+	RecoveryCodes      []string   `gorm:"serializer:json;size:2000" json:"-"`       // 恢复码的 SHA256 哈希，使用后移除
+	LastLoginAt        *time.Time `json:"lastLoginAt,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (AdminUser) TableName() string {
+	return "admin_users"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+)
+
+// VersionArtifact 版本的平台制品（同一版本可为不同 os/arch 提供不同的包）
+type VersionArtifact struct {
+	ID              uint      `gorm:"primaryKey" json:"-"`
+	VersionID       uint      `gorm:"not null;uniqueIndex:idx_artifact_platform" json:"-"`
+	OS              string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_artifact_platform" json:"os"`
+	Arch            string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_artifact_platform" json:"arch"`
+	Variant         string    `gorm:"type:varchar(50);uniqueIndex:idx_artifact_platform" json:"variant,omitempty"`
+	FileName        string    `gorm:"type:varchar(255);not null" json:"fileName"`
+	FilePath        string    `gorm:"type:varchar(500);not null" json:"filePath"`
+	FileSize        int64     `json:"fileSize"`
+	FileHash        string    `gorm:"type:varchar(64);not null" json:"fileHash"`
+	Signature       string    `gorm:"type:varchar(100)" json:"signature,omitempty"`
+	Encrypted       bool      `gorm:"default:false" json:"encrypted,omitempty"`
+	EncryptedSize   int64     `json:"encryptedSize,omitempty"`
+	EncryptedHash   string    `gorm:"type:varchar(64)" json:"encryptedHash,omitempty"`
+	EncryptionKeyID string    `gorm:"type:varchar(16)" json:"encryptionKeyId,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// TableName 指定表名
+func (VersionArtifact) TableName() string {
+	return "version_artifacts"
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// ChannelPolicy 通道级升级策略
+type ChannelPolicy struct {
+	ID                  uint      `gorm:"primaryKey" json:"-"`
+	ProgramID           string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_channel_policy" json:"programId"`
+	Channel             string    `gorm:"type:varchar(10);not null;uniqueIndex:idx_channel_policy" json:"channel"`
+	MinSupportedVersion string    `gorm:"type:varchar(20)" json:"minSupportedVersion"` // 低于该版本的客户端必须更新
+	UpdatedAt           time.Time `json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (ChannelPolicy) TableName() string {
+	return "channel_policies"
+}
@@ -6,10 +6,14 @@ import (
 	"gorm.io/gorm"
 )
 
+// EncryptionKey 程序加密密钥；每个程序保留密钥历史，同一时间只有一个 active 密钥
 type EncryptionKey struct {
 	ID        uint           `gorm:"primaryKey"`
-	ProgramID string         `gorm:"uniqueIndex;size:50;not null"`
-	KeyData   string         `gorm:"size:255;not null"`
+	ProgramID string         `gorm:"size:50;not null;uniqueIndex:idx_encryption_key_id"`
+	KeyID     string         `gorm:"size:16;uniqueIndex:idx_encryption_key_id" json:"keyId"` // 由密钥内容派生（pkgcrypt.KeyID），写入加密文件头
+	KeyData   string         `gorm:"size:255;not null" json:"-"`                             // 用 masterKey 派生的 KEK 包装（service.SecretBox），旧数据为明文 base64
+	Status    string         `gorm:"size:20;not null;default:active" json:"status"`          // active | retiring | retired
+	RetiresAt *time.Time     `json:"retiresAt,omitempty"`                                    // retiring 密钥的宽限期截止时间，之后不再接受
 	CreatedAt time.Time      `json:"createdAt"`
 	UpdatedAt time.Time      `json:"updatedAt"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
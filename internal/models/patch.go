@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// VersionPatch 版本的增量补丁（从较早版本的包重建该版本的包）
+type VersionPatch struct {
+	ID          uint      `gorm:"primaryKey" json:"-"`
+	VersionID   uint      `gorm:"not null;uniqueIndex:idx_patch_from" json:"-"`
+	FromVersion string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_patch_from" json:"from"`
+	Platform    string    `gorm:"type:varchar(100);uniqueIndex:idx_patch_from" json:"platform,omitempty"` // 对应的平台制品，为空表示主文件
+	FileName    string    `gorm:"type:varchar(255);not null" json:"fileName"`
+	FilePath    string    `gorm:"type:varchar(500);not null" json:"filePath"`
+	FileSize    int64     `json:"fileSize"`
+	FileHash    string    `gorm:"type:varchar(64);not null" json:"fileHash"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TableName 指定表名
+func (VersionPatch) TableName() string {
+	return "version_patches"
+}
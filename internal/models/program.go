@@ -14,6 +14,8 @@ type Program struct {
 	Description      string         `gorm:"size:500" json:"description"`
 	IconURL          string         `gorm:"size:255" json:"iconUrl"`
 	EncryptionKey    string         `gorm:"size:100" json:"encryptionKey"`
+	SigningPublicKey string         `gorm:"size:100" json:"signingPublicKey,omitempty"` // Ed25519 公钥（base64），配置后发布必须携带签名
+	EncryptPackages  bool           `gorm:"default:false" json:"encryptPackages"`       // 上传的包以 encryption_keys 中的密钥加密存储
 	IsActive         bool           `gorm:"default:true" json:"isActive"`
 	CreatedAt        time.Time      `json:"createdAt"`
 	UpdatedAt        time.Time      `json:"updatedAt"`
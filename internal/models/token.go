@@ -10,9 +10,15 @@ import (
 type Token struct {
 	ID          uint           `gorm:"primaryKey"`
 	TokenID     string         `gorm:"uniqueIndex;size:64;not null" json:"tokenId"`
-	TokenValue  string         `gorm:"size:128;not null" json:"-"` // 不 JSON 序列化，存储实际的 Token 值
+	TokenValue  string         `gorm:"size:128;not null" json:"-"` // 已废弃：只存储哈希（TokenID），保留为空；旧数据由 migrate-secrets 清除
 	ProgramID   string         `gorm:"index;size:50;not null" json:"programId"`
-	TokenType   string         `gorm:"size:20;not null" json:"tokenType"` // admin, upload, download
+	TokenType   string         `gorm:"size:20;not null" json:"tokenType"`                // admin, upload, download
+	Name        string         `gorm:"size:100" json:"name,omitempty"`                   // 管理 API 创建的 Token 名称，为空表示程序默认 Token
+	Scopes      []string       `gorm:"serializer:json;size:255" json:"scopes,omitempty"` // 为空时按 TokenType 取默认权限
+	Channel     string         `gorm:"size:10" json:"channel,omitempty"`                 // 限定可操作的通道，为空表示不限
+	RotatedAt   *time.Time     `json:"rotatedAt,omitempty"`                              // 被轮换的时间，宽限期内仍有效直到 ExpiresAt
+	ReplacedBy  string         `gorm:"size:64" json:"replacedBy,omitempty"`              // 替换 Token 的 TokenID
+	Replacement string         `gorm:"size:255" json:"-"`                                // 替换 Token 的明文（SecretBox 加密），更新检查时下发给仍在使用旧 Token 的客户端
 	CreatedBy   string         `gorm:"size:100" json:"createdBy"`
 	ExpiresAt   *time.Time     `json:"expiresAt"`
 	IsActive    bool           `gorm:"default:true" json:"isActive"`
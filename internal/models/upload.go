@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// UploadSession 分片上传会话
+type UploadSession struct {
+	ID             string     `gorm:"primaryKey;size:32" json:"id"`
+	ProgramID      string     `gorm:"size:50;index;not null" json:"programId"`
+	Channel        string     `gorm:"size:10;not null" json:"channel"`
+	Version        string     `gorm:"size:20;not null" json:"version"`
+	TotalSize      int64      `gorm:"not null" json:"totalSize"`
+	ChunkSize      int64      `gorm:"not null" json:"chunkSize"`
+	FileHash       string     `gorm:"size:64" json:"fileHash,omitempty"` // 可选，完成时校验整个文件的 SHA256
+	ReleaseNotes   string     `gorm:"type:text" json:"releaseNotes"`
+	Mandatory      bool       `json:"mandatory"`
+	Rollout        int        `gorm:"default:100" json:"rollout"`
+	Signature      string     `gorm:"size:100" json:"signature,omitempty"`    // 发布清单签名，完成时校验
+	ReleaseStatus  string     `gorm:"size:20" json:"releaseStatus,omitempty"` // 完成后版本的发布状态，为空表示立即发布
+	PublishAt      *time.Time `json:"publishAt,omitempty"`
+	MinUpgradeFrom string     `gorm:"size:20" json:"minUpgradeFrom,omitempty"`
+	RequiredStep   bool       `json:"requiredStep,omitempty"`
+	Status         string     `gorm:"size:20;not null;default:active" json:"status"` // active | completed
+	CreatedBy      string     `gorm:"size:100" json:"createdBy"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// TableName 指定表名
+func (UploadSession) TableName() string {
+	return "upload_sessions"
+}
+
+// UploadChunk 已接收的分片
+type UploadChunk struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	SessionID string    `gorm:"size:32;not null;uniqueIndex:idx_upload_chunk" json:"-"`
+	Index     int       `gorm:"not null;uniqueIndex:idx_upload_chunk" json:"index"`
+	Offset    int64     `json:"offset"`
+	Size      int64     `json:"size"`
+	Hash      string    `gorm:"size:64;not null" json:"sha256"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName 指定表名
+func (UploadChunk) TableName() string {
+	return "upload_chunks"
+}
@@ -8,17 +8,35 @@ import (
 
 type Version struct {
 	gorm.Model
-	ProgramID     string    `gorm:"type:varchar(50);index;not null;uniqueIndex:idx_program_version_channel" json:"programId"`
-	Version       string    `gorm:"type:varchar(20);uniqueIndex:idx_program_version_channel" json:"version"`
-	Channel       string    `gorm:"type:varchar(10);uniqueIndex:idx_program_version_channel" json:"channel"`
-	FileName      string    `gorm:"type:varchar(255);not null" json:"fileName"`
-	FilePath      string    `gorm:"type:varchar(500);not null" json:"filePath"`
-	FileSize      int64     `json:"fileSize"`
-	FileHash      string    `gorm:"type:varchar(64);not null" json:"fileHash"`
-	ReleaseNotes  string    `gorm:"type:text" json:"releaseNotes"`
-	PublishDate   time.Time `json:"publishDate"`
-	DownloadCount int64     `gorm:"default:0" json:"downloadCount"`
-	Mandatory     bool      `gorm:"default:false" json:"mandatory"`
+	ProgramID         string     `gorm:"type:varchar(50);index;not null;uniqueIndex:idx_program_version_channel" json:"programId"`
+	Version           string     `gorm:"type:varchar(20);uniqueIndex:idx_program_version_channel" json:"version"`
+	Channel           string     `gorm:"type:varchar(10);uniqueIndex:idx_program_version_channel" json:"channel"`
+	FileName          string     `gorm:"type:varchar(255);not null" json:"fileName"`
+	FilePath          string     `gorm:"type:varchar(500);not null" json:"filePath"`
+	FileSize          int64      `json:"fileSize"`
+	FileHash          string     `gorm:"type:varchar(64);not null" json:"fileHash"`
+	ReleaseNotes      string     `gorm:"type:text" json:"releaseNotes"`
+	PublishDate       time.Time  `json:"publishDate"`
+	DownloadCount     int64      `gorm:"default:0" json:"downloadCount"`
+	Mandatory         bool       `gorm:"default:false" json:"mandatory"`
+	RolloutPercentage int        `gorm:"default:100" json:"rolloutPercentage"` // 灰度比例 0-100，按安装 ID 与版本号哈希分组
+	Yanked            bool       `gorm:"default:false;index" json:"yanked"`    // 已撤回：不再作为最新版本下发，但仍可显式下载
+	YankReason        string     `gorm:"type:varchar(500)" json:"yankReason,omitempty"`
+	YankedAt          *time.Time `json:"yankedAt,omitempty"`
+	PromotedFrom      string     `gorm:"type:varchar(10)" json:"promotedFrom,omitempty"` // 晋升来源通道，与来源版本共用同一存储文件
+	PromotedBy        string     `gorm:"type:varchar(100)" json:"promotedBy,omitempty"`
+	PromotedAt        *time.Time `json:"promotedAt,omitempty"`
+	Signature         string     `gorm:"type:varchar(100)" json:"signature,omitempty"`           // 主文件发布清单的 Ed25519 签名（base64）
+	Status            string     `gorm:"type:varchar(20);default:published;index" json:"status"` // draft | scheduled | published，仅 published 对公开接口可见
+	PublishAt         *time.Time `json:"publishAt,omitempty"`                                    // 定时发布时间（status 为 scheduled 时由调度器发布）
+	MinUpgradeFrom    string     `gorm:"type:varchar(20)" json:"minUpgradeFrom,omitempty"`       // 可直接升级到本版本的最低版本，更低的客户端需先升级到中间版本
+	RequiredStep      bool       `gorm:"default:false" json:"requiredStep,omitempty"`            // 必经版本（如包含数据迁移），更低的客户端不能跳过
+	Encrypted         bool       `gorm:"default:false" json:"encrypted,omitempty"`               // 以程序加密密钥分块 AES-GCM 加密存储，FileSize/FileHash 为明文
+	EncryptedSize     int64      `json:"encryptedSize,omitempty"`                                // 磁盘上密文的大小
+	EncryptedHash     string     `gorm:"type:varchar(64)" json:"encryptedHash,omitempty"`        // 密文 SHA256（下载的 ETag）
+	EncryptionKeyID   string     `gorm:"type:varchar(16)" json:"encryptionKeyId,omitempty"`      // 加密所用密钥的 ID，客户端据此选择密钥
+
+	Artifacts []VersionArtifact `gorm:"foreignKey:VersionID" json:"artifacts,omitempty"` // 多平台制品，为空时所有平台共用 FileName 指向的包
 }
 
 func (Version) TableName() string {
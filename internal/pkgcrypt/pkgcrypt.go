@@ -0,0 +1,337 @@
+// Package pkgcrypt 更新包的分块 AES-GCM 加密格式
+//
+// 服务端在保存上传文件时流式加密，客户端下载后流式解密。文件格式：
+//
+//	magic "UPKG" | 格式版本 (1 字节) | 分块大小 (uint32 大端) | 密钥 ID (8 字节) | nonce 前缀 (7 字节)
+//	分块 0 密文 + tag | 分块 1 密文 + tag | ... | 最后一块密文 + tag
+//
+// 每块的 nonce 为 nonce 前缀 + 块序号 (uint32 大端) + 最后一块标记 (1 字节)，
+// 文件头作为附加数据参与认证。篡改、重排、截断或追加分块都会导致解密失败。
+//
+// 密钥 ID 由密钥内容派生（见 KeyID），密钥轮换期间客户端按文件头中的 ID 从 Keyring 选择密钥。
+package pkgcrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// DefaultChunkSize 默认分块大小（明文）
+	DefaultChunkSize = 64 << 10
+
+	formatVersion   = 2
+	keyIDSize       = 8
+	noncePrefixSize = 7
+	headerSize      = 4 + 1 + 4 + keyIDSize + noncePrefixSize
+	maxChunkSize    = 16 << 20
+)
+
+var magic = []byte("UPKG")
+
+var (
+	// ErrInvalidKey 密钥格式错误
+	ErrInvalidKey = errors.New("pkgcrypt: invalid key")
+	// ErrInvalidFormat 不是受支持的加密包格式
+	ErrInvalidFormat = errors.New("pkgcrypt: invalid format")
+	// ErrAuthentication 密文被篡改、截断或使用了错误的密钥
+	ErrAuthentication = errors.New("pkgcrypt: authentication failed")
+	// ErrUnknownKey 密钥环中没有加密时使用的密钥
+	ErrUnknownKey = errors.New("pkgcrypt: unknown key")
+)
+
+// ParseKey 解析 base64 编码的 AES 密钥（16、24 或 32 字节）
+func ParseKey(b64 string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: expected 16, 24, or 32 bytes, got %d", ErrInvalidKey, len(key))
+}
+
+// KeyID 由密钥内容派生的密钥 ID（16 位十六进制），不泄露密钥本身
+func KeyID(key []byte) string {
+	return hex.EncodeToString(rawKeyID(key))
+}
+
+func rawKeyID(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("pkgcrypt key id\x00"), key...))
+	return sum[:keyIDSize]
+}
+
+// Keyring 按密钥 ID 索引的解密密钥，密钥轮换期间同时持有新旧密钥
+type Keyring map[string][]byte
+
+// NewKeyring 由若干密钥创建密钥环
+func NewKeyring(keys ...[]byte) Keyring {
+	k := make(Keyring, len(keys))
+	for _, key := range keys {
+		k.Add(key)
+	}
+	return k
+}
+
+// Add 加入密钥，返回其密钥 ID
+func (k Keyring) Add(key []byte) string {
+	id := KeyID(key)
+	k[id] = key
+	return id
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce 计算分块的 nonce
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// Writer 流式加密写入器，Close 时写入最后一块，必须调用
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	out    []byte
+	index  uint32
+	closed bool
+}
+
+// NewWriter 创建加密写入器并写入文件头
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	return NewWriterSize(w, key, DefaultChunkSize)
+}
+
+// NewWriterSize 以指定分块大小创建加密写入器
+func NewWriterSize(w io.Writer, key []byte, chunkSize int) (*Writer, error) {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("pkgcrypt: invalid chunk size %d", chunkSize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, formatVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(chunkSize))
+	header = append(header, rawKeyID(key)...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+		out:    make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Write 加密并写入数据；满一块且还有后续数据时才写出，以便 Close 标记最后一块
+func (e *Writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("pkgcrypt: write after close")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close 写入最后一块（明文为空时也会写入，用于检测截断），不关闭底层写入器
+func (e *Writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *Writer) flush(last bool) error {
+	if e.index == math.MaxUint32 {
+		return errors.New("pkgcrypt: too many chunks")
+	}
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.prefix, e.index, last), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Reader 流式解密读取器，到达最后一块后返回 io.EOF
+type Reader struct {
+	keyID  string
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	in     []byte
+	plain  []byte
+	index  uint32
+	done   bool
+}
+
+// NewReader 读取并校验文件头，按其中的密钥 ID 从 keys 选择密钥并创建解密读取器
+func NewReader(r io.Reader, keys Keyring) (*Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	if !bytes.Equal(header[:4], magic) || header[4] != formatVersion {
+		return nil, ErrInvalidFormat
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[5:9]))
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("%w: chunk size %d", ErrInvalidFormat, chunkSize)
+	}
+	keyID := hex.EncodeToString(header[9 : 9+keyIDSize])
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		keyID:  keyID,
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		header: header,
+		prefix: header[9+keyIDSize:],
+		in:     make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// KeyID 文件加密时使用的密钥 ID
+func (d *Reader) KeyID() string {
+	return d.keyID
+}
+
+// Read 读取解密后的明文
+func (d *Reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next 读取并解密下一块；读不满一块或其后没有数据时，该块必须是最后一块
+func (d *Reader) next() error {
+	n, err := io.ReadFull(d.r, d.in)
+	switch {
+	case err == io.EOF:
+		return fmt.Errorf("%w: missing final chunk", ErrAuthentication)
+	case err == io.ErrUnexpectedEOF:
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			d.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if n < d.aead.Overhead() {
+		return fmt.Errorf("%w: truncated chunk", ErrAuthentication)
+	}
+
+	plain, err := d.aead.Open(d.in[:0], chunkNonce(d.prefix, d.index, d.done), d.in[:n], d.header)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrAuthentication, d.index)
+	}
+	d.index++
+	d.plain = plain
+	return nil
+}
+
+// WrapKey 用 kek 加密（包装）密钥 key，返回 base64 编码的 nonce + 密文
+//
+// 密钥轮换时服务端用客户端已有的旧密钥包装新密钥下发，key 的密钥 ID 作为附加数据参与认证。
+func WrapKey(kek, key []byte) (string, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, key, []byte("pkgcrypt key wrap\x00"+KeyID(key)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnwrapKey 用 kek 解开 WrapKey 包装的密钥，并确认其密钥 ID 为 keyID
+func UnwrapKey(kek []byte, keyID, wrapped string) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrInvalidFormat)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	key, err := aead.Open(nil, nonce, ciphertext, []byte("pkgcrypt key wrap\x00"+keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrapped key", ErrAuthentication)
+	}
+	if KeyID(key) != keyID {
+		return nil, fmt.Errorf("%w: wrapped key id mismatch", ErrAuthentication)
+	}
+	return key, nil
+}
@@ -0,0 +1,129 @@
+package pkgcrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func seal(t *testing.T, key, plain []byte, chunkSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriterSize(&buf, key, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), NewKeyring(key))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 48, 1000} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		got, err := open(key, seal(t, key, plain, 16))
+		if err != nil {
+			t.Fatalf("size %d: open failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestTamperDetected(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	plain := bytes.Repeat([]byte("update package "), 10)
+	sealed := seal(t, key, plain, 32)
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[headerSize+5] ^= 1
+
+	chunk := 32 + 16
+	tests := map[string][]byte{
+		"flipped bit":   flipped,
+		"truncated":     sealed[:headerSize+chunk],
+		"dropped final": sealed[:len(sealed)-(len(plain)%32+16)],
+		"appended":      append(append([]byte(nil), sealed...), sealed[headerSize:headerSize+chunk]...),
+	}
+	for name, data := range tests {
+		if _, err := open(key, data); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("%s: expected ErrAuthentication, got %v", name, err)
+		}
+	}
+
+	wrongKey := make([]byte, 32)
+	rand.Read(wrongKey)
+	if _, err := open(wrongKey, sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("wrong key: expected ErrUnknownKey, got %v", err)
+	}
+	if _, err := open(key, plain); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("plaintext: expected ErrInvalidFormat, got %v", err)
+	}
+}
+
+func TestKeyringSelectsKey(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+	keys := NewKeyring(oldKey, newKey)
+
+	for _, key := range [][]byte{oldKey, newKey} {
+		plain := []byte("encrypted with " + KeyID(key))
+		r, err := NewReader(bytes.NewReader(seal(t, key, plain, 16)), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.KeyID() != KeyID(key) {
+			t.Errorf("KeyID() = %s, want %s", r.KeyID(), KeyID(key))
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("decrypt with keyring failed: %v", err)
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	kek, key := make([]byte, 32), make([]byte, 32)
+	rand.Read(kek)
+	rand.Read(key)
+
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnwrapKey(kek, KeyID(key), wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Error("unwrapped key mismatch")
+	}
+
+	if _, err := UnwrapKey(key, KeyID(key), wrapped); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("wrong kek: expected ErrAuthentication, got %v", err)
+	}
+	if _, err := UnwrapKey(kek, KeyID(kek), wrapped); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("wrong key id: expected ErrAuthentication, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version 解析后的语义化版本号（SemVer 2.0）
+//
+// Parse 得到的版本核心版本号总是三段；Compare 为兼容历史数据宽松解析，
+// 核心版本号允许任意段数（如 1.2 或 1.2.3.4），缺失的段按 0 处理。
+type Version struct {
+	Core       []int64
+	PreRelease []string
+	Build      string
+	Original   string
+}
+
+// Parse 按 SemVer 2.0 规范严格解析版本号
+//
+// 核心版本号必须为 MAJOR.MINOR.PATCH 三段，数字不能有前导零；
+// 预发布和构建元数据的标识不能为空且只能包含 [0-9A-Za-z-]，数字预发布标识不能有前导零。
+func Parse(s string) (*Version, error) {
+	return parse(s, true)
+}
+
+// parseLenient 宽松解析已保存的历史版本号：忽略首尾空白和 v 前缀，核心版本号允许任意段数，不检查标识字符
+func parseLenient(s string) (*Version, error) {
+	return parse(s, false)
+}
+
+func parse(s string, strict bool) (*Version, error) {
+	original := s
+	if !strict {
+		s = strings.TrimSpace(s)
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	}
+	if s == "" {
+		return nil, fmt.Errorf("invalid version %q: empty", original)
+	}
+
+	v := &Version{Original: original}
+
+	// 构建元数据（+ 之后）不参与比较
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		v.Build = s[i+1:]
+		s = s[:i]
+		if v.Build == "" {
+			return nil, fmt.Errorf("invalid version %q: empty build metadata", original)
+		}
+		if strict {
+			for _, id := range strings.Split(v.Build, ".") {
+				if !validIdentifier(id) {
+					return nil, fmt.Errorf("invalid version %q: bad build identifier %q", original, id)
+				}
+			}
+		}
+	}
+
+	// 预发布标识（- 之后）
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre := s[i+1:]
+		s = s[:i]
+		if pre == "" {
+			return nil, fmt.Errorf("invalid version %q: empty pre-release", original)
+		}
+		for _, id := range strings.Split(pre, ".") {
+			if id == "" {
+				return nil, fmt.Errorf("invalid version %q: empty pre-release identifier", original)
+			}
+			if strict && (!validIdentifier(id) || isNumeric(id) && hasLeadingZero(id)) {
+				return nil, fmt.Errorf("invalid version %q: bad pre-release identifier %q", original, id)
+			}
+			v.PreRelease = append(v.PreRelease, id)
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if strict && len(parts) != 3 {
+		return nil, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", original)
+	}
+	for _, part := range parts {
+		if strict && (!isNumeric(part) || hasLeadingZero(part)) {
+			return nil, fmt.Errorf("invalid version %q: bad numeric part %q", original, part)
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q: bad numeric part %q", original, part)
+		}
+		v.Core = append(v.Core, n)
+	}
+
+	return v, nil
+}
+
+// validIdentifier 标识不为空且只包含 [0-9A-Za-z-]
+func validIdentifier(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func hasLeadingZero(id string) bool {
+	return len(id) > 1 && id[0] == '0'
+}
+
+// Compare 比较两个版本
+// 返回: -1 (v < o), 0 (v == o), 1 (v > o)
+func (v *Version) Compare(o *Version) int {
+	maxLen := len(v.Core)
+	if len(o.Core) > maxLen {
+		maxLen = len(o.Core)
+	}
+	for i := 0; i < maxLen; i++ {
+		var a, b int64
+		if i < len(v.Core) {
+			a = v.Core[i]
+		}
+		if i < len(o.Core) {
+			b = o.Core[i]
+		}
+		if a != b {
+			if a > b {
+				return 1
+			}
+			return -1
+		}
+	}
+
+	// 有预发布标识的版本优先级低于正式版本
+	switch {
+	case len(v.PreRelease) == 0 && len(o.PreRelease) == 0:
+		return 0
+	case len(v.PreRelease) == 0:
+		return 1
+	case len(o.PreRelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.PreRelease) && i < len(o.PreRelease); i++ {
+		if c := compareIdentifier(v.PreRelease[i], o.PreRelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(v.PreRelease) > len(o.PreRelease):
+		return 1
+	case len(v.PreRelease) < len(o.PreRelease):
+		return -1
+	}
+	return 0
+}
+
+// IsPreRelease 是否为预发布版本
+func (v *Version) IsPreRelease() bool {
+	return len(v.PreRelease) > 0
+}
+
+// compareIdentifier 比较单个预发布标识：
+// 纯数字按数值比较，数字标识低于字母数字标识，其余按 ASCII 排序
+func compareIdentifier(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+
+	switch {
+	case errA == nil && errB == nil:
+		if na == nb {
+			return 0
+		}
+		if na > nb {
+			return 1
+		}
+		return -1
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare 比较两个版本号字符串
+// 返回: -1 (a < b), 0 (a == b), 1 (a > b)
+//
+// 为兼容历史数据宽松解析（见 parseLenient）；无法解析的版本号总是低于合法版本号，两者都无法解析时按字符串比较。
+func Compare(a, b string) int {
+	va, errA := parseLenient(a)
+	vb, errB := parseLenient(b)
+
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// Valid 检查版本号是否为合法的 SemVer 2.0 版本号
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
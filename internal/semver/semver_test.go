@@ -0,0 +1,115 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		v1       string
+		v2       string
+		expected int
+	}{
+		{"equal", "1.0.0", "1.0.0", 0},
+		{"minor greater", "1.3.0", "1.2.9", 1},
+		{"numeric not lexical", "1.10.0", "1.9.0", 1},
+		{"with v prefix", "v1.0.0", "1.0.0", 0},
+		{"missing parts are zero", "1.2", "1.2.0", 0},
+		{"four parts", "1.2.3.4", "1.2.3.3", 1},
+		{"release beats pre-release", "1.0.0", "1.0.0-rc.1", 1},
+		{"pre-release numeric order", "1.0.0-beta.11", "1.0.0-beta.2", 1},
+		{"numeric below alphanumeric", "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"longer pre-release wins", "1.0.0-alpha.1", "1.0.0-alpha", 1},
+		{"alphanumeric lexical", "1.0.0-beta", "1.0.0-alpha.beta", 1},
+		{"build metadata ignored", "1.0.0+build.5", "1.0.0+build.1", 0},
+		{"invalid below valid", "latest", "0.0.1", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Compare(tt.v1, tt.v2)
+			if result != tt.expected {
+				t.Errorf("Compare(%q, %q) = %d, want %d", tt.v1, tt.v2, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompare_SpecPrecedence(t *testing.T) {
+	// SemVer 2.0 规范 11.4 节给出的优先级顺序
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+	}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		if Compare(ordered[i], ordered[i+1]) != -1 {
+			t.Errorf("expected %s < %s", ordered[i], ordered[i+1])
+		}
+		if Compare(ordered[i+1], ordered[i]) != 1 {
+			t.Errorf("expected %s > %s", ordered[i+1], ordered[i])
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	invalid := []string{"", "1.x.0", "1.0.0-", "1.0.0+", "1.0.0-alpha..1"}
+	for _, s := range invalid {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestParse_Strict(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		valid   bool
+	}{
+		{"release", "1.2.3", true},
+		{"zeros", "0.0.0", true},
+		{"pre-release", "1.0.0-alpha.1", true},
+		{"hyphen in pre-release", "1.0.0-x-y-z.--", true},
+		{"zero pre-release identifier", "1.0.0-0.3.7", true},
+		{"alphanumeric with leading zero", "1.0.0-0alpha", true},
+		{"build metadata", "1.0.0-beta+exp.sha.5114f85", true},
+		{"build leading zero allowed", "1.0.0+001", true},
+
+		{"two parts", "1.2", false},
+		{"four parts", "1.2.3.4", false},
+		{"one part", "1", false},
+		{"v prefix", "v1.2.3", false},
+		{"surrounding space", " 1.2.3", false},
+		{"leading zero major", "01.2.3", false},
+		{"leading zero patch", "1.2.03", false},
+		{"leading zero numeric pre-release", "1.0.0-01", false},
+		{"leading zero in later pre-release", "1.0.0-alpha.007", false},
+		{"underscore in pre-release", "1.0.0-alpha_1", false},
+		{"dot only pre-release", "1.0.0-.", false},
+		{"unicode in pre-release", "1.0.0-bêta", false},
+		{"empty build identifier", "1.0.0+build..1", false},
+		{"invalid build character", "1.0.0+build/1", false},
+		{"signed part", "1.+2.3", false},
+		{"empty core part", "1..3", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.version)
+			if (err == nil) != tt.valid {
+				t.Errorf("Parse(%q) error = %v, want valid = %v", tt.version, err, tt.valid)
+			}
+			if Valid(tt.version) != tt.valid {
+				t.Errorf("Valid(%q) = %v, want %v", tt.version, !tt.valid, tt.valid)
+			}
+		})
+	}
+}
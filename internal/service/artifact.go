@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"docufiller-update-server/internal/models"
+)
+
+// Platform 客户端平台（os/arch，可选 variant）
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// ParsePlatform 解析平台标识，格式为 os-arch 或 os-arch-variant（如 linux-arm64、windows-amd64-portable）
+func ParsePlatform(key string) (Platform, error) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(key)), "-", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os-arch[-variant]", key)
+	}
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// IsZero 是否未指定平台
+func (p Platform) IsZero() bool {
+	return p.OS == "" && p.Arch == ""
+}
+
+// String 返回 os-arch[-variant] 形式的平台标识
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "-" + p.Arch + "-" + p.Variant
+	}
+	return p.OS + "-" + p.Arch
+}
+
+// SelectArtifact 为平台选择版本制品
+//
+// 优先精确匹配 variant；未指定 variant 时优先选择无 variant 的制品，其次任意同 os/arch 制品。
+func SelectArtifact(v *models.Version, p Platform) (*models.VersionArtifact, bool) {
+	var fallback *models.VersionArtifact
+	for i := range v.Artifacts {
+		a := &v.Artifacts[i]
+		if a.OS != p.OS || a.Arch != p.Arch {
+			continue
+		}
+		if a.Variant == p.Variant {
+			return a, true
+		}
+		if p.Variant == "" && fallback == nil {
+			fallback = a
+		}
+	}
+	return fallback, fallback != nil
+}
+
+// SupportsPlatform 检查版本是否可用于指定平台（无多平台制品的版本视为通用包）
+func SupportsPlatform(v *models.Version, p Platform) bool {
+	if p.IsZero() || len(v.Artifacts) == 0 {
+		return true
+	}
+	_, ok := SelectArtifact(v, p)
+	return ok
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/semver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidVersion 版本号不是合法的语义化版本
+var ErrInvalidVersion = errors.New("invalid version")
+
+// GetChannelPolicy 获取通道升级策略，未配置时返回空策略
+func (s *VersionService) GetChannelPolicy(programID, channel string) (*models.ChannelPolicy, error) {
+	policy := models.ChannelPolicy{ProgramID: programID, Channel: channel}
+	err := s.db.Where("program_id = ? AND channel = ?", programID, channel).First(&policy).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetMinSupportedVersion 设置通道的最低支持版本，低于该版本的客户端检查更新时会被要求强制更新
+//
+// version 为空表示取消限制。
+func (s *VersionService) SetMinSupportedVersion(programID, channel, version string) (*models.ChannelPolicy, error) {
+	if version != "" && !semver.Valid(version) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidVersion, version)
+	}
+
+	policy := models.ChannelPolicy{ProgramID: programID, Channel: channel, MinSupportedVersion: version}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "program_id"}, {Name: "channel"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min_supported_version", "updated_at"}),
+	}).Create(&policy).Error
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChannelPolicy(programID, channel)
+}
+
+// BelowMinSupported 判断客户端当前版本是否低于通道的最低支持版本
+func BelowMinSupported(policy *models.ChannelPolicy, current string) bool {
+	return policy != nil && policy.MinSupportedVersion != "" && current != "" &&
+		semver.Compare(current, policy.MinSupportedVersion) < 0
+}
@@ -22,6 +22,8 @@ type ClientPackagerConfig struct {
 	ProgramID   string
 	Token       string
 	EncryptionKey string
+	SigningPublicKey string // 发布签名公钥，写入更新客户端配置用于校验
+	PreviousEncryptionKeys []string // 宽限期内的旧密钥，用于解密轮换前加密的包
 }
 
 // ClientPackagerResult 客户端打包结果
@@ -54,10 +56,10 @@ func (p *ClientPackager) GeneratePublishClient(programID, outputDir string) (*Cl
 		return nil, fmt.Errorf("获取程序信息失败: %v", err)
 	}
 
-	// 获取程序的 Token 和密钥
-	uploadToken, err := p.programService.tokenService.GetToken(programID, "upload", "system")
+	// 获取程序的 Token 和密钥（Token 只存储哈希，每个客户端包签发新的 Token，之前的客户端包的 Token 进入轮换宽限期）
+	_, uploadToken, err := p.programService.tokenService.RotatePackagerToken(programID, "upload")
 	if err != nil {
-		return nil, fmt.Errorf("获取上传 Token 失败: %v", err)
+		return nil, fmt.Errorf("生成上传 Token 失败: %v", err)
 	}
 
 	// 获取加密密钥 - 修复：从 encryption_keys 表获取
@@ -75,7 +77,7 @@ func (p *ClientPackager) GeneratePublishClient(programID, outputDir string) (*Cl
 	clientPkgConfig := ClientPackagerConfig{
 		ServerURL:      serverURL,
 		ProgramID:      programID,
-		Token:          uploadToken.TokenValue,
+		Token:          uploadToken,
 		EncryptionKey:  encryptionKey,  // 修复：使用从数据库获取的密钥
 	}
 
@@ -150,10 +152,10 @@ func (p *ClientPackager) GenerateUpdateClient(programID, outputDir string) (*Cli
 		return nil, fmt.Errorf("获取程序信息失败: %v", err)
 	}
 
-	// 获取程序的 Token 和密钥
-	downloadToken, err := p.programService.tokenService.GetToken(programID, "download", "system")
+	// 获取程序的 Token 和密钥（Token 只存储哈希，每个客户端包签发新的 Token，之前的客户端包的 Token 进入轮换宽限期）
+	_, downloadToken, err := p.programService.tokenService.RotatePackagerToken(programID, "download")
 	if err != nil {
-		return nil, fmt.Errorf("获取下载 Token 失败: %v", err)
+		return nil, fmt.Errorf("生成下载 Token 失败: %v", err)
 	}
 
 	// 获取加密密钥 - 修复：从 encryption_keys 表获取
@@ -162,6 +164,11 @@ func (p *ClientPackager) GenerateUpdateClient(programID, outputDir string) (*Cli
 		return nil, fmt.Errorf("获取加密密钥失败: %v", err)
 	}
 
+	previousKeys, err := p.programService.RetiringEncryptionKeys(programID)
+	if err != nil {
+		return nil, fmt.Errorf("获取旧加密密钥失败: %v", err)
+	}
+
 	// 打包配置 - 修复：从配置读取 serverUrl
 	serverURL := p.config.ServerURL
 	if serverURL == "" {
@@ -171,8 +178,10 @@ func (p *ClientPackager) GenerateUpdateClient(programID, outputDir string) (*Cli
 	clientPkgConfig := ClientPackagerConfig{
 		ServerURL:      serverURL,
 		ProgramID:      programID,
-		Token:          downloadToken.TokenValue,
+		Token:          downloadToken,
 		EncryptionKey:  encryptionKey,  // 修复：使用从数据库获取的密钥
+		SigningPublicKey: program.SigningPublicKey,
+		PreviousEncryptionKeys: previousKeys,
 	}
 
 	// 创建临时目录
@@ -243,6 +252,13 @@ func (p *ClientPackager) GenerateUpdateClient(programID, outputDir string) (*Cli
 
 // generateConfigFile 生成配置文件
 func generateConfigFile(config ClientPackagerConfig) []byte {
+	previousKeys := ""
+	if len(config.PreviousEncryptionKeys) > 0 {
+		previousKeys = "  encryption_keys:\n"
+		for _, k := range config.PreviousEncryptionKeys {
+			previousKeys += fmt.Sprintf("    - \"%s\"\n", k)
+		}
+	}
 	content := fmt.Sprintf(`# Update Server Configuration
 
 server:
@@ -255,11 +271,12 @@ program:
 auth:
   token: "%s"
   encryption_key: "%s"
+%s  signing_public_key: "%s"
 
 logging:
   level: info
   file: "client.log"
-`, config.ServerURL, config.ProgramID, config.Token, config.EncryptionKey)
+`, config.ServerURL, config.ProgramID, config.Token, config.EncryptionKey, previousKeys, config.SigningPublicKey)
 	return []byte(content)
 }
 
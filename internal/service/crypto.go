@@ -4,22 +4,29 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 
-	"golang.org/x/crypto/hkdf"
+	"docufiller-update-server/internal/models"
 )
 
 type CryptoService struct {
 	masterKey []byte
+	secrets   *SecretBox
+	keys      ProgramKeyStore
+}
+
+// ProgramKeyStore 按密钥 ID 查找程序加密密钥（由 ProgramService 实现）
+type ProgramKeyStore interface {
+	UsableEncryptionKey(programID, keyID string) (*models.EncryptionKey, error)
 }
 
 type EncryptedData struct {
 	Encrypted  bool   `json:"encrypted"`
 	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"keyId,omitempty"` // 程序加密密钥 ID，为空表示使用 masterKey 派生的密钥
 	IV         string `json:"iv"`
 	Ciphertext string `json:"ciphertext"`
 	Tag        string `json:"tag"`
@@ -28,27 +35,48 @@ type EncryptedData struct {
 func NewCryptoService(masterKey string) *CryptoService {
 	return &CryptoService{
 		masterKey: []byte(masterKey),
+		secrets:   NewSecretBox(masterKey),
 	}
 }
 
-// DeriveKey 使用 HKDF 从 masterKey 派生程序专用密钥
-func (s *CryptoService) DeriveKey(programID string) ([]byte, error) {
-	salt := []byte("update-server-salt-" + programID)
-	hash := sha256.New
+// SecretBox 返回用同一 masterKey 加解密存储密钥的 SecretBox，供其他服务共用
+func (s *CryptoService) SecretBox() *SecretBox {
+	return s.secrets
+}
 
-	hkdf := hkdf.New(hash, s.masterKey, salt, nil)
+// SetKeyStore 设置程序密钥查找，携带 keyId 的载荷使用对应的程序加密密钥
+func (s *CryptoService) SetKeyStore(keys ProgramKeyStore) {
+	s.keys = keys
+}
 
-	key := make([]byte, 32) // AES-256
-	if _, err := io.ReadFull(hkdf, key); err != nil {
+// payloadKey 载荷使用的密钥：keyID 为空时从 masterKey 派生，否则为仍可使用的程序密钥
+func (s *CryptoService) payloadKey(programID, keyID string) ([]byte, error) {
+	if keyID == "" {
+		return s.DeriveKey(programID)
+	}
+	if s.keys == nil {
+		return nil, ErrKeyNotUsable
+	}
+	record, err := s.keys.UsableEncryptionKey(programID, keyID)
+	if err != nil {
 		return nil, err
 	}
+	return s.secrets.OpenKey(record)
+}
 
-	return key, nil
+// DeriveKey 使用 HKDF 从 masterKey 派生程序专用密钥
+func (s *CryptoService) DeriveKey(programID string) ([]byte, error) {
+	return deriveKey(s.masterKey, programID, "")
 }
 
 // Encrypt 加密数据
 func (s *CryptoService) Encrypt(plaintext []byte, programID string) (*EncryptedData, error) {
-	key, err := s.DeriveKey(programID)
+	return s.EncryptWithKey(plaintext, programID, "")
+}
+
+// EncryptWithKey 使用指定的程序密钥加密数据，keyID 为空时与 Encrypt 相同
+func (s *CryptoService) EncryptWithKey(plaintext []byte, programID, keyID string) (*EncryptedData, error) {
+	key, err := s.payloadKey(programID, keyID)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +105,7 @@ func (s *CryptoService) Encrypt(plaintext []byte, programID string) (*EncryptedD
 	return &EncryptedData{
 		Encrypted:  true,
 		Algorithm:  "AES-256-GCM",
+		KeyID:      keyID,
 		IV:         iv,
 		Ciphertext: base64.StdEncoding.EncodeToString(tagAndCiphertext),
 	}, nil
@@ -88,7 +117,7 @@ func (s *CryptoService) Decrypt(data *EncryptedData, programID string) ([]byte,
 		return nil, errors.New("invalid encrypted data format")
 	}
 
-	key, err := s.DeriveKey(programID)
+	key, err := s.payloadKey(programID, data.KeyID)
 	if err != nil {
 		return nil, err
 	}
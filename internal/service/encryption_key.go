@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
+	"gorm.io/gorm"
+)
+
+// 加密密钥状态
+const (
+	KeyStatusActive   = "active"   // 当前密钥：新上传的包和下发的配置使用它
+	KeyStatusRetiring = "retiring" // 已被替换：宽限期内仍可解密，并用于向旧客户端下发新密钥
+	KeyStatusRetired  = "retired"  // 宽限期已过：不再接受
+)
+
+// DefaultKeyGracePeriod 密钥轮换后旧密钥的默认宽限期
+const DefaultKeyGracePeriod = 30 * 24 * time.Hour
+
+// ErrKeyNotUsable 密钥不存在或已退役
+var ErrKeyNotUsable = errors.New("encryption key is not usable")
+
+// KeyUpdate 用客户端已有的旧密钥包装的当前密钥，随更新检查下发
+type KeyUpdate struct {
+	KeyID       string `json:"keyId"`
+	WrappedKey  string `json:"wrappedKey"`  // pkgcrypt.WrapKey 的结果
+	WrappedWith string `json:"wrappedWith"` // 包装所用的旧密钥 ID
+}
+
+// newKeyRecord 生成随机密钥，返回 active 状态的密钥记录（KeyData 已包装）和密钥原文
+func newKeyRecord(box *SecretBox, programID string) (*models.EncryptionKey, []byte, error) {
+	raw, err := generateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyData, err := box.SealKey(programID, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.EncryptionKey{
+		ProgramID: programID,
+		KeyID:     pkgcrypt.KeyID(raw),
+		KeyData:   keyData,
+		Status:    KeyStatusActive,
+	}, raw, nil
+}
+
+// keyUsable 密钥当前是否可用于解密（active，或宽限期内的 retiring）
+func keyUsable(k *models.EncryptionKey, now time.Time) bool {
+	switch k.Status {
+	case KeyStatusActive:
+		return true
+	case KeyStatusRetiring:
+		return k.RetiresAt == nil || now.Before(*k.RetiresAt)
+	}
+	return false
+}
+
+// effectiveKeyStatus 宽限期已过的 retiring 密钥按 retired 处理
+func effectiveKeyStatus(k *models.EncryptionKey, now time.Time) string {
+	if k.Status == KeyStatusRetiring && !keyUsable(k, now) {
+		return KeyStatusRetired
+	}
+	return k.Status
+}
+
+// activeEncryptionKey 获取程序当前的 active 密钥
+func activeEncryptionKey(db *gorm.DB, programID string) (*models.EncryptionKey, error) {
+	var k models.EncryptionKey
+	err := db.Where("program_id = ? AND status = ?", programID, KeyStatusActive).
+		Order("id DESC").First(&k).Error
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// usableEncryptionKey 按密钥 ID 获取仍可使用的密钥
+func usableEncryptionKey(db *gorm.DB, programID, keyID string) (*models.EncryptionKey, error) {
+	var k models.EncryptionKey
+	err := db.Where("program_id = ? AND key_id = ?", programID, keyID).First(&k).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeyNotUsable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !keyUsable(&k, time.Now()) {
+		return nil, ErrKeyNotUsable
+	}
+	return &k, nil
+}
+
+// ActiveEncryptionKey 获取程序当前的 active 密钥
+func (s *ProgramService) ActiveEncryptionKey(programID string) (*models.EncryptionKey, error) {
+	return activeEncryptionKey(s.db, programID)
+}
+
+// UsableEncryptionKey 按密钥 ID 获取仍可使用的密钥（active 或宽限期内的 retiring）
+func (s *ProgramService) UsableEncryptionKey(programID, keyID string) (*models.EncryptionKey, error) {
+	return usableEncryptionKey(s.db, programID, keyID)
+}
+
+// ListEncryptionKeys 列出程序的密钥历史（新的在前），状态按宽限期折算
+func (s *ProgramService) ListEncryptionKeys(programID string) ([]models.EncryptionKey, error) {
+	var keys []models.EncryptionKey
+	if err := s.db.Where("program_id = ?", programID).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range keys {
+		keys[i].Status = effectiveKeyStatus(&keys[i], now)
+	}
+	return keys, nil
+}
+
+// RetiringEncryptionKeys 宽限期内的旧密钥（base64），写入新下发的客户端配置
+func (s *ProgramService) RetiringEncryptionKeys(programID string) ([]string, error) {
+	var keys []models.EncryptionKey
+	if err := s.db.Where("program_id = ? AND status = ?", programID, KeyStatusRetiring).
+		Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var result []string
+	for i := range keys {
+		if !keyUsable(&keys[i], now) {
+			continue
+		}
+		key, err := s.secrets.OpenKey(&keys[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, encodeKey(key))
+	}
+	return result, nil
+}
+
+// RotateEncryptionKey 生成新的 active 密钥，原密钥转为 retiring，宽限期 grace 后退役
+//
+// 宽限期内旧密钥加密的包仍可解密，持有旧密钥的客户端在更新检查时获得用旧密钥包装的新密钥。
+// 宽限期已过的 retiring 密钥在轮换时标记为 retired。返回新密钥记录及其 base64 原文。
+func (s *ProgramService) RotateEncryptionKey(programID string, grace time.Duration) (*models.EncryptionKey, string, error) {
+	if grace <= 0 {
+		grace = DefaultKeyGracePeriod
+	}
+	record, key, err := newKeyRecord(s.secrets, programID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	retiresAt := now.Add(grace)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.EncryptionKey{}).
+			Where("program_id = ? AND status = ? AND retires_at <= ?", programID, KeyStatusRetiring, now).
+			Update("status", KeyStatusRetired).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.EncryptionKey{}).
+			Where("program_id = ? AND status = ?", programID, KeyStatusActive).
+			Updates(map[string]interface{}{"status": KeyStatusRetiring, "retires_at": retiresAt}).Error; err != nil {
+			return err
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return record, encodeKey(key), nil
+}
+
+// EncryptionKeyUpdate 客户端持有的密钥不是当前密钥时，返回用该密钥包装的当前密钥
+//
+// 客户端密钥未知或已退役时返回 nil（无法安全下发，需重新获取客户端配置）。
+func (s *VersionService) EncryptionKeyUpdate(programID, clientKeyID string) (*KeyUpdate, error) {
+	active, err := activeEncryptionKey(s.db, programID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil || active.KeyID == clientKeyID {
+		return nil, err
+	}
+	old, err := usableEncryptionKey(s.db, programID, clientKeyID)
+	if errors.Is(err, ErrKeyNotUsable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kek, err := s.secrets.OpenKey(old)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.secrets.OpenKey(active)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := pkgcrypt.WrapKey(kek, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+	return &KeyUpdate{KeyID: active.KeyID, WrappedKey: wrapped, WrappedWith: old.KeyID}, nil
+}
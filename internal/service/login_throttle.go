@@ -0,0 +1,116 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// 登录限流参数：超过免费失败次数后按指数增长锁定
+const (
+	loginUserFreeFailures = 5                // 同一用户名允许的连续失败次数
+	loginIPFreeFailures   = 20               // 同一 IP 允许的连续失败次数（NAT 后可能有多个用户）
+	loginLockoutBase      = time.Minute      // 第一次锁定时长，之后每次失败翻倍
+	loginLockoutMax       = time.Hour        // 最长锁定时长
+	loginFailureWindow    = 15 * time.Minute // 超过此时间没有失败（且未锁定）后计数清零
+)
+
+const (
+	loginKindIP   = "ip"
+	loginKindUser = "user"
+)
+
+var ErrLoginLocked = errors.New("too many failed login attempts")
+
+// LoginThrottle 按 IP 和用户名统计登录失败，超过阈值后锁定
+type LoginThrottle struct {
+	db *gorm.DB
+}
+
+func NewLoginThrottle(db *gorm.DB) *LoginThrottle {
+	return &LoginThrottle{db: db}
+}
+
+// Check IP 或用户名处于锁定期时返回 ErrLoginLocked 和剩余锁定时间
+func (t *LoginThrottle) Check(ip, username string) (time.Duration, error) {
+	var attempts []models.LoginAttempt
+	err := t.db.Where("(kind = ? AND subject = ?) OR (kind = ? AND subject = ?)",
+		loginKindIP, ip, loginKindUser, username).Find(&attempts).Error
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, a := range attempts {
+		if a.LockedUntil != nil && a.LockedUntil.After(now) {
+			if d := a.LockedUntil.Sub(now); d > retryAfter {
+				retryAfter = d
+			}
+		}
+	}
+	if retryAfter > 0 {
+		return retryAfter, ErrLoginLocked
+	}
+	return 0, nil
+}
+
+// RecordFailure 记录一次登录失败（密码或两步验证码错误）
+func (t *LoginThrottle) RecordFailure(ip, username string) error {
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		if err := recordLoginFailure(tx, loginKindIP, ip, loginIPFreeFailures); err != nil {
+			return err
+		}
+		return recordLoginFailure(tx, loginKindUser, username, loginUserFreeFailures)
+	})
+}
+
+// RecordSuccess 登录成功后清除用户名的失败计数
+//
+// IP 计数不清除：否则攻击者可以用自己的账号登录来重置对其他账号的猜测次数。
+func (t *LoginThrottle) RecordSuccess(username string) error {
+	return t.db.Where("kind = ? AND subject = ?", loginKindUser, username).Delete(&models.LoginAttempt{}).Error
+}
+
+// Cleanup 删除已过期的失败记录
+func (t *LoginThrottle) Cleanup() (int64, error) {
+	cutoff := time.Now().Add(-loginFailureWindow)
+	result := t.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", cutoff, cutoff).
+		Delete(&models.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+func recordLoginFailure(tx *gorm.DB, kind, subject string, freeFailures int) error {
+	if subject == "" {
+		return nil
+	}
+	attempt := models.LoginAttempt{Kind: kind, Subject: subject}
+	if err := tx.Where("kind = ? AND subject = ?", kind, subject).FirstOrInit(&attempt).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	lastActive := attempt.LastFailureAt
+	if attempt.LockedUntil != nil && attempt.LockedUntil.After(lastActive) {
+		lastActive = *attempt.LockedUntil
+	}
+	if now.Sub(lastActive) > loginFailureWindow {
+		attempt.Failures = 0
+		attempt.LockedUntil = nil
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	if over := attempt.Failures - freeFailures; over > 0 {
+		lockout := loginLockoutMax
+		if over <= 10 {
+			if d := loginLockoutBase << (over - 1); d < loginLockoutMax {
+				lockout = d
+			}
+		}
+		until := now.Add(lockout)
+		attempt.LockedUntil = &until
+	}
+	return tx.Save(&attempt).Error
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrEncryptionKeyMissing 程序没有可用的加密密钥
+var ErrEncryptionKeyMissing = errors.New("program has no encryption key")
+
+// PackageEncryptionKey 获取程序的包加密密钥，程序未开启加密存储时返回 nil
+func (s *VersionService) PackageEncryptionKey(programID string) ([]byte, error) {
+	var program models.Program
+	err := s.db.Select("encrypt_packages").Where("program_id = ?", programID).First(&program).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !program.EncryptPackages) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadPackageKey(s.db, s.secrets, programID)
+}
+
+// loadPackageKey 从 encryption_keys 表读取并解开程序当前的 active 密钥
+func loadPackageKey(db *gorm.DB, box *SecretBox, programID string) ([]byte, error) {
+	record, err := activeEncryptionKey(db, programID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEncryptionKeyMissing
+		}
+		return nil, err
+	}
+	key, err := box.OpenKey(record)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEncryptionKeyMissing, err)
+	}
+	return key, nil
+}
+
+// SetPackageEncryption 开启或关闭程序的加密存储，只影响之后上传的版本
+func (s *ProgramService) SetPackageEncryption(programID string, enabled bool) error {
+	if enabled {
+		if _, err := loadPackageKey(s.db, s.secrets, programID); err != nil {
+			return err
+		}
+	}
+	result := s.db.Model(&models.Program{}).
+		Where("program_id = ?", programID).
+		Update("encrypt_packages", enabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"docufiller-update-server/internal/delta"
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/semver"
+)
+
+// DefaultPatchBaseCount 上传新版本时为之前多少个版本生成增量补丁
+const DefaultPatchBaseCount = 3
+
+// patchJobs 后台补丁生成任务：同一时间只生成一个版本的补丁，避免多个上传同时占用大量磁盘和 CPU
+var patchJobs struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// schedulePatches 在后台为新发布的版本生成增量补丁，失败只记录日志
+func (s *VersionService) schedulePatches(v models.Version) {
+	patchJobs.wg.Add(1)
+	go func() {
+		defer patchJobs.wg.Done()
+		patchJobs.mu.Lock()
+		defer patchJobs.mu.Unlock()
+
+		if patches, err := s.GeneratePatches(&v, DefaultPatchBaseCount); err != nil {
+			logger.Warnf("Failed to generate patches for %s/%s/%s: %v", v.ProgramID, v.Channel, v.Version, err)
+		} else if len(patches) > 0 {
+			logger.Infof("Generated %d patches for %s/%s/%s", len(patches), v.ProgramID, v.Channel, v.Version)
+		}
+	}()
+}
+
+// WaitPatchJobs 等待已安排的后台补丁生成完成
+func WaitPatchJobs() {
+	patchJobs.wg.Wait()
+}
+
+// GeneratePatches 为新版本生成来自之前 baseCount 个版本的增量补丁
+//
+// 只使用同一通道中已发布、未撤回且版本号更低的版本作为基础；主文件和各平台制品分别生成补丁。
+// 包不是 zip 或补丁不比完整包小时跳过；单个补丁失败只记录日志，不影响版本发布。
+// 加密存储的版本既不生成补丁也不作为基础版本，补丁会以明文暴露包内容。
+func (s *VersionService) GeneratePatches(v *models.Version, baseCount int) ([]models.VersionPatch, error) {
+	if baseCount <= 0 || v.Encrypted {
+		return nil, nil
+	}
+
+	var candidates []models.Version
+	if err := s.db.Preload("Artifacts").Scopes(publishedScope).
+		Where("program_id = ? AND channel = ? AND yanked = ? AND id <> ?", v.ProgramID, v.Channel, false, v.ID).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	SortVersionsDesc(candidates)
+
+	var bases []models.Version
+	for _, c := range candidates {
+		if !c.Encrypted && semver.Compare(c.Version, v.Version) < 0 {
+			bases = append(bases, c)
+		}
+		if len(bases) == baseCount {
+			break
+		}
+	}
+
+	var patches []models.VersionPatch
+	for i := range bases {
+		base := &bases[i]
+
+		if base.FileHash != v.FileHash {
+			p, err := s.createPatch(v, base.Version, "", s.storageSvc.GetVersionFilePath(base), s.storageSvc.GetVersionFilePath(v), v.FileSize)
+			if err != nil {
+				logger.Warnf("Failed to generate patch %s -> %s: %v", base.Version, v.Version, err)
+			} else if p != nil {
+				patches = append(patches, *p)
+			}
+		}
+
+		for j := range v.Artifacts {
+			a := &v.Artifacts[j]
+			old, ok := findArtifact(base, a.OS, a.Arch, a.Variant)
+			if !ok || old.FileHash == a.FileHash {
+				continue
+			}
+			platform := Platform{OS: a.OS, Arch: a.Arch, Variant: a.Variant}.String()
+			p, err := s.createPatch(v, base.Version, platform, s.storageSvc.GetArtifactFilePath(old), s.storageSvc.GetArtifactFilePath(a), a.FileSize)
+			if err != nil {
+				logger.Warnf("Failed to generate patch %s -> %s (%s): %v", base.Version, v.Version, platform, err)
+			} else if p != nil {
+				patches = append(patches, *p)
+			}
+		}
+	}
+
+	return patches, nil
+}
+
+// createPatch 生成单个补丁文件并保存记录，补丁不适用时返回 nil
+func (s *VersionService) createPatch(v *models.Version, fromVersion, platform, oldPath, newPath string, newSize int64) (*models.VersionPatch, error) {
+	dir := s.storageSvc.PatchDir(v.ProgramID, v.Channel, v.Version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fileName := fmt.Sprintf("%s-%s-to-%s.patch", v.ProgramID, fromVersion, v.Version)
+	if platform != "" {
+		fileName = fmt.Sprintf("%s-%s-to-%s-%s.patch", v.ProgramID, fromVersion, v.Version, platform)
+	}
+	filePath := filepath.Join(dir, fileName)
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	err = delta.Diff(oldPath, newPath, io.MultiWriter(f, hash, counter))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath)
+		if errors.Is(err, delta.ErrNotZip) {
+			logger.Debugf("Skip patch %s -> %s: %v", fromVersion, v.Version, err)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if counter.n >= newSize {
+		os.Remove(filePath)
+		logger.Debugf("Skip patch %s -> %s: not smaller than full package", fromVersion, v.Version)
+		return nil, nil
+	}
+
+	p := &models.VersionPatch{
+		VersionID:   v.ID,
+		FromVersion: fromVersion,
+		Platform:    platform,
+		FileName:    fileName,
+		FilePath:    dir,
+		FileSize:    counter.n,
+		FileHash:    hex.EncodeToString(hash.Sum(nil)),
+	}
+	if err := s.db.Create(p).Error; err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+
+	logger.Infof("Patch generated: %s, size: %d (full: %d)", filePath, p.FileSize, newSize)
+	return p, nil
+}
+
+// ListPatches 列出版本指定平台的增量补丁（platform 为空表示主文件）
+func (s *VersionService) ListPatches(versionID uint, platform string) ([]models.VersionPatch, error) {
+	var patches []models.VersionPatch
+	err := s.db.Where("version_id = ? AND platform = ?", versionID, platform).
+		Order("file_size ASC").Find(&patches).Error
+	return patches, err
+}
+
+// GetPatch 获取从指定版本出发的增量补丁
+func (s *VersionService) GetPatch(versionID uint, fromVersion, platform string) (*models.VersionPatch, error) {
+	var p models.VersionPatch
+	err := s.db.Where("version_id = ? AND from_version = ? AND platform = ?", versionID, fromVersion, platform).First(&p).Error
+	return &p, err
+}
+
+// findArtifact 按平台精确查找版本的制品
+func findArtifact(v *models.Version, goos, arch, variant string) (*models.VersionArtifact, bool) {
+	for i := range v.Artifacts {
+		a := &v.Artifacts[i]
+		if a.OS == goos && a.Arch == arch && a.Variant == variant {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -3,15 +3,20 @@ package service
 import (
 	"crypto/rand"
 	"docufiller-update-server/internal/models"
-	"encoding/base64"
+	"docufiller-update-server/internal/signing"
 	"errors"
+	"strings"
 
 	"gorm.io/gorm"
 )
 
+// ErrProgramNameRequired 程序名称不能为空
+var ErrProgramNameRequired = errors.New("program name is required")
+
 type ProgramService struct {
 	db           *gorm.DB
 	tokenService *TokenService
+	secrets      *SecretBox // 包装和解开数据库中的程序密钥
 }
 
 func NewProgramService(db *gorm.DB) *ProgramService {
@@ -21,6 +26,12 @@ func NewProgramService(db *gorm.DB) *ProgramService {
 	}
 }
 
+// SetSecretBox 设置包装存储密钥所用的 SecretBox（见 CryptoService.SecretBox）
+func (s *ProgramService) SetSecretBox(box *SecretBox) {
+	s.secrets = box
+	s.tokenService.SetSecretBox(box)
+}
+
 // CreateProgram 创建程序
 func (s *ProgramService) CreateProgram(program *models.Program) error {
 	return s.db.Create(program).Error
@@ -61,6 +72,41 @@ func (s *ProgramService) UpdateProgram(program *models.Program) error {
 	return s.db.Save(program).Error
 }
 
+// UpdateProgramRequest 修改程序信息，为 nil 的字段保持不变
+type UpdateProgramRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IconURL     *string `json:"iconUrl"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+// UpdateProgramInfo 修改程序的名称、描述、图标和启用状态
+func (s *ProgramService) UpdateProgramInfo(programID string, req UpdateProgramRequest) (*models.Program, error) {
+	program, err := s.GetByProgramID(programID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, ErrProgramNameRequired
+		}
+		program.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		program.Description = *req.Description
+	}
+	if req.IconURL != nil {
+		program.IconURL = *req.IconURL
+	}
+	if req.IsActive != nil {
+		program.IsActive = *req.IsActive
+	}
+	if err := s.db.Save(program).Error; err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
 // DeleteProgram 删除程序（软删除）
 func (s *ProgramService) DeleteProgram(programID string) error {
 	return s.db.Where("program_id = ?", programID).Delete(&models.Program{}).Error
@@ -85,18 +131,14 @@ func (s *ProgramService) CreateProgramWithOptions(req CreateProgramRequest) (*Cr
 		response.Program = program
 
 		// 生成加密密钥
-		encryptionKey, err := s.GenerateEncryptionKey()
+		keyRecord, key, err := newKeyRecord(s.secrets, program.ProgramID)
 		if err != nil {
 			return err
 		}
-		keyRecord := &models.EncryptionKey{
-			ProgramID: program.ProgramID,
-			KeyData:   encryptionKey,
-		}
 		if err := tx.Create(keyRecord).Error; err != nil {
 			return err
 		}
-		response.EncryptionKey = encryptionKey
+		response.EncryptionKey = encodeKey(key)
 
 		// 生成上传Token（传递事务上下文）
 		_, uploadToken, err := s.tokenService.GenerateTokenWithDB(tx, program.ProgramID, "upload", "system")
@@ -124,38 +166,58 @@ func (s *ProgramService) CreateProgramWithOptions(req CreateProgramRequest) (*Cr
 
 // GenerateEncryptionKey 生成32字节随机密钥
 func (s *ProgramService) GenerateEncryptionKey() (string, error) {
+	key, err := generateKey()
+	if err != nil {
+		return "", err
+	}
+	return encodeKey(key), nil
+}
+
+// generateKey 生成32字节随机密钥
+func generateKey() ([]byte, error) {
 	key := make([]byte, 32)
 	if _, err := rand.Read(key); err != nil {
-		return "", err
+		return nil, err
 	}
-	return base64.StdEncoding.EncodeToString(key), nil
+	return key, nil
 }
 
-// GetProgramEncryptionKey 获取程序的加密密钥
+// GetProgramEncryptionKey 获取程序当前的加密密钥
 func (s *ProgramService) GetProgramEncryptionKey(programID string) (string, error) {
-	var key models.EncryptionKey
-	err := s.db.Where("program_id = ?", programID).First(&key).Error
+	record, err := activeEncryptionKey(s.db, programID)
 	if err != nil {
 		return "", err
 	}
-	return key.KeyData, nil
-}
-
-// RegenerateEncryptionKey 重新生成加密密钥
-func (s *ProgramService) RegenerateEncryptionKey(programID string) (string, error) {
-	newKey, err := s.GenerateEncryptionKey()
+	key, err := s.secrets.OpenKey(record)
 	if err != nil {
 		return "", err
 	}
+	return encodeKey(key), nil
+}
 
-	err = s.db.Model(&models.EncryptionKey{}).
+// RegenerateEncryptionKey 重新生成加密密钥（以默认宽限期轮换，见 RotateEncryptionKey）
+func (s *ProgramService) RegenerateEncryptionKey(programID string) (string, error) {
+	_, key, err := s.RotateEncryptionKey(programID, DefaultKeyGracePeriod)
+	return key, err
+}
+
+// SetSigningPublicKey 设置程序的签名公钥（空字符串表示关闭签名校验）
+func (s *ProgramService) SetSigningPublicKey(programID, publicKey string) error {
+	if publicKey != "" {
+		if _, err := signing.ParsePublicKey(publicKey); err != nil {
+			return err
+		}
+	}
+	result := s.db.Model(&models.Program{}).
 		Where("program_id = ?", programID).
-		Update("key_data", newKey).Error
-	if err != nil {
-		return "", err
+		Update("signing_public_key", publicKey)
+	if result.Error != nil {
+		return result.Error
 	}
-
-	return newKey, nil
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 type CreateProgramRequest struct {
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// 版本发布状态
+const (
+	VersionStatusDraft     = "draft"     // 草稿：仅上传 Token 和管理员 Token 可见，用于发布前验证
+	VersionStatusScheduled = "scheduled" // 定时：到达 PublishAt 后由调度器发布
+	VersionStatusPublished = "published" // 已发布：对公开接口可见
+)
+
+var (
+	// ErrInvalidStatus 不支持的发布状态或发布时间
+	ErrInvalidStatus = errors.New("invalid release status")
+	// ErrVersionNotPublished 版本尚未发布
+	ErrVersionNotPublished = errors.New("version is not published")
+)
+
+// IsPublished 版本是否已对公开接口可见（旧记录没有状态时视为已发布）
+func IsPublished(v *models.Version) bool {
+	return v.Status == "" || v.Status == VersionStatusPublished
+}
+
+// ResolveReleaseStatus 根据请求的状态和发布时间确定版本的初始状态
+//
+// 只给出发布时间时视为定时发布；定时发布的时间已过则直接发布；草稿不接受发布时间。
+func ResolveReleaseStatus(status string, publishAt *time.Time, now time.Time) (string, *time.Time, error) {
+	if status == "" {
+		status = VersionStatusPublished
+		if publishAt != nil {
+			status = VersionStatusScheduled
+		}
+	}
+
+	switch status {
+	case VersionStatusDraft:
+		if publishAt != nil {
+			return "", nil, fmt.Errorf("%w: drafts cannot have a publish time", ErrInvalidStatus)
+		}
+		return status, nil, nil
+	case VersionStatusScheduled:
+		if publishAt == nil {
+			return "", nil, fmt.Errorf("%w: scheduled releases need a publish time", ErrInvalidStatus)
+		}
+		if !publishAt.After(now) {
+			return VersionStatusPublished, nil, nil
+		}
+		return status, publishAt, nil
+	case VersionStatusPublished:
+		if publishAt != nil && publishAt.After(now) {
+			return "", nil, fmt.Errorf("%w: published releases cannot have a future publish time", ErrInvalidStatus)
+		}
+		return status, nil, nil
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrInvalidStatus, status)
+}
+
+// ParsePublishAt 解析 RFC 3339 格式的发布时间，空字符串返回 nil
+func ParsePublishAt(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: publishAt must be an RFC 3339 timestamp", ErrInvalidStatus)
+	}
+	return &t, nil
+}
+
+// SetReleaseStatus 修改版本的发布状态（草稿转定时或立即发布，也可撤回为草稿）
+func (s *VersionService) SetReleaseStatus(programID, channel, version, status string, publishAt *time.Time) (*models.Version, error) {
+	v, err := s.GetVersion(programID, channel, version)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	status, publishAt, err = ResolveReleaseStatus(status, publishAt, now)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"status":     status,
+		"publish_at": publishAt,
+	}
+	if status == VersionStatusPublished && !IsPublished(v) {
+		updates["publish_date"] = now
+	}
+	if err := s.db.Model(v).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return s.GetVersion(programID, channel, version)
+}
+
+// PublishDueVersions 发布所有到期的定时版本，返回本次发布的版本
+func (s *VersionService) PublishDueVersions(now time.Time) ([]models.Version, error) {
+	var due []models.Version
+	if err := s.db.Where("status = ? AND publish_at <= ?", VersionStatusScheduled, now).
+		Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	published := make([]models.Version, 0, len(due))
+	for i := range due {
+		v := &due[i]
+		// 条件更新，避免与手动修改状态的请求互相覆盖
+		result := s.db.Model(&models.Version{}).
+			Where("id = ? AND status = ?", v.ID, VersionStatusScheduled).
+			Updates(map[string]interface{}{
+				"status":       VersionStatusPublished,
+				"publish_date": *v.PublishAt,
+			})
+		if result.Error != nil {
+			return published, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		v.Status = VersionStatusPublished
+		v.PublishDate = *v.PublishAt
+		published = append(published, *v)
+	}
+	return published, nil
+}
+
+// ListPublished 获取已发布的版本列表（公开接口）
+func (s *VersionService) ListPublished(programID, channel string) ([]models.Version, error) {
+	var versions []models.Version
+	query := s.db.Scopes(publishedScope).Where("program_id = ?", programID)
+	if channel != "" {
+		query = query.Where("channel = ?", channel)
+	}
+	err := query.Preload("Artifacts").Order("publish_date DESC").Find(&versions).Error
+	return versions, err
+}
+
+// publishedScope 只查询已发布的版本
+func publishedScope(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ?", VersionStatusPublished)
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
+	"golang.org/x/crypto/hkdf"
+	"gorm.io/gorm"
+)
+
+// sealedKeyPrefix 已用 KEK 包装的 KeyData 前缀；没有前缀的是旧版明文（base64）密钥
+const sealedKeyPrefix = "sealed:v1:"
+
+// keyEncryptionInfo 派生 KEK 时的 HKDF info，与载荷加密（DeriveKey）的密钥区分
+const keyEncryptionInfo = "encryption-key-wrap"
+
+// ErrMasterKeyMissing 未配置主密钥，无法加解密存储的程序密钥
+var ErrMasterKeyMissing = errors.New("master key is not configured")
+
+// SecretBox 信封加密：程序密钥在数据库中以 masterKey 派生的 KEK 包装后存储
+//
+// KEK 按程序派生（与 DeriveKey 相同的 HKDF，使用不同的 info），包装时绑定密钥 ID，
+// 因此复制出的 versions.db 不含任何可直接使用的程序密钥。
+type SecretBox struct {
+	masterKey []byte
+}
+
+func NewSecretBox(masterKey string) *SecretBox {
+	return &SecretBox{masterKey: []byte(masterKey)}
+}
+
+// deriveKey 使用 HKDF 从 masterKey 派生程序专用的 32 字节密钥
+func deriveKey(masterKey []byte, programID, info string) ([]byte, error) {
+	salt := []byte("update-server-salt-" + programID)
+	var infoBytes []byte
+	if info != "" {
+		infoBytes = []byte(info)
+	}
+	key := make([]byte, 32) // AES-256
+	if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, salt, infoBytes), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// IsSealedKey KeyData 是否已用 KEK 包装
+func IsSealedKey(keyData string) bool {
+	return strings.HasPrefix(keyData, sealedKeyPrefix)
+}
+
+// SealKey 用程序的 KEK 包装密钥，结果写入 EncryptionKey.KeyData
+func (b *SecretBox) SealKey(programID string, key []byte) (string, error) {
+	if b == nil || len(b.masterKey) == 0 {
+		return "", ErrMasterKeyMissing
+	}
+	kek, err := deriveKey(b.masterKey, programID, keyEncryptionInfo)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := pkgcrypt.WrapKey(kek, key)
+	if err != nil {
+		return "", err
+	}
+	return sealedKeyPrefix + wrapped, nil
+}
+
+// OpenKey 解开密钥记录中的程序密钥；旧版明文记录直接解析，便于迁移前继续运行
+func (b *SecretBox) OpenKey(k *models.EncryptionKey) ([]byte, error) {
+	if !IsSealedKey(k.KeyData) {
+		return pkgcrypt.ParseKey(k.KeyData)
+	}
+	if b == nil || len(b.masterKey) == 0 {
+		return nil, ErrMasterKeyMissing
+	}
+	kek, err := deriveKey(b.masterKey, k.ProgramID, keyEncryptionInfo)
+	if err != nil {
+		return nil, err
+	}
+	key, err := pkgcrypt.UnwrapKey(kek, k.KeyID, strings.TrimPrefix(k.KeyData, sealedKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key %s of %s: %w", k.KeyID, k.ProgramID, err)
+	}
+	return key, nil
+}
+
+// SealValue 用程序的 KEK（info 区分用途）加密短秘密值，aad 绑定所属记录
+func (b *SecretBox) SealValue(programID, info, aad string, value []byte) (string, error) {
+	gcm, err := b.valueCipher(programID, info)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, value, []byte(aad))
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenValue 解密 SealValue 的结果
+func (b *SecretBox) OpenValue(programID, info, aad, sealed string) ([]byte, error) {
+	gcm, err := b.valueCipher(programID, info)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedKeyPrefix))
+	if err != nil || !IsSealedKey(sealed) || len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed sealed value", pkgcrypt.ErrInvalidFormat)
+	}
+	value, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("%w: sealed value", pkgcrypt.ErrAuthentication)
+	}
+	return value, nil
+}
+
+func (b *SecretBox) valueCipher(programID, info string) (cipher.AEAD, error) {
+	if b == nil || len(b.masterKey) == 0 {
+		return nil, ErrMasterKeyMissing
+	}
+	key, err := deriveKey(b.masterKey, programID, info)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SecretsMigrationResult 迁移或重新包装处理的记录数
+type SecretsMigrationResult struct {
+	KeysSealed      int   // 新包装的明文密钥
+	KeysRewrapped   int   // 从旧主密钥重新包装的密钥
+	TokensCleared   int64 // 清除明文值的 Token
+	TokensRewrapped int   // 重新加密的待下发替换 Token（见 RotateToken）
+	TOTPRewrapped   int   // 重新加密的管理员 TOTP 密钥
+}
+
+// CountPlaintextSecrets 统计仍以明文存储的程序密钥和 Token（迁移前的数据库）
+func CountPlaintextSecrets(db *gorm.DB) (keys, tokens int64, err error) {
+	if err = db.Unscoped().Model(&models.EncryptionKey{}).
+		Where("key_data NOT LIKE ?", sealedKeyPrefix+"%").Count(&keys).Error; err != nil {
+		return 0, 0, err
+	}
+	if err = db.Unscoped().Model(&models.Token{}).
+		Where("token_value IS NOT NULL AND token_value <> ''").Count(&tokens).Error; err != nil {
+		return 0, 0, err
+	}
+	return keys, tokens, nil
+}
+
+// MigrateSecrets 一次性迁移：包装所有明文程序密钥，并清除 Token 的明文值（只保留哈希 TokenID）
+//
+// 已包装的记录保持不变，重复执行是安全的。
+func MigrateSecrets(db *gorm.DB, box *SecretBox) (*SecretsMigrationResult, error) {
+	return RewrapSecrets(db, nil, box)
+}
+
+// RewrapSecrets 主密钥轮换：用 oldBox 解开所有程序密钥，再用 newBox 重新包装
+//
+// oldBox 为 nil 时只处理明文记录（即 MigrateSecrets）。明文 Token 同时被清除。
+// 全部在一个事务中完成，任一密钥无法解开时不做任何修改。
+func RewrapSecrets(db *gorm.DB, oldBox, newBox *SecretBox) (*SecretsMigrationResult, error) {
+	if newBox == nil || len(newBox.masterKey) == 0 {
+		return nil, ErrMasterKeyMissing
+	}
+	result := &SecretsMigrationResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var keys []models.EncryptionKey
+		if err := tx.Unscoped().Order("id").Find(&keys).Error; err != nil {
+			return err
+		}
+		for i := range keys {
+			k := &keys[i]
+			sealed := IsSealedKey(k.KeyData)
+			if sealed && oldBox == nil {
+				continue
+			}
+			raw, err := oldBox.OpenKey(k)
+			if err != nil {
+				return err
+			}
+			keyData, err := newBox.SealKey(k.ProgramID, raw)
+			if err != nil {
+				return err
+			}
+			updates := map[string]interface{}{"key_data": keyData}
+			if k.KeyID == "" {
+				updates["key_id"] = pkgcrypt.KeyID(raw)
+			}
+			if err := tx.Unscoped().Model(&models.EncryptionKey{}).Where("id = ?", k.ID).
+				Updates(updates).Error; err != nil {
+				return err
+			}
+			if sealed {
+				result.KeysRewrapped++
+			} else {
+				result.KeysSealed++
+			}
+		}
+
+		cleared := tx.Unscoped().Model(&models.Token{}).
+			Where("token_value IS NOT NULL AND token_value <> ''").
+			Update("token_value", "")
+		if cleared.Error != nil {
+			return cleared.Error
+		}
+		result.TokensCleared = cleared.RowsAffected
+
+		// 轮换中的旧 Token 保存着待下发的替换 Token，同样需要换用新主密钥
+		if oldBox == nil {
+			return nil
+		}
+		var rotating []models.Token
+		if err := tx.Unscoped().Where("replacement IS NOT NULL AND replacement <> ''").Find(&rotating).Error; err != nil {
+			return err
+		}
+		for i := range rotating {
+			t := &rotating[i]
+			value, err := oldBox.OpenValue(t.ProgramID, tokenReplacementInfo, t.TokenID, t.Replacement)
+			if err != nil {
+				return fmt.Errorf("failed to open replacement of token %s: %w", t.TokenID, err)
+			}
+			sealed, err := newBox.SealValue(t.ProgramID, tokenReplacementInfo, t.TokenID, value)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&models.Token{}).Where("id = ?", t.ID).
+				Update("replacement", sealed).Error; err != nil {
+				return err
+			}
+			result.TokensRewrapped++
+		}
+
+		// 管理员的 TOTP 密钥
+		var users []models.AdminUser
+		if err := tx.Where("totp_secret IS NOT NULL AND totp_secret <> ''").Find(&users).Error; err != nil {
+			return err
+		}
+		for i := range users {
+			u := &users[i]
+			value, err := oldBox.OpenValue("", totpSecretInfo, u.Username, u.TOTPSecret)
+			if err != nil {
+				return fmt.Errorf("failed to open TOTP secret of user %s: %w", u.Username, err)
+			}
+			sealed, err := newBox.SealValue("", totpSecretInfo, u.Username, value)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.AdminUser{}).Where("id = ?", u.ID).
+				Update("totp_secret", sealed).Error; err != nil {
+				return err
+			}
+			result.TOTPRewrapped++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// encodeKey 程序密钥的外部表示（base64），用于下发给管理员和客户端配置
+func encodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
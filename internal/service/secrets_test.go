@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSecretBox_SealAndOpen(t *testing.T) {
+	box := NewSecretBox("master-key-one")
+	key, _ := generateKey()
+
+	sealed, err := box.SealKey("prog-a", key)
+	if err != nil {
+		t.Fatalf("SealKey failed: %v", err)
+	}
+	if !IsSealedKey(sealed) || strings.Contains(sealed, base64.StdEncoding.EncodeToString(key)) {
+		t.Fatalf("Sealed key data leaks the key: %s", sealed)
+	}
+
+	record := &models.EncryptionKey{ProgramID: "prog-a", KeyID: pkgcrypt.KeyID(key), KeyData: sealed}
+	opened, err := box.OpenKey(record)
+	if err != nil || string(opened) != string(key) {
+		t.Fatalf("OpenKey failed: %v", err)
+	}
+
+	// 其他主密钥、其他程序、缺少主密钥都无法解开
+	if _, err := NewSecretBox("master-key-two").OpenKey(record); err == nil {
+		t.Error("Expected open with another master key to fail")
+	}
+	moved := *record
+	moved.ProgramID = "prog-b"
+	if _, err := box.OpenKey(&moved); err == nil {
+		t.Error("Expected open under another program to fail")
+	}
+	var missing *SecretBox
+	if _, err := missing.OpenKey(record); !errors.Is(err, ErrMasterKeyMissing) {
+		t.Errorf("Expected ErrMasterKeyMissing, got %v", err)
+	}
+
+	// 旧版明文记录仍可读取
+	legacy := &models.EncryptionKey{ProgramID: "prog-a", KeyData: base64.StdEncoding.EncodeToString(key)}
+	if opened, err := missing.OpenKey(legacy); err != nil || string(opened) != string(key) {
+		t.Errorf("Expected legacy plaintext key to open, got %v", err)
+	}
+}
+
+func TestMigrateAndRewrapSecrets(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "secrets.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Token{}, &models.EncryptionKey{}, &models.AdminUser{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+
+	// 迁移前的数据：明文密钥（无 key_id）和明文 Token
+	key, _ := generateKey()
+	db.Create(&models.EncryptionKey{ProgramID: "prog-a", KeyData: base64.StdEncoding.EncodeToString(key), Status: KeyStatusActive})
+	db.Create(&models.Token{TokenID: "legacy-hash", TokenValue: "legacy-value", ProgramID: "prog-a", TokenType: "upload", IsActive: true})
+
+	if keys, tokens, _ := CountPlaintextSecrets(db); keys != 1 || tokens != 1 {
+		t.Fatalf("Expected 1 plaintext key and token, got %d and %d", keys, tokens)
+	}
+
+	oldBox := NewSecretBox("old-master-key")
+	result, err := MigrateSecrets(db, oldBox)
+	if err != nil {
+		t.Fatalf("MigrateSecrets failed: %v", err)
+	}
+	if result.KeysSealed != 1 || result.TokensCleared != 1 {
+		t.Errorf("Unexpected migration result: %+v", result)
+	}
+	if keys, tokens, _ := CountPlaintextSecrets(db); keys != 0 || tokens != 0 {
+		t.Errorf("Expected no plaintext secrets after migration, got %d and %d", keys, tokens)
+	}
+	if again, err := MigrateSecrets(db, oldBox); err != nil || again.KeysSealed != 0 {
+		t.Errorf("Expected repeated migration to be a no-op, got %+v, %v", again, err)
+	}
+
+	var record models.EncryptionKey
+	db.First(&record)
+	if record.KeyID != pkgcrypt.KeyID(key) {
+		t.Errorf("Expected key id to be backfilled, got %q", record.KeyID)
+	}
+
+	// 主密钥轮换：错误的旧主密钥不做任何修改
+	newBox := NewSecretBox("new-master-key")
+	if _, err := RewrapSecrets(db, NewSecretBox("wrong-master-key"), newBox); err == nil {
+		t.Fatal("Expected rewrap with wrong old master key to fail")
+	}
+	if _, err := oldBox.OpenKey(&record); err != nil {
+		t.Fatalf("Failed rewrap must leave keys untouched: %v", err)
+	}
+
+	// 轮换中的 Token 的替换值也需要重新加密
+	replacement, _ := oldBox.SealValue("prog-a", tokenReplacementInfo, "rotating-hash", []byte("new-value"))
+	db.Create(&models.Token{TokenID: "rotating-hash", ProgramID: "prog-a", TokenType: "download", IsActive: true,
+		ReplacedBy: "new-hash", Replacement: replacement})
+	totpSecret, _ := oldBox.SealValue("", totpSecretInfo, "alice", []byte("JBSWY3DPEHPK3PXP"))
+	db.Create(&models.AdminUser{Username: "alice", PasswordHash: "x", Role: RoleOwner, TOTPSecret: totpSecret, TOTPEnabled: true})
+
+	result, err = RewrapSecrets(db, oldBox, newBox)
+	if err != nil || result.KeysRewrapped != 1 || result.TokensRewrapped != 1 || result.TOTPRewrapped != 1 {
+		t.Fatalf("RewrapSecrets failed: %+v, %v", result, err)
+	}
+	db.First(&record)
+	if opened, err := newBox.OpenKey(&record); err != nil || string(opened) != string(key) {
+		t.Errorf("Expected key to open with new master key, got %v", err)
+	}
+	if _, err := oldBox.OpenKey(&record); err == nil {
+		t.Error("Expected old master key to no longer open the key")
+	}
+	var rotating models.Token
+	db.Where("token_id = ?", "rotating-hash").First(&rotating)
+	if value, err := newBox.OpenValue("prog-a", tokenReplacementInfo, "rotating-hash", rotating.Replacement); err != nil || string(value) != "new-value" {
+		t.Errorf("Expected replacement to open with new master key, got %v", err)
+	}
+	var alice models.AdminUser
+	db.Where("username = ?", "alice").First(&alice)
+	if value, err := newBox.OpenValue("", totpSecretInfo, "alice", alice.TOTPSecret); err != nil || string(value) != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Expected TOTP secret to open with new master key, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// SessionTTL 登录会话有效期
+	SessionTTL = 12 * time.Hour
+	// PreAuthTTL 密码验证通过后等待输入两步验证码的时限
+	PreAuthTTL = 5 * time.Minute
+	// sessionTouchInterval 最近活动时间的更新间隔，避免每个请求都写数据库
+	sessionTouchInterval = time.Minute
+)
+
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+// SessionService 管理后台登录会话（服务端存储，可列出和撤销）
+type SessionService struct {
+	db *gorm.DB
+}
+
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+// hashSessionToken 会话 Token 的 SHA256（十六进制），即 SessionID
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create 创建会话，返回写入 Cookie 的会话 Token（只返回这一次）
+//
+// preAuth 会话只表示密码已通过、等待两步验证码，有效期为 PreAuthTTL。
+func (s *SessionService) Create(username, ip, userAgent string, preAuth bool) (string, *models.AdminSession, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	ttl := SessionTTL
+	if preAuth {
+		ttl = PreAuthTTL
+	}
+	now := time.Now()
+	session := &models.AdminSession{
+		SessionID:  hashSessionToken(token),
+		Username:   username,
+		PreAuth:    preAuth,
+		IP:         ip,
+		UserAgent:  userAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// Get 按会话 Token 获取未过期的会话，并更新最近活动时间
+func (s *SessionService) Get(token string) (*models.AdminSession, error) {
+	if token == "" {
+		return nil, ErrSessionNotFound
+	}
+	var session models.AdminSession
+	err := s.db.Where("session_id = ? AND expires_at > ?", hashSessionToken(token), time.Now()).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		session.LastSeenAt = now
+		s.db.Model(&session).Update("last_seen_at", now)
+	}
+	return &session, nil
+}
+
+// List 列出用户未过期的登录会话（最近活动的在前）
+func (s *SessionService) List(username string) ([]models.AdminSession, error) {
+	var sessions []models.AdminSession
+	err := s.db.Where("username = ? AND pre_auth = ? AND expires_at > ?", username, false, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke 按会话 Token 删除会话（登出）
+func (s *SessionService) Revoke(token string) error {
+	return s.db.Where("session_id = ?", hashSessionToken(token)).Delete(&models.AdminSession{}).Error
+}
+
+// RevokeSession 撤销用户的指定会话
+func (s *SessionService) RevokeSession(username, sessionID string) error {
+	result := s.db.Where("username = ? AND session_id = ?", username, sessionID).Delete(&models.AdminSession{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAll 撤销用户的所有会话，exceptSessionID 非空时保留该会话（当前会话）
+func (s *SessionService) RevokeAll(username, exceptSessionID string) (int64, error) {
+	query := s.db.Where("username = ?", username)
+	if exceptSessionID != "" {
+		query = query.Where("session_id <> ?", exceptSessionID)
+	}
+	result := query.Delete(&models.AdminSession{})
+	return result.RowsAffected, result.Error
+}
+
+// CleanupExpired 删除过期的会话
+func (s *SessionService) CleanupExpired() (int64, error) {
+	result := s.db.Where("expires_at <= ?", time.Now()).Delete(&models.AdminSession{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestSessionDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sessions.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.AdminSession{}, &models.LoginAttempt{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	return db
+}
+
+func TestSessionService(t *testing.T) {
+	db := newTestSessionDB(t)
+	sessions := NewSessionService(db)
+
+	token, created, err := sessions.Create("alice", "10.0.0.1", "Firefox", false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.SessionID == token {
+		t.Error("session ID must not be the raw token")
+	}
+	got, err := sessions.Get(token)
+	if err != nil || got.Username != "alice" || got.IP != "10.0.0.1" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	if _, err := sessions.Get("unknown"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("unknown token: expected ErrSessionNotFound, got %v", err)
+	}
+
+	// 预认证会话不出现在会话列表中
+	other, _, _ := sessions.Create("alice", "10.0.0.2", "Chrome", false)
+	sessions.Create("alice", "10.0.0.3", "Chrome", true)
+	list, err := sessions.List("alice")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("List = %d sessions, %v; want 2", len(list), err)
+	}
+
+	// 过期会话不可用，并由 CleanupExpired 删除
+	expired, expiredSession, _ := sessions.Create("alice", "10.0.0.4", "Safari", false)
+	db.Model(expiredSession).Update("expires_at", time.Now().Add(-time.Minute))
+	if _, err := sessions.Get(expired); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expired session: expected ErrSessionNotFound, got %v", err)
+	}
+	if n, err := sessions.CleanupExpired(); err != nil || n != 1 {
+		t.Errorf("CleanupExpired = %d, %v; want 1", n, err)
+	}
+
+	// 撤销其他用户的会话视为不存在
+	if err := sessions.RevokeSession("bob", created.SessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoke other user's session: expected ErrSessionNotFound, got %v", err)
+	}
+	if err := sessions.RevokeSession("alice", created.SessionID); err != nil {
+		t.Fatalf("RevokeSession failed: %v", err)
+	}
+	if _, err := sessions.Get(token); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("revoked session is still valid: %v", err)
+	}
+
+	// RevokeAll 保留当前会话
+	current, currentSession, _ := sessions.Create("alice", "10.0.0.5", "Edge", false)
+	if n, err := sessions.RevokeAll("alice", currentSession.SessionID); err != nil || n != 2 {
+		t.Errorf("RevokeAll = %d, %v; want 2 (other + pre-auth)", n, err)
+	}
+	if _, err := sessions.Get(other); err == nil {
+		t.Error("other session should be revoked")
+	}
+	if _, err := sessions.Get(current); err != nil {
+		t.Errorf("current session should be kept: %v", err)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	db := newTestSessionDB(t)
+	throttle := NewLoginThrottle(db)
+
+	for i := 0; i < loginUserFreeFailures; i++ {
+		if _, err := throttle.Check("10.0.0.1", "alice"); err != nil {
+			t.Fatalf("attempt %d: unexpected lock: %v", i+1, err)
+		}
+		if err := throttle.RecordFailure("10.0.0.1", "alice"); err != nil {
+			t.Fatalf("RecordFailure failed: %v", err)
+		}
+	}
+	if _, err := throttle.Check("10.0.0.1", "alice"); err != nil {
+		t.Fatalf("free failures should not lock: %v", err)
+	}
+
+	// 超过免费次数后锁定，锁定时长按失败次数翻倍
+	throttle.RecordFailure("10.0.0.1", "alice")
+	retryAfter, err := throttle.Check("10.0.0.9", "alice")
+	if !errors.Is(err, ErrLoginLocked) || retryAfter <= 0 || retryAfter > loginLockoutBase {
+		t.Fatalf("after first lock: Check = %v, %v", retryAfter, err)
+	}
+	throttle.RecordFailure("10.0.0.1", "alice")
+	retryAfter, _ = throttle.Check("10.0.0.9", "alice")
+	if retryAfter <= loginLockoutBase || retryAfter > 2*loginLockoutBase {
+		t.Errorf("second lock should double: retryAfter = %v", retryAfter)
+	}
+
+	// 其他用户名不受影响
+	if _, err := throttle.Check("10.0.0.9", "bob"); err != nil {
+		t.Errorf("other username should not be locked: %v", err)
+	}
+
+	// 登录成功只清除用户名计数，不清除 IP 计数
+	if err := throttle.RecordSuccess("alice"); err != nil {
+		t.Fatalf("RecordSuccess failed: %v", err)
+	}
+	if _, err := throttle.Check("10.0.0.9", "alice"); err != nil {
+		t.Errorf("success should reset the username counter: %v", err)
+	}
+	var ipAttempt models.LoginAttempt
+	db.Where("kind = ? AND subject = ?", loginKindIP, "10.0.0.1").First(&ipAttempt)
+	if ipAttempt.Failures != loginUserFreeFailures+2 {
+		t.Errorf("IP failures = %d, want %d", ipAttempt.Failures, loginUserFreeFailures+2)
+	}
+
+	// 同一 IP 对多个用户名的失败累计后锁定该 IP
+	for i := ipAttempt.Failures; i < loginIPFreeFailures+1; i++ {
+		throttle.RecordFailure("10.0.0.1", "")
+	}
+	if _, err := throttle.Check("10.0.0.1", "carol"); !errors.Is(err, ErrLoginLocked) {
+		t.Errorf("IP should be locked, got %v", err)
+	}
+
+	// 窗口期外的失败记录被清理
+	old := time.Now().Add(-2 * loginFailureWindow)
+	db.Model(&models.LoginAttempt{}).Where("1 = 1").Updates(map[string]interface{}{"last_failure_at": old, "locked_until": old})
+	if n, err := throttle.Cleanup(); err != nil || n != 1 {
+		t.Errorf("Cleanup = %d, %v; want 1", n, err)
+	}
+}
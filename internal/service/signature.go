@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/signing"
+)
+
+var (
+	// ErrSignatureRequired 程序已配置签名公钥，但发布时未提供签名
+	ErrSignatureRequired = errors.New("signature required")
+	// ErrSignatureInvalid 签名校验失败
+	ErrSignatureInvalid = errors.New("signature invalid")
+)
+
+// VersionManifest 构造主文件的发布清单
+func VersionManifest(v *models.Version) signing.Manifest {
+	return signing.Manifest{
+		ProgramID: v.ProgramID,
+		Version:   v.Version,
+		Channel:   v.Channel,
+		FileHash:  v.FileHash,
+		FileSize:  v.FileSize,
+		Mandatory: v.Mandatory,
+	}
+}
+
+// ArtifactManifest 构造平台制品的发布清单
+func ArtifactManifest(v *models.Version, a *models.VersionArtifact) signing.Manifest {
+	m := VersionManifest(v)
+	m.Platform = Platform{OS: a.OS, Arch: a.Arch, Variant: a.Variant}.String()
+	m.FileHash = a.FileHash
+	m.FileSize = a.FileSize
+	return m
+}
+
+// SigningPublicKey 获取程序的签名公钥，未配置时返回空字符串
+func (s *VersionService) SigningPublicKey(programID string) (string, error) {
+	var program models.Program
+	err := s.db.Select("signing_public_key").Where("program_id = ?", programID).Limit(1).Find(&program).Error
+	return program.SigningPublicKey, err
+}
+
+// VerifySignatures 校验版本主文件和各平台制品的签名
+//
+// 未配置签名公钥的程序不做校验；配置后每个文件都必须带有效签名。
+func (s *VersionService) VerifySignatures(v *models.Version) error {
+	publicKey, err := s.SigningPublicKey(v.ProgramID)
+	if err != nil {
+		return err
+	}
+	return verifyVersionSignatures(publicKey, v)
+}
+
+func verifyVersionSignatures(publicKey string, v *models.Version) error {
+	if publicKey == "" {
+		return nil
+	}
+	if err := verifyManifest(publicKey, VersionManifest(v), v.Signature); err != nil {
+		return fmt.Errorf("%w: main file", err)
+	}
+	for i := range v.Artifacts {
+		a := &v.Artifacts[i]
+		m := ArtifactManifest(v, a)
+		if err := verifyManifest(publicKey, m, a.Signature); err != nil {
+			return fmt.Errorf("%w: platform %s", err, m.Platform)
+		}
+	}
+	return nil
+}
+
+func verifyManifest(publicKey string, m signing.Manifest, signature string) error {
+	if signature == "" {
+		return ErrSignatureRequired
+	}
+	if err := signing.Verify(publicKey, m, signature); err != nil {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
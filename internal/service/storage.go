@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 
 	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
 )
 
 type StorageService struct {
@@ -21,45 +23,182 @@ func NewStorageService(basePath string) *StorageService {
 
 // SaveFile 保存文件到指定路径
 func (s *StorageService) SaveFile(programID, channel, version string, file io.Reader) (string, int64, string, error) {
-	// 创建目录: data/packages/{programID}/{channel}/{version}/
-	dir := filepath.Join(s.basePath, programID, channel, version)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	return s.SaveFileAs(programID, channel, version, PackageFileName(programID, version), file)
+}
+
+// SaveArtifact 保存指定平台的制品文件
+func (s *StorageService) SaveArtifact(programID, channel, version, platform string, file io.Reader) (string, int64, string, error) {
+	return s.SaveFileAs(programID, channel, version, ArtifactFileName(programID, version, platform), file)
+}
+
+// PackageFileName 版本主文件的文件名
+func PackageFileName(programID, version string) string {
+	return fmt.Sprintf("%s-%s.zip", programID, version)
+}
+
+// ArtifactFileName 平台制品的文件名
+func ArtifactFileName(programID, version, platform string) string {
+	return fmt.Sprintf("%s-%s-%s.zip", programID, version, platform)
+}
+
+// SaveFileAs 以指定文件名保存文件到版本目录
+func (s *StorageService) SaveFileAs(programID, channel, version, fileName string, file io.Reader) (string, int64, string, error) {
+	stored, err := s.Store(programID, channel, version, fileName, file, nil)
+	if err != nil {
 		return "", 0, "", err
 	}
+	return stored.FileName, stored.Size, stored.Hash, nil
+}
 
-	// 计算哈希
-	hash := sha256.New()
-	tee := io.TeeReader(file, hash)
+// StoredFile 已保存到版本目录的文件
+type StoredFile struct {
+	FileName      string
+	Size          int64  // 明文大小
+	Hash          string // 明文 SHA256
+	Encrypted     bool
+	EncryptedSize int64  // 加密存储时磁盘上密文的大小
+	EncryptedHash string // 加密存储时密文的 SHA256
+	KeyID         string // 加密存储时所用密钥的 ID
+}
+
+// Apply 将加密信息写入版本记录
+func (f *StoredFile) Apply(v *models.Version) {
+	v.Encrypted, v.EncryptedSize, v.EncryptedHash = f.Encrypted, f.EncryptedSize, f.EncryptedHash
+	v.EncryptionKeyID = f.KeyID
+}
+
+// ApplyArtifact 将加密信息写入平台制品记录
+func (f *StoredFile) ApplyArtifact(a *models.VersionArtifact) {
+	a.Encrypted, a.EncryptedSize, a.EncryptedHash = f.Encrypted, f.EncryptedSize, f.EncryptedHash
+	a.EncryptionKeyID = f.KeyID
+}
+
+// Store 以指定文件名保存文件到版本目录
+//
+// key 不为空时在写入磁盘的同时以分块 AES-GCM 加密，明文不落盘，
+// 同时记录明文和密文的大小与哈希。
+// 内容先写入同目录下的临时文件，完整写入后才重命名为目标文件，失败时不影响已有文件。
+func (s *StorageService) Store(programID, channel, version, fileName string, file io.Reader, key []byte) (*StoredFile, error) {
+	// 创建目录: data/packages/{programID}/{channel}/{version}/
+	dir := s.VersionDir(programID, channel, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
 
-	// 保存文件
-	fileName := fmt.Sprintf("%s-%s.zip", programID, version)
 	filePath := filepath.Join(dir, fileName)
+	f, err := os.CreateTemp(dir, "."+fileName+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := f.Name()
 
-	f, err := os.Create(filePath)
+	stored, err := WritePackage(f, file, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
 	if err != nil {
-		return "", 0, "", err
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	stored.FileName = fileName
+
+	if stored.Encrypted {
+		logger.Infof("File saved encrypted: %s, size: %d, hash: %s", filePath, stored.EncryptedSize, stored.EncryptedHash)
+	} else {
+		logger.Infof("File saved: %s, size: %d, hash: %s", filePath, stored.Size, stored.Hash)
+	}
+
+	return stored, nil
+}
+
+// WritePackage 将明文写入 dst，key 不为空时加密，返回明文与写入内容的大小和哈希
+func WritePackage(dst io.Writer, src io.Reader, key []byte) (*StoredFile, error) {
+	plainHash := sha256.New()
+	tee := io.TeeReader(src, plainHash)
+	if key == nil {
+		size, err := io.Copy(dst, tee)
+		if err != nil {
+			return nil, err
+		}
+		return &StoredFile{Size: size, Hash: hex.EncodeToString(plainHash.Sum(nil))}, nil
 	}
-	defer f.Close()
 
-	size, err := io.Copy(f, tee)
+	cipherHash := sha256.New()
+	counter := &countingWriter{}
+	w, err := pkgcrypt.NewWriter(io.MultiWriter(dst, cipherHash, counter), key)
 	if err != nil {
-		return "", 0, "", err
+		return nil, err
+	}
+	size, err := io.Copy(w, tee)
+	if err != nil {
+		return nil, err
 	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &StoredFile{
+		Size:          size,
+		Hash:          hex.EncodeToString(plainHash.Sum(nil)),
+		Encrypted:     true,
+		EncryptedSize: counter.n,
+		EncryptedHash: hex.EncodeToString(cipherHash.Sum(nil)),
+		KeyID:         pkgcrypt.KeyID(key),
+	}, nil
+}
 
-	fileHash := hex.EncodeToString(hash.Sum(nil))
+// MoveFileAs 将已写好的文件原子地移动到版本目录（源文件需与存储目录在同一文件系统）
+func (s *StorageService) MoveFileAs(programID, channel, version, fileName, srcPath string) error {
+	dir := s.VersionDir(programID, channel, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	filePath := filepath.Join(dir, fileName)
+	if err := os.Rename(srcPath, filePath); err != nil {
+		return err
+	}
+	logger.Infof("File saved: %s", filePath)
+	return nil
+}
+
+// GetArtifactFilePath 获取平台制品的文件路径
+func (s *StorageService) GetArtifactFilePath(a *models.VersionArtifact) string {
+	return filepath.Join(a.FilePath, a.FileName)
+}
 
-	logger.Infof("File saved: %s, size: %d, hash: %s", filePath, size, fileHash)
+// GetVersionFilePath 获取版本记录实际指向的文件路径
+//
+// 晋升到其他通道的版本与来源版本共用同一文件，因此优先使用记录中保存的路径。
+func (s *StorageService) GetVersionFilePath(v *models.Version) string {
+	if v.FilePath != "" && v.FileName != "" {
+		return filepath.Join(v.FilePath, v.FileName)
+	}
+	return s.GetFilePath(v.ProgramID, v.Channel, v.Version)
+}
+
+// VersionDir 获取版本文件的存放目录
+func (s *StorageService) VersionDir(programID, channel, version string) string {
+	return filepath.Join(s.basePath, programID, channel, version)
+}
+
+// PatchDir 获取版本增量补丁的存放目录
+func (s *StorageService) PatchDir(programID, channel, version string) string {
+	return filepath.Join(s.VersionDir(programID, channel, version), "patches")
+}
 
-	return fileName, size, fileHash, nil
+// GetPatchFilePath 获取增量补丁的文件路径
+func (s *StorageService) GetPatchFilePath(p *models.VersionPatch) string {
+	return filepath.Join(p.FilePath, p.FileName)
 }
 
-// DeleteFile 删除文件
-func (s *StorageService) DeleteFile(programID, channel, version string) error {
-	dir := filepath.Join(s.basePath, programID, channel, version)
-	return os.RemoveAll(dir)
+// DeleteVersionFiles 删除版本记录指向的文件目录
+func (s *StorageService) DeleteVersionFiles(v *models.Version) error {
+	return os.RemoveAll(filepath.Dir(s.GetVersionFilePath(v)))
 }
 
 // GetFilePath 获取文件路径
 func (s *StorageService) GetFilePath(programID, channel, version string) string {
-	return filepath.Join(s.basePath, programID, channel, version, fmt.Sprintf("%s-%s.zip", programID, version))
+	return filepath.Join(s.basePath, programID, channel, version, PackageFileName(programID, version))
 }
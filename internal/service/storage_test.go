@@ -0,0 +1,67 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingReader 读取部分内容后返回错误，模拟中断的上传
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStorageService_StoreKeepsExistingFileOnFailure(t *testing.T) {
+	storage := NewStorageService(t.TempDir())
+	fileName := PackageFileName("app", "1.0.0")
+
+	stored, err := storage.Store("app", "stable", "1.0.0", fileName, strings.NewReader("live package"), nil)
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if stored.Size != int64(len("live package")) {
+		t.Errorf("Size = %d", stored.Size)
+	}
+
+	// 中断的写入和加密失败都不能破坏已有文件
+	if _, err := storage.Store("app", "stable", "1.0.0", fileName, &failingReader{data: "partial"}, nil); err == nil {
+		t.Fatal("Store should fail when the reader fails")
+	}
+	if _, err := storage.Store("app", "stable", "1.0.0", fileName, strings.NewReader("new package"), []byte("short key")); err == nil {
+		t.Fatal("Store should fail with an invalid key")
+	}
+
+	path := storage.GetFilePath("app", "stable", "1.0.0")
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "live package" {
+		t.Errorf("existing file = %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("temporary files left behind: %v", names)
+	}
+
+	// 成功的写入替换文件
+	if _, err := storage.Store("app", "stable", "1.0.0", fileName, io.LimitReader(strings.NewReader("replaced"), 8), nil); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "replaced" {
+		t.Errorf("file = %q, want replaced", data)
+	}
+}
@@ -6,13 +6,48 @@ import (
 	"docufiller-update-server/internal/models"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Token 权限范围
+const (
+	ScopeDownload = "download" // 下载已发布的包和补丁
+	ScopeUpload   = "upload"   // 上传新版本（含分片上传），可访问草稿
+	ScopePublish  = "publish"  // 修改发布状态、晋升到其他通道
+	ScopeYank     = "yank"     // 撤回与取消撤回版本
+	ScopeDelete   = "delete"   // 删除版本
+)
+
+// AllScopes 全部权限范围
+var AllScopes = []string{ScopeDownload, ScopeUpload, ScopePublish, ScopeYank, ScopeDelete}
+
+// defaultScopes 未设置 Scopes 的 Token（程序默认 Token 和旧数据）按类型取得的权限
+var defaultScopes = map[string][]string{
+	"upload":   {ScopeUpload, ScopePublish, ScopeYank, ScopeDelete},
+	"download": {ScopeDownload},
+}
+
+var (
+	ErrInvalidScope      = errors.New("invalid token scope")
+	ErrInvalidExpiry     = errors.New("token expiry must be in the future")
+	ErrTokenNameRequired = errors.New("token name is required")
+)
+
+// CreateTokenRequest 管理 API 创建 Token 的参数
+type CreateTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Channel   string     `json:"channel"`   // 为空表示不限通道
+	ExpiresAt *time.Time `json:"expiresAt"` // 为空表示不过期
+}
+
 type TokenService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	secrets *SecretBox
 }
 
 func NewTokenService(db *gorm.DB) *TokenService {
@@ -26,25 +61,25 @@ func (s *TokenService) GenerateToken(programID, tokenType, createdBy string) (*m
 
 // GenerateTokenWithDB 使用指定数据库实例生成新 Token（支持事务上下文）
 func (s *TokenService) GenerateTokenWithDB(db *gorm.DB, programID, tokenType, createdBy string) (*models.Token, string, error) {
-	// 生成随机 Token 值
-	randomBytes := make([]byte, 32)
-	if _, err := rand.Read(randomBytes); err != nil {
+	return s.generateToken(db, programID, tokenType, "", createdBy)
+}
+
+// generateToken 生成名为 name 的 Token，name 为空表示程序默认 Token
+func (s *TokenService) generateToken(db *gorm.DB, programID, tokenType, name, createdBy string) (*models.Token, string, error) {
+	tokenValue, tokenID, err := newTokenValue()
+	if err != nil {
 		return nil, "", err
 	}
-	tokenValue := hex.EncodeToString(randomBytes)
-
-	// 计算哈希
-	hash := sha256.Sum256([]byte(tokenValue))
-	tokenID := hex.EncodeToString(hash[:])
 
+	// 只存储哈希，明文 Token 仅返回给调用方
 	token := &models.Token{
-		TokenID:    tokenID,
-		TokenValue: tokenValue,
-		ProgramID:  programID,
-		TokenType:  tokenType,
-		CreatedBy:  createdBy,
-		IsActive:   true,
-		CreatedAt:  time.Now(),
+		TokenID:   tokenID,
+		ProgramID: programID,
+		TokenType: tokenType,
+		Name:      name,
+		CreatedBy: createdBy,
+		IsActive:  true,
+		CreatedAt: time.Now(),
 	}
 
 	if err := db.Create(token).Error; err != nil {
@@ -54,6 +89,18 @@ func (s *TokenService) GenerateTokenWithDB(db *gorm.DB, programID, tokenType, cr
 	return token, tokenValue, nil
 }
 
+// newTokenValue 生成随机 Token 值及其哈希（TokenID）
+func newTokenValue() (tokenValue, tokenID string, err error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", "", err
+	}
+	tokenValue = hex.EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(tokenValue))
+	return tokenValue, hex.EncodeToString(hash[:]), nil
+}
+
 // ValidateToken 验证 Token
 func (s *TokenService) ValidateToken(tokenValue string) (*models.Token, error) {
 	hash := sha256.Sum256([]byte(tokenValue))
@@ -79,26 +126,201 @@ func (s *TokenService) ValidateToken(tokenValue string) (*models.Token, error) {
 	return &token, nil
 }
 
-// HasPermission 检查权限
-func (s *TokenService) HasPermission(token *models.Token, requiredType, programID string) bool {
+// HasPermission 检查 Token 能否以 scope 权限操作程序的 channel 通道
+//
+// channel 为空表示通道尚未确定（如中间件阶段），此时不检查通道限制，
+// 由确定了通道的 handler 再次调用。
+func (s *TokenService) HasPermission(token *models.Token, scope, programID, channel string) bool {
 	// Admin Token 拥有所有权限
 	if token.TokenType == "admin" {
 		return true
 	}
 
-	// 检查 Token 类型
-	if token.TokenType != requiredType {
+	// 检查程序权限
+	if token.ProgramID != "*" && token.ProgramID != programID {
+		return false
+	}
+
+	// 检查权限范围
+	if !HasScope(token, scope) {
 		return false
 	}
 
-	// 检查程序权限
-	if token.ProgramID != "*" && token.ProgramID != programID {
+	// 检查通道限制
+	if token.Channel != "" && channel != "" && token.Channel != channel {
 		return false
 	}
 
 	return true
 }
 
+// TokenScopes Token 的有效权限范围：未设置时按类型取默认权限
+func TokenScopes(token *models.Token) []string {
+	if len(token.Scopes) > 0 {
+		return token.Scopes
+	}
+	if token.TokenType == "admin" {
+		return AllScopes
+	}
+	return defaultScopes[token.TokenType]
+}
+
+// HasScope Token 是否拥有 scope 权限
+func HasScope(token *models.Token, scope string) bool {
+	for _, sc := range TokenScopes(token) {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeScopes 校验并去重权限范围，按 AllScopes 的顺序返回
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	requested := make(map[string]bool, len(scopes))
+	for _, sc := range scopes {
+		requested[strings.ToLower(strings.TrimSpace(sc))] = true
+	}
+	var result []string
+	for _, sc := range AllScopes {
+		if requested[sc] {
+			result = append(result, sc)
+			delete(requested, sc)
+		}
+	}
+	for sc := range requested {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidScope, sc)
+	}
+	return result, nil
+}
+
+// CreateToken 创建带名称、权限范围、通道限制和过期时间的程序 Token，返回记录和明文 Token
+func (s *TokenService) CreateToken(programID, createdBy string, req CreateTokenRequest) (*models.Token, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, "", ErrTokenNameRequired
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	// 类型只用于分类，权限以 Scopes 为准
+	tokenType := "upload"
+	if len(scopes) == 1 && scopes[0] == ScopeDownload {
+		tokenType = "download"
+	}
+
+	tokenValue, tokenID, err := newTokenValue()
+	if err != nil {
+		return nil, "", err
+	}
+	token := &models.Token{
+		TokenID:   tokenID,
+		ProgramID: programID,
+		TokenType: tokenType,
+		Name:      name,
+		Scopes:    scopes,
+		Channel:   strings.TrimSpace(req.Channel),
+		CreatedBy: createdBy,
+		ExpiresAt: req.ExpiresAt,
+		IsActive:  true,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, tokenValue, nil
+}
+
+// CreateAdminToken 创建管理 Token，用于自动化调用管理 API，返回记录和明文 Token
+func (s *TokenService) CreateAdminToken(name, createdBy string, expiresAt *time.Time) (*models.Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrTokenNameRequired
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	tokenValue, tokenID, err := newTokenValue()
+	if err != nil {
+		return nil, "", err
+	}
+	token := &models.Token{
+		TokenID:   tokenID,
+		ProgramID: "*",
+		TokenType: "admin",
+		Name:      name,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+		IsActive:  true,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, tokenValue, nil
+}
+
+// ListAdminTokens 列出管理 Token（含已撤销的，新的在前）
+func (s *TokenService) ListAdminTokens() ([]models.Token, error) {
+	var tokens []models.Token
+	err := s.db.Where("token_type = ?", "admin").Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// RevokeAdminToken 撤销管理 Token，不存在时返回 gorm.ErrRecordNotFound
+func (s *TokenService) RevokeAdminToken(tokenID string) error {
+	result := s.db.Model(&models.Token{}).
+		Where("token_type = ? AND token_id = ?", "admin", tokenID).
+		Update("is_active", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListTokens 列出程序的 Token（含已撤销的，新的在前）
+func (s *TokenService) ListTokens(programID string) ([]models.Token, error) {
+	var tokens []models.Token
+	err := s.db.Where("program_id = ?", programID).Order("id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetTokenByID 按 TokenID 获取程序的 Token
+func (s *TokenService) GetTokenByID(programID, tokenID string) (*models.Token, error) {
+	var token models.Token
+	err := s.db.Where("program_id = ? AND token_id = ?", programID, tokenID).First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeProgramToken 撤销程序的指定 Token，不存在时返回 gorm.ErrRecordNotFound
+func (s *TokenService) RevokeProgramToken(programID, tokenID string) error {
+	result := s.db.Model(&models.Token{}).
+		Where("program_id = ? AND token_id = ?", programID, tokenID).
+		Update("is_active", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 // RevokeToken 撤销 Token
 func (s *TokenService) RevokeToken(tokenID string) error {
 	return s.db.Model(&models.Token{}).
@@ -106,7 +328,7 @@ func (s *TokenService) RevokeToken(tokenID string) error {
 		Update("is_active", false).Error
 }
 
-// RegenerateToken 重新生成指定类型的 Token
+// RegenerateToken 重新生成指定类型的 Token，旧 Token 立即失效（带宽限期的轮换见 RotateToken）
 func (s *TokenService) RegenerateToken(programID, tokenType, createdBy string) (*models.Token, string, error) {
 	// 先撤销旧的 Token
 	if err := s.RevokeTokenByType(programID, tokenType); err != nil {
@@ -117,27 +339,19 @@ func (s *TokenService) RegenerateToken(programID, tokenType, createdBy string) (
 	return s.GenerateToken(programID, tokenType, createdBy)
 }
 
-// RevokeTokenByType 根据类型撤销 Token
+// RevokeTokenByType 根据类型撤销程序的默认 Token（管理 API 创建的命名 Token 不受影响）
 func (s *TokenService) RevokeTokenByType(programID, tokenType string) error {
 	return s.db.Model(&models.Token{}).
-		Where("program_id = ? AND token_type = ?", programID, tokenType).
+		Where("program_id = ? AND token_type = ? AND (name IS NULL OR name = '')", programID, tokenType).
 		Update("is_active", false).Error
 }
 
-// GetToken 获取指定程序的 Token
-func (s *TokenService) GetToken(programID, tokenType, createdBy string) (*models.Token, error) {
+// GetToken 获取指定程序当前有效的默认 Token，不存在时返回 gorm.ErrRecordNotFound
+func (s *TokenService) GetToken(programID, tokenType string) (*models.Token, error) {
 	var token models.Token
-	err := s.db.Where("program_id = ? AND token_type = ? AND is_active = ?", programID, tokenType, true).
-		First(&token).Error
+	err := s.db.Where("program_id = ? AND token_type = ? AND is_active = ? AND (name IS NULL OR name = '')", programID, tokenType, true).
+		Order("id DESC").First(&token).Error
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// 如果没有找到 Token，创建一个新的
-			newToken, _, err := s.GenerateToken(programID, tokenType, createdBy)
-			if err != nil {
-				return nil, err
-			}
-			return newToken, nil
-		}
 		return nil, err
 	}
 	return &token, nil
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"docufiller-update-server/internal/logger"
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// DefaultTokenGracePeriod Token 轮换后旧 Token 的默认宽限期
+const DefaultTokenGracePeriod = 7 * 24 * time.Hour
+
+// tokenReplacementInfo 加密替换 Token 时的 HKDF info
+const tokenReplacementInfo = "token-replacement"
+
+// TokenUpdate 替换 Token，随更新检查下发给仍在使用旧 Token 的客户端
+type TokenUpdate struct {
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // 旧 Token 失效时间
+}
+
+// SetSecretBox 设置加密替换 Token 所用的 SecretBox（未设置时只能立即撤销，不能带宽限期轮换）
+func (s *TokenService) SetSecretBox(box *SecretBox) {
+	s.secrets = box
+}
+
+// RotateToken 为程序生成新的默认 Token，旧 Token 在宽限期 grace 内仍然有效
+//
+// 旧 Token 记录替换 Token（加密存储），宽限期内用旧 Token 做更新检查的客户端会收到新 Token。
+// 已在轮换中的旧 Token 改为指向最新的 Token。grace <= 0 时立即撤销旧 Token（同 RegenerateToken）。
+func (s *TokenService) RotateToken(programID, tokenType, createdBy string, grace time.Duration) (*models.Token, string, error) {
+	if grace <= 0 {
+		return s.RegenerateToken(programID, tokenType, createdBy)
+	}
+	return s.rotateToken(programID, tokenType, "", createdBy, grace)
+}
+
+// PackagerTokenName 客户端打包器签发的 Token 名称，可在程序的 Token 列表中查看和撤销
+func PackagerTokenName(tokenType string) string {
+	return "client-packager-" + tokenType
+}
+
+// RotatePackagerToken 为新生成的客户端包签发 Token，并轮换之前的客户端包使用的同名 Token
+//
+// 旧 Token 在 DefaultTokenGracePeriod 后过期，宽限期内已分发的更新客户端会在更新检查时收到新 Token；
+// 未设置 SecretBox 时旧 Token 立即撤销。
+func (s *TokenService) RotatePackagerToken(programID, tokenType string) (*models.Token, string, error) {
+	name := PackagerTokenName(tokenType)
+	if s.secrets == nil {
+		if err := s.db.Model(&models.Token{}).
+			Where("program_id = ? AND token_type = ? AND name = ?", programID, tokenType, name).
+			Update("is_active", false).Error; err != nil {
+			return nil, "", err
+		}
+		return s.generateToken(s.db, programID, tokenType, name, "client-packager")
+	}
+	return s.rotateToken(programID, tokenType, name, "client-packager", DefaultTokenGracePeriod)
+}
+
+// rotateToken 生成名为 name 的新 Token（name 为空表示默认 Token），同名的旧 Token 在宽限期 grace 内仍然有效
+func (s *TokenService) rotateToken(programID, tokenType, name, createdBy string, grace time.Duration) (*models.Token, string, error) {
+	if s.secrets == nil {
+		return nil, "", ErrMasterKeyMissing
+	}
+
+	var token *models.Token
+	var tokenValue string
+	now := time.Now()
+	expiresAt := now.Add(grace)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("program_id = ? AND token_type = ? AND is_active = ?", programID, tokenType, true)
+		if name == "" {
+			query = query.Where("name IS NULL OR name = ''")
+		} else {
+			query = query.Where("name = ?", name)
+		}
+		var old []models.Token
+		if err := query.Find(&old).Error; err != nil {
+			return err
+		}
+
+		var err error
+		token, tokenValue, err = s.generateToken(tx, programID, tokenType, name, createdBy)
+		if err != nil {
+			return err
+		}
+
+		for i := range old {
+			t := &old[i]
+			replacement, err := s.secrets.SealValue(programID, tokenReplacementInfo, t.TokenID, []byte(tokenValue))
+			if err != nil {
+				return err
+			}
+			updates := map[string]interface{}{
+				"replaced_by": token.TokenID,
+				"replacement": replacement,
+			}
+			if t.RotatedAt == nil {
+				updates["rotated_at"] = now
+			}
+			if t.ExpiresAt == nil || t.ExpiresAt.After(expiresAt) {
+				updates["expires_at"] = expiresAt
+			}
+			if err := tx.Model(&models.Token{}).Where("id = ?", t.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return token, tokenValue, nil
+}
+
+// TokenReplacement 返回轮换中的旧 Token 的替换 Token
+//
+// Token 未被轮换，或替换 Token 已撤销、过期时返回 nil。
+func (s *TokenService) TokenReplacement(token *models.Token) (*TokenUpdate, error) {
+	if token.ReplacedBy == "" || token.Replacement == "" {
+		return nil, nil
+	}
+
+	var replacement models.Token
+	err := s.db.Where("token_id = ? AND is_active = ?", token.ReplacedBy, true).First(&replacement).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if replacement.ExpiresAt != nil && replacement.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	value, err := s.secrets.OpenValue(token.ProgramID, tokenReplacementInfo, token.TokenID, token.Replacement)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenUpdate{Token: string(value), ExpiresAt: token.ExpiresAt}, nil
+}
+
+// NoteRotatedUse 记录宽限期内对旧 Token 的使用，便于找出尚未更换 Token 的主机
+func (s *TokenService) NoteRotatedUse(token *models.Token, host, installID string) {
+	if token.ReplacedBy == "" {
+		return
+	}
+	logger.WithFields(map[string]interface{}{
+		"program":    token.ProgramID,
+		"tokenId":    token.TokenID,
+		"replacedBy": token.ReplacedBy,
+		"expiresAt":  token.ExpiresAt,
+		"host":       host,
+		"installId":  installID,
+	}).Warn("Rotated token used during grace period")
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"docufiller-update-server/internal/models"
+	"errors"
 	"testing"
 	"time"
 
@@ -48,21 +49,95 @@ func TestTokenService_HasPermission(t *testing.T) {
 
 	// 测试 Admin Token
 	adminToken, _, _ := tokenSvc.GenerateToken("*", "admin", "system")
-	if !tokenSvc.HasPermission(adminToken, "upload", "any-program") {
+	if !tokenSvc.HasPermission(adminToken, "upload", "any-program", "") {
 		t.Error("Admin token should have all permissions")
 	}
 
 	// 测试 Program Token
 	programToken, _, _ := tokenSvc.GenerateToken("my-app", "upload", "admin")
-	if !tokenSvc.HasPermission(programToken, "upload", "my-app") {
+	if !tokenSvc.HasPermission(programToken, "upload", "my-app", "") {
 		t.Error("Program token should have access to own program")
 	}
 
-	if tokenSvc.HasPermission(programToken, "upload", "other-app") {
+	if tokenSvc.HasPermission(programToken, "upload", "other-app", "") {
 		t.Error("Program token should not have access to other programs")
 	}
 }
 
+func TestTokenService_ScopesAndChannel(t *testing.T) {
+	db := setupTestDB(t)
+	tokenSvc := NewTokenService(db)
+
+	// 默认 Token 按类型取得权限
+	downloadToken, _, _ := tokenSvc.GenerateToken("my-app", "download", "admin")
+	if !tokenSvc.HasPermission(downloadToken, ScopeDownload, "my-app", "stable") {
+		t.Error("Download token should be able to download")
+	}
+	if tokenSvc.HasPermission(downloadToken, ScopeUpload, "my-app", "stable") {
+		t.Error("Download token should not be able to upload")
+	}
+
+	// 只能向 beta 发布的 Token
+	expiresAt := time.Now().Add(time.Hour)
+	betaToken, tokenValue, err := tokenSvc.CreateToken("my-app", "admin", CreateTokenRequest{
+		Name:      "ci-beta",
+		Scopes:    []string{"publish", "upload", "upload"},
+		Channel:   "beta",
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	if len(betaToken.Scopes) != 2 || betaToken.Scopes[0] != ScopeUpload || betaToken.Scopes[1] != ScopePublish {
+		t.Errorf("Expected normalized scopes [upload publish], got %v", betaToken.Scopes)
+	}
+	validated, err := tokenSvc.ValidateToken(tokenValue)
+	if err != nil || validated.Channel != "beta" || len(validated.Scopes) != 2 {
+		t.Fatalf("Expected created token to validate with its restrictions, got %+v, %v", validated, err)
+	}
+	if !tokenSvc.HasPermission(validated, ScopePublish, "my-app", "beta") {
+		t.Error("Token should be able to publish to beta")
+	}
+	if tokenSvc.HasPermission(validated, ScopePublish, "my-app", "stable") {
+		t.Error("Token should not be able to publish to stable")
+	}
+	if tokenSvc.HasPermission(validated, ScopeYank, "my-app", "beta") {
+		t.Error("Token should not have the yank scope")
+	}
+	if !tokenSvc.HasPermission(validated, ScopeUpload, "my-app", "") {
+		t.Error("Channel restriction should not apply before the channel is known")
+	}
+
+	// 参数校验
+	if _, _, err := tokenSvc.CreateToken("my-app", "admin", CreateTokenRequest{Name: "x", Scopes: []string{"root"}}); !errors.Is(err, ErrInvalidScope) {
+		t.Errorf("Expected ErrInvalidScope, got %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if _, _, err := tokenSvc.CreateToken("my-app", "admin", CreateTokenRequest{Name: "x", Scopes: []string{"download"}, ExpiresAt: &past}); !errors.Is(err, ErrInvalidExpiry) {
+		t.Errorf("Expected ErrInvalidExpiry, got %v", err)
+	}
+	if _, _, err := tokenSvc.CreateToken("my-app", "admin", CreateTokenRequest{Scopes: []string{"download"}}); !errors.Is(err, ErrTokenNameRequired) {
+		t.Errorf("Expected ErrTokenNameRequired, got %v", err)
+	}
+
+	// 重新生成默认 Token 不影响命名 Token
+	if _, _, err := tokenSvc.RegenerateToken("my-app", "upload", "admin"); err != nil {
+		t.Fatalf("RegenerateToken failed: %v", err)
+	}
+	if _, err := tokenSvc.ValidateToken(tokenValue); err != nil {
+		t.Errorf("Named token should survive default token regeneration: %v", err)
+	}
+	if err := tokenSvc.RevokeProgramToken("other-app", betaToken.TokenID); err == nil {
+		t.Error("Should not revoke a token through another program")
+	}
+	if err := tokenSvc.RevokeProgramToken("my-app", betaToken.TokenID); err != nil {
+		t.Fatalf("RevokeProgramToken failed: %v", err)
+	}
+	if _, err := tokenSvc.ValidateToken(tokenValue); err == nil {
+		t.Error("Revoked token should be invalid")
+	}
+}
+
 func TestTokenService_RevokeToken(t *testing.T) {
 	db := setupTestDB(t)
 	tokenSvc := NewTokenService(db)
@@ -124,3 +199,113 @@ func TestTokenService_ExpiredToken(t *testing.T) {
 		t.Errorf("Error message mismatch: got %s, want 'token expired'", err.Error())
 	}
 }
+
+func TestTokenService_RotateToken(t *testing.T) {
+	db := setupTestDB(t)
+	tokenSvc := NewTokenService(db)
+
+	_, oldValue, err := tokenSvc.GenerateToken("rotating-app", "download", "system")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	// 未设置 SecretBox 时无法保存替换 Token
+	if _, _, err := tokenSvc.RotateToken("rotating-app", "download", "admin", time.Hour); !errors.Is(err, ErrMasterKeyMissing) {
+		t.Fatalf("Expected ErrMasterKeyMissing, got %v", err)
+	}
+	tokenSvc.SetSecretBox(NewSecretBox("test-master-key"))
+
+	newToken, newValue, err := tokenSvc.RotateToken("rotating-app", "download", "admin", time.Hour)
+	if err != nil {
+		t.Fatalf("RotateToken failed: %v", err)
+	}
+
+	// 宽限期内新旧 Token 都有效
+	old, err := tokenSvc.ValidateToken(oldValue)
+	if err != nil {
+		t.Fatalf("Old token should stay valid during the grace period: %v", err)
+	}
+	if _, err := tokenSvc.ValidateToken(newValue); err != nil {
+		t.Fatalf("New token should be valid: %v", err)
+	}
+	if old.ReplacedBy != newToken.TokenID || old.RotatedAt == nil || old.ExpiresAt == nil {
+		t.Errorf("Old token not marked as rotated: %+v", old)
+	}
+	if old.Replacement == "" || old.Replacement == newValue {
+		t.Error("Replacement should be stored sealed")
+	}
+
+	update, err := tokenSvc.TokenReplacement(old)
+	if err != nil || update == nil || update.Token != newValue {
+		t.Fatalf("Expected replacement token, got %+v, %v", update, err)
+	}
+
+	// 再次轮换：旧 Token 改为指向最新 Token，宽限期不会延长
+	expiresAt := *old.ExpiresAt
+	_, latestValue, err := tokenSvc.RotateToken("rotating-app", "download", "admin", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Second RotateToken failed: %v", err)
+	}
+	old, _ = tokenSvc.ValidateToken(oldValue)
+	if update, _ := tokenSvc.TokenReplacement(old); update == nil || update.Token != latestValue {
+		t.Errorf("Expected old token to be re-pointed at the latest token, got %+v", update)
+	}
+	if !old.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Grace period should not be extended: %v -> %v", expiresAt, old.ExpiresAt)
+	}
+
+	// 宽限期为 0 时立即撤销
+	if _, _, err := tokenSvc.RotateToken("rotating-app", "download", "admin", 0); err != nil {
+		t.Fatalf("Immediate RotateToken failed: %v", err)
+	}
+	for _, value := range []string{oldValue, newValue, latestValue} {
+		if _, err := tokenSvc.ValidateToken(value); err == nil {
+			t.Error("Immediate rotation should revoke previous tokens")
+		}
+	}
+}
+
+func TestTokenService_RotatePackagerToken(t *testing.T) {
+	db := setupTestDB(t)
+	tokenSvc := NewTokenService(db)
+	tokenSvc.SetSecretBox(NewSecretBox("test-master-key"))
+
+	_, defaultValue, err := tokenSvc.GenerateToken("packaged-app", "download", "system")
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	first, firstValue, err := tokenSvc.RotatePackagerToken("packaged-app", "download")
+	if err != nil {
+		t.Fatalf("RotatePackagerToken failed: %v", err)
+	}
+	if first.Name != PackagerTokenName("download") {
+		t.Errorf("Packager token name = %q", first.Name)
+	}
+
+	// 再次打包：上一个客户端包的 Token 进入宽限期并指向新 Token，程序默认 Token 不受影响
+	_, secondValue, err := tokenSvc.RotatePackagerToken("packaged-app", "download")
+	if err != nil {
+		t.Fatalf("Second RotatePackagerToken failed: %v", err)
+	}
+	old, err := tokenSvc.ValidateToken(firstValue)
+	if err != nil {
+		t.Fatalf("Previous packager token should stay valid during the grace period: %v", err)
+	}
+	if old.ExpiresAt == nil || old.ExpiresAt.After(time.Now().Add(DefaultTokenGracePeriod)) {
+		t.Errorf("Previous packager token should expire after the grace period, expiresAt = %v", old.ExpiresAt)
+	}
+	if update, _ := tokenSvc.TokenReplacement(old); update == nil || update.Token != secondValue {
+		t.Errorf("Expected the previous packager token to be replaced by the new one, got %+v", update)
+	}
+	if token, err := tokenSvc.ValidateToken(defaultValue); err != nil || token.ExpiresAt != nil {
+		t.Errorf("Default token should not be rotated: %+v, %v", token, err)
+	}
+
+	// 同时只有一个未进入轮换的打包器 Token
+	var active int64
+	db.Model(&models.Token{}).Where("program_id = ? AND name = ? AND expires_at IS NULL", "packaged-app", PackagerTokenName("download")).Count(&active)
+	if active != 1 {
+		t.Errorf("Expected one current packager token, got %d", active)
+	}
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"gorm.io/gorm"
+)
+
+// 分片上传默认参数
+const (
+	DefaultChunkSize  = 8 << 20  // 8MB
+	MaxChunkSize      = 64 << 20 // 64MB
+	UploadSessionTTL  = 24 * time.Hour
+	UploadStatusOpen  = "active"
+	UploadStatusDone  = "completed"
+	uploadDirName     = ".uploads"
+	assembledFileName = "assembled"
+)
+
+var (
+	// ErrFileTooLarge 文件超过服务器允许的大小
+	ErrFileTooLarge = errors.New("file exceeds maximum allowed size")
+	// ErrUploadExpired 上传会话已过期或已完成
+	ErrUploadExpired = errors.New("upload session is no longer active")
+	// ErrChunkInvalid 分片序号或大小不符合会话参数
+	ErrChunkInvalid = errors.New("invalid chunk")
+	// ErrChunkChecksum 分片校验和不匹配
+	ErrChunkChecksum = errors.New("chunk checksum mismatch")
+	// ErrUploadIncomplete 仍有分片未上传
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrFileHashMismatch 合并后的文件哈希与声明不一致
+	ErrFileHashMismatch = errors.New("file hash mismatch")
+)
+
+// UploadService 分片上传会话管理
+type UploadService struct {
+	db          *gorm.DB
+	storageSvc  *StorageService
+	maxFileSize int64
+}
+
+// NewUploadService 创建分片上传服务，maxFileSize <= 0 表示不限制
+func NewUploadService(db *gorm.DB, storageSvc *StorageService, maxFileSize int64) *UploadService {
+	return &UploadService{
+		db:          db,
+		storageSvc:  storageSvc,
+		maxFileSize: maxFileSize,
+	}
+}
+
+// MaxFileSize 返回允许上传的最大文件大小（0 表示不限制）
+func (s *UploadService) MaxFileSize() int64 {
+	return s.maxFileSize
+}
+
+// CreateSession 创建上传会话
+func (s *UploadService) CreateSession(session *models.UploadSession) error {
+	if session.TotalSize <= 0 {
+		return fmt.Errorf("%w: total size must be positive", ErrChunkInvalid)
+	}
+	if s.maxFileSize > 0 && session.TotalSize > s.maxFileSize {
+		return ErrFileTooLarge
+	}
+	if session.ChunkSize == 0 {
+		session.ChunkSize = DefaultChunkSize
+	}
+	if session.ChunkSize < 0 || session.ChunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: chunk size must be between 1 and %d", ErrChunkInvalid, MaxChunkSize)
+	}
+	if session.Rollout == 0 {
+		session.Rollout = 100
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	session.ID = hex.EncodeToString(id)
+	session.Status = UploadStatusOpen
+	session.ExpiresAt = time.Now().Add(UploadSessionTTL)
+
+	if err := os.MkdirAll(s.sessionDir(session.ID), 0755); err != nil {
+		return err
+	}
+	return s.db.Create(session).Error
+}
+
+// GetSession 获取程序的上传会话
+func (s *UploadService) GetSession(programID, id string) (*models.UploadSession, error) {
+	var session models.UploadSession
+	err := s.db.Where("id = ? AND program_id = ?", id, programID).First(&session).Error
+	return &session, err
+}
+
+// ChunkCount 会话需要的分片数量
+func ChunkCount(session *models.UploadSession) int {
+	return int((session.TotalSize + session.ChunkSize - 1) / session.ChunkSize)
+}
+
+// expectedChunkSize 指定分片应有的大小（最后一片可能较小）
+func expectedChunkSize(session *models.UploadSession, index int) int64 {
+	offset := int64(index) * session.ChunkSize
+	if remaining := session.TotalSize - offset; remaining < session.ChunkSize {
+		return remaining
+	}
+	return session.ChunkSize
+}
+
+// SaveChunk 保存一个分片，重复上传同一序号会覆盖之前的内容
+func (s *UploadService) SaveChunk(session *models.UploadSession, index int, r io.Reader, checksum string) (*models.UploadChunk, error) {
+	if session.Status != UploadStatusOpen || time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	if index < 0 || index >= ChunkCount(session) {
+		return nil, fmt.Errorf("%w: index %d out of range", ErrChunkInvalid, index)
+	}
+	expected := expectedChunkSize(session, index)
+
+	chunkPath := s.chunkPath(session.ID, index)
+	tmpPath := chunkPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	hash := sha256.New()
+	// 多读 1 字节用于发现超长分片
+	size, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(r, expected+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size != expected {
+		return nil, fmt.Errorf("%w: chunk %d must be %d bytes, got %d", ErrChunkInvalid, index, expected, size)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if sum != checksum {
+		return nil, ErrChunkChecksum
+	}
+
+	if err := os.Rename(tmpPath, chunkPath); err != nil {
+		return nil, err
+	}
+
+	chunk := models.UploadChunk{
+		SessionID: session.ID,
+		Index:     index,
+		Offset:    int64(index) * session.ChunkSize,
+		Size:      size,
+		Hash:      sum,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ? AND `index` = ?", session.ID, index).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&chunk).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+// ListChunks 列出会话已接收的分片（按序号排序）
+func (s *UploadService) ListChunks(sessionID string) ([]models.UploadChunk, error) {
+	var chunks []models.UploadChunk
+	err := s.db.Where("session_id = ?", sessionID).Order("`index` ASC").Find(&chunks).Error
+	return chunks, err
+}
+
+// MissingChunks 返回尚未接收的分片序号
+func (s *UploadService) MissingChunks(session *models.UploadSession, chunks []models.UploadChunk) []int {
+	received := make(map[int]bool, len(chunks))
+	for _, c := range chunks {
+		received[c.Index] = true
+	}
+	missing := []int{}
+	for i := 0; i < ChunkCount(session); i++ {
+		if !received[i] {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Assemble 按顺序合并分片并原子地移动到版本目录
+//
+// 合并结果先写入会话目录，校验大小和哈希后再重命名为最终文件，
+// 因此版本目录中不会出现不完整的包。key 不为空时合并的同时加密（见 StorageService.Store）。
+func (s *UploadService) Assemble(session *models.UploadSession, fileName string, key []byte) (*StoredFile, error) {
+	if session.Status != UploadStatusOpen {
+		return nil, ErrUploadExpired
+	}
+	chunks, err := s.ListChunks(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.MissingChunks(session, chunks)) > 0 {
+		return nil, ErrUploadIncomplete
+	}
+
+	assembledPath := filepath.Join(s.sessionDir(session.ID), assembledFileName)
+	out, err := os.Create(assembledPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < ChunkCount(session); i++ {
+			if _, err := appendFile(pw, s.chunkPath(session.ID, i)); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	stored, err := WritePackage(out, pr, key)
+	pr.Close()
+	if err != nil {
+		out.Close()
+		os.Remove(assembledPath)
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(assembledPath)
+		return nil, err
+	}
+
+	if stored.Size != session.TotalSize {
+		os.Remove(assembledPath)
+		return nil, fmt.Errorf("%w: assembled %d bytes, expected %d", ErrChunkInvalid, stored.Size, session.TotalSize)
+	}
+	if session.FileHash != "" && session.FileHash != stored.Hash {
+		os.Remove(assembledPath)
+		return nil, ErrFileHashMismatch
+	}
+
+	if err := s.storageSvc.MoveFileAs(session.ProgramID, session.Channel, session.Version, fileName, assembledPath); err != nil {
+		os.Remove(assembledPath)
+		return nil, err
+	}
+
+	stored.FileName = fileName
+	return stored, nil
+}
+
+// Complete 标记会话已完成并清理分片
+func (s *UploadService) Complete(session *models.UploadSession) error {
+	now := time.Now()
+	if err := s.db.Model(session).Updates(map[string]interface{}{
+		"status":       UploadStatusDone,
+		"completed_at": &now,
+	}).Error; err != nil {
+		return err
+	}
+	return s.cleanup(session.ID)
+}
+
+// Abort 取消会话并删除已上传的分片
+func (s *UploadService) Abort(session *models.UploadSession) error {
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.ID).Delete(&models.UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(session).Error
+	}); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.sessionDir(session.ID))
+}
+
+// CleanupExpired 删除过期未完成的会话
+func (s *UploadService) CleanupExpired() (int, error) {
+	var sessions []models.UploadSession
+	if err := s.db.Where("status = ? AND expires_at < ?", UploadStatusOpen, time.Now()).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+	for i := range sessions {
+		if err := s.Abort(&sessions[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+func (s *UploadService) cleanup(sessionID string) error {
+	if err := s.db.Where("session_id = ?", sessionID).Delete(&models.UploadChunk{}).Error; err != nil {
+		return err
+	}
+	return os.RemoveAll(s.sessionDir(sessionID))
+}
+
+func (s *UploadService) sessionDir(id string) string {
+	return filepath.Join(s.storageSvc.basePath, uploadDirName, id)
+}
+
+func (s *UploadService) chunkPath(id string, index int) string {
+	return filepath.Join(s.sessionDir(id), strconv.Itoa(index)+".chunk")
+}
+
+func appendFile(w io.Writer, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
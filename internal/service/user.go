@@ -0,0 +1,360 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 管理后台用户角色
+const (
+	RoleOwner      = "owner"      // 全部权限，包括用户、管理 Token 以及程序的创建和删除
+	RoleMaintainer = "maintainer" // 可查看所有程序，可修改 Programs 中列出的程序
+	RoleViewer     = "viewer"     // 只读
+)
+
+// 管理操作所需的权限级别
+const (
+	PermRead  = "read"  // 查看
+	PermWrite = "write" // 修改程序的版本、Token、密钥，打包客户端
+	PermOwner = "owner" // 用户和管理 Token 管理、创建和删除程序
+)
+
+// minPasswordLength 管理员密码最小长度
+const minPasswordLength = 8
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUsernameRequired   = errors.New("username is required")
+	ErrInvalidRole        = errors.New("role must be owner, maintainer or viewer")
+	ErrWeakPassword       = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	ErrLastOwner          = errors.New("cannot remove the last active owner")
+)
+
+// dummyPasswordHash 用户不存在时也做一次 bcrypt 比较，避免通过响应时间探测用户名
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CreateUserRequest 创建管理后台用户的参数
+type CreateUserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"` // 为空时生成临时密码，首次登录后须修改
+	Role     string   `json:"role"`
+	Programs []string `json:"programs"` // maintainer 可修改的程序
+}
+
+// UpdateUserRequest 修改用户角色和状态，为 nil 的字段保持不变
+type UpdateUserRequest struct {
+	Role     *string   `json:"role"`
+	Programs *[]string `json:"programs"`
+	IsActive *bool     `json:"isActive"`
+}
+
+type UserService struct {
+	db           *gorm.DB
+	secrets      *SecretBox // 包装 TOTP 密钥
+	totpRequired bool
+}
+
+func NewUserService(db *gorm.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// UserCan 用户能否以 perm 权限操作程序（programID 为空表示不针对具体程序）
+func UserCan(user *models.AdminUser, perm, programID string) bool {
+	switch user.Role {
+	case RoleOwner:
+		return true
+	case RoleMaintainer:
+		switch perm {
+		case PermRead:
+			return true
+		case PermWrite:
+			for _, p := range user.Programs {
+				if p == programID && programID != "" {
+					return true
+				}
+			}
+		}
+	case RoleViewer:
+		return perm == PermRead
+	}
+	return false
+}
+
+func validRole(role string) bool {
+	return role == RoleOwner || role == RoleMaintainer || role == RoleViewer
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// temporaryPassword 生成随机临时密码
+func temporaryPassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CreateUser 创建用户；未提供密码时返回生成的临时密码（用户首次登录后须修改）
+func (s *UserService) CreateUser(req CreateUserRequest) (*models.AdminUser, string, error) {
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		return nil, "", ErrUsernameRequired
+	}
+	if !validRole(req.Role) {
+		return nil, "", ErrInvalidRole
+	}
+
+	password, temporary := req.Password, ""
+	if password == "" {
+		var err error
+		if temporary, err = temporaryPassword(); err != nil {
+			return nil, "", err
+		}
+		password = temporary
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user := &models.AdminUser{
+		Username:           username,
+		PasswordHash:       hash,
+		Role:               req.Role,
+		MustChangePassword: temporary != "",
+		IsActive:           true,
+	}
+	if req.Role == RoleMaintainer {
+		user.Programs = req.Programs
+	}
+
+	var count int64
+	if err := s.db.Model(&models.AdminUser{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, "", err
+	}
+	if count > 0 {
+		return nil, "", ErrUserExists
+	}
+	if err := s.db.Create(user).Error; err != nil {
+		return nil, "", err
+	}
+	return user, temporary, nil
+}
+
+// Authenticate 校验用户名和密码，成功时记录登录时间
+func (s *UserService) Authenticate(username, password string) (*models.AdminUser, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil || !user.IsActive {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	user.LastLoginAt = &now
+	s.db.Model(user).Update("last_login_at", now)
+	return user, nil
+}
+
+// GetUser 按用户名获取用户
+func (s *UserService) GetUser(username string) (*models.AdminUser, error) {
+	var user models.AdminUser
+	err := s.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers 列出所有用户
+func (s *UserService) ListUsers() ([]models.AdminUser, error) {
+	var users []models.AdminUser
+	err := s.db.Order("id").Find(&users).Error
+	return users, err
+}
+
+// CountUsers 用户总数
+func (s *UserService) CountUsers() (int64, error) {
+	var count int64
+	err := s.db.Model(&models.AdminUser{}).Count(&count).Error
+	return count, err
+}
+
+// UpdateUser 修改用户角色、可修改的程序和启用状态；不能降级或停用最后一个 owner
+func (s *UserService) UpdateUser(username string, req UpdateUserRequest) (*models.AdminUser, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	role := user.Role
+	if req.Role != nil {
+		if !validRole(*req.Role) {
+			return nil, ErrInvalidRole
+		}
+		role = *req.Role
+	}
+	removesOwner := user.Role == RoleOwner && (role != RoleOwner || (req.IsActive != nil && !*req.IsActive))
+
+	user.Role = role
+	if req.Programs != nil {
+		user.Programs = *req.Programs
+	}
+	if role != RoleMaintainer {
+		user.Programs = nil
+	}
+	if req.IsActive != nil {
+		user.IsActive = *req.IsActive
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if removesOwner {
+			if err := s.ensureOtherOwner(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return saveUserFields(tx, user, "role", "programs", "is_active")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(username)
+}
+
+// DeleteUser 删除用户；不能删除最后一个 owner
+func (s *UserService) DeleteUser(username string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if user.Role == RoleOwner {
+			if err := s.ensureOtherOwner(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(user).Error
+	})
+}
+
+// saveUserFields 更新用户的指定字段（包括零值）；使用结构体更新以便 JSON 序列化字段生效
+func saveUserFields(tx *gorm.DB, user *models.AdminUser, columns ...string) error {
+	return tx.Model(user).Select(columns).Updates(user).Error
+}
+
+// ensureOtherOwner 除 userID 外还有其他启用的 owner，否则返回 ErrLastOwner
+func (s *UserService) ensureOtherOwner(tx *gorm.DB, userID uint) error {
+	var count int64
+	if err := tx.Model(&models.AdminUser{}).
+		Where("role = ? AND is_active = ? AND id <> ?", RoleOwner, true, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// ChangePassword 用户修改自己的密码，需要提供当前密码
+func (s *UserService) ChangePassword(username, currentPassword, newPassword string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(user).Updates(map[string]interface{}{
+		"password_hash":        hash,
+		"must_change_password": false,
+	}).Error
+}
+
+// ResetPassword 强制重置用户密码，返回临时密码；用户下次登录后须先修改密码
+func (s *UserService) ResetPassword(username string) (string, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+	password, err := temporaryPassword()
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"password_hash":        hash,
+		"must_change_password": true,
+	}).Error; err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// BootstrapFromConfig 首次启动时将 config.yaml 中的管理员账号迁移为 owner
+//
+// 只在用户表为空且配置了用户名和密码时执行，返回是否创建了用户。
+func (s *UserService) BootstrapFromConfig(username, password string) (bool, error) {
+	if username == "" || password == "" {
+		return false, nil
+	}
+	count, err := s.CountUsers()
+	if err != nil || count > 0 {
+		return false, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, err
+	}
+	// 配置中的旧密码可能短于最小长度，迁移时保留原密码但要求首次登录后修改
+	user := &models.AdminUser{
+		Username:           username,
+		PasswordHash:       string(hash),
+		Role:               RoleOwner,
+		MustChangePassword: len(password) < minPasswordLength,
+		IsActive:           true,
+	}
+	if err := s.db.Create(user).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/totp"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestUserCan(t *testing.T) {
+	owner := &models.AdminUser{Role: RoleOwner}
+	maintainer := &models.AdminUser{Role: RoleMaintainer, Programs: []string{"app-a"}}
+	viewer := &models.AdminUser{Role: RoleViewer}
+
+	cases := []struct {
+		user      *models.AdminUser
+		perm      string
+		programID string
+		want      bool
+	}{
+		{owner, PermOwner, "", true},
+		{owner, PermWrite, "app-b", true},
+		{maintainer, PermRead, "app-b", true},
+		{maintainer, PermWrite, "app-a", true},
+		{maintainer, PermWrite, "app-b", false},
+		{maintainer, PermWrite, "", false},
+		{maintainer, PermOwner, "", false},
+		{viewer, PermRead, "app-a", true},
+		{viewer, PermWrite, "app-a", false},
+	}
+	for _, tc := range cases {
+		if got := UserCan(tc.user, tc.perm, tc.programID); got != tc.want {
+			t.Errorf("UserCan(%s, %s, %q) = %v, want %v", tc.user.Role, tc.perm, tc.programID, got, tc.want)
+		}
+	}
+}
+
+func newTestUserService(t *testing.T) *UserService {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.AdminUser{}); err != nil {
+		t.Fatalf("AutoMigrate failed: %v", err)
+	}
+	users := NewUserService(db)
+	users.SetSecretBox(NewSecretBox("test-master-key"))
+	return users
+}
+
+func TestUserService(t *testing.T) {
+	users := newTestUserService(t)
+
+	// 首次启动迁移配置中的账号，之后不再执行
+	if created, err := users.BootstrapFromConfig("admin", "admin123"); err != nil || !created {
+		t.Fatalf("BootstrapFromConfig failed: %v", err)
+	}
+	if created, _ := users.BootstrapFromConfig("other", "password1"); created {
+		t.Error("Bootstrap should only run on an empty user table")
+	}
+	owner, err := users.Authenticate("admin", "admin123")
+	if err != nil || owner.Role != RoleOwner {
+		t.Fatalf("Expected migrated owner to log in, got %v", err)
+	}
+	if _, err := users.Authenticate("admin", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+	if _, err := users.Authenticate("nobody", "admin123"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+
+	// 参数校验
+	if _, _, err := users.CreateUser(CreateUserRequest{Username: "x", Role: "root"}); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+	if _, _, err := users.CreateUser(CreateUserRequest{Username: "x", Password: "short", Role: RoleViewer}); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("Expected ErrWeakPassword, got %v", err)
+	}
+	if _, _, err := users.CreateUser(CreateUserRequest{Username: "admin", Password: "password1", Role: RoleViewer}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+
+	// 不提供密码时生成临时密码，须修改后才能正常使用
+	dev, temporary, err := users.CreateUser(CreateUserRequest{Username: "dev", Role: RoleMaintainer, Programs: []string{"app-a"}})
+	if err != nil || temporary == "" || !dev.MustChangePassword {
+		t.Fatalf("CreateUser failed: %+v, %v", dev, err)
+	}
+	if err := users.ChangePassword("dev", temporary, "new-password"); err != nil {
+		t.Fatalf("ChangePassword failed: %v", err)
+	}
+	dev, err = users.Authenticate("dev", "new-password")
+	if err != nil || dev.MustChangePassword || len(dev.Programs) != 1 || dev.Programs[0] != "app-a" {
+		t.Fatalf("Unexpected user after password change: %+v, %v", dev, err)
+	}
+
+	reset, err := users.ResetPassword("dev")
+	if err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if _, err := users.Authenticate("dev", "new-password"); err == nil {
+		t.Error("Old password should no longer work after reset")
+	}
+	if dev, err = users.Authenticate("dev", reset); err != nil || !dev.MustChangePassword {
+		t.Errorf("Expected reset password to require a change, got %+v, %v", dev, err)
+	}
+
+	programs := []string{"app-a", "app-b"}
+	if dev, err = users.UpdateUser("dev", UpdateUserRequest{Programs: &programs}); err != nil || len(dev.Programs) != 2 {
+		t.Fatalf("Expected programs to be updated, got %+v, %v", dev, err)
+	}
+
+	// 修改角色时清除程序范围；停用的用户无法登录
+	viewer, role := RoleViewer, RoleViewer
+	inactive := false
+	dev, err = users.UpdateUser("dev", UpdateUserRequest{Role: &role, IsActive: &inactive})
+	if err != nil || dev.Role != viewer || len(dev.Programs) != 0 || dev.IsActive {
+		t.Fatalf("UpdateUser failed: %+v, %v", dev, err)
+	}
+	if _, err := users.Authenticate("dev", reset); err == nil {
+		t.Error("Inactive user should not log in")
+	}
+
+	// 不能移除最后一个 owner
+	if _, err := users.UpdateUser("admin", UpdateUserRequest{Role: &viewer}); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner on demotion, got %v", err)
+	}
+	if err := users.DeleteUser("admin"); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("Expected ErrLastOwner on delete, got %v", err)
+	}
+	if err := users.DeleteUser("dev"); err != nil {
+		t.Errorf("DeleteUser failed: %v", err)
+	}
+	if _, err := users.GetUser("dev"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after delete, got %v", err)
+	}
+}
+
+func TestUserTOTP(t *testing.T) {
+	users := newTestUserService(t)
+	if _, _, err := users.CreateUser(CreateUserRequest{Username: "alice", Password: "alice-password", Role: RoleMaintainer}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	if _, err := users.ConfirmTOTPEnrollment("alice", "123456"); !errors.Is(err, ErrTOTPNotPending) {
+		t.Errorf("Expected ErrTOTPNotPending, got %v", err)
+	}
+	enrollment, err := users.BeginTOTPEnrollment("alice")
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment failed: %v", err)
+	}
+	stored, _ := users.GetUser("alice")
+	if stored.TOTPSecret == enrollment.Secret || stored.TOTPEnabled {
+		t.Fatal("TOTP secret must be stored sealed and not enabled before confirmation")
+	}
+
+	// 上一个时间步的验证码用于确认绑定，当前时间步的验证码用于登录
+	now := time.Now()
+	previous, _ := totp.Code(enrollment.Secret, totp.Step(now)-1)
+	current, _ := totp.Code(enrollment.Secret, totp.Step(now))
+	if _, err := users.ConfirmTOTPEnrollment("alice", "000000"); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Expected ErrInvalidTOTPCode, got %v", err)
+	}
+	codes, err := users.ConfirmTOTPEnrollment("alice", previous)
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("ConfirmTOTPEnrollment failed: %v", err)
+	}
+	if _, err := users.BeginTOTPEnrollment("alice"); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("Expected ErrTOTPAlreadyEnabled, got %v", err)
+	}
+
+	// 已使用的时间步不能重放
+	if _, err := users.VerifySecondFactor("alice", previous); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Expected replayed code to be rejected, got %v", err)
+	}
+	if _, err := users.VerifySecondFactor("alice", current); err != nil {
+		t.Errorf("VerifySecondFactor failed: %v", err)
+	}
+	if _, err := users.VerifySecondFactor("alice", current); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Expected code to be single-use, got %v", err)
+	}
+
+	// 恢复码只能使用一次
+	if _, err := users.VerifySecondFactor("alice", codes[0]); err != nil {
+		t.Errorf("Recovery code rejected: %v", err)
+	}
+	if _, err := users.VerifySecondFactor("alice", codes[0]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Expected used recovery code to be rejected, got %v", err)
+	}
+	fresh, err := users.RegenerateRecoveryCodes("alice", codes[1])
+	if err != nil || len(fresh) != recoveryCodeCount {
+		t.Fatalf("RegenerateRecoveryCodes failed: %v", err)
+	}
+	if _, err := users.VerifySecondFactor("alice", codes[2]); !errors.Is(err, ErrInvalidTOTPCode) {
+		t.Errorf("Expected old recovery codes to be invalidated, got %v", err)
+	}
+
+	// 角色要求两步验证时不能自行关闭
+	users.SetTOTPRequired(true)
+	if err := users.DisableTOTP("alice", fresh[0]); !errors.Is(err, ErrTOTPRequired) {
+		t.Errorf("Expected ErrTOTPRequired, got %v", err)
+	}
+	users.SetTOTPRequired(false)
+	if err := users.DisableTOTP("alice", fresh[0]); err != nil {
+		t.Fatalf("DisableTOTP failed: %v", err)
+	}
+	if _, err := users.VerifySecondFactor("alice", fresh[1]); !errors.Is(err, ErrTOTPNotEnabled) {
+		t.Errorf("Expected ErrTOTPNotEnabled after disabling, got %v", err)
+	}
+}
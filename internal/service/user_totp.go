@@ -0,0 +1,241 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/totp"
+)
+
+const (
+	// totpIssuer 验证器应用中显示的服务名称
+	totpIssuer = "Update Server"
+	// totpSecretInfo 派生 TOTP 密钥包装密钥的 HKDF info
+	totpSecretInfo = "admin-totp-secret"
+	// totpSkew 允许前后各一个时间步的时钟偏差
+	totpSkew = 1
+	// recoveryCodeCount 每次生成的恢复码数量
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotPending     = errors.New("no pending two-factor enrollment, start one first")
+	ErrInvalidTOTPCode    = errors.New("invalid verification code")
+	ErrTOTPRequired       = errors.New("two-factor authentication is required for this role")
+)
+
+// TOTPEnrollment 待确认的 TOTP 绑定信息，用于在验证器应用中添加账号
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI，前端渲染为二维码
+}
+
+// SetSecretBox 设置 TOTP 密钥的包装器
+func (s *UserService) SetSecretBox(box *SecretBox) {
+	s.secrets = box
+}
+
+// SetTOTPRequired 设置可发布、轮换密钥的角色（owner、maintainer）是否必须启用两步验证
+func (s *UserService) SetTOTPRequired(required bool) {
+	s.totpRequired = required
+}
+
+// TOTPRequired 用户的角色是否必须启用两步验证
+func (s *UserService) TOTPRequired(user *models.AdminUser) bool {
+	return s.totpRequired && (user.Role == RoleOwner || user.Role == RoleMaintainer)
+}
+
+// NeedsTOTPEnrollment 用户必须启用两步验证但尚未启用
+func (s *UserService) NeedsTOTPEnrollment(user *models.AdminUser) bool {
+	return s.TOTPRequired(user) && !user.TOTPEnabled
+}
+
+// BeginTOTPEnrollment 生成新的 TOTP 密钥，须用 ConfirmTOTPEnrollment 校验一次验证码后才启用
+func (s *UserService) BeginTOTPEnrollment(username string) (*TOTPEnrollment, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.secrets.SealValue("", totpSecretInfo, user.Username, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("totp_secret", sealed).Error; err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(secret, totpIssuer, user.Username),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment 校验验证码后启用两步验证，返回一次性恢复码（只显示一次）
+func (s *UserService) ConfirmTOTPEnrollment(username, code string) ([]string, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotPending
+	}
+	secret, err := s.openTOTPSecret(user)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	if err := saveUserFields(s.db, user, "totp_enabled", "totp_last_step", "recovery_codes"); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor 校验 TOTP 验证码或恢复码；恢复码使用后失效，同一验证码不能重复使用
+func (s *UserService) VerifySecondFactor(username, code string) (*models.AdminUser, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	secret, err := s.openTOTPSecret(user)
+	if err != nil {
+		return nil, err
+	}
+	if step, ok := totp.Validate(secret, code, time.Now(), totpSkew); ok {
+		// 条件更新：并发请求中同一时间步只有一个能通过
+		result := s.db.Model(&models.AdminUser{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrInvalidTOTPCode
+		}
+		return user, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range user.RecoveryCodes {
+		if h != hash {
+			continue
+		}
+		user.RecoveryCodes = append(append([]string{}, user.RecoveryCodes[:i]...), user.RecoveryCodes[i+1:]...)
+		if err := saveUserFields(s.db, user, "recovery_codes"); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	return nil, ErrInvalidTOTPCode
+}
+
+// RegenerateRecoveryCodes 校验验证码后生成新的恢复码，旧恢复码全部失效
+func (s *UserService) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	user, err := s.VerifySecondFactor(username, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.RecoveryCodes = hashes
+	if err := saveUserFields(s.db, user, "recovery_codes"); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP 用户校验验证码后关闭自己的两步验证；角色要求两步验证时不允许关闭
+func (s *UserService) DisableTOTP(username, code string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if s.TOTPRequired(user) {
+		return ErrTOTPRequired
+	}
+	if _, err := s.VerifySecondFactor(username, code); err != nil {
+		return err
+	}
+	return s.ResetTOTP(username)
+}
+
+// ResetTOTP 清除用户的两步验证（owner 为丢失验证器的用户操作），用户需重新绑定
+func (s *UserService) ResetTOTP(username string) error {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	return saveUserFields(s.db, user, "totp_secret", "totp_enabled", "totp_last_step", "recovery_codes")
+}
+
+func (s *UserService) openTOTPSecret(user *models.AdminUser) (string, error) {
+	secret, err := s.secrets.OpenValue("", totpSecretInfo, user.Username, user.TOTPSecret)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// generateRecoveryCodes 生成恢复码（xxxxx-xxxxx）及其哈希
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 恢复码忽略大小写、空格和连字符
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
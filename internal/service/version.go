@@ -1,13 +1,50 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
 	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/semver"
 	"gorm.io/gorm"
 )
 
+// 最新版本选择策略
+const (
+	LatestStrategySemver      = "semver"       // 按语义化版本号取最高版本（默认）
+	LatestStrategyPublishDate = "publish_date" // 按发布时间取最新版本（旧行为）
+)
+
+var (
+	// ErrInvalidStrategy 不支持的最新版本选择策略
+	ErrInvalidStrategy = errors.New("invalid strategy")
+	// ErrVersionExists 目标通道已存在相同版本
+	ErrVersionExists = errors.New("version already exists")
+	// ErrVersionFilesInUse 版本目录仍被晋升到其他通道的版本引用
+	ErrVersionFilesInUse = errors.New("version files are still used by a promoted version, delete it first")
+	// ErrVersionYanked 版本已被撤回
+	ErrVersionYanked = errors.New("version is yanked")
+)
+
+// LatestVersionQuery 最新版本查询条件
+type LatestVersionQuery struct {
+	ProgramID string
+	Channel   string
+	Strategy  string
+	InstallID string   // 客户端安装 ID，用于灰度分组
+	Platform  Platform // 客户端平台，为空时返回主文件
+	Current   string   // 客户端当前版本，提供时按升级路径返回下一跳
+}
+
 type VersionService struct {
 	db         *gorm.DB
 	storageSvc *StorageService
+	secrets    *SecretBox // 解开数据库中包装存储的程序密钥
 }
 
 func NewVersionService(db *gorm.DB, storageSvc *StorageService) *VersionService {
@@ -17,13 +54,113 @@ func NewVersionService(db *gorm.DB, storageSvc *StorageService) *VersionService
 	}
 }
 
-// GetLatestVersion 获取最新版本
+// SetSecretBox 设置解开存储密钥所用的 SecretBox（见 CryptoService.SecretBox）
+func (s *VersionService) SetSecretBox(box *SecretBox) {
+	s.secrets = box
+}
+
+// GetLatestVersion 获取最新版本（按语义化版本号）
 func (s *VersionService) GetLatestVersion(programID, channel string) (*models.Version, error) {
-	var version models.Version
-	err := s.db.Where("program_id = ? AND channel = ?", programID, channel).
-		Order("publish_date DESC").
-		First(&version).Error
-	return &version, err
+	return s.FindLatestVersion(LatestVersionQuery{ProgramID: programID, Channel: channel})
+}
+
+// FindLatestVersion 按查询条件获取最新版本
+//
+// 提供 Current 时返回升级路径上的下一跳，见 FindUpgradePath。
+func (s *VersionService) FindLatestVersion(q LatestVersionQuery) (*models.Version, error) {
+	next, _, err := s.FindUpgradePath(q)
+	return next, err
+}
+
+// FindUpgradePath 按查询条件获取升级路径的下一跳和最终的最新版本
+//
+// 未发布和已撤回的版本不参与选择；候选版本按策略排序后依次检查灰度比例和平台制品，
+// 未命中灰度或缺少对应平台包时，客户端会拿到上一个可用版本。
+//
+// 提供 Current 时，下一跳是按策略排序的第一个满足以下条件的候选版本：
+// 高于当前版本、当前版本不低于其 MinUpgradeFrom、与当前版本之间没有必经版本（RequiredStep）。
+// 必经版本不受灰度和平台限制地阻挡更高的版本，未命中的客户端停在必经版本之前。
+// 没有可走的下一跳时返回不高于当前版本的最新候选版本（客户端视为已是最新）。
+func (s *VersionService) FindUpgradePath(q LatestVersionQuery) (next, latest *models.Version, err error) {
+	var versions []models.Version
+	if err := s.db.Preload("Artifacts").Scopes(publishedScope).
+		Where("program_id = ? AND channel = ? AND yanked = ?", q.ProgramID, q.Channel, false).
+		Find(&versions).Error; err != nil {
+		return nil, nil, err
+	}
+
+	switch q.Strategy {
+	case "", LatestStrategySemver:
+		SortVersionsDesc(versions)
+	case LatestStrategyPublishDate:
+		sort.SliceStable(versions, func(i, j int) bool {
+			return versions[i].PublishDate.After(versions[j].PublishDate)
+		})
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidStrategy, q.Strategy)
+	}
+
+	var candidates []*models.Version
+	for i := range versions {
+		if InRolloutCohort(q.InstallID, versions[i].Version, versions[i].RolloutPercentage) &&
+			SupportsPlatform(&versions[i], q.Platform) {
+			candidates = append(candidates, &versions[i])
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	latest = candidates[0]
+	if q.Current == "" {
+		return latest, latest, nil
+	}
+
+	var steps []string
+	for _, v := range versions {
+		if v.RequiredStep && semver.Compare(v.Version, q.Current) > 0 {
+			steps = append(steps, v.Version)
+		}
+	}
+
+	var fallback *models.Version
+	for _, v := range candidates {
+		if semver.Compare(v.Version, q.Current) <= 0 {
+			if fallback == nil {
+				fallback = v
+			}
+			continue
+		}
+		if canUpgradeDirectly(q.Current, v, steps) {
+			return v, latest, nil
+		}
+	}
+	if fallback == nil {
+		return nil, latest, gorm.ErrRecordNotFound
+	}
+	return fallback, latest, nil
+}
+
+// canUpgradeDirectly 判断当前版本能否直接升级到目标版本
+func canUpgradeDirectly(current string, target *models.Version, steps []string) bool {
+	if target.MinUpgradeFrom != "" && semver.Compare(current, target.MinUpgradeFrom) < 0 {
+		return false
+	}
+	for _, step := range steps {
+		if semver.Compare(step, target.Version) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SortVersionsDesc 按语义化版本号从高到低排序，版本相同时较新发布的在前
+func SortVersionsDesc(versions []models.Version) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if c := semver.Compare(versions[i].Version, versions[j].Version); c != 0 {
+			return c > 0
+		}
+		return versions[i].PublishDate.After(versions[j].PublishDate)
+	})
 }
 
 // GetVersionList 获取版本列表
@@ -33,7 +170,7 @@ func (s *VersionService) GetVersionList(programID, channel string) ([]models.Ver
 	if channel != "" {
 		query = query.Where("channel = ?", channel)
 	}
-	err := query.Order("publish_date DESC").Find(&versions).Error
+	err := query.Preload("Artifacts").Order("publish_date DESC").Find(&versions).Error
 	return versions, err
 }
 
@@ -45,7 +182,7 @@ func (s *VersionService) ListByProgramID(programID string) ([]models.Version, er
 // GetVersion 获取指定版本
 func (s *VersionService) GetVersion(programID, channel, version string) (*models.Version, error) {
 	var v models.Version
-	err := s.db.Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version).First(&v).Error
+	err := s.db.Preload("Artifacts").Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version).First(&v).Error
 	return &v, err
 }
 
@@ -58,9 +195,267 @@ func (s *VersionService) CreateVersion(version *models.Version) error {
 	return s.db.Create(version).Error
 }
 
+// PublishVersion 校验签名、创建版本记录，并在后台生成增量补丁
+//
+// 补丁生成需要解压和比较多个完整包，不在上传请求中等待；生成完成前或失败时客户端回退到完整下载。
+func (s *VersionService) PublishVersion(v *models.Version) error {
+	if err := s.VerifySignatures(v); err != nil {
+		return err
+	}
+	if err := s.CreateVersion(v); err != nil {
+		return err
+	}
+	s.schedulePatches(*v)
+	return nil
+}
+
+// PromoteVersion 将版本从来源通道晋升到目标通道
+//
+// 新记录指向来源版本的同一存储文件，保留哈希、大小、发布说明和强制更新标记，不会重复写入文件。
+// 签名覆盖通道，因此 signatures 需提供目标通道的新签名，键为平台标识，主文件为空字符串。
+func (s *VersionService) PromoteVersion(programID, version, fromChannel, toChannel, promotedBy string, signatures map[string]string) (*models.Version, error) {
+	publicKey, err := s.SigningPublicKey(programID)
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted *models.Version
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var source models.Version
+		if err := tx.Preload("Artifacts").Where("program_id = ? AND channel = ? AND version = ?", programID, fromChannel, version).
+			First(&source).Error; err != nil {
+			return err
+		}
+		if source.Yanked {
+			return ErrVersionYanked
+		}
+		if !IsPublished(&source) {
+			return ErrVersionNotPublished
+		}
+
+		var count int64
+		if err := tx.Model(&models.Version{}).
+			Where("program_id = ? AND channel = ? AND version = ?", programID, toChannel, version).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrVersionExists
+		}
+
+		now := time.Now()
+		promoted = &models.Version{
+			ProgramID:    source.ProgramID,
+			Version:      source.Version,
+			Channel:      toChannel,
+			FileName:     source.FileName,
+			FilePath:     source.FilePath,
+			FileSize:     source.FileSize,
+			FileHash:     source.FileHash,
+			ReleaseNotes: source.ReleaseNotes,
+			PublishDate:  now,
+			Mandatory:    source.Mandatory,
+			PromotedFrom: fromChannel,
+			PromotedBy:   promotedBy,
+			PromotedAt:   &now,
+			Signature:    signatures[""],
+
+			MinUpgradeFrom:  source.MinUpgradeFrom,
+			RequiredStep:    source.RequiredStep,
+			Encrypted:       source.Encrypted,
+			EncryptedSize:   source.EncryptedSize,
+			EncryptedHash:   source.EncryptedHash,
+			EncryptionKeyID: source.EncryptionKeyID,
+		}
+		for _, a := range source.Artifacts {
+			promoted.Artifacts = append(promoted.Artifacts, models.VersionArtifact{
+				OS:        a.OS,
+				Arch:      a.Arch,
+				Variant:   a.Variant,
+				FileName:  a.FileName,
+				FilePath:  a.FilePath,
+				FileSize:  a.FileSize,
+				FileHash:  a.FileHash,
+				Signature: signatures[Platform{OS: a.OS, Arch: a.Arch, Variant: a.Variant}.String()],
+
+				Encrypted:       a.Encrypted,
+				EncryptedSize:   a.EncryptedSize,
+				EncryptedHash:   a.EncryptedHash,
+				EncryptionKeyID: a.EncryptionKeyID,
+			})
+		}
+		if err := verifyVersionSignatures(publicKey, promoted); err != nil {
+			return err
+		}
+		return tx.Create(promoted).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, nil
+}
+
+// IsArtifactShared 检查版本的存储目录是否还被其他版本记录或其平台制品引用（例如晋升产生的记录）
+func (s *VersionService) IsArtifactShared(v *models.Version) (bool, error) {
+	return s.isDirReferenced(v.FilePath, v.ID)
+}
+
+// CheckUploadTarget 检查版本是否可以上传到存储目录
+//
+// 版本已存在时返回 ErrVersionExists。来源版本删除后晋升记录仍指向原目录，
+// 此时重新上传会覆盖晋升版本正在下发的文件，返回 ErrVersionFilesInUse。
+func (s *VersionService) CheckUploadTarget(programID, channel, version string) error {
+	if _, err := s.GetVersion(programID, channel, version); err == nil {
+		return ErrVersionExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	inUse, err := s.isDirReferenced(s.storageSvc.VersionDir(programID, channel, version), 0)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrVersionFilesInUse
+	}
+	return nil
+}
+
+// DiscardUploadFiles 删除上传失败的版本已写入存储目录的文件
+//
+// 目录已被版本记录引用（例如并发上传已成功创建同一版本）时保留文件。
+func (s *VersionService) DiscardUploadFiles(programID, channel, version string) error {
+	if err := s.CheckUploadTarget(programID, channel, version); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.storageSvc.VersionDir(programID, channel, version))
+}
+
+// isDirReferenced 检查除 versionID 外是否有版本记录或平台制品的文件位于 dir
+func (s *VersionService) isDirReferenced(dir string, versionID uint) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.Version{}).
+		Where("id <> ? AND file_path = ?", versionID, dir).
+		Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	err := s.db.Model(&models.VersionArtifact{}).
+		Where("version_id <> ? AND file_path = ?", versionID, dir).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// SetRolloutPercentage 修改版本的灰度比例
+func (s *VersionService) SetRolloutPercentage(programID, channel, version string, percentage int) error {
+	if percentage < 0 || percentage > 100 {
+		return fmt.Errorf("rollout percentage must be between 0 and 100, got %d", percentage)
+	}
+	result := s.db.Model(&models.Version{}).
+		Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version).
+		Update("rollout_percentage", percentage)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateVersionRequest 修改版本信息，为 nil 的字段保持不变
+//
+// 只包含不在签名清单中的字段；mandatory 等已签名的字段需重新上传或晋升。
+type UpdateVersionRequest struct {
+	ReleaseNotes   *string `json:"releaseNotes"`
+	MinUpgradeFrom *string `json:"minUpgradeFrom"`
+	RequiredStep   *bool   `json:"requiredStep"`
+}
+
+// UpdateVersion 修改版本的发布说明和升级路径设置
+func (s *VersionService) UpdateVersion(programID, channel, version string, req UpdateVersionRequest) (*models.Version, error) {
+	updates := map[string]interface{}{}
+	if req.ReleaseNotes != nil {
+		updates["release_notes"] = *req.ReleaseNotes
+	}
+	if req.MinUpgradeFrom != nil {
+		updates["min_upgrade_from"] = *req.MinUpgradeFrom
+	}
+	if req.RequiredStep != nil {
+		updates["required_step"] = *req.RequiredStep
+	}
+	v, err := s.GetVersion(programID, channel, version)
+	if err != nil || len(updates) == 0 {
+		return v, err
+	}
+	if err := s.db.Model(v).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return s.GetVersion(programID, channel, version)
+}
+
+// YankVersion 撤回版本（保留记录和文件，仅不再作为最新版本下发）
+func (s *VersionService) YankVersion(programID, channel, version, reason string) (*models.Version, error) {
+	now := time.Now()
+	return s.updateYanked(programID, channel, version, map[string]interface{}{
+		"yanked":      true,
+		"yank_reason": reason,
+		"yanked_at":   &now,
+	})
+}
+
+// UnyankVersion 取消撤回
+func (s *VersionService) UnyankVersion(programID, channel, version string) (*models.Version, error) {
+	return s.updateYanked(programID, channel, version, map[string]interface{}{
+		"yanked":      false,
+		"yank_reason": "",
+		"yanked_at":   nil,
+	})
+}
+
+func (s *VersionService) updateYanked(programID, channel, version string, updates map[string]interface{}) (*models.Version, error) {
+	v, err := s.GetVersion(programID, channel, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(v).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return s.GetVersion(programID, channel, version)
+}
+
+// InRolloutCohort 判断安装 ID 是否命中版本的灰度范围
+//
+// 分桶由 sha256(version + ":" + installID) 确定，同一安装在同一版本上的结果始终一致；
+// 比例提高时已命中的安装保持命中。未提供安装 ID 的客户端只能拿到全量发布的版本。
+func InRolloutCohort(installID, version string, percentage int) bool {
+	if percentage >= 100 {
+		return true
+	}
+	if percentage <= 0 || installID == "" {
+		return false
+	}
+	return RolloutBucket(installID, version) < percentage
+}
+
+// RolloutBucket 计算安装 ID 在指定版本上的灰度分桶（0-99）
+func RolloutBucket(installID, version string) int {
+	sum := sha256.Sum256([]byte(version + ":" + installID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
 // DeleteVersion 删除版本（硬删除，允许重新上传相同版本）
 func (s *VersionService) DeleteVersion(programID, channel, version string) error {
-	return s.db.Unscoped().Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version).Delete(&models.Version{}).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Unscoped().Model(&models.Version{}).
+			Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version)
+		if err := tx.Where("version_id IN (?)", query.Select("id")).Delete(&models.VersionArtifact{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("version_id IN (?)", query.Select("id")).Delete(&models.VersionPatch{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("program_id = ? AND channel = ? AND version = ?", programID, channel, version).Delete(&models.Version{}).Error
+	})
 }
 
 // IncrementDownloadCount 增加下载计数
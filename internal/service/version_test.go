@@ -0,0 +1,242 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"docufiller-update-server/internal/models"
+)
+
+func TestInRolloutCohort(t *testing.T) {
+	if !InRolloutCohort("", "1.0.0", 100) {
+		t.Error("Full rollout should include clients without install id")
+	}
+	if InRolloutCohort("", "1.0.0", 50) {
+		t.Error("Partial rollout should exclude clients without install id")
+	}
+	if InRolloutCohort("install-1", "1.0.0", 0) {
+		t.Error("Zero rollout should exclude every client")
+	}
+
+	// 相同输入结果稳定，比例提高时已命中的安装保持命中
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("install-%d", i)
+		in5 := InRolloutCohort(id, "2.0.0", 5)
+		if in5 != InRolloutCohort(id, "2.0.0", 5) {
+			t.Fatalf("Cohort membership for %s is not deterministic", id)
+		}
+		if in5 && !InRolloutCohort(id, "2.0.0", 25) {
+			t.Fatalf("%s left the cohort when rollout increased", id)
+		}
+		if InRolloutCohort(id, "2.0.0", 25) {
+			hits++
+		}
+	}
+	if hits < 150 || hits > 350 {
+		t.Errorf("Expected roughly 25%% of installs in cohort, got %d/1000", hits)
+	}
+}
+
+func TestVersionService_FindLatestVersionRollout(t *testing.T) {
+	db := setupTestDB(t)
+	db.AutoMigrate(&models.Version{}, &models.VersionArtifact{}, &models.VersionPatch{})
+	versionSvc := NewVersionService(db, nil)
+
+	programID := fmt.Sprintf("rollout-%d", time.Now().UnixNano())
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if err := versionSvc.CreateVersion(&models.Version{
+			ProgramID:   programID,
+			Version:     v,
+			Channel:     "stable",
+			FileName:    v + ".zip",
+			FilePath:    v,
+			FileHash:    v,
+			PublishDate: time.Now(),
+		}); err != nil {
+			t.Fatalf("CreateVersion failed: %v", err)
+		}
+	}
+	if err := versionSvc.SetRolloutPercentage(programID, "stable", "1.1.0", 0); err != nil {
+		t.Fatalf("SetRolloutPercentage failed: %v", err)
+	}
+
+	latest, err := versionSvc.FindLatestVersion(LatestVersionQuery{ProgramID: programID, Channel: "stable", InstallID: "install-1"})
+	if err != nil {
+		t.Fatalf("FindLatestVersion failed: %v", err)
+	}
+	if latest.Version != "1.0.0" {
+		t.Errorf("Client outside cohort should get 1.0.0, got %s", latest.Version)
+	}
+
+	if err := versionSvc.SetRolloutPercentage(programID, "stable", "1.1.0", 100); err != nil {
+		t.Fatalf("SetRolloutPercentage failed: %v", err)
+	}
+	latest, err = versionSvc.FindLatestVersion(LatestVersionQuery{ProgramID: programID, Channel: "stable", InstallID: "install-1"})
+	if err != nil {
+		t.Fatalf("FindLatestVersion failed: %v", err)
+	}
+	if latest.Version != "1.1.0" {
+		t.Errorf("Full rollout should return 1.1.0, got %s", latest.Version)
+	}
+
+	if err := versionSvc.SetRolloutPercentage(programID, "stable", "9.9.9", 50); err == nil {
+		t.Error("SetRolloutPercentage should fail for unknown version")
+	}
+}
+
+func TestResolveReleaseStatus(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		status    string
+		publishAt *time.Time
+		want      string
+		wantErr   bool
+	}{
+		{"", nil, VersionStatusPublished, false},
+		{"", &future, VersionStatusScheduled, false},
+		{"", &past, VersionStatusPublished, false},
+		{VersionStatusDraft, nil, VersionStatusDraft, false},
+		{VersionStatusDraft, &future, "", true},
+		{VersionStatusScheduled, nil, "", true},
+		{VersionStatusPublished, &future, "", true},
+		{"live", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, _, err := ResolveReleaseStatus(tt.status, tt.publishAt, now)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveReleaseStatus(%q, %v) = %q, %v; want %q, error %v", tt.status, tt.publishAt, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestVersionService_PublishDueVersions(t *testing.T) {
+	db := setupTestDB(t)
+	db.AutoMigrate(&models.Version{}, &models.VersionArtifact{}, &models.VersionPatch{})
+	versionSvc := NewVersionService(db, nil)
+
+	programID := fmt.Sprintf("schedule-%d", time.Now().UnixNano())
+	publishAt := time.Now().Add(time.Hour)
+	for _, v := range []struct {
+		version string
+		status  string
+	}{
+		{"1.0.0", VersionStatusPublished},
+		{"1.1.0", VersionStatusScheduled},
+		{"1.2.0", VersionStatusDraft},
+	} {
+		version := &models.Version{
+			ProgramID:   programID,
+			Version:     v.version,
+			Channel:     "stable",
+			FileName:    v.version + ".zip",
+			FilePath:    v.version,
+			FileHash:    v.version,
+			PublishDate: time.Now(),
+			Status:      v.status,
+		}
+		if v.status == VersionStatusScheduled {
+			version.PublishAt = &publishAt
+		}
+		if err := versionSvc.CreateVersion(version); err != nil {
+			t.Fatalf("CreateVersion failed: %v", err)
+		}
+	}
+
+	latest, err := versionSvc.GetLatestVersion(programID, "stable")
+	if err != nil || latest.Version != "1.0.0" {
+		t.Fatalf("Expected 1.0.0 before schedule, got %v, %v", latest, err)
+	}
+
+	if published, _ := versionSvc.PublishDueVersions(time.Now()); len(published) != 0 {
+		t.Errorf("Nothing should be due yet, published %d", len(published))
+	}
+
+	published, err := versionSvc.PublishDueVersions(publishAt.Add(time.Second))
+	if err != nil || len(published) != 1 || published[0].Version != "1.1.0" {
+		t.Fatalf("Expected 1.1.0 to be published, got %v, %v", published, err)
+	}
+
+	latest, _ = versionSvc.GetLatestVersion(programID, "stable")
+	if latest.Version != "1.1.0" || !latest.PublishDate.Equal(publishAt) {
+		t.Errorf("Expected 1.1.0 published at %v, got %s at %v", publishAt, latest.Version, latest.PublishDate)
+	}
+
+	list, _ := versionSvc.ListPublished(programID, "")
+	if len(list) != 2 {
+		t.Errorf("Draft should stay hidden, got %d published versions", len(list))
+	}
+}
+
+func TestVersionService_FindUpgradePath(t *testing.T) {
+	db := setupTestDB(t)
+	db.AutoMigrate(&models.Version{}, &models.VersionArtifact{}, &models.VersionPatch{}, &models.ChannelPolicy{})
+	versionSvc := NewVersionService(db, nil)
+
+	programID := fmt.Sprintf("path-%d", time.Now().UnixNano())
+	for _, v := range []struct {
+		version        string
+		minUpgradeFrom string
+		requiredStep   bool
+	}{
+		{"1.0.0", "", false},
+		{"1.5.0", "", false},
+		{"2.0.0", "", true},
+		{"2.1.0", "", false},
+		{"3.0.0", "2.1.0", false},
+	} {
+		if err := versionSvc.CreateVersion(&models.Version{
+			ProgramID:      programID,
+			Version:        v.version,
+			Channel:        "stable",
+			FileName:       v.version + ".zip",
+			FilePath:       v.version,
+			FileHash:       v.version,
+			PublishDate:    time.Now(),
+			MinUpgradeFrom: v.minUpgradeFrom,
+			RequiredStep:   v.requiredStep,
+		}); err != nil {
+			t.Fatalf("CreateVersion failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		current string
+		want    string
+	}{
+		{"", "3.0.0"},
+		{"1.0.0", "2.0.0"}, // 必经版本 2.0.0 挡住更高的版本
+		{"2.0.0", "2.1.0"}, // 3.0.0 要求至少 2.1.0
+		{"2.1.0", "3.0.0"},
+		{"3.0.0", "3.0.0"},
+	}
+	for _, tt := range tests {
+		next, latest, err := versionSvc.FindUpgradePath(LatestVersionQuery{ProgramID: programID, Channel: "stable", Current: tt.current})
+		if err != nil {
+			t.Fatalf("FindUpgradePath(%q) failed: %v", tt.current, err)
+		}
+		if next.Version != tt.want || latest.Version != "3.0.0" {
+			t.Errorf("FindUpgradePath(%q) = %s -> %s, want %s -> 3.0.0", tt.current, next.Version, latest.Version, tt.want)
+		}
+	}
+
+	policy, err := versionSvc.SetMinSupportedVersion(programID, "stable", "2.0.0")
+	if err != nil {
+		t.Fatalf("SetMinSupportedVersion failed: %v", err)
+	}
+	if !BelowMinSupported(policy, "1.5.0") || BelowMinSupported(policy, "2.0.0") {
+		t.Errorf("Unexpected BelowMinSupported result for policy %q", policy.MinSupportedVersion)
+	}
+	if _, err := versionSvc.SetMinSupportedVersion(programID, "stable", "abc"); !errors.Is(err, ErrInvalidVersion) {
+		t.Errorf("Expected ErrInvalidVersion, got %v", err)
+	}
+	policy, _ = versionSvc.SetMinSupportedVersion(programID, "stable", "")
+	if BelowMinSupported(policy, "1.0.0") {
+		t.Error("Cleared policy should not force updates")
+	}
+}
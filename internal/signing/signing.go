@@ -0,0 +1,105 @@
+// Package signing 使用 Ed25519 对发布清单签名和验签
+//
+// 私钥由发布方离线保管，服务端和客户端只持有公钥；服务端被攻破时，
+// 攻击者无法同时替换包和签名。密钥和签名均使用标准 base64 编码。
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidKey 密钥格式错误
+	ErrInvalidKey = errors.New("signing: invalid key")
+	// ErrInvalidSignature 签名与清单不匹配
+	ErrInvalidSignature = errors.New("signing: invalid signature")
+)
+
+// Manifest 发布清单：签名覆盖的版本信息
+type Manifest struct {
+	ProgramID string
+	Version   string
+	Channel   string
+	Platform  string // 平台制品（os-arch[-variant]），主文件为空
+	FileHash  string // SHA256（十六进制）
+	FileSize  int64
+	Mandatory bool
+}
+
+// Bytes 返回清单的规范化字节序列（签名与验签的输入）
+func (m Manifest) Bytes() []byte {
+	var b strings.Builder
+	b.WriteString("update-manifest-v1\n")
+	b.WriteString("program=" + m.ProgramID + "\n")
+	b.WriteString("version=" + m.Version + "\n")
+	b.WriteString("channel=" + m.Channel + "\n")
+	b.WriteString("platform=" + m.Platform + "\n")
+	b.WriteString("sha256=" + strings.ToLower(m.FileHash) + "\n")
+	b.WriteString("size=" + strconv.FormatInt(m.FileSize, 10) + "\n")
+	b.WriteString("mandatory=" + strconv.FormatBool(m.Mandatory) + "\n")
+	return []byte(b.String())
+}
+
+// GenerateKey 生成新的密钥对，返回 base64 编码的公钥和私钥
+func GenerateKey() (publicKey, privateKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv), nil
+}
+
+// ParsePublicKey 解析 base64 编码的公钥
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key must be %d bytes base64", ErrInvalidKey, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParsePrivateKey 解析 base64 编码的私钥（64 字节私钥或 32 字节种子）
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	switch len(key) {
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	}
+	return nil, fmt.Errorf("%w: private key must be %d or %d bytes base64", ErrInvalidKey, ed25519.PrivateKeySize, ed25519.SeedSize)
+}
+
+// Sign 使用私钥对清单签名，返回 base64 签名
+func Sign(privateKey string, m Manifest) (string, error) {
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.Bytes())), nil
+}
+
+// Verify 使用公钥校验清单签名
+func Verify(publicKey string, m Manifest, signature string) error {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(key, m.Bytes(), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
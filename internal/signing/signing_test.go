@@ -0,0 +1,59 @@
+package signing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	m := Manifest{
+		ProgramID: "docufiller",
+		Version:   "1.2.0",
+		Channel:   "stable",
+		FileHash:  "ab12",
+		FileSize:  1024,
+		Mandatory: true,
+	}
+	sig, err := Sign(priv, m)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := Verify(pub, m, sig); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	// 任一字段被篡改都应校验失败
+	tampered := []Manifest{m, m, m, m, m}
+	tampered[0].FileHash = "cd34"
+	tampered[1].FileSize = 2048
+	tampered[2].Mandatory = false
+	tampered[3].Channel = "beta"
+	tampered[4].Platform = "linux-amd64"
+	for i, tm := range tampered {
+		if err := Verify(pub, tm, sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("tampered manifest %d: expected ErrInvalidSignature, got %v", i, err)
+		}
+	}
+
+	otherPub, _, _ := GenerateKey()
+	if err := Verify(otherPub, m, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong key: expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	if _, err := ParsePublicKey("not-base64!"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+	if _, err := ParsePrivateKey("AAAA"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+	if err := Verify("AAAA", Manifest{}, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+}
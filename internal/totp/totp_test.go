@@ -0,0 +1,68 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量（取 8 位验证码的后 6 位）
+func TestCodeRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := Code(secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code failed: %v", err)
+		}
+		if code != v.code {
+			t.Errorf("Code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	previous, _ := Code(secret, Step(now)-1)
+	old, _ := Code(secret, Step(now)-3)
+
+	if step, ok := Validate(secret, previous, now, 1); !ok || step != Step(now)-1 {
+		t.Errorf("Expected previous step to be accepted, got %d, %v", step, ok)
+	}
+	if _, ok := Validate(secret, old, now, 1); ok {
+		t.Error("Code outside the skew window should be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Error("Short code should be rejected")
+	}
+	if _, ok := Validate("not base32!", "123456", now, 1); ok {
+		t.Error("Invalid secret should be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("JBSWY3DPEHPK3PXP", "Update Server", "alice")
+	if !strings.HasPrefix(uri, "otpauth://totp/Update%20Server:alice?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	for _, part := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Update+Server", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %s missing %s", uri, part)
+		}
+	}
+}
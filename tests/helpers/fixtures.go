@@ -127,19 +127,20 @@ func getAdminToken(t TestingT, srv *TestServer) string {
 	return srv.AdminToken
 }
 
-// GetProgramTokens returns the upload and download tokens for a program
+// GetProgramTokens issues fresh upload and download tokens for a program
+// (only token hashes are stored, so existing tokens cannot be read back)
 func GetProgramTokens(t TestingT, srv *TestServer, programID string) (upload, download string) {
-	uploadToken, err := srv.TokenService.GetToken(programID, "upload", "system")
+	_, upload, err := srv.TokenService.GenerateToken(programID, "upload", "system")
 	if err != nil {
-		t.Fatalf("Failed to get upload token: %v", err)
+		t.Fatalf("Failed to generate upload token: %v", err)
 	}
 
-	downloadToken, err := srv.TokenService.GetToken(programID, "download", "system")
+	_, download, err = srv.TokenService.GenerateToken(programID, "download", "system")
 	if err != nil {
-		t.Fatalf("Failed to get download token: %v", err)
+		t.Fatalf("Failed to generate download token: %v", err)
 	}
 
-	return uploadToken.TokenValue, downloadToken.TokenValue
+	return upload, download
 }
 
 // createMultipartUploadRequest creates a multipart form upload request
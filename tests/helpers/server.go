@@ -27,6 +27,7 @@ type TestServer struct {
 	URL               string
 	TempDir           string
 	TokenService      *service.TokenService
+	UserService       *service.UserService
 	ProgramService    *service.ProgramService
 	VersionService    *service.VersionService
 	StorageBasePath   string
@@ -60,9 +61,17 @@ func setupTestServer(t TestingT) *TestServer {
 	// Auto migrate all models
 	err = db.AutoMigrate(
 		&models.Version{},
+		&models.VersionArtifact{},
+		&models.VersionPatch{},
+		&models.UploadSession{},
+		&models.UploadChunk{},
+		&models.ChannelPolicy{},
 		&models.Program{},
 		&models.Token{},
 		&models.EncryptionKey{},
+		&models.AdminUser{},
+		&models.AdminSession{},
+		&models.LoginAttempt{},
 	)
 	if err != nil {
 		t.Fatalf("Failed to migrate database: %v", err)
@@ -81,6 +90,9 @@ func setupTestServer(t TestingT) *TestServer {
 			Port: 18080,
 			Host: "127.0.0.1",
 		},
+		Storage: config.StorageConfig{
+			MaxFileSize: 10 << 20,
+		},
 		Crypto: config.CryptoConfig{
 			MasterKey: "test-master-key-for-testing-32bytes!!",
 		},
@@ -97,6 +109,7 @@ func setupTestServer(t TestingT) *TestServer {
 	// Add Session middleware
 	store := cookie.NewStore([]byte(cfg.Crypto.MasterKey))
 	router.Use(sessions.Sessions("admin-session", store))
+	router.Use(handler.SecureSessionCookie(sessions.Options{Path: "/", HttpOnly: true}))
 
 	// Initialize services
 	tokenSvc := service.NewTokenService(db)
@@ -111,9 +124,18 @@ func setupTestServer(t TestingT) *TestServer {
 
 	// Initialize services
 	storageService := service.NewStorageService(storageBasePath)
+	tokenSvc.SetSecretBox(cryptoSvc.SecretBox())
 	programService := service.NewProgramService(db)
+	programService.SetSecretBox(cryptoSvc.SecretBox())
+	cryptoSvc.SetKeyStore(programService)
 	versionService := service.NewVersionService(db, storageService)
+	versionService.SetSecretBox(cryptoSvc.SecretBox())
 	clientPackagerService := service.NewClientPackager(programService, cfg)
+	userService := service.NewUserService(db)
+	userService.SetSecretBox(cryptoSvc.SecretBox())
+	if _, err := userService.BootstrapFromConfig(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+		t.Fatalf("Failed to create admin user: %v", err)
+	}
 
 	// Get a test server port
 	serverURL := "http://127.0.0.1:18080"
@@ -125,13 +147,14 @@ func setupTestServer(t TestingT) *TestServer {
 		URL:             serverURL,
 		TempDir:         tempDir,
 		TokenService:    tokenSvc,
+		UserService:     userService,
 		ProgramService:  programService,
 		VersionService:  versionService,
 		StorageBasePath: storageBasePath,
 	}
 
 	// Setup routes
-	setupTestRoutes(router, db, cfg, tokenSvc, authMiddleware, cryptoMiddleware, programService, versionService, clientPackagerService, storageBasePath)
+	setupTestRoutes(router, db, cfg, tokenSvc, userService, authMiddleware, cryptoMiddleware, programService, versionService, clientPackagerService, storageBasePath)
 
 	return ts
 }
@@ -197,6 +220,7 @@ func setupTestRoutes(
 	db *gorm.DB,
 	cfg *config.Config,
 	tokenSvc *service.TokenService,
+	userService *service.UserService,
 	authMiddleware *middleware.AuthMiddleware,
 	cryptoMiddleware *middleware.CryptoMiddleware,
 	programService *service.ProgramService,
@@ -208,7 +232,9 @@ func setupTestRoutes(
 	r.Use(cryptoMiddleware.Process())
 
 	// Initialize handlers
-	authHandler := handler.NewAuthHandler(cfg)
+	sessionService := service.NewSessionService(db)
+	authHandler := handler.NewAuthHandler(cfg, userService, sessionService, service.NewLoginThrottle(db))
+	userHandler := handler.NewUserHandler(userService, sessionService)
 
 	adminHandler := handler.NewAdminHandler(
 		programService,
@@ -217,21 +243,79 @@ func setupTestRoutes(
 		clientPackagerService,
 	)
 
-	versionHandler := handler.NewVersionHandler(db)
-
-	// Admin API routes
+	versionHandler := handler.NewVersionHandler(db, versionService.GetStorageService())
+	versionHandler.SetMaxFileSize(cfg.Storage.MaxFileSize)
+	versionHandler.SetSecretBox(service.NewSecretBox(cfg.Crypto.MasterKey))
+	uploadHandler := handler.NewUploadHandler(db, versionService.GetStorageService(), cfg.Storage.MaxFileSize)
+	uploadHandler.SetSecretBox(service.NewSecretBox(cfg.Crypto.MasterKey))
+
+	// Login routes need no authentication
+	r.POST("/api/admin/login", authHandler.Login)
+	r.POST("/api/admin/login/totp", authHandler.LoginTOTP)
+
+	// Admin API routes: requests with an Authorization header must carry an admin
+	// token; requests with a session cookie go through the real session check;
+	// requests with neither stand in for a logged-in owner
+	sessionAuth := handler.AuthMiddleware(userService, sessionService)
+	r.POST("/admin/logout", sessionAuth, authHandler.Logout)
+	requireOwner := handler.RequirePermission(service.PermOwner)
+	requireWrite := handler.RequirePermission(service.PermWrite)
 	adminAPI := r.Group("/api/admin")
+	adminAPI.Use(authMiddleware.RequireAdminOr(func(c *gin.Context) {
+		if _, err := c.Cookie("admin-session"); err == nil {
+			sessionAuth(c)
+			return
+		}
+		c.Next()
+	}))
 	{
-		adminAPI.POST("/login", authHandler.Login)
+		adminAPI.GET("/me", userHandler.GetCurrentUser)
+		adminAPI.PUT("/me/password", userHandler.ChangePassword)
+		adminAPI.GET("/me/sessions", userHandler.ListMySessions)
+		adminAPI.DELETE("/me/sessions", userHandler.RevokeMyOtherSessions)
+		adminAPI.DELETE("/me/sessions/:sessionId", userHandler.RevokeMySession)
+		adminAPI.POST("/me/totp", userHandler.BeginTOTP)
+		adminAPI.POST("/me/totp/verify", userHandler.ConfirmTOTP)
+		adminAPI.POST("/me/totp/recovery-codes", userHandler.RegenerateRecoveryCodes)
+		adminAPI.DELETE("/me/totp", userHandler.DisableTOTP)
+		adminAPI.GET("/users", requireOwner, userHandler.ListUsers)
+		adminAPI.POST("/users", requireOwner, userHandler.CreateUser)
+		adminAPI.PUT("/users/:username", requireOwner, userHandler.UpdateUser)
+		adminAPI.DELETE("/users/:username", requireOwner, userHandler.DeleteUser)
+		adminAPI.POST("/users/:username/reset-password", requireOwner, userHandler.ResetPassword)
+		adminAPI.DELETE("/users/:username/totp", requireOwner, userHandler.ResetTOTP)
+		adminAPI.GET("/users/:username/sessions", requireOwner, userHandler.ListUserSessions)
+		adminAPI.DELETE("/users/:username/sessions", requireOwner, userHandler.RevokeUserSessions)
 		adminAPI.GET("/stats", adminHandler.GetStats)
 		adminAPI.GET("/programs", adminHandler.ListPrograms)
-		adminAPI.POST("/programs", adminHandler.CreateProgram)
+		adminAPI.POST("/programs", requireOwner, adminHandler.CreateProgram)
 		adminAPI.GET("/programs/:programId", adminHandler.GetProgramDetail)
-		adminAPI.DELETE("/programs/:programId", adminHandler.DeleteProgram)
+		adminAPI.PUT("/programs/:programId", adminHandler.UpdateProgram)
+		adminAPI.DELETE("/programs/:programId", requireOwner, adminHandler.DeleteProgram)
 		adminAPI.GET("/programs/:programId/versions", adminHandler.ListVersions)
-		adminAPI.DELETE("/programs/:programId/versions/:version", adminHandler.DeleteVersion)
-		adminAPI.GET("/programs/:programId/client/publish", adminHandler.DownloadPublishClient)
-		adminAPI.GET("/programs/:programId/client/update", adminHandler.DownloadUpdateClient)
+		adminAPI.PUT("/programs/:programId/versions/:version", adminHandler.UpdateVersion)
+		adminAPI.DELETE("/programs/:programId/versions/:version", versionHandler.DeleteVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/yank", versionHandler.YankVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/unyank", versionHandler.UnyankVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/publish", versionHandler.PublishVersion)
+		adminAPI.POST("/programs/:programId/versions/:version/promote", versionHandler.PromoteVersion)
+		adminAPI.PUT("/programs/:programId/versions/:version/rollout", adminHandler.SetRollout)
+		adminAPI.GET("/programs/:programId/client/publish", requireWrite, adminHandler.DownloadPublishClient)
+		adminAPI.GET("/programs/:programId/client/update", requireWrite, adminHandler.DownloadUpdateClient)
+		adminAPI.PUT("/programs/:programId/signing-key", adminHandler.SetSigningKey)
+		adminAPI.POST("/programs/:programId/tokens/regenerate", adminHandler.RegenerateToken)
+		adminAPI.GET("/programs/:programId/tokens", adminHandler.ListTokens)
+		adminAPI.POST("/programs/:programId/tokens", adminHandler.CreateToken)
+		adminAPI.GET("/programs/:programId/tokens/:tokenId", adminHandler.GetTokenDetail)
+		adminAPI.DELETE("/programs/:programId/tokens/:tokenId", adminHandler.RevokeToken)
+		adminAPI.GET("/tokens", requireOwner, adminHandler.ListAdminTokens)
+		adminAPI.POST("/tokens", requireOwner, adminHandler.CreateAdminToken)
+		adminAPI.DELETE("/tokens/:tokenId", requireOwner, adminHandler.RevokeAdminToken)
+		adminAPI.POST("/programs/:programId/encryption/regenerate", adminHandler.RegenerateEncryptionKey)
+		adminAPI.GET("/programs/:programId/encryption/keys", adminHandler.ListEncryptionKeys)
+		adminAPI.PUT("/programs/:programId/encryption", adminHandler.SetPackageEncryption)
+		adminAPI.GET("/programs/:programId/channels/:channel/policy", adminHandler.GetChannelPolicy)
+		adminAPI.PUT("/programs/:programId/channels/:channel/policy", adminHandler.SetChannelPolicy)
 	}
 
 	// Public API routes
@@ -240,9 +324,9 @@ func setupTestRoutes(
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
-		public.GET("/programs/:programId/versions/latest", versionHandler.GetLatestVersion)
+		public.GET("/programs/:programId/versions/latest", authMiddleware.OptionalAuth(), versionHandler.GetLatestVersion)
 		public.GET("/programs/:programId/versions", versionHandler.GetVersionList)
-		public.GET("/programs/:programId/versions/:channel/:version", versionHandler.GetVersionDetail)
+		public.GET("/programs/:programId/versions/:channel/:version", authMiddleware.OptionalAuth(), versionHandler.GetVersionDetail)
 	}
 
 	// Authenticated upload routes
@@ -251,18 +335,31 @@ func setupTestRoutes(
 	{
 		upload.POST("/programs/:programId/versions", versionHandler.UploadVersion)
 		upload.DELETE("/programs/:programId/versions/:channel/:version", versionHandler.DeleteVersion)
+		upload.POST("/programs/:programId/versions/:version/yank", versionHandler.YankVersion)
+		upload.POST("/programs/:programId/versions/:version/unyank", versionHandler.UnyankVersion)
+		upload.POST("/programs/:programId/versions/:version/promote", versionHandler.PromoteVersion)
+		upload.POST("/programs/:programId/versions/:version/publish", versionHandler.PublishVersion)
+		upload.POST("/programs/:programId/uploads", uploadHandler.CreateSession)
+		upload.GET("/programs/:programId/uploads/:uploadId", uploadHandler.GetSession)
+		upload.PUT("/programs/:programId/uploads/:uploadId/chunks/:index", uploadHandler.UploadChunk)
+		upload.POST("/programs/:programId/uploads/:uploadId/complete", uploadHandler.CompleteSession)
+		upload.DELETE("/programs/:programId/uploads/:uploadId", uploadHandler.AbortSession)
 	}
 
 	// Authenticated download routes
 	download := r.Group("/api")
-	download.Use(authMiddleware.RequireDownload())
+	download.Use(authMiddleware.RequireDownloadOrUpload())
 	{
 		download.GET("/programs/:programId/download/:channel/:version", versionHandler.DownloadFile)
+		download.GET("/programs/:programId/patches/:channel/:version/:from", versionHandler.DownloadPatch)
 	}
 }
 
 // Close cleans up test resources
 func (srv *TestServer) Close() error {
+	// Wait for background patch generation still using the database and temp dir
+	service.WaitPatchJobs()
+
 	// Close database connection
 	if srv.DB != nil {
 		sqlDB, _ := srv.DB.DB()
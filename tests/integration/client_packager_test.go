@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"regexp"
 	"testing"
 
 	"docufiller-update-server/internal/config"
@@ -16,6 +17,17 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// assertConfigToken checks that the packaged config carries a freshly issued,
+// valid token of the given type (tokens are stored as hashes and cannot be read back)
+func assertConfigToken(t *testing.T, srv *helpers.TestServer, configContent, tokenType string) {
+	m := regexp.MustCompile(`token: "([0-9a-f]{64})"`).FindStringSubmatch(configContent)
+	require.Len(t, m, 2, "Token should be in config")
+	token, err := srv.TokenService.ValidateToken(m[1])
+	require.NoError(t, err)
+	assert.Equal(t, tokenType, token.TokenType)
+	assert.Equal(t, srv.TestProgramID, token.ProgramID)
+}
+
 func TestClientPackager_GeneratePublishClient(t *testing.T) {
 	// Setup test server
 	srv := helpers.SetupTestServerWithProgram(t)
@@ -83,7 +95,7 @@ func TestClientPackager_GeneratePublishClient(t *testing.T) {
 	configContent := buf.String()
 	assert.Contains(t, configContent, "http://test-server:8080", "Server URL should be from config")
 	assert.Contains(t, configContent, srv.TestProgramID, "Program ID should be in config")
-	assert.Contains(t, configContent, srv.TestUploadToken, "Upload token should be in config")
+	assertConfigToken(t, srv, configContent, "upload")
 	assert.Contains(t, configContent, encryptionKey, "Encryption key should be in config")
 	assert.NotContains(t, configContent, "encryption_key: \"\"", "Encryption key should not be empty")
 	assert.NotContains(t, configContent, "http://localhost:8080", "Should not contain hardcoded localhost:8080")
@@ -142,7 +154,7 @@ func TestClientPackager_GenerateUpdateClient(t *testing.T) {
 
 	configContent := buf.String()
 	assert.Contains(t, configContent, "http://test-update-server:9090", "Server URL should be from config")
-	assert.Contains(t, configContent, srv.TestDownloadToken, "Download token should be in config")
+	assertConfigToken(t, srv, configContent, "download")
 	assert.Contains(t, configContent, encryptionKey, "Encryption key should be in config")
 	assert.NotContains(t, configContent, "encryption_key: \"\"", "Encryption key should not be empty")
 	assert.NotContains(t, configContent, "http://localhost:8080", "Should not contain hardcoded localhost:8080")
@@ -0,0 +1,181 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/internal/models"
+	"docufiller-update-server/internal/pkgcrypt"
+	"docufiller-update-server/internal/service"
+	"docufiller-update-server/tests/helpers"
+)
+
+// TestEncryptedPackages tests that packages are encrypted at rest and served as authenticated ciphertext
+func TestEncryptedPackages(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "EncryptedApp", "For encryption testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	setEncryption := func(enabled bool) int {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/admin/programs/%s/encryption", programID), bytes.NewBufferString(fmt.Sprintf(`{"enabled":%v}`, enabled)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+srv.AdminToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Encryption needs a key in encryption_keys
+	assert.Equal(t, http.StatusBadRequest, setEncryption(true))
+	key := make([]byte, 32)
+	rand.Read(key)
+	assert.NoError(t, srv.DB.Create(&models.EncryptionKey{ProgramID: programID, KeyData: base64.StdEncoding.EncodeToString(key)}).Error)
+	assert.Equal(t, http.StatusOK, setEncryption(true))
+
+	zipPath := filepath.Join(t.TempDir(), "encrypted.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "encrypted release"})
+	plain, _ := os.ReadFile(zipPath)
+	uploadTestPackage(t, srv, programID, uploadToken, "1.0.0", zipPath)
+
+	var v models.Version
+	assert.NoError(t, srv.DB.Where("program_id = ? AND version = ?", programID, "1.0.0").First(&v).Error)
+	assert.True(t, v.Encrypted)
+	assert.Equal(t, sha256Hex(plain), v.FileHash)
+	assert.Equal(t, int64(len(plain)), v.FileSize)
+
+	// The stored file is ciphertext matching the recorded encrypted hash
+	stored, err := os.ReadFile(srv.VersionService.GetStorageService().GetVersionFilePath(&v))
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, stored)
+	assert.Equal(t, v.EncryptedHash, sha256Hex(stored))
+	assert.Equal(t, v.EncryptedSize, int64(len(stored)))
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/download/stable/1.0.0", programID), nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"`+v.EncryptedHash+`"`, w.Header().Get("ETag"))
+	assert.Equal(t, "aes-gcm-chunked", w.Header().Get("X-Package-Encrypted"))
+
+	r, err := pkgcrypt.NewReader(w.Body, pkgcrypt.NewKeyring(key))
+	assert.NoError(t, err)
+	decrypted, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+}
+
+// TestEncryptionKeyRotation tests that rotating a key keeps old packages readable and hands the new key to old clients
+func TestEncryptionKeyRotation(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "RotatingApp", "For key rotation testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	oldKey := make([]byte, 32)
+	rand.Read(oldKey)
+	assert.NoError(t, srv.DB.Create(&models.EncryptionKey{
+		ProgramID: programID,
+		KeyID:     pkgcrypt.KeyID(oldKey),
+		KeyData:   base64.StdEncoding.EncodeToString(oldKey),
+		Status:    "active",
+	}).Error)
+	assert.NoError(t, srv.ProgramService.SetPackageEncryption(programID, true))
+
+	zipPath := filepath.Join(t.TempDir(), "v1.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "before rotation"})
+	uploadTestPackage(t, srv, programID, uploadToken, "1.0.0", zipPath)
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/admin/programs/%s/encryption/regenerate", programID), bytes.NewBufferString(`{"gracePeriodHours":24}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+srv.AdminToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rotated struct {
+		KeyID         string `json:"keyId"`
+		EncryptionKey string `json:"encryptionKey"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	newKey, err := pkgcrypt.ParseKey(rotated.EncryptionKey)
+	assert.NoError(t, err)
+	assert.Equal(t, pkgcrypt.KeyID(newKey), rotated.KeyID)
+
+	// The new key is stored wrapped under the master key, never in plaintext
+	var stored models.EncryptionKey
+	assert.NoError(t, srv.DB.Where("program_id = ? AND key_id = ?", programID, rotated.KeyID).First(&stored).Error)
+	assert.True(t, service.IsSealedKey(stored.KeyData))
+	assert.NotContains(t, stored.KeyData, rotated.EncryptionKey)
+
+	zipPath = filepath.Join(t.TempDir(), "v2.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "after rotation"})
+	uploadTestPackage(t, srv, programID, uploadToken, "2.0.0", zipPath)
+
+	// A client holding only the old key gets the new key wrapped under it
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+	req.Header.Set("X-Encryption-Key-ID", pkgcrypt.KeyID(oldKey))
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var latest struct {
+		Version         string `json:"version"`
+		EncryptionKeyID string `json:"encryptionKeyId"`
+		KeyUpdate       *struct {
+			KeyID       string `json:"keyId"`
+			WrappedKey  string `json:"wrappedKey"`
+			WrappedWith string `json:"wrappedWith"`
+		} `json:"keyUpdate"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	assert.Equal(t, "2.0.0", latest.Version)
+	assert.Equal(t, rotated.KeyID, latest.EncryptionKeyID)
+	if assert.NotNil(t, latest.KeyUpdate) {
+		assert.Equal(t, pkgcrypt.KeyID(oldKey), latest.KeyUpdate.WrappedWith)
+		unwrapped, err := pkgcrypt.UnwrapKey(oldKey, latest.KeyUpdate.KeyID, latest.KeyUpdate.WrappedKey)
+		assert.NoError(t, err)
+		assert.Equal(t, newKey, unwrapped)
+	}
+
+	// A client on the new key gets no update
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+	req.Header.Set("X-Encryption-Key-ID", rotated.KeyID)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "keyUpdate")
+
+	// Packages from before and after the rotation both decrypt with the client's keyring
+	keys := pkgcrypt.NewKeyring(oldKey, newKey)
+	for _, version := range []string{"1.0.0", "2.0.0"} {
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/download/stable/%s", programID, version), nil)
+		req.Header.Set("Authorization", "Bearer "+downloadToken)
+		w = httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		r, err := pkgcrypt.NewReader(w.Body, keys)
+		if assert.NoError(t, err, version) {
+			_, err = io.ReadAll(r)
+			assert.NoError(t, err, version)
+		}
+	}
+
+	keyList, err := srv.ProgramService.ListEncryptionKeys(programID)
+	assert.NoError(t, err)
+	if assert.Len(t, keyList, 2) {
+		assert.Equal(t, "active", keyList[0].Status)
+		assert.Equal(t, "retiring", keyList[1].Status)
+	}
+}
@@ -52,14 +52,16 @@ func TestCreateProgram(t *testing.T) {
 	srv.DB.Where("program_id = ?", programID).First(&program)
 	assert.Equal(t, "TestApp", program.Name)
 
-	// Verify auto-generated tokens
-	uploadToken, err := srv.TokenService.GetToken(programID, "upload", "system")
-	assert.NoError(t, err)
-	assert.NotEmpty(t, uploadToken.TokenValue)
-
-	downloadToken, err := srv.TokenService.GetToken(programID, "download", "system")
-	assert.NoError(t, err)
-	assert.NotEmpty(t, downloadToken.TokenValue)
+	// Verify auto-generated tokens: returned once, stored only as hashes
+	for _, field := range []string{"UploadToken", "DownloadToken"} {
+		tokenValue, _ := response[field].(string)
+		assert.NotEmpty(t, tokenValue, field+" should be in response")
+		token, err := srv.TokenService.ValidateToken(tokenValue)
+		if assert.NoError(t, err) {
+			assert.Equal(t, programID, token.ProgramID)
+			assert.Empty(t, token.TokenValue)
+		}
+	}
 
 	// Verify encryption key from response
 	encryptionKey, ok := response["EncryptionKey"].(string)
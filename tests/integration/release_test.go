@@ -0,0 +1,109 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/tests/helpers"
+)
+
+// TestDraftRelease tests that drafts stay hidden until published
+func TestDraftRelease(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "DraftApp", "For release lifecycle testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	zipPath := filepath.Join(t.TempDir(), "draft.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "draft build"})
+
+	upload := func(version string, fields map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, _ := os.Open(zipPath)
+		defer file.Close()
+		part, _ := writer.CreateFormFile("file", "draft.zip")
+		_, _ = io.Copy(part, file)
+		_ = writer.WriteField("channel", "stable")
+		_ = writer.WriteField("version", version)
+		for k, v := range fields {
+			_ = writer.WriteField(k, v)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload("1.0.0", map[string]string{"status": "live"}).Code)
+	assert.Equal(t, http.StatusBadRequest, upload("1.0.0", map[string]string{"status": "scheduled"}).Code)
+
+	w := upload("1.0.0", map[string]string{"status": "draft"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	latestURL := fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID)
+	detailURL := fmt.Sprintf("/api/programs/%s/versions/stable/1.0.0", programID)
+	downloadURL := fmt.Sprintf("/api/programs/%s/download/stable/1.0.0", programID)
+
+	// Public endpoints hide the draft
+	assert.Equal(t, http.StatusNotFound, get(latestURL, "").Code)
+	assert.Equal(t, "[]", get(fmt.Sprintf("/api/programs/%s/versions", programID), "").Body.String())
+	assert.Equal(t, http.StatusNotFound, get(detailURL, "").Code)
+	assert.Equal(t, http.StatusNotFound, get(downloadURL, downloadToken).Code)
+
+	// Upload and admin tokens can fetch the draft for smoke testing
+	assert.Equal(t, http.StatusOK, get(detailURL, uploadToken).Code)
+	assert.Equal(t, http.StatusOK, get(downloadURL, uploadToken).Code)
+	assert.Equal(t, http.StatusOK, get(downloadURL, srv.AdminToken).Code)
+
+	// Publishing makes it live
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions/1.0.0/publish?channel=stable", programID), nil)
+	req.Header.Set("Authorization", "Bearer "+uploadToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = get(latestURL, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var latest map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	assert.Equal(t, "1.0.0", latest["version"])
+	assert.Equal(t, "published", latest["status"])
+	assert.Equal(t, http.StatusOK, get(downloadURL, downloadToken).Code)
+
+	// A scheduled release stays hidden until its publish time
+	publishAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w = upload("1.1.0", map[string]string{"publishAt": publishAt})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(get(latestURL, "").Body.Bytes(), &latest)
+	assert.Equal(t, "1.0.0", latest["version"])
+
+	var scheduled map[string]interface{}
+	json.Unmarshal(get(fmt.Sprintf("/api/programs/%s/versions/stable/1.1.0", programID), uploadToken).Body.Bytes(), &scheduled)
+	assert.Equal(t, "scheduled", scheduled["status"])
+}
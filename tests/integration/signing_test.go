@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/internal/signing"
+	"docufiller-update-server/tests/helpers"
+)
+
+// TestSignedRelease tests that a program with a signing key only accepts signed releases
+func TestSignedRelease(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "SignedApp", "For signing testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	publicKey, privateKey, err := signing.GenerateKey()
+	assert.NoError(t, err)
+
+	setKey := func(key string) int {
+		body, _ := json.Marshal(map[string]string{"publicKey": key})
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/admin/programs/%s/signing-key", programID), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+srv.AdminToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, setKey("not-a-key"))
+	assert.Equal(t, http.StatusOK, setKey(publicKey))
+
+	zipPath := filepath.Join(t.TempDir(), "signed.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "signed release"})
+	content, _ := os.ReadFile(zipPath)
+
+	manifest := signing.Manifest{
+		ProgramID: programID,
+		Version:   "1.0.0",
+		Channel:   "stable",
+		FileHash:  sha256Hex(content),
+		FileSize:  int64(len(content)),
+		Mandatory: true,
+	}
+	signature, err := signing.Sign(privateKey, manifest)
+	assert.NoError(t, err)
+	unmandatory := manifest
+	unmandatory.Mandatory = false
+	wrongSignature, _ := signing.Sign(privateKey, unmandatory)
+
+	upload := func(signature string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, _ := os.Open(zipPath)
+		defer file.Close()
+		part, _ := writer.CreateFormFile("file", "signed.zip")
+		_, _ = io.Copy(part, file)
+		_ = writer.WriteField("channel", "stable")
+		_ = writer.WriteField("version", "1.0.0")
+		_ = writer.WriteField("mandatory", "true")
+		if signature != "" {
+			_ = writer.WriteField("signature", signature)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Unsigned and mis-signed uploads are rejected
+	assert.Equal(t, http.StatusBadRequest, upload("").Code)
+	assert.Equal(t, http.StatusBadRequest, upload(wrongSignature).Code)
+
+	w := upload(signature)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The signature is served with the latest version and verifies against the manifest
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var latest struct {
+		Version   string `json:"version"`
+		FileHash  string `json:"fileHash"`
+		FileSize  int64  `json:"fileSize"`
+		Mandatory bool   `json:"mandatory"`
+		Signature string `json:"signature"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	assert.Equal(t, signature, latest.Signature)
+	served := manifest
+	served.FileHash, served.FileSize, served.Mandatory = latest.FileHash, latest.FileSize, latest.Mandatory
+	assert.NoError(t, signing.Verify(publicKey, served, latest.Signature))
+
+	// Promotion needs a signature for the target channel
+	promote := func(body string) int {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions/1.0.0/promote", programID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, promote(`{"from":"stable","to":"beta"}`))
+	assert.Equal(t, http.StatusBadRequest, promote(fmt.Sprintf(`{"from":"stable","to":"beta","signature":%q}`, signature)))
+
+	betaManifest := manifest
+	betaManifest.Channel = "beta"
+	betaSignature, _ := signing.Sign(privateKey, betaManifest)
+	assert.Equal(t, http.StatusOK, promote(fmt.Sprintf(`{"from":"stable","to":"beta","signature":%q}`, betaSignature)))
+}
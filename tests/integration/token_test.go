@@ -0,0 +1,284 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/tests/helpers"
+)
+
+// TestTokenManagement covers creating, listing, describing and revoking
+// scoped program tokens, and the scope/channel checks on protected routes
+func TestTokenManagement(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "TokenApp", "For token management testing")
+
+	adminRequest := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+	tokensPath := fmt.Sprintf("/api/admin/programs/%s/tokens", programID)
+
+	// Create an upload token restricted to the beta channel
+	w := adminRequest("POST", tokensPath, map[string]interface{}{
+		"name":      "ci-beta",
+		"scopes":    []string{"upload"},
+		"channel":   "beta",
+		"expiresAt": time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+	})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		Token struct {
+			TokenID string   `json:"tokenId"`
+			Name    string   `json:"name"`
+			Scopes  []string `json:"scopes"`
+			Channel string   `json:"channel"`
+		} `json:"token"`
+		TokenValue string `json:"tokenValue"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.NotEmpty(t, created.TokenValue)
+	assert.Equal(t, "ci-beta", created.Token.Name)
+	assert.Equal(t, []string{"upload"}, created.Token.Scopes)
+	assert.Equal(t, "beta", created.Token.Channel)
+
+	// Invalid requests are rejected
+	w = adminRequest("POST", tokensPath, map[string]interface{}{"name": "bad", "scopes": []string{"everything"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = adminRequest("POST", tokensPath, map[string]interface{}{
+		"name": "expired", "scopes": []string{"download"},
+		"expiresAt": time.Now().Add(-time.Hour).Format(time.RFC3339),
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	upload := func(token, channel, version string) int {
+		zipPath := filepath.Join(t.TempDir(), "app.zip")
+		writeTestZip(t, zipPath, map[string]string{"app.txt": version})
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, _ := os.Open(zipPath)
+		defer file.Close()
+		part, _ := writer.CreateFormFile("file", "app.zip")
+		_, _ = io.Copy(part, file)
+		_ = writer.WriteField("channel", channel)
+		_ = writer.WriteField("version", version)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The channel restriction applies to the channel in the request body
+	assert.Equal(t, http.StatusOK, upload(created.TokenValue, "beta", "1.0.0"))
+	assert.Equal(t, http.StatusForbidden, upload(created.TokenValue, "stable", "1.0.0"))
+
+	// Without the yank scope the token cannot yank, even on its own channel
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions/1.0.0/yank?channel=beta", programID),
+		bytes.NewReader([]byte(`{"reason":"broken"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+created.TokenValue)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Upload-only tokens cannot download published packages (hidden as not found)
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/download/beta/1.0.0", programID), nil)
+	req.Header.Set("Authorization", "Bearer "+created.TokenValue)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// List and describe never return token values
+	w = adminRequest("GET", tokensPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.Token.TokenID)
+	assert.NotContains(t, w.Body.String(), created.TokenValue)
+
+	w = adminRequest("GET", tokensPath+"/"+created.Token.TokenID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"ci-beta"`)
+
+	// Revoke
+	w = adminRequest("DELETE", tokensPath+"/"+created.Token.TokenID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, upload(created.TokenValue, "beta", "1.0.1"))
+
+	w = adminRequest("DELETE", tokensPath+"/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// TestTokenRotation covers rotating a default token with a grace period:
+// both tokens work during the window and update checks deliver the replacement
+func TestTokenRotation(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "RotatingTokenApp", "For token rotation testing")
+	uploadToken, oldToken := helpers.GetProgramTokens(t, srv, programID)
+
+	zipPath := filepath.Join(t.TempDir(), "app.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "1.0.0"})
+	uploadTestPackage(t, srv, programID, uploadToken, "1.0.0", zipPath)
+
+	rotate := func(body string) (int, string) {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/admin/programs/%s/tokens/regenerate?type=download", programID), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		var resp struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Token
+	}
+	download := func(token string) int {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/download/stable/1.0.0", programID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w.Code
+	}
+	checkLatest := func(token string) map[string]interface{} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	code, _ := rotate(`{"gracePeriodHours":-1}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, newToken := rotate(`{"gracePeriodHours":24}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, newToken)
+
+	// Both tokens are valid during the grace period
+	assert.Equal(t, http.StatusOK, download(oldToken))
+	assert.Equal(t, http.StatusOK, download(newToken))
+
+	// Update checks with the old token deliver the replacement
+	resp := checkLatest(oldToken)
+	if update, ok := resp["tokenUpdate"].(map[string]interface{}); assert.True(t, ok, "expected tokenUpdate") {
+		assert.Equal(t, newToken, update["token"])
+		assert.NotEmpty(t, update["expiresAt"])
+	}
+	assert.NotContains(t, checkLatest(newToken), "tokenUpdate")
+
+	// Rotating without a grace period revokes the previous tokens immediately
+	code, latestToken := rotate(`{"gracePeriodHours":0}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusUnauthorized, download(oldToken))
+	assert.Equal(t, http.StatusUnauthorized, download(newToken))
+	assert.Equal(t, http.StatusOK, download(latestToken))
+}
+
+// TestAdminTokenAPI covers automation against the management API with an admin bearer token
+func TestAdminTokenAPI(t *testing.T) {
+	srv := helpers.SetupTestServer(t)
+	defer srv.Close()
+
+	// Bootstrap the first admin token directly, as gen-token does
+	_, adminToken, err := srv.TokenService.CreateAdminToken("ci", "gen-token", nil)
+	assert.NoError(t, err)
+
+	request := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Program CRUD
+	w := request("POST", "/api/admin/programs", adminToken, map[string]string{"programId": "ci-app", "name": "CI App"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var program struct {
+		UploadToken string `json:"uploadToken"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &program)
+
+	w = request("PUT", "/api/admin/programs/ci-app", adminToken, map[string]string{"description": "Built by CI"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Built by CI")
+	w = request("PUT", "/api/admin/programs/ci-app", adminToken, map[string]string{"name": " "})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Non-admin and invalid tokens are rejected instead of falling back to the session
+	assert.Equal(t, http.StatusForbidden, request("GET", "/api/admin/programs", program.UploadToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/admin/programs", "not-a-token", nil).Code)
+
+	// Version edits and deletes
+	zipPath := filepath.Join(t.TempDir(), "app.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "1.0.0"})
+	uploadTestPackage(t, srv, "ci-app", adminToken, "1.0.0", zipPath)
+
+	w = request("PUT", "/api/admin/programs/ci-app/versions/1.0.0?channel=stable", adminToken, map[string]string{"releaseNotes": "edited"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"releaseNotes":"edited"`)
+	w = request("PUT", "/api/admin/programs/ci-app/versions/9.9.9?channel=stable", adminToken, map[string]string{"releaseNotes": "x"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = request("DELETE", "/api/admin/programs/ci-app/versions/1.0.0?channel=stable", adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = srv.VersionService.GetVersion("ci-app", "stable", "1.0.0")
+	assert.Error(t, err)
+
+	// Admin token management
+	w = request("POST", "/api/admin/tokens", adminToken, map[string]string{"name": "nightly"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		Token struct {
+			TokenID string `json:"tokenId"`
+		} `json:"token"`
+		TokenValue string `json:"tokenValue"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, http.StatusOK, request("GET", "/api/admin/programs", created.TokenValue, nil).Code)
+
+	w = request("GET", "/api/admin/tokens", adminToken, nil)
+	assert.Contains(t, w.Body.String(), created.Token.TokenID)
+	assert.NotContains(t, w.Body.String(), created.TokenValue)
+
+	assert.Equal(t, http.StatusOK, request("DELETE", "/api/admin/tokens/"+created.Token.TokenID, adminToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/admin/programs", created.TokenValue, nil).Code)
+
+	w = request("DELETE", "/api/admin/programs/ci-app", adminToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,113 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/tests/helpers"
+)
+
+// TestUpgradePath tests required stepping stones and the channel minimum supported version
+func TestUpgradePath(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "PathApp", "For upgrade path testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	zipPath := filepath.Join(t.TempDir(), "path.zip")
+	writeTestZip(t, zipPath, map[string]string{"app.txt": "path build"})
+
+	upload := func(version string, fields map[string]string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, _ := os.Open(zipPath)
+		defer file.Close()
+		part, _ := writer.CreateFormFile("file", "path.zip")
+		_, _ = io.Copy(part, file)
+		_ = writer.WriteField("channel", "stable")
+		_ = writer.WriteField("version", version)
+		for k, v := range fields {
+			_ = writer.WriteField(k, v)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+	latest := func(current string) map[string]interface{} {
+		url := fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID)
+		if current != "" {
+			url += "&current=" + current
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	setPolicy := func(minVersion string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"minSupportedVersion": minVersion})
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/admin/programs/%s/channels/stable/policy", programID), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload("1.0.0", map[string]string{"minUpgradeFrom": "abc"}).Code)
+	assert.Equal(t, http.StatusOK, upload("1.0.0", nil).Code)
+	assert.Equal(t, http.StatusOK, upload("2.0.0", map[string]string{"requiredStep": "true"}).Code)
+	assert.Equal(t, http.StatusOK, upload("3.0.0", map[string]string{"minUpgradeFrom": "2.0.0"}).Code)
+
+	// Without current the absolute latest is returned
+	resp := latest("")
+	assert.Equal(t, "3.0.0", resp["version"])
+	assert.Nil(t, resp["latestVersion"])
+
+	// Older clients must go through the stepping stone first
+	resp = latest("1.0.0")
+	assert.Equal(t, "2.0.0", resp["version"])
+	assert.Equal(t, "3.0.0", resp["latestVersion"])
+	assert.Equal(t, true, resp["requiredStep"])
+	assert.Equal(t, false, resp["mandatory"])
+
+	resp = latest("2.0.0")
+	assert.Equal(t, "3.0.0", resp["version"])
+	assert.Nil(t, resp["latestVersion"])
+
+	// Clients below the channel minimum are told the update is mandatory
+	assert.Equal(t, http.StatusBadRequest, setPolicy("not-a-version").Code)
+	assert.Equal(t, http.StatusOK, setPolicy("2.0.0").Code)
+
+	resp = latest("1.0.0")
+	assert.Equal(t, "2.0.0", resp["version"])
+	assert.Equal(t, true, resp["mandatory"])
+	assert.Equal(t, false, resp["releaseMandatory"])
+	assert.Equal(t, true, resp["belowMinSupported"])
+	assert.Equal(t, "2.0.0", resp["minSupportedVersion"])
+
+	resp = latest("2.0.0")
+	assert.Equal(t, false, resp["mandatory"])
+	assert.Nil(t, resp["belowMinSupported"])
+
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/api/admin/programs/%s/channels/stable/policy", programID), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"minSupportedVersion":"2.0.0"`)
+}
@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/tests/helpers"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TestChunkedUpload tests the upload session lifecycle with an out-of-order, retried chunk upload
+func TestChunkedUpload(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "ChunkApp", "For chunked upload testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	content := make([]byte, 2560) // 3 chunks of 1024
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	do := func(method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	createBody, _ := json.Marshal(map[string]interface{}{
+		"channel":   "stable",
+		"version":   "2.0.0",
+		"totalSize": len(content),
+		"chunkSize": 1024,
+		"sha256":    sha256Hex(content),
+		"notes":     "Chunked release",
+	})
+	w := do("POST", fmt.Sprintf("/api/programs/%s/uploads", programID), createBody, map[string]string{"Content-Type": "application/json"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var session struct {
+		ID         string `json:"id"`
+		ChunkCount int    `json:"chunkCount"`
+		Missing    []int  `json:"missing"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &session)
+	assert.Equal(t, 3, session.ChunkCount)
+	assert.Equal(t, []int{0, 1, 2}, session.Missing)
+
+	base := fmt.Sprintf("/api/programs/%s/uploads/%s", programID, session.ID)
+	chunk := func(i int) []byte {
+		end := (i + 1) * 1024
+		if end > len(content) {
+			end = len(content)
+		}
+		return content[i*1024 : end]
+	}
+	putChunk := func(i int, data []byte, checksum string) int {
+		return do("PUT", fmt.Sprintf("%s/chunks/%d", base, i), data, map[string]string{"X-Chunk-SHA256": checksum}).Code
+	}
+
+	assert.Equal(t, http.StatusOK, putChunk(2, chunk(2), sha256Hex(chunk(2))))
+	assert.Equal(t, http.StatusOK, putChunk(0, chunk(0), sha256Hex(chunk(0))))
+	assert.Equal(t, http.StatusUnprocessableEntity, putChunk(1, chunk(1), sha256Hex(chunk(0))))
+	assert.Equal(t, http.StatusBadRequest, putChunk(1, chunk(1)[:10], sha256Hex(chunk(1)[:10])))
+	assert.Equal(t, http.StatusBadRequest, putChunk(3, chunk(2), sha256Hex(chunk(2))))
+
+	// Query received offsets
+	w = do("GET", base, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &session)
+	assert.Equal(t, []int{1}, session.Missing)
+
+	// Completing with a missing chunk fails
+	w = do("POST", base+"/complete", nil, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	assert.Equal(t, http.StatusOK, putChunk(1, chunk(1), sha256Hex(chunk(1))))
+	w = do("POST", base+"/complete", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, sha256Hex(content), response["version"]["fileHash"])
+	assert.Equal(t, float64(len(content)), response["version"]["fileSize"])
+
+	stored, err := os.ReadFile(filepath.Join(srv.StorageBasePath, programID, "stable", "2.0.0", programID+"-2.0.0.zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, stored)
+
+	// Session can't be reused after completion
+	assert.Equal(t, http.StatusGone, putChunk(0, chunk(0), sha256Hex(chunk(0))))
+}
+
+// TestChunkedUploadSizeLimit tests that StorageConfig.MaxFileSize is enforced
+func TestChunkedUploadSizeLimit(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "LimitApp", "For size limit testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"channel":   "stable",
+		"version":   "1.0.0",
+		"totalSize": 11 << 20, // test server limit is 10MB
+	})
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/uploads", programID), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+uploadToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+// TestChunkedUploadRolloutValidation tests that an explicit rollout of 0 is rejected
+func TestChunkedUploadRolloutValidation(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "RolloutApp", "For rollout validation testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	create := func(fields map[string]interface{}) *httptest.ResponseRecorder {
+		fields["channel"] = "stable"
+		fields["version"] = "1.0.0"
+		fields["totalSize"] = 1024
+		body, _ := json.Marshal(fields)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/uploads", programID), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, rollout := range []int{0, -1, 101} {
+		w := create(map[string]interface{}{"rollout": rollout})
+		assert.Equal(t, http.StatusBadRequest, w.Code, "rollout %d", rollout)
+		assert.Contains(t, w.Body.String(), "rollout must be between 1 and 100")
+	}
+
+	// Omitting rollout releases to everyone
+	w := create(map[string]interface{}{})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var session map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &session)
+	assert.Equal(t, float64(100), session["rollout"])
+}
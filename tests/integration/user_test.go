@@ -0,0 +1,295 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"docufiller-update-server/internal/service"
+	"docufiller-update-server/internal/totp"
+	"docufiller-update-server/tests/helpers"
+)
+
+// sessionRequester returns a helper that sends a JSON request with the given
+// session cookie (empty for none)
+func sessionRequester(srv *helpers.TestServer) func(cookie, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return func(cookie, method, path string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+}
+
+// sessionCookie extracts the session cookie set by a response
+func sessionCookie(w *httptest.ResponseRecorder) string {
+	return strings.Split(w.Header().Get("Set-Cookie"), ";")[0]
+}
+
+// TestAdminUserRoles logs in as owner, maintainer and viewer users and checks
+// the per-program role checks, user management and forced password change
+func TestAdminUserRoles(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programA := helpers.CreateTestProgram(t, srv, "RoleAppA", "Maintained program")
+	programB := helpers.CreateTestProgram(t, srv, "RoleAppB", "Other program")
+
+	request := sessionRequester(srv)
+	login := func(username, password string) string {
+		w := request("", "POST", "/api/admin/login", map[string]string{"username": username, "password": password})
+		if !assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+			t.FailNow()
+		}
+		return sessionCookie(w)
+	}
+
+	owner := login("admin", "test-password")
+
+	// Owner creates a maintainer for program A and a viewer
+	w := request(owner, "POST", "/api/admin/users", map[string]interface{}{
+		"username": "dev", "password": "dev-password", "role": "maintainer", "programs": []string{programA},
+	})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = request(owner, "POST", "/api/admin/users", map[string]interface{}{"username": "guest", "role": "viewer"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		TemporaryPassword string `json:"temporaryPassword"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.NotEmpty(t, created.TemporaryPassword)
+	w = request(owner, "POST", "/api/admin/users", map[string]interface{}{"username": "dev", "password": "dev-password", "role": "viewer"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Maintainer: read everything, write only program A, no owner routes
+	dev := login("dev", "dev-password")
+	policy := map[string]interface{}{"rolloutPercentage": 50}
+	assert.Equal(t, http.StatusOK, request(dev, "GET", "/api/admin/programs", nil).Code)
+	assert.Equal(t, http.StatusOK, request(dev, "GET", fmt.Sprintf("/api/admin/programs/%s/versions", programB), nil).Code)
+	assert.Equal(t, http.StatusOK, request(dev, "PUT", fmt.Sprintf("/api/admin/programs/%s", programA), map[string]string{"description": "updated"}).Code)
+	assert.Equal(t, http.StatusForbidden, request(dev, "PUT", fmt.Sprintf("/api/admin/programs/%s", programB), map[string]string{"description": "updated"}).Code)
+	assert.Equal(t, http.StatusForbidden, request(dev, "PUT", fmt.Sprintf("/api/admin/programs/%s/channels/stable/policy", programB), policy).Code)
+	assert.Equal(t, http.StatusForbidden, request(dev, "DELETE", fmt.Sprintf("/api/admin/programs/%s", programA), nil).Code)
+	assert.Equal(t, http.StatusForbidden, request(dev, "GET", "/api/admin/users", nil).Code)
+	assert.Equal(t, http.StatusForbidden, request(dev, "GET", "/api/admin/tokens", nil).Code)
+
+	// Viewer must change the temporary password first, then has read-only access
+	guest := login("guest", created.TemporaryPassword)
+	w = request(guest, "GET", "/api/admin/programs", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "mustChangePassword")
+	w = request(guest, "PUT", "/api/admin/me/password", map[string]string{"currentPassword": "wrong-password", "newPassword": "guest-password"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(guest, "PUT", "/api/admin/me/password", map[string]string{"currentPassword": created.TemporaryPassword, "newPassword": "short"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(guest, "PUT", "/api/admin/me/password", map[string]string{"currentPassword": created.TemporaryPassword, "newPassword": "guest-password"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = request(guest, "GET", fmt.Sprintf("/api/admin/programs/%s", programA), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var detail map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	assert.Empty(t, detail["encryptionKey"], "viewers should not see the encryption key")
+	assert.Equal(t, http.StatusForbidden, request(guest, "PUT", fmt.Sprintf("/api/admin/programs/%s", programA), map[string]string{"description": "x"}).Code)
+	assert.Equal(t, http.StatusForbidden, request(guest, "GET", fmt.Sprintf("/api/admin/programs/%s/client/update", programA), nil).Code)
+
+	w = request(guest, "GET", "/api/admin/me", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"role":"viewer"`)
+
+	// Forced reset invalidates the old password
+	w = request(owner, "POST", "/api/admin/users/dev/reset-password", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request("", "POST", "/api/admin/login", map[string]string{"username": "dev", "password": "dev-password"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Deactivated users lose their existing sessions
+	w = request(owner, "PUT", "/api/admin/users/guest", map[string]interface{}{"isActive": false})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, request(guest, "GET", "/api/admin/programs", nil).Code)
+
+	// The last owner cannot be demoted or deleted
+	w = request(owner, "PUT", "/api/admin/users/admin", map[string]interface{}{"role": service.RoleViewer})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = request(owner, "DELETE", "/api/admin/users/admin", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, http.StatusOK, request(owner, "DELETE", "/api/admin/users/guest", nil).Code)
+	assert.Equal(t, http.StatusNotFound, request(owner, "DELETE", "/api/admin/users/guest", nil).Code)
+}
+
+// TestAdminTOTPLogin covers mandatory TOTP enrollment for roles that can
+// publish, and the two-step login with TOTP and recovery codes
+func TestAdminTOTPLogin(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+	request := sessionRequester(srv)
+
+	_, _, err := srv.UserService.CreateUser(service.CreateUserRequest{Username: "ops", Password: "ops-password", Role: service.RoleMaintainer})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	srv.UserService.SetTOTPRequired(true)
+	credentials := map[string]string{"username": "ops", "password": "ops-password"}
+
+	// Without TOTP the maintainer can log in, but only to enroll
+	w := request("", "POST", "/api/admin/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"totpEnrollmentRequired":true`)
+	ops := sessionCookie(w)
+	w = request(ops, "GET", "/api/admin/programs", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "totpEnrollmentRequired")
+
+	w = request(ops, "POST", "/api/admin/me/totp", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var enrollment service.TOTPEnrollment
+	json.Unmarshal(w.Body.Bytes(), &enrollment)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now())-1)
+	w = request(ops, "POST", "/api/admin/me/totp/verify", map[string]string{"code": code})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var confirmed struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &confirmed)
+	assert.Len(t, confirmed.RecoveryCodes, 10)
+	assert.Equal(t, http.StatusOK, request(ops, "GET", "/api/admin/programs", nil).Code)
+
+	// Required TOTP cannot be turned off by the user
+	w = request(ops, "DELETE", "/api/admin/me/totp", map[string]string{"code": confirmed.RecoveryCodes[0]})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Password alone now only yields a pre-auth session
+	w = request("", "POST", "/api/admin/login", credentials)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"totpRequired":true`)
+	preAuth := sessionCookie(w)
+	assert.Equal(t, http.StatusUnauthorized, request(preAuth, "GET", "/api/admin/programs", nil).Code)
+
+	w = request(preAuth, "POST", "/api/admin/login/totp", map[string]string{"code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(preAuth, "POST", "/api/admin/login/totp", map[string]string{"code": confirmed.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	ops = sessionCookie(w)
+	assert.Equal(t, http.StatusOK, request(ops, "GET", "/api/admin/programs", nil).Code)
+
+	// A used recovery code and a second-step call without a pre-auth session fail
+	w = request(preAuth, "POST", "/api/admin/login/totp", map[string]string{"code": confirmed.RecoveryCodes[1]})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request("", "POST", "/api/admin/login/totp", map[string]string{"code": confirmed.RecoveryCodes[2]})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// An owner resets a lost authenticator; the user must enroll again
+	owner := sessionCookie(request("", "POST", "/api/admin/login", map[string]string{"username": "admin", "password": "test-password"}))
+	w = request(owner, "DELETE", "/api/admin/users/ops/totp", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "owner also needs TOTP once it is mandatory")
+	srv.UserService.SetTOTPRequired(false)
+	w = request(owner, "DELETE", "/api/admin/users/ops/totp", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request("", "POST", "/api/admin/login", credentials)
+	assert.Contains(t, w.Body.String(), `"success":true`)
+}
+
+// TestAdminLoginThrottleAndSessions covers login lockout, listing and revoking
+// server-side sessions, and the Secure flag behind a TLS proxy
+func TestAdminLoginThrottleAndSessions(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+	request := sessionRequester(srv)
+
+	_, _, err := srv.UserService.CreateUser(service.CreateUserRequest{Username: "dev", Password: "dev-password", Role: service.RoleViewer})
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	login := func(username, password, userAgent string) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(map[string]string{"username": username, "password": password})
+		req := httptest.NewRequest("POST", "/api/admin/login", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Session cookies are marked Secure when the request came in over HTTPS
+	w := login("admin", "test-password", "Browser A")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "Secure")
+	first := sessionCookie(w)
+	second := sessionCookie(login("admin", "test-password", "Browser B"))
+	w = request("", "POST", "/api/admin/login", map[string]string{"username": "admin", "password": "test-password"})
+	assert.NotContains(t, w.Header().Get("Set-Cookie"), "Secure")
+
+	// Both sessions are listed with client details; the caller's is marked current
+	w = request(first, "GET", "/api/admin/me/sessions", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var listed []struct {
+		SessionID string `json:"sessionId"`
+		UserAgent string `json:"userAgent"`
+		IP        string `json:"ip"`
+		Current   bool   `json:"current"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Len(t, listed, 3)
+	var secondID string
+	for _, s := range listed {
+		assert.NotEmpty(t, s.IP)
+		if s.UserAgent == "Browser A" {
+			assert.True(t, s.Current)
+		}
+		if s.UserAgent == "Browser B" {
+			secondID = s.SessionID
+		}
+	}
+
+	// Revoking a session logs it out immediately
+	assert.Equal(t, http.StatusOK, request(second, "GET", "/api/admin/programs", nil).Code)
+	assert.Equal(t, http.StatusOK, request(first, "DELETE", "/api/admin/me/sessions/"+secondID, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(second, "GET", "/api/admin/programs", nil).Code)
+	assert.Equal(t, http.StatusNotFound, request(first, "DELETE", "/api/admin/me/sessions/"+secondID, nil).Code)
+
+	// Logout deletes the server-side session, so a replayed cookie no longer works
+	third := sessionCookie(login("admin", "test-password", "Browser C"))
+	assert.Equal(t, http.StatusOK, request(third, "POST", "/admin/logout", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(third, "GET", "/api/admin/programs", nil).Code)
+
+	// An owner can list and end another user's sessions; a password reset ends them too
+	dev := sessionCookie(login("dev", "dev-password", "Dev Browser"))
+	w = request(first, "GET", "/api/admin/users/dev/sessions", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Dev Browser")
+	assert.Equal(t, http.StatusForbidden, request(dev, "GET", "/api/admin/users/admin/sessions", nil).Code)
+	assert.Equal(t, http.StatusOK, request(first, "POST", "/api/admin/users/dev/reset-password", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(dev, "GET", "/api/admin/me", nil).Code)
+
+	// Repeated failures lock the username with Retry-After, even for the right password
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("admin", "wrong-password", "Attacker").Code)
+	}
+	w = login("admin", "test-password", "Browser A")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Existing sessions are unaffected by the lockout
+	assert.Equal(t, http.StatusOK, request(first, "GET", "/api/admin/programs", nil).Code)
+}
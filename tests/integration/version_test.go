@@ -1,8 +1,12 @@
 package integration
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -10,10 +14,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 
+	"docufiller-update-server/internal/service"
 	"docufiller-update-server/tests/helpers"
 )
 
@@ -69,6 +76,189 @@ func TestUploadVersion(t *testing.T) {
 	assert.Equal(t, "stable", versionObj["channel"])
 	assert.Equal(t, "1.0.0", versionObj["version"])
 	assert.NotEmpty(t, versionObj["fileName"])
+
+	// Re-uploading the same version is rejected and leaves the stored file untouched
+	storedPath := filepath.Join(srv.StorageBasePath, programID, "stable", "1.0.0", fmt.Sprintf("%s-1.0.0.zip", programID))
+	stored, err := os.ReadFile(storedPath)
+	assert.NoError(t, err)
+
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	part, _ = writer.CreateFormFile("file", "replacement.zip")
+	_, _ = part.Write([]byte("replacement content"))
+	_ = writer.WriteField("channel", "stable")
+	_ = writer.WriteField("version", "1.0.0")
+	writer.Close()
+
+	req = httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+uploadToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	after, err := os.ReadFile(storedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, after)
+}
+
+// TestUploadMultiPlatformVersion tests uploading per-platform artifacts and selecting them by os/arch
+func TestUploadMultiPlatformVersion(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "MultiPlatformApp", "For artifact testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	platformContent := map[string]string{
+		"windows-amd64": "windows build",
+		"linux-amd64":   "linux build",
+		"linux-arm64":   "arm build",
+	}
+	for platform, content := range platformContent {
+		part, _ := writer.CreateFormFile("file."+platform, platform+".zip")
+		_, _ = part.Write([]byte(content))
+	}
+	_ = writer.WriteField("channel", "stable")
+	_ = writer.WriteField("version", "3.0.0")
+	writer.Close()
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+uploadToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Latest selects the artifact by query parameters
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable&os=linux&arch=arm64", programID), nil)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var latest map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	assert.Equal(t, "linux-arm64", latest["platform"])
+	assert.Equal(t, float64(len(platformContent["linux-arm64"])), latest["fileSize"])
+
+	// Unknown platform has no eligible version
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+	req.Header.Set("X-Client-OS", "darwin")
+	req.Header.Set("X-Client-Arch", "arm64")
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Download selects the artifact from client headers
+	req = httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/download/stable/3.0.0", programID), nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	req.Header.Set("X-Client-OS", "windows")
+	req.Header.Set("X-Client-Arch", "amd64")
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, platformContent["windows-amd64"], w.Body.String())
+}
+
+// TestDeltaPatches tests that uploading a new version produces patches from earlier versions
+func TestDeltaPatches(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "PatchApp", "For patch testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	shared := strings.Repeat("shared library bytes ", 8192)
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		zipPath := filepath.Join(t.TempDir(), version+".zip")
+		writeTestZip(t, zipPath, map[string]string{
+			"lib/shared.dll": shared,
+			"app.exe":        "app " + version,
+		})
+		uploadTestPackage(t, srv, programID, uploadToken, version, zipPath)
+	}
+	// Patches are generated in the background after publishing
+	service.WaitPatchJobs()
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable", programID), nil)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var latest struct {
+		Version  string `json:"version"`
+		FileSize int64  `json:"fileSize"`
+		Patches  []struct {
+			From string `json:"from"`
+			Size int64  `json:"size"`
+			Hash string `json:"hash"`
+			URL  string `json:"url"`
+		} `json:"patches"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &latest)
+	assert.Equal(t, "1.1.0", latest.Version)
+	if !assert.Len(t, latest.Patches, 1) {
+		return
+	}
+	patch := latest.Patches[0]
+	assert.Equal(t, "1.0.0", patch.From)
+	assert.Less(t, patch.Size, latest.FileSize)
+
+	// Patch download requires a download token
+	req = httptest.NewRequest("GET", patch.URL, nil)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest("GET", patch.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, patch.Size, int64(w.Body.Len()))
+}
+
+// writeTestZip writes a zip archive with the given entries
+func writeTestZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// uploadTestPackage uploads a package as a stable version
+func uploadTestPackage(t *testing.T, srv *helpers.TestServer, programID, token, version, zipPath string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	file, _ := os.Open(zipPath)
+	defer file.Close()
+	part, _ := writer.CreateFormFile("file", filepath.Base(zipPath))
+	_, _ = io.Copy(part, file)
+	_ = writer.WriteField("channel", "stable")
+	_ = writer.WriteField("version", version)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload %s failed: %d %s", version, w.Code, w.Body.String())
+	}
 }
 
 // TestListVersions tests listing versions for a program
@@ -121,6 +311,250 @@ func TestGetLatestVersion(t *testing.T) {
 	assert.Equal(t, "stable", response["channel"])
 }
 
+// TestGetLatestVersionSemverOrdering tests that latest follows semantic version order, not publish date
+func TestGetLatestVersionSemverOrdering(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "SemverTestApp", "For semver testing")
+
+	// 1.2.9 hotfix is published after 1.3.0
+	helpers.CreateTestVersion(t, srv.DB, programID, "stable", "1.3.0-rc.1")
+	helpers.CreateTestVersion(t, srv.DB, programID, "stable", "1.3.0")
+	helpers.CreateTestVersion(t, srv.DB, programID, "stable", "1.2.9")
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantVersion string
+	}{
+		{"default is semver", "", http.StatusOK, "1.3.0"},
+		{"explicit semver", "&strategy=semver", http.StatusOK, "1.3.0"},
+		{"publish date", "&strategy=publish_date", http.StatusOK, "1.2.9"},
+		{"invalid strategy", "&strategy=random", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable%s", programID, tt.query), nil)
+
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantVersion == "" {
+				return
+			}
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			assert.Equal(t, tt.wantVersion, response["version"])
+		})
+	}
+}
+
+// TestYankVersion tests withdrawing and restoring a version
+func TestYankVersion(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "YankTestApp", "For yank testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	helpers.CreateTestVersion(t, srv.DB, programID, "stable", "1.0.0")
+	helpers.CreateTestVersion(t, srv.DB, programID, "stable", "1.1.0")
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+	getLatest := func() map[string]interface{} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/latest?channel=stable&current=1.1.0", programID), nil)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	// Reason is required
+	w := post(fmt.Sprintf("/api/programs/%s/versions/1.1.0/yank?channel=stable", programID), `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = post(fmt.Sprintf("/api/programs/%s/versions/1.1.0/yank?channel=stable", programID), `{"reason":"corrupts settings"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Latest skips the yanked version and reports the caller's version as yanked
+	latest := getLatest()
+	assert.Equal(t, "1.0.0", latest["version"])
+	assert.Equal(t, true, latest["currentYanked"])
+	assert.Equal(t, "corrupts settings", latest["currentYankReason"])
+
+	// Detail still shows the yanked version with its reason
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/stable/1.1.0", programID), nil)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var detail map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	assert.Equal(t, true, detail["yanked"])
+	assert.Equal(t, "corrupts settings", detail["yankReason"])
+
+	w = post(fmt.Sprintf("/api/programs/%s/versions/1.1.0/unyank?channel=stable", programID), ``)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	latest = getLatest()
+	assert.Equal(t, "1.1.0", latest["version"])
+	assert.Nil(t, latest["currentYanked"])
+}
+
+// TestPromoteVersion tests promoting a version between channels without re-uploading
+func TestPromoteVersion(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "PromoteTestApp", "For promote testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	source := helpers.CreateTestVersion(t, srv.DB, programID, "beta", "2.0.0")
+
+	promote := func(version, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions/%s/promote", programID, version), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := promote("2.0.0", `{"from":"beta","to":"stable"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/programs/%s/versions/stable/2.0.0", programID), nil)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var promoted map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &promoted)
+	assert.Equal(t, source.FileHash, promoted["fileHash"])
+	assert.Equal(t, source.FilePath, promoted["filePath"])
+	assert.Equal(t, source.ReleaseNotes, promoted["releaseNotes"])
+	assert.Equal(t, "beta", promoted["promotedFrom"])
+	assert.NotEmpty(t, promoted["promotedBy"])
+	assert.NotEmpty(t, promoted["promotedAt"])
+
+	// Promoting twice conflicts, unknown source is not found
+	assert.Equal(t, http.StatusConflict, promote("2.0.0", `{"from":"beta","to":"stable"}`).Code)
+	assert.Equal(t, http.StatusNotFound, promote("9.9.9", `{"from":"beta","to":"stable"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, promote("2.0.0", `{"from":"beta","to":"beta"}`).Code)
+}
+
+// TestPromotedVersionKeepsSharedFiles tests that files shared with a promoted version are neither deleted nor overwritten
+func TestPromotedVersionKeepsSharedFiles(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "PromoteShareApp", "For shared file testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	upload := func(content string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "app.zip")
+		_, _ = part.Write([]byte(content))
+		_ = writer.WriteField("channel", "beta")
+		_ = writer.WriteField("version", "2.0.0")
+		writer.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+srv.AdminToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := upload("original package")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request("POST", fmt.Sprintf("/api/programs/%s/versions/2.0.0/promote", programID), `{"from":"beta","to":"stable"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	storedPath := filepath.Join(srv.StorageBasePath, programID, "beta", "2.0.0", programID+"-2.0.0.zip")
+
+	// Deleting the source keeps the file the promoted version still serves
+	w = request("DELETE", fmt.Sprintf("/api/admin/programs/%s/versions/2.0.0?channel=beta", programID), "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stored, err := os.ReadFile(storedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "original package", string(stored))
+
+	// Re-uploading the source version would overwrite it
+	w = upload("replacement package")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "promoted version")
+	stored, err = os.ReadFile(storedPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "original package", string(stored))
+
+	// Once the promoted version is gone the files are deleted and the version can be uploaded again
+	w = request("DELETE", fmt.Sprintf("/api/admin/programs/%s/versions/2.0.0?channel=stable", programID), "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = os.Stat(storedPath)
+	assert.True(t, os.IsNotExist(err))
+	w = upload("replacement package")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// TestUploadVersionRemovesFilesOnFailure tests that stored files are deleted when creating the version fails
+func TestUploadVersionRemovesFilesOnFailure(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "UploadFailApp", "For failed upload testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	// Fail every version insert after the files are stored
+	err := srv.DB.Callback().Create().Before("gorm:create").Register("test:fail_versions", func(db *gorm.DB) {
+		if db.Statement.Table == "versions" {
+			db.AddError(errors.New("database unavailable"))
+		}
+	})
+	assert.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "app.zip")
+	_, _ = part.Write([]byte("package"))
+	_ = writer.WriteField("channel", "stable")
+	_ = writer.WriteField("version", "1.0.0")
+	writer.Close()
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+uploadToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+	_, err = os.Stat(filepath.Join(srv.StorageBasePath, programID, "stable", "1.0.0"))
+	assert.True(t, os.IsNotExist(err), "stored files should be removed")
+}
+
 // TestDeleteVersion tests deleting a version
 func TestDeleteVersion(t *testing.T) {
 	srv := helpers.SetupTestServerWithAdmin(t)
@@ -193,6 +627,88 @@ func TestUploadValidation(t *testing.T) {
 	}
 }
 
+// TestUploadVersionRejectsInvalidSemver tests that uploads must use a strict SemVer 2.0 version
+func TestUploadVersionRejectsInvalidSemver(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "SemverApp", "For version validation testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	for _, version := range []string{"1.2", "1.2.3.4", "v1.2.3", "01.2.3", "1.0.0-01", "1.0.0-alpha_1"} {
+		t.Run(version, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "app.zip")
+			_, _ = part.Write([]byte("package"))
+			_ = writer.WriteField("channel", "stable")
+			_ = writer.WriteField("version", version)
+			writer.Close()
+
+			req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.Header.Set("Authorization", "Bearer "+uploadToken)
+			w := httptest.NewRecorder()
+			srv.Router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "valid semantic version")
+		})
+	}
+}
+
+// TestUploadVersionSizeLimit tests that oversized uploads are rejected with 413
+func TestUploadVersionSizeLimit(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "UploadLimitApp", "For upload size limit testing")
+	uploadToken, _ := helpers.GetProgramTokens(t, srv, programID)
+
+	// upload streams a multipart body with a file of the given size
+	upload := func(size int64) *httptest.ResponseRecorder {
+		pr, pw := io.Pipe()
+		writer := multipart.NewWriter(pw)
+		go func() {
+			_ = writer.WriteField("channel", "stable")
+			_ = writer.WriteField("version", "1.0.0")
+			part, _ := writer.CreateFormFile("file", "app.zip")
+			if _, err := io.CopyN(part, zeroReader{}, size); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			pw.CloseWithError(writer.Close())
+		}()
+		defer pr.Close()
+
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/programs/%s/versions", programID), pr)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+uploadToken)
+		w := httptest.NewRecorder()
+		srv.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A file over the limit (test server limit is 10MB)
+	w := upload(11 << 20)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+
+	// A body over the request limit is cut off while parsing instead of being written to disk
+	w = upload(16*(10<<20) + 2<<20)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "request exceeds maximum size")
+}
+
+// zeroReader yields an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
 // TestGetVersionDetail tests getting a specific version's details
 func TestGetVersionDetail(t *testing.T) {
 	srv := helpers.SetupTestServerWithAdmin(t)
@@ -236,3 +752,49 @@ func TestDownloadTokenAuth(t *testing.T) {
 	// Should fail without authentication
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
+
+// TestDownloadRange tests ETag and Range support on the download route
+func TestDownloadRange(t *testing.T) {
+	srv := helpers.SetupTestServerWithAdmin(t)
+	defer srv.Close()
+
+	programID := helpers.CreateTestProgram(t, srv, "RangeApp", "For range testing")
+	uploadToken, downloadToken := helpers.GetProgramTokens(t, srv, programID)
+
+	zipPath := helpers.CreateTestVersionZip(t, "RangeApp", "1.0.0")
+	defer os.Remove(zipPath)
+	uploadTestPackage(t, srv, programID, uploadToken, "1.0.0", zipPath)
+	full, _ := os.ReadFile(zipPath)
+	sum := sha256.Sum256(full)
+
+	url := fmt.Sprintf("/api/programs/%s/download/stable/1.0.0", programID)
+
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	w := httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"`+hex.EncodeToString(sum[:])+`"`, etag)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	// Resume from byte 10 with a matching If-Range
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("If-Range", etag)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, full[10:], w.Body.Bytes())
+
+	// A stale If-Range gets the whole file
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+downloadToken)
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("If-Range", `"stale"`)
+	w = httptest.NewRecorder()
+	srv.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, len(full), w.Body.Len())
+}
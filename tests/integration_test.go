@@ -46,7 +46,7 @@ func SetupTestServer(t *testing.T) *TestServer {
 	assert.NoError(t, err)
 
 	// Auto migrate
-	err = db.AutoMigrate(&models.Version{}, &models.Program{}, &models.Token{})
+	err = db.AutoMigrate(&models.Version{}, &models.VersionArtifact{}, &models.VersionPatch{}, &models.UploadSession{}, &models.UploadChunk{}, &models.ChannelPolicy{}, &models.Program{}, &models.Token{})
 	assert.NoError(t, err)
 
 	// Initialize logger (suppress output)
@@ -69,7 +69,7 @@ func SetupTestServer(t *testing.T) *TestServer {
 	_, downloadToken, _ := tokenSvc.GenerateToken("test-program", "download", "download")
 
 	// Setup routes
-	setupTestRoutes(router, db, authMiddleware)
+	setupTestRoutes(router, db, authMiddleware, service.NewStorageService(filepath.Join(tempDir, "packages")))
 
 	return &TestServer{
 		Router:        router,
@@ -82,41 +82,51 @@ func SetupTestServer(t *testing.T) *TestServer {
 
 // TearDownTestServer cleans up test resources
 func (ts *TestServer) TearDownTestServer(t *testing.T) {
+	// Wait for background patch generation still using the database and temp dir
+	service.WaitPatchJobs()
+
 	// Close database connection
 	sqlDB, _ := ts.DB.DB()
 	if sqlDB != nil {
 		sqlDB.Close()
 	}
 
-	// Remove temp directory
+	// Remove temp directory; the asynchronous token last-used update may still be
+	// writing the database journal, so retry briefly
 	err := os.RemoveAll(ts.TempDir)
+	for i := 0; err != nil && i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		err = os.RemoveAll(ts.TempDir)
+	}
 	assert.NoError(t, err)
 }
 
-func setupTestRoutes(r *gin.Engine, db *gorm.DB, authMiddleware *middleware.AuthMiddleware) {
+func setupTestRoutes(r *gin.Engine, db *gorm.DB, authMiddleware *middleware.AuthMiddleware, storageSvc *service.StorageService) {
+	versionHandler := handler.NewVersionHandler(db, storageSvc)
+
 	// Public routes
 	public := r.Group("/api")
 	{
 		public.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
-		public.GET("/programs/:programId/versions/latest", handler.NewVersionHandler(db).GetLatestVersion)
-		public.GET("/programs/:programId/versions", handler.NewVersionHandler(db).GetVersionList)
-		public.GET("/programs/:programId/versions/:channel/:version", handler.NewVersionHandler(db).GetVersionDetail)
+		public.GET("/programs/:programId/versions/latest", versionHandler.GetLatestVersion)
+		public.GET("/programs/:programId/versions", versionHandler.GetVersionList)
+		public.GET("/programs/:programId/versions/:channel/:version", versionHandler.GetVersionDetail)
 	}
 
 	// Authenticated routes
 	upload := r.Group("/api")
 	upload.Use(authMiddleware.RequireUpload())
 	{
-		upload.POST("/programs/:programId/versions", handler.NewVersionHandler(db).UploadVersion)
-		upload.DELETE("/programs/:programId/versions/:channel/:version", handler.NewVersionHandler(db).DeleteVersion)
+		upload.POST("/programs/:programId/versions", versionHandler.UploadVersion)
+		upload.DELETE("/programs/:programId/versions/:channel/:version", versionHandler.DeleteVersion)
 	}
 
 	download := r.Group("/api")
 	download.Use(authMiddleware.RequireDownload())
 	{
-		download.GET("/programs/:programId/download/:channel/:version", handler.NewVersionHandler(db).DownloadFile)
+		download.GET("/programs/:programId/download/:channel/:version", versionHandler.DownloadFile)
 	}
 }
 
@@ -416,7 +426,7 @@ func BenchmarkGetLatestVersion(b *testing.B) {
 
 	dbPath := filepath.Join(tempDir, "bench.db")
 	db, _ := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
-	db.AutoMigrate(&models.Version{})
+	db.AutoMigrate(&models.Version{}, &models.VersionArtifact{}, &models.VersionPatch{})
 
 	// Create 100 test versions
 	for i := 0; i < 100; i++ {
@@ -425,7 +435,7 @@ func BenchmarkGetLatestVersion(b *testing.B) {
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.GET("/api/programs/:programId/versions/latest", handler.NewVersionHandler(db).GetLatestVersion)
+	router.GET("/api/programs/:programId/versions/latest", handler.NewVersionHandler(db, service.NewStorageService(filepath.Join(tempDir, "packages"))).GetLatestVersion)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -7,11 +7,43 @@ let currentUploadToken = null;  // 当前程序的 upload token
 
 // ==================== 初始化 ====================
 
-document.addEventListener('DOMContentLoaded', function() {
+document.addEventListener('DOMContentLoaded', async function() {
+    await ensurePasswordChanged();
+    await ensureTOTPEnrolled();
     loadPrograms();
     setupEventListeners();
 });
 
+// 临时密码或被重置密码的用户须先修改密码
+async function ensurePasswordChanged() {
+    try {
+        const response = await fetch('/api/admin/me');
+        if (!response.ok) return;
+        const user = await response.json();
+        while (user.mustChangePassword) {
+            const currentPassword = prompt('首次登录或密码已被重置，请输入当前密码：');
+            if (currentPassword === null) return;
+            const newPassword = prompt('请输入新密码（至少 8 位）：');
+            if (newPassword === null) return;
+
+            const result = await fetch('/api/admin/me/password', {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ currentPassword, newPassword })
+            });
+            if (result.ok) {
+                user.mustChangePassword = false;
+                showToast('密码已修改');
+            } else {
+                const data = await result.json();
+                alert('修改密码失败: ' + (data.error || '未知错误'));
+            }
+        }
+    } catch (error) {
+        console.error('Failed to load current user:', error);
+    }
+}
+
 function setupEventListeners() {
     // 创建程序按钮
     document.getElementById('createProgramBtn').addEventListener('click', function() {
@@ -51,6 +83,41 @@ function setupEventListeners() {
     });
 }
 
+// 角色要求两步验证但尚未启用时，引导绑定验证器
+async function ensureTOTPEnrolled() {
+    try {
+        const response = await fetch('/api/admin/me');
+        if (!response.ok) return;
+        const user = await response.json();
+        if (!user.totpRequired || user.totpEnabled) return;
+
+        const begin = await fetch('/api/admin/me/totp', { method: 'POST' });
+        if (!begin.ok) return;
+        const enrollment = await begin.json();
+
+        while (true) {
+            const code = prompt(
+                '当前角色必须启用两步验证。\n请在验证器应用中添加以下账号（或扫描该 URI 的二维码）：\n\n' +
+                enrollment.uri + '\n\n密钥：' + enrollment.secret + '\n\n然后输入 6 位验证码：');
+            if (code === null) return;
+
+            const result = await fetch('/api/admin/me/totp/verify', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ code })
+            });
+            const data = await result.json();
+            if (result.ok) {
+                alert('两步验证已启用。请妥善保存以下恢复码（每个只能使用一次，只显示一次）：\n\n' + data.recoveryCodes.join('\n'));
+                return;
+            }
+            alert('验证失败: ' + (data.error || '未知错误'));
+        }
+    } catch (error) {
+        console.error('Failed to enroll two-factor authentication:', error);
+    }
+}
+
 // ==================== 程序列表管理 ====================
 
 async function loadPrograms() {
@@ -201,9 +268,12 @@ async function handleCreateProgram(e) {
 
 // ==================== Token & 密钥管理 ====================
 
+// Token 只存储哈希，明文仅在创建或重新生成时返回
+const TOKEN_HIDDEN_TEXT = '已隐藏（仅在生成时显示，请重新生成）';
+
 function updateTokensModule(data) {
-    document.getElementById('uploadToken').textContent = data.uploadToken || 'loading...';
-    document.getElementById('downloadToken').textContent = data.downloadToken || 'loading...';
+    document.getElementById('uploadToken').textContent = data.uploadToken || TOKEN_HIDDEN_TEXT;
+    document.getElementById('downloadToken').textContent = data.downloadToken || TOKEN_HIDDEN_TEXT;
     document.getElementById('encryptionKey').textContent = data.encryptionKey || 'loading...';
 }
 
@@ -225,6 +295,10 @@ async function copyToken(type) {
         showToast('内容未加载', 'error');
         return;
     }
+    if (text === TOKEN_HIDDEN_TEXT) {
+        showToast('Token 仅在生成时显示，请重新生成', 'error');
+        return;
+    }
 
     try {
         await navigator.clipboard.writeText(text);
@@ -250,7 +324,7 @@ async function copyToken(type) {
 async function regenerateToken(type) {
     if (!currentProgram) return;
 
-    if (!confirm(`确定要重新生成 ${type} token 吗？`)) {
+    if (!confirm(`确定要重新生成 ${type} token 吗？旧 token 在 7 天宽限期内仍然有效，客户端检查更新时会自动收到新 token。`)) {
         return;
     }
 
@@ -368,7 +442,7 @@ function renderVersions(versions) {
         const deleteBtn = document.createElement('button');
         deleteBtn.className = 'btn btn-sm btn-danger';
         deleteBtn.innerHTML = '<i class="fas fa-trash"></i>';
-        deleteBtn.onclick = () => deleteVersion(v.version);
+        deleteBtn.onclick = () => deleteVersion(v.version, v.channel);
         tdActions.appendChild(deleteBtn);
 
         tr.appendChild(tdVersion);
@@ -381,7 +455,7 @@ function renderVersions(versions) {
     });
 }
 
-async function deleteVersion(version) {
+async function deleteVersion(version, channel) {
     if (!currentProgram) return;
 
     if (!confirm(`确定要删除版本 ${version} 吗？`)) {
@@ -389,7 +463,7 @@ async function deleteVersion(version) {
     }
 
     try {
-        const response = await fetch(`/api/admin/programs/${currentProgram}/versions/${version}`, {
+        const response = await fetch(`/api/admin/programs/${currentProgram}/versions/${version}?channel=${encodeURIComponent(channel)}`, {
             method: 'DELETE'
         });
 
@@ -428,10 +502,14 @@ function handleFileSelect(e) {
 async function handleUploadVersion(e) {
     e.preventDefault();
 
-    if (!currentProgram || !currentUploadToken) {
+    if (!currentProgram) {
         showToast('请先选择程序', 'error');
         return;
     }
+    if (!currentUploadToken) {
+        showToast('请先重新生成 Upload Token', 'error');
+        return;
+    }
 
     const fileInput = document.getElementById('versionFile');
     const file = fileInput.files[0];
@@ -562,7 +640,7 @@ async function downloadPublishClient() {
 
 function updateCommandExamples(data) {
     const serverUrl = window.location.origin;
-    const uploadToken = data.uploadToken;
+    const uploadToken = data.uploadToken || '<YOUR_UPLOAD_TOKEN>';
     const programId = data.program.programId;
 
     const command = `# 上传新版本